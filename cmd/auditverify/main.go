@@ -0,0 +1,58 @@
+// Command auditverify checks the hash chain of the audit log. It exits with
+// status 1 when an event was changed or removed and 2 when the log can't be read.
+//
+//	go run ./cmd/auditverify [-head <hash>]
+//
+// Pass the head printed by an earlier run as -head to also detect the newest
+// events being removed since then.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/abhiii71/clean-code-abhi/pkg/config"
+	di "github.com/abhiii71/clean-code-abhi/pkg/dependencies"
+	"github.com/abhiii71/clean-code-abhi/pkg/logger"
+	userauth "github.com/abhiii71/clean-code-abhi/pkg/user_auth"
+)
+
+func main() {
+	head := flag.String("head", "", "hash of an event recorded earlier that must still be in the chain")
+	flag.Parse()
+
+	cnf, err := config.LoadConfig()
+	if err != nil {
+		log.Fatal("failed to load environments: ", err)
+	}
+	logs, err := logger.New(cnf)
+	if err != nil {
+		log.Fatal("failed to create the logger: ", err)
+	}
+	repo, err := di.NewRepository(cnf, logs)
+	if err != nil {
+		log.Fatal("failed to connect to the database: ", err)
+	}
+
+	os.Exit(run(context.Background(), repo, *head, os.Stdout))
+}
+
+// run verifies the audit log of repo and prints the outcome to out, it
+// returns the exit status
+func run(ctx context.Context, repo userauth.Repository, head string, out io.Writer) int {
+	result, err := userauth.VerifyAuditLog(ctx, repo, head)
+	if err != nil {
+		fmt.Fprintln(out, "failed to read the audit log:", err)
+		return 2
+	}
+	if !result.Valid {
+		fmt.Fprintf(out, "audit log is broken at event %d: %s\n", result.BrokenAt, result.Reason)
+		return 1
+	}
+	fmt.Fprintf(out, "audit log is intact: %d events, head %s\n", result.Events, result.Head)
+	return 0
+}
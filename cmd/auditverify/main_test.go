@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	userauth "github.com/abhiii71/clean-code-abhi/pkg/user_auth"
+)
+
+// failingRepository can't read the audit log
+type failingRepository struct {
+	userauth.Repository
+}
+
+func (failingRepository) ListAuditEvents(context.Context, userauth.AuditEventFilter) ([]userauth.AuditEvent, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repo := userauth.NewMemoryRepository(log)
+	service := userauth.NewService(repo, &userauth.PasswordPolicy{MinLength: 8}, userauth.NewPasswordHasher(userauth.NewBcryptHasher(4)),
+		[]byte("test-token-secret-of-at-least-32-bytes"), nil, nil, userauth.OAuthServerConfig{}, userauth.MagicLinkConfig{}, userauth.NewLogMailer(log), log)
+	for _, email := range []string{"jane@example.com", "john@example.com"} {
+		_, err := service.UserRegister(ctx, userauth.UserRegisterRequest{
+			FirstName: "Test", Email: email, Password: "Passw0rd!", DOB: userauth.Date(time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)), Gender: "female",
+		})
+		if err != nil {
+			t.Fatalf("UserRegister(%s): %v", email, err)
+		}
+	}
+	result, err := userauth.VerifyAuditLog(ctx, repo, "")
+	if err != nil || result.Events != 2 {
+		t.Fatalf("VerifyAuditLog = %+v, %v, want 2 events", result, err)
+	}
+
+	tests := []struct {
+		name       string
+		repo       userauth.Repository
+		head       string
+		wantStatus int
+		wantOutput string
+	}{
+		{"intact", repo, "", 0, "audit log is intact: 2 events, head " + result.Head},
+		{"intact with its head", repo, result.Head, 0, "audit log is intact"},
+		{"head missing", repo, strings.Repeat("0", 64), 1, "audit log is broken at event 2: the expected head is not part of the chain"},
+		{"unreadable", failingRepository{repo}, "", 2, "failed to read the audit log: connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if status := run(ctx, tt.repo, tt.head, &out); status != tt.wantStatus {
+				t.Errorf("got status %d, want %d", status, tt.wantStatus)
+			}
+			if !strings.Contains(out.String(), tt.wantOutput) {
+				t.Errorf("got output %q, want it to contain %q", out.String(), tt.wantOutput)
+			}
+		})
+	}
+}
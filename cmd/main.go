@@ -18,17 +18,20 @@ import (
 // @securityDefinitions.apikey BearerAuth
 // @in header
 // @name Authorization
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
 func main() {
 	//loadConfig
 	cnf, err := config.LoadConfig()
 	if err != nil {
-		log.Fatal("failed to load environments")
+		log.Fatal("failed to load environments: ", err)
 	}
 
 	// server Initialization
 	server, err := di.InitializeEvents(cnf)
 	if err != nil {
-		log.Fatal("Failed to initialize the files")
+		log.Fatal("Failed to initialize the files: ", err)
 	}
 	server.Start(cnf)
 }
@@ -0,0 +1,288 @@
+// Command mockidp is an OpenID Connect provider for trying out and testing the
+// provider login locally. It signs in anyone with the email they type in, or
+// the login_hint sent with the authorization request, without a password.
+//
+//	go run ./cmd/mockidp [-addr :9000] [-issuer http://localhost:9000]
+//
+// Point OIDC_PROVIDERS_FILE at a file like:
+//
+//	[{"name": "mock", "issuer": "http://localhost:9000", "client_id": "clean-code",
+//	  "client_secret": "secret", "redirect_url": "http://localhost:8080/applicant/external/v1/oidc/mock/callback"}]
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	keyID   = "mockidp"
+	codeTTL = time.Minute
+)
+
+// authorization is what an issued code was granted for
+type authorization struct {
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	email         string
+	name          string
+	expiresAt     time.Time
+}
+
+type provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+func main() {
+	addr := flag.String("addr", ":9000", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer URL, the address the service reaches the provider at")
+	clientID := flag.String("client-id", "clean-code", "client_id of the service")
+	clientSecret := flag.String("client-secret", "secret", "client_secret of the service, empty for a public client")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal("failed to generate the signing key: ", err)
+	}
+	p := &provider{
+		issuer:       strings.TrimSuffix(*issuer, "/"),
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		key:          key,
+		codes:        map[string]authorization{},
+	}
+
+	log.Printf("mock identity provider %s listening on %s", p.issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, p.routes()))
+}
+
+func (p *provider) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	return mux
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<title>Mock identity provider</title>
+<form method="get" action="/authorize">
+{{range $name, $values := .}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}">
+{{end}}{{end}}<label>Email <input name="email" type="email" required autofocus></label>
+<label>Name <input name="name"></label>
+<button>Sign in</button>
+<button name="deny" value="1" formnovalidate>Cancel</button>
+</form>
+`))
+
+// authorize asks for the email to sign in with and redirects back with a code
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != p.clientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	// from here on errors go back to the client
+	redirect := func(params url.Values) {
+		params.Set("state", q.Get("state"))
+		u := *redirectURI
+		u.RawQuery = params.Encode()
+		http.Redirect(w, r, u.String(), http.StatusFound)
+	}
+	switch {
+	case q.Get("response_type") != "code":
+		redirect(url.Values{"error": {"unsupported_response_type"}})
+		return
+	case !strings.Contains(" "+q.Get("scope")+" ", " openid "):
+		redirect(url.Values{"error": {"invalid_scope"}, "error_description": {"openid scope is required"}})
+		return
+	case q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256":
+		redirect(url.Values{"error": {"invalid_request"}, "error_description": {"S256 code_challenge is required"}})
+		return
+	case q.Get("deny") != "":
+		redirect(url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(q.Get("email")))
+	if email == "" {
+		email = strings.ToLower(strings.TrimSpace(q.Get("login_hint")))
+	}
+	if email == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := loginPage.Execute(w, q); err != nil {
+			log.Print("failed to render the login page: ", err)
+		}
+		return
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		redirectURI:   q.Get("redirect_uri"),
+		codeChallenge: q.Get("code_challenge"),
+		nonce:         q.Get("nonce"),
+		email:         email,
+		name:          q.Get("name"),
+		expiresAt:     time.Now().Add(codeTTL),
+	}
+	p.mu.Unlock()
+	log.Printf("signed in %s", email)
+	redirect(url.Values{"code": {code}})
+}
+
+// token exchanges a code for an ID token
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request", "invalid form")
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.clientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.clientSecret)) != 1 {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	// codes are single use, a replayed code fails like an unknown one
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	auth, found := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+	if !found || time.Now().After(auth.expiresAt) || auth.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "unknown, expired or used code")
+		return
+	}
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != auth.codeChallenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match the code_challenge")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            p.issuer,
+		"sub":            subject(auth.email),
+		"aud":            p.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"email":          auth.email,
+		"email_verified": true,
+	}
+	if auth.nonce != "" {
+		claims["nonce"] = auth.nonce
+	}
+	if auth.name != "" {
+		claims["name"] = auth.name
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = keyID
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// subject is the same for every sign in with an email, like the stable sub of
+// a real provider
+func subject(email string) string {
+	sum := sha256.Sum256([]byte(email))
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func tokenError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Print("failed to write the response: ", err)
+	}
+}
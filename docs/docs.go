@@ -19,74 +19,2342 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/applicant/external/v1/admin/audit-events": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Lists the audit log oldest first. Pages are continued by passing next_after_id as after_id.",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "List audit events",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "event type, e.g. auth.login_failed",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "email of the user or admin that caused the event",
+                        "name": "actor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only the events about this user",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 time, only events at or after it",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 time, only events before it",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only events with a greater id",
+                        "name": "after_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size, 100 by default and at most 1000",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid filter",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "not an admin",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/admin/identity-changes": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Lists the identity changes of every user, filtered by status and user",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "List identity changes",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "applied, pending, approved or rejected",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only the changes of this user",
+                        "name": "user_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid filter",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "not an admin",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/admin/identity-changes/{id}/approve": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Applies a pending identity change to the user",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Approve an identity change",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Identity change ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid identity change id",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "not an admin",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "identity change not found",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "identity change was already decided",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/admin/identity-changes/{id}/reject": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Rejects a pending identity change, the user keeps the stored value",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Reject an identity change",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Identity change ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid identity change id",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "not an admin",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "identity change not found",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "identity change was already decided",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/admin/oauth-clients": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Lists the apps that delegate their login to this service",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "List OAuth clients",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "not an admin",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Registers an app that delegates its login to this service. The client_secret of a\nconfidential client is only returned here, public clients get none and must use PKCE.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Register an OAuth client",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "language of the validation messages (en, es, fr, de)",
+                        "name": "Accept-Language",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Client",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/userauth.OAuthClientRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "request body is not valid JSON",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "not an admin",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid fields",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/admin/oauth-clients/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Deletes a client with the consents given to it, its access tokens stop working",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Delete an OAuth client",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Client ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "not an admin",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "client not found",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/admin/users/{id}/history": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Lists the changes of the profile of a user, newest version first",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "List the profile history of a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid user id",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "not an admin",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/admin/users/{id}/history/{version}/revert": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Restores the profile of a user to how it was at a version of its history. The revert is\nrecorded as a new version, identity fields are applied without approval.",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Revert a profile",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Profile version to restore",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "history entry of the revert",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid user id or version",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "not an admin",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "user or profile version not found",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "the recorded address is not valid anymore",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/api-keys": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Lists the API keys of the logged in user including the expired ones, newest first",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "API keys"
+                ],
+                "summary": "List API keys",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Creates a personal access token for scripts, send it in the X-API-Key header instead of a Bearer token.\nA key only works on the routes of its scopes and expires after expires_in_days, 90 by default.\nThe key is only returned here.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "API keys"
+                ],
+                "summary": "Create an API key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "language of the validation messages (en, es, fr, de)",
+                        "name": "Accept-Language",
+                        "in": "header"
+                    },
+                    {
+                        "description": "API key",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/userauth.APIKeyRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "request body is not valid JSON",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "too many API keys",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid fields",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/api-keys/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Deletes an API key of the logged in user, requests made with it are rejected from then on",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "API keys"
+                ],
+                "summary": "Revoke an API key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "API key ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "API key not found",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/genders": {
+            "get": {
+                "description": "Lists the genders offered to users in the order they should be shown.\nA self_described gender needs a gender_description.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Profile"
+                ],
+                "summary": "List gender options",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
         "/applicant/external/v1/login": {
             "post": {
-                "description": "Authenticate a user with email and password and returns JWT Token",
+                "description": "Authenticate a user with email and password and returns JWT Token",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Login user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "language of the validation messages (en, es, fr, de)",
+                        "name": "Accept-Language",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Login Credentials",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/userauth.UserLoginRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "login success with token",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid fields",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/login-history": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Lists every successful login of the logged in user with its time, IP, user agent and device, newest first",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Sessions"
+                ],
+                "summary": "Login history",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/login/magic-link": {
+            "post": {
+                "description": "Emails a link to log in without a password. The link opens MAGIC_LINK_URL with a token\nto post to /login/magic-link/consume from the same browser, the magic_link_device cookie\nset here binds the link to it. The response is the same whether the email is registered\nor not, at most 3 links are sent to a user every 15 minutes.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Email a login link",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "language of the validation messages (en, es, fr, de)",
+                        "name": "Accept-Language",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Email",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/userauth.MagicLinkRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "request body is not valid JSON",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid fields",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/login/magic-link/consume": {
+            "post": {
+                "description": "Logs in with the token of a link from /login/magic-link and returns the same token as /login.\nA link works once and only with the magic_link_device cookie of the browser that asked for it.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Log in with an emailed link",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "language of the validation messages (en, es, fr, de)",
+                        "name": "Accept-Language",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Token of the link",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/userauth.MagicLinkLogin"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "login success with token",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "request body is not valid JSON",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "link invalid, expired, used or opened in another browser",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid fields",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/oauth/.well-known/openid-configuration": {
+            "get": {
+                "description": "The metadata of the authorization server",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "OAuth"
+                ],
+                "summary": "OpenID Connect discovery",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.OAuthServerMetadata"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/oauth/authorize": {
+            "get": {
+                "description": "Shows the login screen, and the consent screen for scopes the user did not allow the\nclient yet, then redirects to the redirect_uri with a code. PKCE with S256 is required\nfor public clients. Errors are sent to the redirect_uri once it is known to be registered.",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "produces": [
+                    "text/html"
+                ],
+                "tags": [
+                    "OAuth"
+                ],
+                "summary": "Authorization endpoint",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "code",
+                        "name": "response_type",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client ID",
+                        "name": "client_id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "registered redirect URI",
+                        "name": "redirect_uri",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "space separated scopes, openid for an ID token",
+                        "name": "scope",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "returned to the client",
+                        "name": "state",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "put in the ID token",
+                        "name": "nonce",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "PKCE challenge",
+                        "name": "code_challenge",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "S256",
+                        "name": "code_challenge_method",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "login or consent screen",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "303": {
+                        "description": "redirect to the client"
+                    },
+                    "400": {
+                        "description": "invalid client or redirect_uri",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Shows the login screen, and the consent screen for scopes the user did not allow the\nclient yet, then redirects to the redirect_uri with a code. PKCE with S256 is required\nfor public clients. Errors are sent to the redirect_uri once it is known to be registered.",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "produces": [
+                    "text/html"
+                ],
+                "tags": [
+                    "OAuth"
+                ],
+                "summary": "Authorization endpoint",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "code",
+                        "name": "response_type",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client ID",
+                        "name": "client_id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "registered redirect URI",
+                        "name": "redirect_uri",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "space separated scopes, openid for an ID token",
+                        "name": "scope",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "returned to the client",
+                        "name": "state",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "put in the ID token",
+                        "name": "nonce",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "PKCE challenge",
+                        "name": "code_challenge",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "S256",
+                        "name": "code_challenge_method",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "login or consent screen",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "303": {
+                        "description": "redirect to the client"
+                    },
+                    "400": {
+                        "description": "invalid client or redirect_uri",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/oauth/jwks": {
+            "get": {
+                "description": "The public keys ID and access tokens are signed with",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "OAuth"
+                ],
+                "summary": "Signing keys",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.JSONWebKeySet"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/oauth/token": {
+            "post": {
+                "description": "Exchanges an authorization code for an access token, and an ID token for the openid\nscope. Confidential clients authenticate with HTTP basic or client_secret in the form.",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "OAuth"
+                ],
+                "summary": "Token endpoint",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "authorization_code",
+                        "name": "grant_type",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "authorization code",
+                        "name": "code",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "redirect URI the code was sent to",
+                        "name": "redirect_uri",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "PKCE verifier",
+                        "name": "code_verifier",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client ID, unless sent with HTTP basic",
+                        "name": "client_id",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "client secret, unless sent with HTTP basic",
+                        "name": "client_secret",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.TokenResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.OAuthError"
+                        }
+                    },
+                    "401": {
+                        "description": "client authentication failed",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.OAuthError"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/oauth/userinfo": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the claims of the user the access token's scopes reveal",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "OAuth"
+                ],
+                "summary": "UserInfo endpoint",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "missing or invalid access token",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.OAuthError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the claims of the user the access token's scopes reveal",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "OAuth"
+                ],
+                "summary": "UserInfo endpoint",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "missing or invalid access token",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.OAuthError"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/oidc/providers": {
+            "get": {
+                "description": "Lists the OpenID Connect providers users can log in with",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "OIDC"
+                ],
+                "summary": "List identity providers",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/oidc/{provider}/callback": {
+            "get": {
+                "description": "Completes a login or link started with the provider. A login returns the token,\ncreated tells whether the user was registered. A link returns the linked identity.",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "OIDC"
+                ],
+                "summary": "Identity provider callback",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Provider name",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "authorization code",
+                        "name": "code",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "state sent to the provider",
+                        "name": "state",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "error returned by the provider",
+                        "name": "error",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "sign in failed or the callback does not match the login started",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "provider not found",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "an account with the email exists, or the identity is linked to another user",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "502": {
+                        "description": "provider could not be reached",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/oidc/{provider}/login": {
+            "get": {
+                "description": "Redirects to the provider to sign in, the provider redirects back to the callback.\nUsers who never logged in with the provider are registered, unless their email is taken.",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "OIDC"
+                ],
+                "summary": "Log in with an identity provider",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Provider name",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "302": {
+                        "description": "Found"
+                    },
+                    "404": {
+                        "description": "provider not found",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "502": {
+                        "description": "provider could not be reached",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/password": {
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Checks the current password and stores the new one if it satisfies the password policy",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Change password",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "language of the validation messages (en, es, fr, de)",
+                        "name": "Accept-Language",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Current and new password",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/userauth.ChangePasswordRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "request body is not valid JSON",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "current password is wrong",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "new password violates the password policy",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/profile": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get the user profile info",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Profile"
+                ],
+                "summary": "Get user Profile",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "profile version, send it back in If-Match when patching"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "user not found",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Updates the user profile info. Identity fields that need admin approval are\nrecorded as pending changes and the response is 202 listing them.\nThe body is a JSON merge patch (RFC 7386), null clears a field and absent\nmembers are left as they are. A JSON patch (RFC 6902) is accepted too.",
+                "consumes": [
+                    "application/json",
+                    "application/merge-patch+json",
+                    "application/json-patch+json"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Profile"
+                ],
+                "summary": "Update user profile",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "ETag returned by GET /profile, or * to skip the check",
+                        "name": "If-Match",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "language of the validation messages (en, es, fr, de)",
+                        "name": "Accept-Language",
+                        "in": "header"
+                    },
+                    {
+                        "description": "User Information Request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/userauth.UserInformationRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "new profile version"
+                            }
+                        }
+                    },
+                    "202": {
+                        "description": "pending_changes wait for an admin",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "new profile version"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "user not found",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "a change of the field is already awaiting approval, or a JSON patch operation does not apply",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "profile was modified since it was read",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "413": {
+                        "description": "patch is larger than 64 KiB",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "415": {
+                        "description": "unsupported patch format",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid fields",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "428": {
+                        "description": "If-Match header is required",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/profile/addresses": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Lists the addresses of the logged in user",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Addresses"
+                ],
+                "summary": "List addresses",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Adds an address to the logged in user, the first home or mailing address becomes primary",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Addresses"
+                ],
+                "summary": "Add an address",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "language of the validation messages (en, es, fr, de)",
+                        "name": "Accept-Language",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Address",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/userauth.AddressRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "request body is not valid JSON",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "another address was made primary concurrently",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid fields",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/profile/addresses/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Gets one address of the logged in user",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Addresses"
+                ],
+                "summary": "Get an address",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Address ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid address id",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "address not found",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Replaces every field of an address of the logged in user, is_primary demotes the current primary address",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Addresses"
+                ],
+                "summary": "Replace an address",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Address ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "language of the validation messages (en, es, fr, de)",
+                        "name": "Accept-Language",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Address",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/userauth.AddressRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid address id or JSON",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "address not found",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "another address was made primary concurrently",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid fields",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Removes an address of the logged in user, removing the primary address promotes the oldest remaining one",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Addresses"
+                ],
+                "summary": "Remove an address",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Address ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "invalid address id",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "address not found",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/profile/history": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Lists the changes of the profile of the logged in user, newest version first.\nEvery entry has the changed fields with their old and new values and the profile as it was afterwards.",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Profile"
+                ],
+                "summary": "List own profile history",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/profile/identities": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Lists the identity providers the logged in user can log in with",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "OIDC"
+                ],
+                "summary": "List linked identity providers",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/profile/identities/{provider}": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Starts signing in with the provider to link it to the logged in user. The client\nopens authorization_url, the provider redirects to the callback which links it.",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "OIDC"
+                ],
+                "summary": "Link an identity provider",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Provider name",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "provider not found",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "502": {
+                        "description": "provider could not be reached",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stops the logged in user from logging in with the provider. The last provider\nof a user without a password can't be unlinked.",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "OIDC"
+                ],
+                "summary": "Unlink an identity provider",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Provider name",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "provider is not linked",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "user has no password and no other provider",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/profile/identity-changes": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Lists the history of name, dob and gender changes of the logged in user",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Profile"
+                ],
+                "summary": "List own identity changes",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/profile/vehicles": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Lists the vehicles of the logged in user",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Vehicles"
+                ],
+                "summary": "List vehicles",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Adds a vehicle to the logged in user",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/problem+json"
                 ],
                 "tags": [
-                    "Auth"
+                    "Vehicles"
                 ],
-                "summary": "Login user",
+                "summary": "Add a vehicle",
                 "parameters": [
                     {
-                        "description": "Login Credentials",
+                        "type": "string",
+                        "description": "language of the validation messages (en, es, fr, de)",
+                        "name": "Accept-Language",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Vehicle",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/userauth.UserLoginRequest"
+                            "$ref": "#/definitions/userauth.VehicleRequest"
                         }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "login success with token",
+                    "201": {
+                        "description": "Created",
                         "schema": {
                             "type": "object",
                             "additionalProperties": true
                         }
                     },
                     "400": {
-                        "description": "invalid request",
+                        "description": "request body is not valid JSON",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/userauth.Problem"
                         }
                     },
                     "401": {
-                        "description": "unauthorized",
+                        "description": "Unauthorized",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid fields",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
                         }
                     }
                 }
             }
         },
-        "/applicant/external/v1/profile": {
+        "/applicant/external/v1/profile/vehicles/{id}": {
             "get": {
                 "security": [
                     {
                         "BearerAuth": []
+                    },
+                    {
+                        "ApiKeyAuth": []
                     }
                 ],
-                "description": "Get the user profile info",
+                "description": "Gets one vehicle of the logged in user",
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/problem+json"
                 ],
                 "tags": [
-                    "Profile"
+                    "Vehicles"
+                ],
+                "summary": "Get a vehicle",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Vehicle ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
-                "summary": "Get user Profile",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -96,46 +2364,67 @@ const docTemplate = `{
                         }
                     },
                     "400": {
-                        "description": "Bad Request",
+                        "description": "invalid vehicle id",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": true
+                            "$ref": "#/definitions/userauth.Problem"
                         }
                     },
                     "401": {
                         "description": "Unauthorized",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": true
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "vehicle not found",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
                         }
                     }
                 }
             },
-            "patch": {
+            "put": {
                 "security": [
                     {
                         "BearerAuth": []
+                    },
+                    {
+                        "ApiKeyAuth": []
                     }
                 ],
-                "description": "Updates the user profile info",
+                "description": "Replaces every field of a vehicle of the logged in user",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/problem+json"
                 ],
                 "tags": [
-                    "Profile"
+                    "Vehicles"
                 ],
-                "summary": "Update user profile",
+                "summary": "Replace a vehicle",
                 "parameters": [
                     {
-                        "description": "User Information Request",
+                        "type": "integer",
+                        "description": "Vehicle ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "language of the validation messages (en, es, fr, de)",
+                        "name": "Accept-Language",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Vehicle",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/userauth.UserInformationRequest"
+                            "$ref": "#/definitions/userauth.VehicleRequest"
                         }
                     }
                 ],
@@ -148,17 +2437,84 @@ const docTemplate = `{
                         }
                     },
                     "400": {
-                        "description": "Bad Request",
+                        "description": "invalid vehicle id or JSON",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": true
+                            "$ref": "#/definitions/userauth.Problem"
                         }
                     },
                     "401": {
                         "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "vehicle not found",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid fields",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    },
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Removes a vehicle of the logged in user",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Vehicles"
+                ],
+                "summary": "Remove a vehicle",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Vehicle ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
                         "schema": {
                             "type": "object",
-                            "additionalProperties": true
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "invalid vehicle id",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "vehicle not found",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
                         }
                     }
                 }
@@ -171,21 +2527,117 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Auth"
+                ],
+                "summary": "Register a new user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "language of the validation messages (en, es, fr, de)",
+                        "name": "Accept-Language",
+                        "in": "header"
+                    },
+                    {
+                        "description": "UserRegisterRequest",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/userauth.UserRegisterRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "request body is not valid JSON",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "email already registered",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid fields",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/sessions": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Lists where the logged in user is logged in, the session of the token used is marked as current",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
+                ],
+                "tags": [
+                    "Sessions"
+                ],
+                "summary": "List active sessions",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/applicant/external/v1/sessions/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Logs the user out of a session, its token is rejected from then on. Revoking the current session logs out.",
+                "produces": [
+                    "application/json",
+                    "application/problem+json"
                 ],
                 "tags": [
-                    "Auth"
+                    "Sessions"
                 ],
-                "summary": "Register a new user",
+                "summary": "Revoke a session",
                 "parameters": [
                     {
-                        "description": "UserRegisterRequest",
-                        "name": "request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/userauth.UserRegisterRequest"
-                        }
+                        "type": "string",
+                        "description": "Session ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
@@ -198,11 +2650,16 @@ const docTemplate = `{
                             }
                         }
                     },
-                    "400": {
-                        "description": "Bad Request",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": true
+                            "$ref": "#/definitions/userauth.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "session not found or already revoked",
+                        "schema": {
+                            "$ref": "#/definitions/userauth.Problem"
                         }
                     }
                 }
@@ -213,6 +2670,9 @@ const docTemplate = `{
                 "security": [
                     {
                         "BearerAuth": []
+                    },
+                    {
+                        "ApiKeyAuth": []
                     }
                 ],
                 "description": "Accepts email and PDF file to upload and store it.",
@@ -220,7 +2680,8 @@ const docTemplate = `{
                     "multipart/form-data"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/problem+json"
                 ],
                 "tags": [
                     "Applicant"
@@ -255,19 +2716,13 @@ const docTemplate = `{
                     "400": {
                         "description": "bad request or invalid file",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/userauth.Problem"
                         }
                     },
                     "500": {
                         "description": "internal server error",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/userauth.Problem"
                         }
                     }
                 }
@@ -275,58 +2730,469 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "userauth.APIKeyRequest": {
+            "type": "object",
+            "required": [
+                "name",
+                "scopes"
+            ],
+            "properties": {
+                "expires_in_days": {
+                    "description": "ExpiresInDays defaults to 90",
+                    "type": "integer",
+                    "maximum": 365,
+                    "minimum": 1,
+                    "example": 30
+                },
+                "name": {
+                    "type": "string",
+                    "maxLength": 100,
+                    "example": "nightly export"
+                },
+                "scopes": {
+                    "type": "array",
+                    "minItems": 1,
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "profile:read",
+                        "vehicles:read"
+                    ]
+                }
+            }
+        },
         "userauth.Address": {
             "type": "object",
             "properties": {
                 "city": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 100
                 },
                 "country": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 100
                 },
                 "postal_code": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 20
+                },
+                "state": {
+                    "type": "string",
+                    "maxLength": 100
+                }
+            }
+        },
+        "userauth.AddressRequest": {
+            "type": "object",
+            "required": [
+                "type"
+            ],
+            "properties": {
+                "city": {
+                    "type": "string",
+                    "maxLength": 100
+                },
+                "country": {
+                    "type": "string",
+                    "maxLength": 100
+                },
+                "is_primary": {
+                    "description": "IsPrimary makes this the profile address, the previous primary one is demoted",
+                    "type": "boolean",
+                    "example": true
+                },
+                "postal_code": {
+                    "type": "string",
+                    "maxLength": 20
                 },
                 "state": {
+                    "type": "string",
+                    "maxLength": 100
+                },
+                "type": {
+                    "enum": [
+                        "home",
+                        "mailing",
+                        "previous"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/userauth.AddressType"
+                        }
+                    ],
+                    "example": "home"
+                },
+                "valid_from": {
+                    "type": "string",
+                    "example": "2018-04-01"
+                },
+                "valid_until": {
+                    "description": "ValidUntil is empty while the user still lives at the address",
+                    "type": "string",
+                    "example": "2022-03-31"
+                }
+            }
+        },
+        "userauth.AddressType": {
+            "type": "string",
+            "enum": [
+                "home",
+                "mailing",
+                "previous"
+            ],
+            "x-enum-varnames": [
+                "AddressHome",
+                "AddressMailing",
+                "AddressPrevious"
+            ]
+        },
+        "userauth.ChangePasswordRequest": {
+            "type": "object",
+            "required": [
+                "new_password"
+            ],
+            "properties": {
+                "current_password": {
+                    "description": "CurrentPassword may be left out by users who signed up with an identity\nprovider and have not set a password yet",
+                    "type": "string",
+                    "maxLength": 1024,
+                    "example": "Password@123"
+                },
+                "new_password": {
+                    "description": "NewPassword is checked against the PasswordPolicy by the service",
+                    "type": "string",
+                    "example": "N3w-Password"
+                }
+            }
+        },
+        "userauth.FieldError": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "type": "string",
+                    "example": "email"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "invalid email format"
+                }
+            }
+        },
+        "userauth.JSONWebKey": {
+            "type": "object",
+            "properties": {
+                "alg": {
+                    "type": "string"
+                },
+                "crv": {
+                    "type": "string"
+                },
+                "e": {
+                    "type": "string"
+                },
+                "kid": {
+                    "type": "string"
+                },
+                "kty": {
+                    "type": "string"
+                },
+                "n": {
+                    "type": "string"
+                },
+                "use": {
+                    "type": "string"
+                },
+                "x": {
+                    "type": "string"
+                },
+                "y": {
                     "type": "string"
                 }
             }
         },
-        "userauth.UserInformationRequest": {
+        "userauth.JSONWebKeySet": {
             "type": "object",
             "properties": {
-                "address": {
-                    "$ref": "#/definitions/userauth.Address"
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/userauth.JSONWebKey"
+                    }
+                }
+            }
+        },
+        "userauth.MagicLinkLogin": {
+            "type": "object",
+            "required": [
+                "token"
+            ],
+            "properties": {
+                "token": {
+                    "type": "string",
+                    "maxLength": 2048
+                }
+            }
+        },
+        "userauth.MagicLinkRequest": {
+            "type": "object",
+            "required": [
+                "email"
+            ],
+            "properties": {
+                "email": {
+                    "type": "string",
+                    "maxLength": 255,
+                    "example": "user@example.com"
+                }
+            }
+        },
+        "userauth.OAuthClientRequest": {
+            "type": "object",
+            "required": [
+                "name",
+                "redirect_uris"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string",
+                    "maxLength": 100,
+                    "example": "Payroll"
+                },
+                "public": {
+                    "description": "Public clients, like single page and mobile apps, get no secret and have to use PKCE",
+                    "type": "boolean",
+                    "example": false
+                },
+                "redirect_uris": {
+                    "type": "array",
+                    "maxItems": 10,
+                    "minItems": 1,
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "https://payroll.example.com/callback"
+                    ]
                 },
-                "created_at": {
+                "scopes": {
+                    "description": "Scopes the client may ask for, openid is always allowed",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "profile",
+                        "email"
+                    ]
+                }
+            }
+        },
+        "userauth.OAuthError": {
+            "type": "object",
+            "properties": {
+                "error": {
                     "type": "string"
                 },
-                "id": {
+                "error_description": {
+                    "type": "string"
+                }
+            }
+        },
+        "userauth.OAuthServerMetadata": {
+            "type": "object",
+            "properties": {
+                "authorization_endpoint": {
+                    "type": "string"
+                },
+                "claims_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "code_challenge_methods_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "grant_types_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "id_token_signing_alg_values_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "issuer": {
+                    "type": "string"
+                },
+                "jwks_uri": {
+                    "type": "string"
+                },
+                "response_types_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "scopes_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "subject_types_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "token_endpoint": {
+                    "type": "string"
+                },
+                "token_endpoint_auth_methods_supported": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "userinfo_endpoint": {
+                    "type": "string"
+                }
+            }
+        },
+        "userauth.Problem": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "user_not_found"
+                },
+                "detail": {
+                    "type": "string",
+                    "example": "user not found"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/userauth.FieldError"
+                    }
+                },
+                "instance": {
+                    "type": "string",
+                    "example": "/applicant/external/v1/profile"
+                },
+                "request_id": {
+                    "type": "string",
+                    "example": "4bf92f3577b34da6a3ce929d0e0e4736"
+                },
+                "status": {
+                    "type": "integer",
+                    "example": 404
+                },
+                "title": {
+                    "type": "string",
+                    "example": "Not Found"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "/problems/user_not_found"
+                }
+            }
+        },
+        "userauth.TokenResponse": {
+            "type": "object",
+            "properties": {
+                "access_token": {
+                    "type": "string"
+                },
+                "expires_in": {
                     "type": "integer"
                 },
-                "updated_at": {
+                "id_token": {
+                    "type": "string"
+                },
+                "scope": {
                     "type": "string"
                 },
-                "vehicle": {
-                    "$ref": "#/definitions/userauth.Vehicle"
+                "token_type": {
+                    "type": "string"
+                }
+            }
+        },
+        "userauth.UserInformationRequest": {
+            "type": "object",
+            "required": [
+                "dob",
+                "first_name",
+                "gender"
+            ],
+            "properties": {
+                "address": {
+                    "$ref": "#/definitions/userauth.Address"
+                },
+                "dob": {
+                    "type": "string",
+                    "example": "2000-01-01"
+                },
+                "first_name": {
+                    "description": "The identity fields follow the UserRegisterRequest rules",
+                    "type": "string",
+                    "maxLength": 100,
+                    "minLength": 2,
+                    "example": "Abhishek"
+                },
+                "gender": {
+                    "type": "string",
+                    "example": "female"
+                },
+                "gender_description": {
+                    "description": "GenderDescription follows the UserRegisterRequest rules",
+                    "type": "string",
+                    "maxLength": 64,
+                    "example": "genderfluid"
+                },
+                "last_name": {
+                    "type": "string",
+                    "maxLength": 100,
+                    "example": "Verma"
                 }
             }
         },
         "userauth.UserLoginRequest": {
             "type": "object",
+            "required": [
+                "email",
+                "password"
+            ],
             "properties": {
                 "email": {
                     "type": "string",
+                    "maxLength": 255,
                     "example": "user@example.com"
                 },
                 "password": {
                     "type": "string",
+                    "maxLength": 1024,
                     "example": "securePassword123"
                 }
             }
         },
         "userauth.UserRegisterRequest": {
             "type": "object",
+            "required": [
+                "dob",
+                "email",
+                "first_name",
+                "gender",
+                "password"
+            ],
             "properties": {
                 "dob": {
                     "type": "string",
@@ -334,15 +3200,24 @@ const docTemplate = `{
                 },
                 "email": {
                     "type": "string",
+                    "maxLength": 255,
                     "example": "abhishek@example.com"
                 },
                 "first_name": {
                     "type": "string",
+                    "maxLength": 100,
+                    "minLength": 2,
                     "example": "Abhishek"
                 },
                 "gender": {
                     "type": "string",
-                    "example": "male/female"
+                    "example": "female"
+                },
+                "gender_description": {
+                    "description": "GenderDescription is the free text of a self_described gender, it is\ndropped for the other genders",
+                    "type": "string",
+                    "maxLength": 64,
+                    "example": "genderfluid"
                 },
                 "id": {
                     "description": "optional",
@@ -351,27 +3226,93 @@ const docTemplate = `{
                 },
                 "last_name": {
                     "type": "string",
+                    "maxLength": 100,
                     "example": "Verma"
                 },
                 "password": {
+                    "description": "Password is checked against the PasswordPolicy by the service",
                     "type": "string",
                     "example": "Password@123"
                 }
             }
         },
-        "userauth.Vehicle": {
+        "userauth.VehicleRequest": {
             "type": "object",
+            "required": [
+                "type"
+            ],
             "properties": {
-                "bike": {
-                    "type": "boolean"
+                "make": {
+                    "type": "string",
+                    "maxLength": 100,
+                    "example": "Maruti Suzuki"
+                },
+                "model": {
+                    "type": "string",
+                    "maxLength": 100,
+                    "example": "Swift"
+                },
+                "owned_from": {
+                    "type": "string",
+                    "example": "2019-06-01"
+                },
+                "owned_until": {
+                    "description": "OwnedUntil is empty while the user still owns the vehicle",
+                    "type": "string",
+                    "example": "2023-01-31"
+                },
+                "registration_number": {
+                    "type": "string",
+                    "maxLength": 20,
+                    "example": "MH12AB1234"
+                },
+                "type": {
+                    "enum": [
+                        "car",
+                        "bike",
+                        "truck",
+                        "scooter",
+                        "other"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/userauth.VehicleType"
+                        }
+                    ],
+                    "example": "car"
                 },
-                "car": {
-                    "type": "boolean"
+                "year": {
+                    "type": "integer",
+                    "maximum": 2100,
+                    "minimum": 1886,
+                    "example": 2019
                 }
             }
+        },
+        "userauth.VehicleType": {
+            "type": "string",
+            "enum": [
+                "car",
+                "bike",
+                "truck",
+                "scooter",
+                "other"
+            ],
+            "x-enum-varnames": [
+                "VehicleCar",
+                "VehicleBike",
+                "VehicleTruck",
+                "VehicleScooter",
+                "VehicleOther"
+            ]
         }
     },
     "securityDefinitions": {
+        "ApiKeyAuth": {
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
+        },
         "BearerAuth": {
             "type": "apiKey",
             "name": "Authorization",
@@ -0,0 +1 @@
+ALTER TABLE user_information DROP COLUMN IF EXISTS version;
@@ -0,0 +1 @@
+ALTER TABLE user_information ADD COLUMN version INT NOT NULL DEFAULT 1;
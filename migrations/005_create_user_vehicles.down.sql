@@ -0,0 +1,14 @@
+ALTER TABLE user_information ADD COLUMN vehicle JSONB;
+
+-- fold the vehicles back into the car and bike flags, details are lost
+INSERT INTO user_information (user_id)
+SELECT DISTINCT user_id FROM user_vehicles
+ON CONFLICT (user_id) DO NOTHING;
+
+UPDATE user_information ui
+SET vehicle = jsonb_build_object(
+    'car', EXISTS (SELECT 1 FROM user_vehicles v WHERE v.user_id = ui.user_id AND v.type = 'car'),
+    'bike', EXISTS (SELECT 1 FROM user_vehicles v WHERE v.user_id = ui.user_id AND v.type = 'bike')
+);
+
+DROP TABLE IF EXISTS user_vehicles;
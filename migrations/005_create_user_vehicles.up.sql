@@ -0,0 +1,26 @@
+CREATE TABLE user_vehicles (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(20) NOT NULL,
+    make VARCHAR(100),
+    model VARCHAR(100),
+    year INT,
+    registration_number VARCHAR(20),
+    owned_from DATE,
+    owned_until DATE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT user_vehicles_type_check CHECK (type IN ('car', 'bike', 'truck', 'scooter', 'other')),
+    CONSTRAINT user_vehicles_ownership_check CHECK (owned_until IS NULL OR owned_from IS NULL OR owned_until >= owned_from)
+);
+
+CREATE INDEX user_vehicles_user_id_idx ON user_vehicles (user_id);
+
+-- every car or bike flag that was set becomes a vehicle with only its type known
+INSERT INTO user_vehicles (user_id, type)
+SELECT user_id, 'car' FROM user_information WHERE (vehicle->>'car')::BOOLEAN;
+
+INSERT INTO user_vehicles (user_id, type)
+SELECT user_id, 'bike' FROM user_information WHERE (vehicle->>'bike')::BOOLEAN;
+
+ALTER TABLE user_information DROP COLUMN vehicle;
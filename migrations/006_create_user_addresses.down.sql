@@ -0,0 +1,18 @@
+ALTER TABLE user_information ADD COLUMN address JSONB;
+
+-- only the primary address is kept, the other addresses are lost
+INSERT INTO user_information (user_id)
+SELECT user_id FROM user_addresses WHERE is_primary
+ON CONFLICT (user_id) DO NOTHING;
+
+UPDATE user_information ui
+SET address = jsonb_build_object(
+    'city', a.city,
+    'state', a.state,
+    'postal_code', a.postal_code,
+    'country', a.country
+)
+FROM user_addresses a
+WHERE a.user_id = ui.user_id AND a.is_primary;
+
+DROP TABLE IF EXISTS user_addresses;
@@ -0,0 +1,31 @@
+CREATE TABLE user_addresses (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(20) NOT NULL,
+    city VARCHAR(100),
+    state VARCHAR(100),
+    postal_code VARCHAR(20),
+    country VARCHAR(100),
+    valid_from DATE,
+    valid_until DATE,
+    is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT user_addresses_type_check CHECK (type IN ('home', 'mailing', 'previous')),
+    CONSTRAINT user_addresses_validity_check CHECK (valid_until IS NULL OR valid_from IS NULL OR valid_until >= valid_from),
+    CONSTRAINT user_addresses_primary_check CHECK (NOT is_primary OR type <> 'previous')
+);
+
+CREATE INDEX user_addresses_user_id_idx ON user_addresses (user_id);
+CREATE UNIQUE INDEX user_addresses_primary_key ON user_addresses (user_id) WHERE is_primary;
+
+-- the single profile address becomes the primary home address
+INSERT INTO user_addresses (user_id, type, city, state, postal_code, country, is_primary, created_at, updated_at)
+SELECT
+    user_id, 'home',
+    address->>'city', address->>'state', address->>'postal_code', address->>'country',
+    TRUE, COALESCE(created_at, CURRENT_TIMESTAMP), COALESCE(updated_at, CURRENT_TIMESTAMP)
+FROM user_information
+WHERE address IS NOT NULL AND address <> 'null'::JSONB;
+
+ALTER TABLE user_information DROP COLUMN address;
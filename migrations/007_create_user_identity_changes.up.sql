@@ -0,0 +1,17 @@
+CREATE TABLE user_identity_changes (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    field VARCHAR(20) NOT NULL,
+    old_value VARCHAR(100) NOT NULL,
+    new_value VARCHAR(100) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    requested_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    decided_at TIMESTAMP,
+    decided_by VARCHAR(255),
+    CONSTRAINT user_identity_changes_field_check CHECK (field IN ('first_name', 'last_name', 'dob', 'gender')),
+    CONSTRAINT user_identity_changes_status_check CHECK (status IN ('applied', 'pending', 'approved', 'rejected'))
+);
+
+CREATE INDEX user_identity_changes_user_id_idx ON user_identity_changes (user_id);
+-- a field has at most one change awaiting approval
+CREATE UNIQUE INDEX user_identity_changes_pending_key ON user_identity_changes (user_id, field) WHERE status = 'pending';
@@ -0,0 +1,14 @@
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_gender_description_check;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_gender_check;
+
+-- only female and male have a value in the old M/F scheme, the other genders
+-- are left empty rather than guessed
+UPDATE users SET gender = CASE gender WHEN 'female' THEN 'F' WHEN 'male' THEN 'M' ELSE '' END;
+
+UPDATE user_identity_changes SET
+    old_value = CASE old_value WHEN 'female' THEN 'F' WHEN 'male' THEN 'M' ELSE '' END,
+    new_value = CASE new_value WHEN 'female' THEN 'F' WHEN 'male' THEN 'M' ELSE '' END
+WHERE field = 'gender';
+
+ALTER TABLE users DROP COLUMN IF EXISTS gender_description;
+ALTER TABLE users ALTER COLUMN gender TYPE VARCHAR(10);
@@ -0,0 +1,35 @@
+ALTER TABLE users ALTER COLUMN gender TYPE VARCHAR(20);
+ALTER TABLE users ADD COLUMN gender_description VARCHAR(64) NOT NULL DEFAULT '';
+
+-- M and F keep their meaning. The old S option can't be mapped to how the
+-- user identifies without asking them, it becomes prefer_not_to_say like any
+-- value the old validator should never have let through.
+UPDATE users SET gender = CASE UPPER(gender)
+    WHEN 'F' THEN 'female'
+    WHEN 'FEMALE' THEN 'female'
+    WHEN 'M' THEN 'male'
+    WHEN 'MALE' THEN 'male'
+    ELSE 'prefer_not_to_say'
+END;
+
+UPDATE user_identity_changes SET
+    old_value = CASE UPPER(old_value)
+        WHEN 'F' THEN 'female'
+        WHEN 'FEMALE' THEN 'female'
+        WHEN 'M' THEN 'male'
+        WHEN 'MALE' THEN 'male'
+        ELSE 'prefer_not_to_say'
+    END,
+    new_value = CASE UPPER(new_value)
+        WHEN 'F' THEN 'female'
+        WHEN 'FEMALE' THEN 'female'
+        WHEN 'M' THEN 'male'
+        WHEN 'MALE' THEN 'male'
+        ELSE 'prefer_not_to_say'
+    END
+WHERE field = 'gender';
+
+ALTER TABLE users ADD CONSTRAINT users_gender_check
+    CHECK (gender IN ('female', 'male', 'non_binary', 'self_described', 'prefer_not_to_say'));
+ALTER TABLE users ADD CONSTRAINT users_gender_description_check
+    CHECK (gender = 'self_described' OR gender_description = '');
@@ -0,0 +1,15 @@
+CREATE TABLE user_profile_history (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    request_id VARCHAR(64),
+    -- the version an admin reverted the profile to
+    reverted_from INT,
+    changes JSONB NOT NULL,
+    profile JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- a profile version has at most one entry, newest first for GET /profile/history
+CREATE UNIQUE INDEX user_profile_history_version_key ON user_profile_history (user_id, version DESC);
@@ -0,0 +1,36 @@
+-- user_id has no foreign key so the log outlives the users it is about
+CREATE TABLE audit_events (
+    id SERIAL PRIMARY KEY,
+    occurred_at TIMESTAMP NOT NULL,
+    type VARCHAR(64) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    user_id INT,
+    ip VARCHAR(45),
+    request_id VARCHAR(128),
+    details JSONB NOT NULL,
+    -- empty for the first event
+    prev_hash VARCHAR(64) NOT NULL,
+    hash VARCHAR(64) NOT NULL,
+    -- every event links to a different one so the chain can't fork
+    CONSTRAINT audit_events_prev_hash_key UNIQUE (prev_hash),
+    CONSTRAINT audit_events_hash_key UNIQUE (hash)
+);
+
+CREATE INDEX audit_events_type_idx ON audit_events (type);
+CREATE INDEX audit_events_actor_idx ON audit_events (LOWER(actor));
+CREATE INDEX audit_events_user_id_idx ON audit_events (user_id);
+CREATE INDEX audit_events_occurred_at_idx ON audit_events (occurred_at);
+
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+CREATE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
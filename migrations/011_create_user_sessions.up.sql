@@ -0,0 +1,14 @@
+-- one row per login, revoked and expired sessions are kept as the login history
+CREATE TABLE user_sessions (
+    -- the jti claim of the tokens issued for the session
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    ip VARCHAR(45),
+    user_agent TEXT,
+    device VARCHAR(64) NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX user_sessions_user_id_idx ON user_sessions (user_id, created_at DESC);
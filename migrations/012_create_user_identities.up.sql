@@ -0,0 +1,13 @@
+-- accounts at OpenID Connect providers users log in with, a user can link
+-- one account per provider
+CREATE TABLE user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(64) NOT NULL,
+    -- the sub claim, unique per provider
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT user_identities_subject_key UNIQUE (provider, subject),
+    CONSTRAINT user_identities_provider_key UNIQUE (user_id, provider)
+);
@@ -0,0 +1,41 @@
+-- apps that delegate the login of their users to this service
+CREATE TABLE oauth_clients (
+    id VARCHAR(64) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    -- SHA-256 of the client secret, NULL for public clients
+    secret_hash VARCHAR(64),
+    -- space separated
+    redirect_uris TEXT NOT NULL,
+    scopes TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE oauth_consents (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    scopes TEXT NOT NULL,
+    granted_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, client_id)
+);
+
+CREATE TABLE oauth_authorization_codes (
+    -- SHA-256 of the code
+    code_hash VARCHAR(64) PRIMARY KEY,
+    client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scopes TEXT NOT NULL,
+    nonce VARCHAR(255),
+    code_challenge VARCHAR(128),
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+-- keys the ID and access tokens are signed with, rotated every OAUTH_KEY_ROTATION
+CREATE TABLE oauth_signing_keys (
+    id VARCHAR(64) PRIMARY KEY,
+    private_key TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX oauth_signing_keys_created_at_idx ON oauth_signing_keys (created_at DESC);
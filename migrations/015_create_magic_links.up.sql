@@ -0,0 +1,11 @@
+-- emailed login links, the signed token in a link carries the id. Used links
+-- are kept to throttle how many links a user gets.
+CREATE TABLE magic_links (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+CREATE INDEX magic_links_user_id_idx ON magic_links (user_id, created_at DESC);
@@ -1,25 +1,59 @@
 package bootserver
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
+
 	"github.com/abhiii71/clean-code-abhi/pkg/config"
+	"github.com/abhiii71/clean-code-abhi/pkg/logger"
 	userauth "github.com/abhiii71/clean-code-abhi/pkg/user_auth"
 	"github.com/gin-gonic/gin"
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 type ServerHttp struct {
-	engine *gin.Engine
+	engine         *gin.Engine
+	log            *slog.Logger
+	tracerProvider *sdktrace.TracerProvider
 }
 
-func NewServerHttp(userHandler userauth.Handler) *ServerHttp {
+// NewServerHttp builds the engine, the client IP of requests is read from
+// X-Forwarded-For only when they come from one of trustedProxies
+func NewServerHttp(userHandler userauth.Handler, trustedProxies []string, log *slog.Logger, tp *sdktrace.TracerProvider) (*ServerHttp, error) {
 	engine := gin.New()
+	// nil trusts no proxy, gin trusts every one by default
+	if len(trustedProxies) == 0 {
+		trustedProxies = nil
+	}
+	if err := engine.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, fmt.Errorf("set trusted proxies: %w", err)
+	}
+	engine.Use(
+		otelgin.Middleware("http-server", otelgin.WithTracerProvider(tp)),
+		logger.RequestID(),
+		logger.AccessLog(log),
+		gin.Recovery(),
+	)
 	engine.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerfiles.Handler))
 	userHandler.MountRoutes(engine)
 
-	return &ServerHttp{engine}
+	return &ServerHttp{engine, log, tp}, nil
 }
 
 func (s *ServerHttp) Start(conf config.Config) {
-	s.engine.Run(conf.Host + ":" + conf.ServerPort)
+	defer func() {
+		if err := s.tracerProvider.Shutdown(context.Background()); err != nil {
+			s.log.Error("failed to flush traces", "err", err)
+		}
+	}()
+
+	addr := conf.Host + ":" + conf.ServerPort
+	s.log.Info("starting http server", "addr", addr)
+	if err := s.engine.Run(addr); err != nil {
+		s.log.Error("http server stopped", "err", err)
+	}
 }
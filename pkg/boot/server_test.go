@@ -0,0 +1,91 @@
+package bootserver
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/abhiii71/clean-code-abhi/pkg/tracing"
+	userauth "github.com/abhiii71/clean-code-abhi/pkg/user_auth"
+	"github.com/gin-gonic/gin"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestClientIPTrustsOnlyConfiguredProxies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		remoteAddr     string
+		want           string
+	}{
+		{"no proxies configured", nil, "203.0.113.7:1234", "203.0.113.7"},
+		{"request from a trusted proxy", []string{"10.0.0.0/8"}, "10.1.2.3:1234", "198.51.100.1"},
+		{"request from another host", []string{"10.0.0.0/8"}, "203.0.113.7:1234", "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewServerHttp(userauth.Handler{}, tt.trustedProxies, log, sdktrace.NewTracerProvider())
+			if err != nil {
+				t.Fatalf("NewServerHttp: %v", err)
+			}
+			s.engine.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+			req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-For", "198.51.100.1")
+			rec := httptest.NewRecorder()
+			s.engine.ServeHTTP(rec, req)
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("got client IP %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewServerHttp(userauth.Handler{}, []string{"not-an-ip"}, log, sdktrace.NewTracerProvider()); err == nil {
+		t.Error("an invalid proxy was accepted")
+	}
+}
+
+func TestRequestSpans(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tp, exporter := tracing.NewInMemory()
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+
+	s, err := NewServerHttp(userauth.Handler{}, nil, log, tp)
+	if err != nil {
+		t.Fatalf("NewServerHttp: %v", err)
+	}
+	s.engine.GET("/items/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	for _, traceparent := range []string{"", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"} {
+		exporter.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/items/42", nil)
+		if traceparent != "" {
+			req.Header.Set("traceparent", traceparent)
+		}
+		s.engine.ServeHTTP(httptest.NewRecorder(), req)
+
+		spans := exporter.GetSpans()
+		if len(spans) != 1 {
+			t.Fatalf("traceparent %q: got %d spans, want one per request", traceparent, len(spans))
+		}
+		span := spans[0]
+		if span.SpanKind != trace.SpanKindServer || !strings.Contains(span.Name, "/items/:id") {
+			t.Errorf("got span %q of kind %v, want a server span named after the route", span.Name, span.SpanKind)
+		}
+		if traceparent == "" && span.Parent.IsValid() {
+			t.Errorf("got parent %v, want a new trace", span.Parent)
+		}
+		if traceparent != "" && (span.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || span.Parent.SpanID().String() != "00f067aa0ba902b7") {
+			t.Errorf("got trace %s parent %s, want the traceparent continued", span.SpanContext.TraceID(), span.Parent.SpanID())
+		}
+	}
+}
@@ -1,32 +1,147 @@
 package config
 
 import (
+	"time"
+
 	"github.com/go-playground/validator/v10"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	PgUserName   string `mapstructure:"PG_USERNAME"`
-	PgPassword   string `mapstructure:"PG_PASSWORD"`
-	PgSSLMode    string `mapstructure:"PG_SSL_MODE"`
-	PGDBmsName   string `mapstructure:"PG_DBMS_NAME"`
-	PGHost       string `mapstructure:"PG_HOST"`
-	PgDriverName string `mapstructure:"PG_DRIVER_NAME"`
-	PGDBName     string `mapstructure:"PG_DB_NAME"`
-	PgPort       string `mapstructure:"PG_PORT"`
+	// DBDriver selects the Repository implementation: postgres, sqlite or memory
+	DBDriver   string `mapstructure:"DB_DRIVER" validate:"oneof=postgres sqlite memory"`
+	SQLitePath string `mapstructure:"SQLITE_PATH"`
+
+	PgUserName string `mapstructure:"PG_USERNAME"`
+	PgPassword string `mapstructure:"PG_PASSWORD"`
+	PgSSLMode  string `mapstructure:"PG_SSL_MODE"`
+	PGDBmsName string `mapstructure:"PG_DBMS_NAME"`
+	PGHost     string `mapstructure:"PG_HOST"`
+	PGDBName   string `mapstructure:"PG_DB_NAME"`
+	PgPort     string `mapstructure:"PG_PORT"`
+
+	PgMaxConns               int32         `mapstructure:"PG_MAX_CONNS" validate:"gte=0"`
+	PgMinConns               int32         `mapstructure:"PG_MIN_CONNS" validate:"gte=0"`
+	PgConnMaxLifetime        time.Duration `mapstructure:"PG_CONN_MAX_LIFETIME" validate:"gte=0"`
+	PgConnMaxIdleTime        time.Duration `mapstructure:"PG_CONN_MAX_IDLE_TIME" validate:"gte=0"`
+	PgConnectTimeout         time.Duration `mapstructure:"PG_CONNECT_TIMEOUT" validate:"gt=0"`
+	PgStatementCacheCapacity int           `mapstructure:"PG_STATEMENT_CACHE_CAPACITY" validate:"gte=0"`
 
 	Host       string `mapstructre:"HOST"`
 	ServerPort string `mapstructure:"SERVER_PORT"`
+	// TrustedProxies are the IPs or CIDRs of the proxies whose X-Forwarded-For
+	// is believed, comma separated. Empty uses the address of the connection.
+	TrustedProxies []string `mapstructure:"TRUSTED_PROXIES" validate:"dive,ip|cidr"`
+
+	LogLevel  string `mapstructure:"LOG_LEVEL"`
+	LogFormat string `mapstructure:"LOG_FORMAT"`
+
+	OtelExporter    string `mapstructure:"OTEL_EXPORTER"`
+	OtelEndpoint    string `mapstructure:"OTEL_ENDPOINT"`
+	OtelInsecure    bool   `mapstructure:"OTEL_INSECURE"`
+	OtelServiceName string `mapstructure:"OTEL_SERVICE_NAME"`
+	OtelSampleRatio string `mapstructure:"OTEL_SAMPLE_RATIO"`
+
+	PasswordMinLength        int  `mapstructure:"PASSWORD_MIN_LENGTH" validate:"gte=1"`
+	PasswordMaxLength        int  `mapstructure:"PASSWORD_MAX_LENGTH" validate:"gtefield=PasswordMinLength,lte=1024"` // login takes at most 1024
+	PasswordRequireUpper     bool `mapstructure:"PASSWORD_REQUIRE_UPPER"`
+	PasswordRequireLower     bool `mapstructure:"PASSWORD_REQUIRE_LOWER"`
+	PasswordRequireDigit     bool `mapstructure:"PASSWORD_REQUIRE_DIGIT"`
+	PasswordRequireSpecial   bool `mapstructure:"PASSWORD_REQUIRE_SPECIAL"`
+	PasswordDisallowPersonal bool `mapstructure:"PASSWORD_DISALLOW_PERSONAL"`
+	// PasswordBreachedList is a file of SHA-1 hashes of leaked passwords, empty disables the check
+	PasswordBreachedList string `mapstructure:"PASSWORD_BREACHED_LIST"`
+
+	// PasswordHashAlgorithm hashes new passwords, hashes of the other one are still
+	// accepted and upgraded on the next successful login
+	PasswordHashAlgorithm string `mapstructure:"PASSWORD_HASH_ALGORITHM" validate:"oneof=argon2id bcrypt"`
+	BcryptCost            int    `mapstructure:"BCRYPT_COST" validate:"min=4,max=31"`
+	Argon2Memory          uint32 `mapstructure:"ARGON2_MEMORY" validate:"gte=8192"` // KiB
+	Argon2Iterations      uint32 `mapstructure:"ARGON2_ITERATIONS" validate:"gte=1"`
+	Argon2Parallelism     uint8  `mapstructure:"ARGON2_PARALLELISM" validate:"gte=1"`
+
+	// TokenSecret is the secret the keys of the login tokens, login links,
+	// consent tickets and OIDC login state are derived from, changing it logs
+	// every user out
+	TokenSecret string `mapstructure:"TOKEN_SECRET" validate:"min=32"`
+
+	// AdminEmails may use the admin routes, comma separated
+	AdminEmails []string `mapstructure:"ADMIN_EMAILS" validate:"dive,email"`
+	// IdentityApprovalFields are the identity fields whose changes wait for an
+	// admin, comma separated, e.g. "dob,gender"
+	IdentityApprovalFields []string `mapstructure:"IDENTITY_APPROVAL_FIELDS" validate:"dive,oneof=first_name last_name dob gender"`
+	// GenderOptions are the genders users can pick, comma separated in the
+	// order they are offered. Users who picked an option that was removed have
+	// to pick another one on their next profile update.
+	GenderOptions []string `mapstructure:"GENDER_OPTIONS" validate:"min=1,unique,dive,oneof=female male non_binary self_described prefer_not_to_say"`
+	// OIDCProvidersFile is a JSON array of the OpenID Connect providers users
+	// can sign in with, empty disables the sign in with providers
+	OIDCProvidersFile string `mapstructure:"OIDC_PROVIDERS_FILE"`
+
+	// OAuthIssuer is the URL other apps reach the authorization server at, the
+	// oauth routes of this service
+	OAuthIssuer string `mapstructure:"OAUTH_ISSUER" validate:"url"`
+	// OAuthKeyRotation is how long a key signs ID and access tokens before a
+	// new one replaces it
+	OAuthKeyRotation time.Duration `mapstructure:"OAUTH_KEY_ROTATION" validate:"gte=1h"`
+
+	// MagicLinkURL is the page the emailed login links open, it posts the
+	// token of the link to the consume endpoint
+	MagicLinkURL string        `mapstructure:"MAGIC_LINK_URL" validate:"url"`
+	MagicLinkTTL time.Duration `mapstructure:"MAGIC_LINK_TTL" validate:"gte=1m,lte=1h"`
+
+	// SMTPAddr is the host:port emails are sent through, empty logs them instead
+	SMTPAddr     string `mapstructure:"SMTP_ADDR" validate:"omitempty,hostname_port"`
+	SMTPUsername string `mapstructure:"SMTP_USERNAME"`
+	SMTPPassword string `mapstructure:"SMTP_PASSWORD"`
+	MailFrom     string `mapstructure:"MAIL_FROM" validate:"email"`
 }
 
 var envs = []string{
-	"PG_USERNAME", "PG_PASSWORD", "PG_SSL_MODE", "PG_DBMS_NAME", "PG_HOST", "PG_DRIVER_NAME", "PG_DB_NAME", "PG_PORT",
-	"HOST", "SERVER_PORT",
+	"DB_DRIVER", "SQLITE_PATH",
+	"PG_USERNAME", "PG_PASSWORD", "PG_SSL_MODE", "PG_DBMS_NAME", "PG_HOST", "PG_DB_NAME", "PG_PORT",
+	"PG_MAX_CONNS", "PG_MIN_CONNS", "PG_CONN_MAX_LIFETIME", "PG_CONN_MAX_IDLE_TIME", "PG_CONNECT_TIMEOUT", "PG_STATEMENT_CACHE_CAPACITY",
+	"HOST", "SERVER_PORT", "TRUSTED_PROXIES",
+	"LOG_LEVEL", "LOG_FORMAT",
+	"OTEL_EXPORTER", "OTEL_ENDPOINT", "OTEL_INSECURE", "OTEL_SERVICE_NAME", "OTEL_SAMPLE_RATIO",
+	"PASSWORD_MIN_LENGTH", "PASSWORD_MAX_LENGTH", "PASSWORD_REQUIRE_UPPER", "PASSWORD_REQUIRE_LOWER",
+	"PASSWORD_REQUIRE_DIGIT", "PASSWORD_REQUIRE_SPECIAL", "PASSWORD_DISALLOW_PERSONAL", "PASSWORD_BREACHED_LIST",
+	"PASSWORD_HASH_ALGORITHM", "BCRYPT_COST", "ARGON2_MEMORY", "ARGON2_ITERATIONS", "ARGON2_PARALLELISM",
+	"TOKEN_SECRET",
+	"ADMIN_EMAILS", "IDENTITY_APPROVAL_FIELDS", "GENDER_OPTIONS",
+	"OIDC_PROVIDERS_FILE", "OAUTH_ISSUER", "OAUTH_KEY_ROTATION",
+	"MAGIC_LINK_URL", "MAGIC_LINK_TTL", "SMTP_ADDR", "SMTP_USERNAME", "SMTP_PASSWORD", "MAIL_FROM",
 }
 
 func LoadConfig() (Config, error) {
 	var config Config
 
+	viper.SetDefault("DB_DRIVER", "postgres")
+	viper.SetDefault("SQLITE_PATH", "clean-code.db")
+	viper.SetDefault("PG_MAX_CONNS", 25)
+	viper.SetDefault("PG_MIN_CONNS", 2)
+	viper.SetDefault("PG_CONN_MAX_LIFETIME", "30m")
+	viper.SetDefault("PG_CONN_MAX_IDLE_TIME", "5m")
+	viper.SetDefault("PG_CONNECT_TIMEOUT", "30s")
+	viper.SetDefault("PG_STATEMENT_CACHE_CAPACITY", 512)
+	viper.SetDefault("PASSWORD_MIN_LENGTH", 8)
+	viper.SetDefault("PASSWORD_MAX_LENGTH", 72)
+	viper.SetDefault("PASSWORD_REQUIRE_UPPER", true)
+	viper.SetDefault("PASSWORD_REQUIRE_LOWER", true)
+	viper.SetDefault("PASSWORD_REQUIRE_SPECIAL", true)
+	viper.SetDefault("PASSWORD_DISALLOW_PERSONAL", true)
+	viper.SetDefault("PASSWORD_HASH_ALGORITHM", "argon2id")
+	viper.SetDefault("BCRYPT_COST", 10)
+	viper.SetDefault("ARGON2_MEMORY", 65536)
+	viper.SetDefault("ARGON2_ITERATIONS", 3)
+	viper.SetDefault("ARGON2_PARALLELISM", 2)
+	viper.SetDefault("GENDER_OPTIONS", "female,male,non_binary,self_described,prefer_not_to_say")
+	viper.SetDefault("OAUTH_ISSUER", "http://localhost:8080/applicant/external/v1/oauth")
+	viper.SetDefault("OAUTH_KEY_ROTATION", "720h")
+	viper.SetDefault("MAGIC_LINK_URL", "http://localhost:3000/login/magic-link")
+	viper.SetDefault("MAGIC_LINK_TTL", "15m")
+	viper.SetDefault("MAIL_FROM", "no-reply@example.com")
+
 	viper.SetConfigFile("./pkg/config/.env")
 	viper.ReadInConfig()
 
@@ -1,26 +1,94 @@
 package db
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
+	"net/url"
+	"time"
 
 	"github.com/abhiii71/clean-code-abhi/pkg/config"
-	_ "github.com/lib/pq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func ConnectPGDB(cnf config.Config) *sql.DB {
-	fmt.Println(cnf)
-	postgresURL := fmt.Sprintf("%v://%v:%v@%v:%v/%v?sslmode=%v", cnf.PGDBmsName, cnf.PgUserName, cnf.PgPassword, cnf.PGHost, cnf.PgPort, cnf.PGDBName, cnf.PgSSLMode)
-	db, err := sql.Open(cnf.PgDriverName, postgresURL)
+const (
+	initialBackoff = 250 * time.Millisecond
+	maxBackoff     = 5 * time.Second
+)
+
+// ConnectPGDB opens the pgx pool and keeps pinging it with exponential
+// backoff until it answers or PG_CONNECT_TIMEOUT is reached
+func ConnectPGDB(cnf config.Config, log *slog.Logger) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(postgresURL(cnf))
 	if err != nil {
-		log.Fatal(err, err.Error(), "driver name", cnf.PgDriverName, "postgres URL", postgresURL)
+		return nil, fmt.Errorf("parse postgres config for %s:%s: %w", cnf.PGHost, cnf.PgPort, err)
 	}
 
-	err = db.Ping()
+	if cnf.PgMaxConns > 0 {
+		poolConfig.MaxConns = cnf.PgMaxConns
+	}
+	poolConfig.MinConns = cnf.PgMinConns
+	poolConfig.MaxConnLifetime = cnf.PgConnMaxLifetime
+	poolConfig.MaxConnIdleTime = cnf.PgConnMaxIdleTime
+	// every query is prepared once per connection and reused from the cache
+	poolConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeCacheStatement
+	poolConfig.ConnConfig.StatementCacheCapacity = cnf.PgStatementCacheCapacity
+
+	ctx, cancel := context.WithTimeout(context.Background(), cnf.PgConnectTimeout)
+	defer cancel()
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
-		log.Fatal("not connected to postgres db: ", err.Error())
+		return nil, fmt.Errorf("create postgres pool: %w", err)
+	}
+
+	if err := pingWithRetry(ctx, pool.Ping, log); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("not connected to postgres db at %s:%s: %w", cnf.PGHost, cnf.PgPort, err)
+	}
+
+	log.Info("connected to postgres db", "host", cnf.PGHost, "port", cnf.PgPort, "db", cnf.PGDBName)
+	return pool, nil
+}
+
+// postgresURL builds the connection URL, the credentials are escaped as URL
+// userinfo so characters like '@' and ':' in them are kept
+func postgresURL(cnf config.Config) string {
+	u := url.URL{
+		Scheme:   cnf.PGDBmsName,
+		User:     url.UserPassword(cnf.PgUserName, cnf.PgPassword),
+		Host:     net.JoinHostPort(cnf.PGHost, cnf.PgPort),
+		Path:     "/" + cnf.PGDBName,
+		RawQuery: url.Values{"sslmode": {cnf.PgSSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+func pingWithRetry(ctx context.Context, ping func(context.Context) error, log *slog.Logger) error {
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := ping(ctx)
+		if err == nil {
+			return nil
+		}
+
+		// no attempt is left when the timeout runs out before the next one
+		if deadline, ok := ctx.Deadline(); ctx.Err() != nil || ok && time.Until(deadline) <= backoff {
+			return fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		}
+		log.Warn("postgres not reachable, retrying", "attempt", attempt, "retry_in", backoff.String(), "err", err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
 	}
-	log.Println("Connected to postres db Successfully!")
-	return db
 }
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abhiii71/clean-code-abhi/pkg/config"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestPostgresURLKeepsCredentials(t *testing.T) {
+	tests := []struct{ user, password string }{
+		{"app", "secret"},
+		{"app", "p@ss:w/rd"},
+		{"a@b:c", "%41 ?#&="},
+	}
+	for _, tt := range tests {
+		cnf := config.Config{PGDBmsName: "postgres", PgUserName: tt.user, PgPassword: tt.password, PGHost: "db.internal", PgPort: "5432", PGDBName: "app", PgSSLMode: "disable"}
+		poolConfig, err := pgxpool.ParseConfig(postgresURL(cnf))
+		if err != nil {
+			t.Fatalf("ParseConfig(%q, %q): %v", tt.user, tt.password, err)
+		}
+		conn := poolConfig.ConnConfig
+		if conn.User != tt.user || conn.Password != tt.password || conn.Host != "db.internal" || conn.Port != 5432 || conn.Database != "app" {
+			t.Errorf("user %q password %q: got %s:%s@%s:%d/%s", tt.user, tt.password, conn.User, conn.Password, conn.Host, conn.Port, conn.Database)
+		}
+	}
+}
+
+func TestPingWithRetry(t *testing.T) {
+	unreachable := errors.New("connection refused")
+
+	t.Run("answers after retries", func(t *testing.T) {
+		var logs bytes.Buffer
+		attempts := 0
+		err := pingWithRetry(context.Background(), func(context.Context) error {
+			if attempts++; attempts < 3 {
+				return unreachable
+			}
+			return nil
+		}, slog.New(slog.NewTextHandler(&logs, nil)))
+		if err != nil || attempts != 3 {
+			t.Fatalf("got %v after %d attempts, want success on the third", err, attempts)
+		}
+		if n := strings.Count(logs.String(), "retrying"); n != 2 {
+			t.Errorf("got %d retry logs, want 2:\n%s", n, logs.String())
+		}
+	})
+
+	t.Run("gives up at the timeout", func(t *testing.T) {
+		var logs bytes.Buffer
+		ctx, cancel := context.WithTimeout(context.Background(), 600*time.Millisecond)
+		defer cancel()
+		attempts := 0
+		err := pingWithRetry(ctx, func(context.Context) error {
+			attempts++
+			return unreachable
+		}, slog.New(slog.NewTextHandler(&logs, nil)))
+
+		// 250ms and 500ms backoffs, the third attempt has no time for another
+		if !errors.Is(err, unreachable) || !strings.Contains(err.Error(), fmt.Sprintf("gave up after %d attempts", attempts)) {
+			t.Errorf("got %v, want the last error wrapped with the %d attempts", err, attempts)
+		}
+		if n := strings.Count(logs.String(), "retrying"); n != attempts-1 {
+			t.Errorf("got %d retry logs for %d attempts, want none after the last one:\n%s", n, attempts, logs.String())
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"database/sql"
+	_ "embed"
+	"fmt"
+	"log/slog"
+
+	"github.com/abhiii71/clean-code-abhi/pkg/config"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema mirrors the postgres migrations, SQLite databases are created
+// on the fly so the schema is applied on every start
+//
+//go:embed sqlite_schema.sql
+var sqliteSchema string
+
+// ConnectSQLite opens the SQLite database at SQLITE_PATH and makes sure the
+// schema exists. ":memory:" gives a throwaway database.
+func ConnectSQLite(cnf config.Config, log *slog.Logger) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", cnf.SQLitePath+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite database %q: %w", cnf.SQLitePath, err)
+	}
+
+	// SQLite allows a single writer, one connection avoids SQLITE_BUSY and
+	// keeps an in-memory database alive for the lifetime of the pool
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("apply sqlite schema: %w", err)
+	}
+
+	log.Info("connected to sqlite db", "path", cnf.SQLitePath)
+	return db, nil
+}
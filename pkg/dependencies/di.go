@@ -1,21 +1,164 @@
 package di
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
 	bootserver "github.com/abhiii71/clean-code-abhi/pkg/boot"
 	"github.com/abhiii71/clean-code-abhi/pkg/config"
 	"github.com/abhiii71/clean-code-abhi/pkg/db"
+	"github.com/abhiii71/clean-code-abhi/pkg/logger"
+	"github.com/abhiii71/clean-code-abhi/pkg/tracing"
 	userauth "github.com/abhiii71/clean-code-abhi/pkg/user_auth"
 )
 
 func InitializeEvents(conf config.Config) (*bootserver.ServerHttp, error) {
-	DB := db.ConnectPGDB(conf)
+	log, err := logger.New(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	tracerProvider, err := tracing.New(context.Background(), conf)
+	if err != nil {
+		return nil, err
+	}
+
+	userRepository, err := NewRepository(conf, log)
+	if err != nil {
+		return nil, err
+	}
+	passwordPolicy, err := newPasswordPolicy(conf, log)
+	if err != nil {
+		return nil, err
+	}
+	oidcProviders, err := newOIDCProviders(conf, log)
+	if err != nil {
+		return nil, err
+	}
+	userService := userauth.NewTracedService(userauth.NewService(userRepository, passwordPolicy, newPasswordHasher(conf), []byte(conf.TokenSecret), identityApprovalFields(conf), oidcProviders, userauth.OAuthServerConfig{
+		Issuer:      strings.TrimSuffix(conf.OAuthIssuer, "/"),
+		KeyRotation: conf.OAuthKeyRotation,
+	}, userauth.MagicLinkConfig{
+		URL: conf.MagicLinkURL,
+		TTL: conf.MagicLinkTTL,
+	}, newMailer(conf, log), log))
+	validator, err := userauth.NewValidator(genderOptions(conf))
+	if err != nil {
+		return nil, err
+	}
+	userHandler := userauth.NewHandler(userService, validator, conf.AdminEmails, log)
+
+	return bootserver.NewServerHttp(*userHandler, conf.TrustedProxies, log, tracerProvider)
+
+}
+
+// newPasswordPolicy builds the PasswordPolicy from the PASSWORD_* settings
+func newPasswordPolicy(conf config.Config, log *slog.Logger) (*userauth.PasswordPolicy, error) {
+	policy := &userauth.PasswordPolicy{
+		MinLength:        conf.PasswordMinLength,
+		MaxLength:        conf.PasswordMaxLength,
+		RequireUpper:     conf.PasswordRequireUpper,
+		RequireLower:     conf.PasswordRequireLower,
+		RequireDigit:     conf.PasswordRequireDigit,
+		RequireSpecial:   conf.PasswordRequireSpecial,
+		DisallowPersonal: conf.PasswordDisallowPersonal,
+	}
+	if conf.PasswordHashAlgorithm == "bcrypt" {
+		policy.MaxBytes = userauth.BcryptMaxPasswordBytes
+	}
+	if conf.PasswordBreachedList != "" {
+		breached, err := userauth.LoadBreachedPasswords(conf.PasswordBreachedList)
+		if err != nil {
+			return nil, fmt.Errorf("load breached password list: %w", err)
+		}
+		policy.Breached = breached
+		log.Info("breached password check enabled", "list", conf.PasswordBreachedList)
+	}
+	return policy, nil
+}
 
-	userRepository := userauth.NewRepository(DB)
-	userService := userauth.NewService(userRepository)
-	userHandler := userauth.NewHandler(userService)
+// newPasswordHasher hashes with PASSWORD_HASH_ALGORITHM and keeps verifying
+// hashes of the other algorithm until their users log in again
+func newPasswordHasher(conf config.Config) userauth.PasswordHasher {
+	bcryptHasher := userauth.NewBcryptHasher(conf.BcryptCost)
+	argon2Hasher := userauth.NewArgon2idHasher(userauth.Argon2Params{
+		Memory:      conf.Argon2Memory,
+		Iterations:  conf.Argon2Iterations,
+		Parallelism: conf.Argon2Parallelism,
+	})
 
-	serverHttp := bootserver.NewServerHttp(*userHandler)
+	if conf.PasswordHashAlgorithm == "bcrypt" {
+		return userauth.NewPasswordHasher(bcryptHasher, argon2Hasher)
+	}
+	return userauth.NewPasswordHasher(argon2Hasher, bcryptHasher)
+}
+
+// newOIDCProviders loads the providers of OIDC_PROVIDERS_FILE, without the
+// file users can only log in with a password
+func newOIDCProviders(conf config.Config, log *slog.Logger) (*userauth.OIDCProviders, error) {
+	if conf.OIDCProvidersFile == "" {
+		return nil, nil
+	}
+	configs, err := userauth.LoadOIDCProviders(conf.OIDCProvidersFile)
+	if err != nil {
+		return nil, fmt.Errorf("load OIDC providers: %w", err)
+	}
+	providers := userauth.NewOIDCProviders(configs, &http.Client{Timeout: 10 * time.Second})
+	log.Info("OIDC login enabled", "providers", providers.Names())
+	return providers, nil
+}
+
+// newMailer sends through SMTP_ADDR, without it emails are only logged
+func newMailer(conf config.Config, log *slog.Logger) userauth.Mailer {
+	if conf.SMTPAddr == "" {
+		log.Warn("SMTP_ADDR is not set, emails are logged instead of sent")
+		return userauth.NewLogMailer(log)
+	}
+	return userauth.NewSMTPMailer(userauth.SMTPConfig{
+		Addr:     conf.SMTPAddr,
+		Username: conf.SMTPUsername,
+		Password: conf.SMTPPassword,
+		From:     conf.MailFrom,
+	})
+}
+
+func identityApprovalFields(conf config.Config) []userauth.IdentityField {
+	fields := make([]userauth.IdentityField, len(conf.IdentityApprovalFields))
+	for i, field := range conf.IdentityApprovalFields {
+		fields[i] = userauth.IdentityField(field)
+	}
+	return fields
+}
 
-	return serverHttp, nil
+func genderOptions(conf config.Config) []userauth.Gender {
+	genders := make([]userauth.Gender, len(conf.GenderOptions))
+	for i, gender := range conf.GenderOptions {
+		genders[i] = userauth.Gender(gender)
+	}
+	return genders
+}
 
+// NewRepository builds the Repository selected by DB_DRIVER
+func NewRepository(conf config.Config, log *slog.Logger) (userauth.Repository, error) {
+	switch conf.DBDriver {
+	case "sqlite":
+		DB, err := db.ConnectSQLite(conf, log)
+		if err != nil {
+			return nil, err
+		}
+		return userauth.NewSQLiteRepository(DB, log), nil
+	case "memory":
+		log.Warn("using the in-memory repository, data is lost on restart")
+		return userauth.NewMemoryRepository(log), nil
+	default:
+		DB, err := db.ConnectPGDB(conf, log)
+		if err != nil {
+			return nil, err
+		}
+		return userauth.NewRepository(DB, log), nil
+	}
 }
@@ -0,0 +1,90 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/abhiii71/clean-code-abhi/pkg/config"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values must never reach the log output
+var sensitiveKeys = map[string]struct{}{
+	"password":      {},
+	"pg_password":   {},
+	"secret":        {},
+	"token":         {},
+	"authorization": {},
+	"api_key":       {},
+}
+
+// New builds a slog logger using LOG_LEVEL and LOG_FORMAT from the config
+func New(cnf config.Config) (*slog.Logger, error) {
+	return newLogger(os.Stdout, cnf.LogLevel, cnf.LogFormat)
+}
+
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+		}
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:       lvl,
+		ReplaceAttr: redact,
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q: must be json or text", format)
+	}
+
+	return slog.New(contextHandler{handler}), nil
+}
+
+// redact replaces the value of any sensitive attribute with a placeholder
+func redact(_ []string, a slog.Attr) slog.Attr {
+	if _, ok := sensitiveKeys[strings.ToLower(a.Key)]; ok {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+// contextHandler adds the request ID and trace IDs stored in the context to every record,
+// a logger derived with WithGroup nests them in its group like any other attribute
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String(requestIDKey, id))
+	}
+	if ctx != nil {
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
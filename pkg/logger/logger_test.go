@@ -0,0 +1,157 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// decodeLines decodes the JSON log lines written to buf
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		lines = append(lines, m)
+	}
+	return lines
+}
+
+func TestRedactsSensitiveAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := newLogger(&buf, "debug", "json")
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+	log.With("token", "eyJhbGciOi").Info("login",
+		"password", "Passw0rd!",
+		"Authorization", "Bearer abc",
+		"api_key", "cck_1_2",
+		"pg_password", "pg",
+		slog.Group("oauth", "secret", "s3cret"),
+		"email", "jane@example.com",
+	)
+
+	line := decodeLines(t, &buf)[0]
+	for _, key := range []string{"token", "password", "Authorization", "api_key", "pg_password"} {
+		if line[key] != redacted {
+			t.Errorf("%s = %v, want %s", key, line[key], redacted)
+		}
+	}
+	if group, _ := line["oauth"].(map[string]any); group["secret"] != redacted {
+		t.Errorf("oauth.secret = %v, want %s", group["secret"], redacted)
+	}
+	if line["email"] != "jane@example.com" {
+		t.Errorf("email = %v, want it kept", line["email"])
+	}
+	if strings.Contains(buf.String(), "Passw0rd!") || strings.Contains(buf.String(), "s3cret") {
+		t.Errorf("a secret reached the output: %s", buf.String())
+	}
+}
+
+func TestAddsContextAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := newLogger(&buf, "info", "json")
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(WithRequestID(context.Background(), "req-1"), sc)
+
+	log.InfoContext(ctx, "with context")
+	log.With("component", "repo").InfoContext(ctx, "derived logger")
+	log.Info("without context")
+
+	lines := decodeLines(t, &buf)
+	for _, line := range lines[:2] {
+		if line["request_id"] != "req-1" || line["trace_id"] != sc.TraceID().String() || line["span_id"] != sc.SpanID().String() {
+			t.Errorf("got %v, want the request and trace IDs of the context", line)
+		}
+	}
+	if _, ok := lines[2]["request_id"]; ok {
+		t.Errorf("got %v, want no request_id without a context", lines[2])
+	}
+}
+
+func TestNewLoggerRejectsInvalidSettings(t *testing.T) {
+	if _, err := newLogger(&bytes.Buffer{}, "loud", "json"); err == nil {
+		t.Error("an invalid level was accepted")
+	}
+	if _, err := newLogger(&bytes.Buffer{}, "info", "xml"); err == nil {
+		t.Error("an invalid format was accepted")
+	}
+	var buf bytes.Buffer
+	log, err := newLogger(&buf, "", "text")
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+	log.Info("text", "password", "Passw0rd!")
+	if !strings.Contains(buf.String(), "password="+redacted) {
+		t.Errorf("got %q, want the password redacted in text output", buf.String())
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	log, err := newLogger(&buf, "info", "json")
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+	engine := gin.New()
+	engine.Use(RequestID(), AccessLog(log))
+	engine.GET("/ping", func(c *gin.Context) {
+		c.String(http.StatusOK, RequestIDFromContext(c.Request.Context()))
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		reused bool
+	}{
+		{"no header", "", false},
+		{"header reused", "client-id-1", true},
+		{"header at the cap", strings.Repeat("a", maxRequestIDLen), true},
+		{"header over the cap", strings.Repeat("a", maxRequestIDLen+1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			engine.ServeHTTP(rec, req)
+
+			id := rec.Header().Get(RequestIDHeader)
+			if tt.reused && id != tt.header {
+				t.Errorf("got request ID %q, want the header %q", id, tt.header)
+			}
+			if !tt.reused && (len(id) != 32 || id == tt.header) {
+				t.Errorf("got request ID %q, want a generated one", id)
+			}
+			if rec.Body.String() != id {
+				t.Errorf("the handler saw request ID %q, the response has %q", rec.Body.String(), id)
+			}
+			if line := decodeLines(t, &buf)[0]; line["request_id"] != id || line["path"] != "/ping" {
+				t.Errorf("got access log %v, want request_id %q", line, id)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/abhiii71/clean-code-abhi/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const defaultServiceName = "clean-code-abhi"
+
+// New builds a tracer provider from the OTEL_* settings, registers it and the
+// W3C trace context propagator globally, and returns it so the caller can
+// flush it on shutdown
+func New(ctx context.Context, cnf config.Config) (*sdktrace.TracerProvider, error) {
+	var opts []sdktrace.TracerProviderOption
+
+	switch strings.ToLower(cnf.OtelExporter) {
+	case "", "none":
+		// spans are still created so trace IDs propagate, they are just not exported
+	case "otlp":
+		clientOpts := []otlptracehttp.Option{}
+		if cnf.OtelEndpoint != "" {
+			clientOpts = append(clientOpts, otlptracehttp.WithEndpoint(cnf.OtelEndpoint))
+		}
+		if cnf.OtelInsecure {
+			clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(ctx, clientOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("create otlp exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	default:
+		return nil, fmt.Errorf("invalid otel exporter %q: must be none or otlp", cnf.OtelExporter)
+	}
+
+	ratio := 1.0
+	if cnf.OtelSampleRatio != "" {
+		var err error
+		ratio, err = strconv.ParseFloat(cnf.OtelSampleRatio, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("invalid otel sample ratio %q: must be between 0 and 1", cnf.OtelSampleRatio)
+		}
+	}
+	opts = append(opts, sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))))
+
+	serviceName := cnf.OtelServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	opts = append(opts, sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))))
+
+	tp := sdktrace.NewTracerProvider(opts...)
+	register(tp)
+	return tp, nil
+}
+
+// NewInMemory registers a tracer provider that keeps every span in memory,
+// for tests that need to assert on the produced spans
+func NewInMemory() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+	)
+	register(tp)
+	return tp, exporter
+}
+
+func register(tp *sdktrace.TracerProvider) {
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+}
+
+var (
+	sqlStringLiteral = regexp.MustCompile(`'(?:[^']|'')*'`)
+	// placeholders like $1 are matched too so they can be kept as they are
+	sqlNumericLiteral = regexp.MustCompile(`\$\d+|\b\d+(?:\.\d+)?\b`)
+	sqlWhitespace     = regexp.MustCompile(`\s+`)
+)
+
+// SanitizeSQL strips literals from a query and collapses whitespace so it can
+// be attached to a span without leaking data
+func SanitizeSQL(query string) string {
+	query = sqlStringLiteral.ReplaceAllString(query, "?")
+	query = sqlNumericLiteral.ReplaceAllStringFunc(query, func(m string) string {
+		if strings.HasPrefix(m, "$") {
+			return m
+		}
+		return "?"
+	})
+	return strings.TrimSpace(sqlWhitespace.ReplaceAllString(query, " "))
+}
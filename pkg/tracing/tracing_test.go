@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSanitizeSQL(t *testing.T) {
+	tests := []struct{ query, want string }{
+		{"SELECT id FROM users WHERE email = $1", "SELECT id FROM users WHERE email = $1"},
+		{"SELECT id FROM users WHERE email = 'jane@example.com'", "SELECT id FROM users WHERE email = ?"},
+		{"SELECT 'it''s' , x FROM t", "SELECT ? , x FROM t"},
+		{"UPDATE users SET version = version + 1, age = 42.5 WHERE id = $2", "UPDATE users SET version = version + ?, age = ? WHERE id = $2"},
+		{"SELECT *\n    FROM t1\n\tWHERE a = $10 LIMIT 100", "SELECT * FROM t1 WHERE a = $10 LIMIT ?"},
+		{"  SELECT 1  ", "SELECT ?"},
+	}
+	for _, tt := range tests {
+		if got := SanitizeSQL(tt.query); got != tt.want {
+			t.Errorf("SanitizeSQL(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestNewInMemory(t *testing.T) {
+	tp, exporter := NewInMemory()
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+
+	// the registered propagator continues a W3C trace context
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(header))
+
+	_, span := otel.Tracer("test").Start(ctx, "work")
+	span.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "work" {
+		t.Fatalf("got %d spans %v, want the span work", len(spans), spans)
+	}
+	if got := spans[0].SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("got trace ID %s, want the one of the traceparent", got)
+	}
+	if got := spans[0].Parent.SpanID().String(); got != "00f067aa0ba902b7" || !spans[0].Parent.IsRemote() {
+		t.Errorf("got parent %s, want the remote span of the traceparent", got)
+	}
+	if spans[0].SpanKind != trace.SpanKindInternal {
+		t.Errorf("got kind %v, want internal", spans[0].SpanKind)
+	}
+}
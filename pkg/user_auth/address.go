@@ -0,0 +1,205 @@
+package userauth
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/language"
+)
+
+// Address rules, they double as the translation keys of the messages
+const (
+	ruleAddressCountry    = "address_country"
+	ruleAddressPostalCode = "address_postal_code"
+	ruleAddressState      = "address_state"
+)
+
+// postalFormat checks and formats the postal codes of one country, the code is
+// trimmed and upper cased before matching
+type postalFormat struct {
+	pattern *regexp.Regexp
+	// format renders a matching code in its canonical spelling, nil keeps it as is
+	format func(code string) string
+}
+
+// postalFormats holds the countries whose postal codes are validated, others
+// are only trimmed and upper cased
+var postalFormats = map[string]postalFormat{
+	"AU": {pattern: regexp.MustCompile(`^\d{4}$`)},
+	"CA": {pattern: regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`), format: splitPostalCode(3)},
+	"DE": {pattern: regexp.MustCompile(`^\d{5}$`)},
+	"ES": {pattern: regexp.MustCompile(`^\d{5}$`)},
+	"FR": {pattern: regexp.MustCompile(`^\d{5}$`)},
+	"GB": {pattern: regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`), format: splitPostalCode(-3)},
+	"IN": {pattern: regexp.MustCompile(`^[1-9]\d{2} ?\d{3}$`), format: joinPostalCode},
+	"JP": {pattern: regexp.MustCompile(`^\d{3}-?\d{4}$`), format: func(code string) string {
+		code = strings.ReplaceAll(code, "-", "")
+		return code[:3] + "-" + code[3:]
+	}},
+	"NL": {pattern: regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`), format: splitPostalCode(4)},
+	"US": {pattern: regexp.MustCompile(`^\d{5}(-\d{4})?$`)},
+}
+
+// splitPostalCode puts a single space at position at, counted from the end
+// when negative, e.g. "SW1A1AA" becomes "SW1A 1AA"
+func splitPostalCode(at int) func(string) string {
+	return func(code string) string {
+		code = joinPostalCode(code)
+		// a copy, the closure is shared by every request
+		i := at
+		if i < 0 {
+			i += len(code)
+		}
+		return code[:i] + " " + code[i:]
+	}
+}
+
+func joinPostalCode(code string) string {
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// subdivisions lists the ISO 3166-2 subdivision codes and names of the
+// countries whose state is validated, the state is stored as the code
+var subdivisions = map[string]map[string]string{
+	"AU": {
+		"ACT": "Australian Capital Territory", "NSW": "New South Wales", "NT": "Northern Territory", "QLD": "Queensland",
+		"SA": "South Australia", "TAS": "Tasmania", "VIC": "Victoria", "WA": "Western Australia",
+	},
+	"CA": {
+		"AB": "Alberta", "BC": "British Columbia", "MB": "Manitoba", "NB": "New Brunswick", "NL": "Newfoundland and Labrador",
+		"NS": "Nova Scotia", "NT": "Northwest Territories", "NU": "Nunavut", "ON": "Ontario", "PE": "Prince Edward Island",
+		"QC": "Quebec", "SK": "Saskatchewan", "YT": "Yukon",
+	},
+	"IN": {
+		"AN": "Andaman and Nicobar Islands", "AP": "Andhra Pradesh", "AR": "Arunachal Pradesh", "AS": "Assam", "BR": "Bihar",
+		"CH": "Chandigarh", "CG": "Chhattisgarh", "DH": "Dadra and Nagar Haveli and Daman and Diu", "DL": "Delhi", "GA": "Goa",
+		"GJ": "Gujarat", "HR": "Haryana", "HP": "Himachal Pradesh", "JK": "Jammu and Kashmir", "JH": "Jharkhand",
+		"KA": "Karnataka", "KL": "Kerala", "LA": "Ladakh", "LD": "Lakshadweep", "MP": "Madhya Pradesh", "MH": "Maharashtra",
+		"MN": "Manipur", "ML": "Meghalaya", "MZ": "Mizoram", "NL": "Nagaland", "OD": "Odisha", "PY": "Puducherry",
+		"PB": "Punjab", "RJ": "Rajasthan", "SK": "Sikkim", "TN": "Tamil Nadu", "TS": "Telangana", "TR": "Tripura",
+		"UP": "Uttar Pradesh", "UK": "Uttarakhand", "WB": "West Bengal",
+	},
+	"US": {
+		"AL": "Alabama", "AK": "Alaska", "AZ": "Arizona", "AR": "Arkansas", "CA": "California", "CO": "Colorado",
+		"CT": "Connecticut", "DE": "Delaware", "DC": "District of Columbia", "FL": "Florida", "GA": "Georgia",
+		"HI": "Hawaii", "ID": "Idaho", "IL": "Illinois", "IN": "Indiana", "IA": "Iowa", "KS": "Kansas", "KY": "Kentucky",
+		"LA": "Louisiana", "ME": "Maine", "MD": "Maryland", "MA": "Massachusetts", "MI": "Michigan", "MN": "Minnesota",
+		"MS": "Mississippi", "MO": "Missouri", "MT": "Montana", "NE": "Nebraska", "NV": "Nevada", "NH": "New Hampshire",
+		"NJ": "New Jersey", "NM": "New Mexico", "NY": "New York", "NC": "North Carolina", "ND": "North Dakota",
+		"OH": "Ohio", "OK": "Oklahoma", "OR": "Oregon", "PA": "Pennsylvania", "RI": "Rhode Island", "SC": "South Carolina",
+		"SD": "South Dakota", "TN": "Tennessee", "TX": "Texas", "UT": "Utah", "VT": "Vermont", "VA": "Virginia",
+		"WA": "Washington", "WV": "West Virginia", "WI": "Wisconsin", "WY": "Wyoming",
+	},
+}
+
+// AddressError lists the address fields that failed validation
+type AddressError struct {
+	Violations []FieldViolation
+}
+
+func (e *AddressError) Error() string { return "invalid address" }
+
+func (e *AddressError) Is(target error) bool { return target == ErrValidation }
+
+func (e *AddressError) fieldViolations() []FieldViolation { return e.Violations }
+
+// NormalizeAddress trims and cases every field of a in place, turns the country
+// into its ISO 3166-1 alpha-2 code and the state into its subdivision code,
+// then checks the postal code and state against the country when it is known.
+// An AddressError is returned for the fields that can't be normalized, their
+// names start with prefix, e.g. "address." when a is nested in the request.
+func NormalizeAddress(a *Address, prefix string) error {
+	var violations []FieldViolation
+
+	normalizeField(a.City, normalizeCity)
+	normalizeField(a.State, collapseSpaces)
+	normalizeField(a.PostalCode, func(s string) string { return strings.ToUpper(collapseSpaces(s)) })
+	normalizeField(a.Country, collapseSpaces)
+
+	country := ""
+	if a.Country != nil && *a.Country != "" {
+		code, ok := countryCode(*a.Country)
+		if !ok {
+			violations = append(violations, FieldViolation{Field: prefix + "country", Rule: ruleAddressCountry})
+		} else {
+			*a.Country = code
+			country = code
+		}
+	}
+
+	if format, ok := postalFormats[country]; ok && a.PostalCode != nil && *a.PostalCode != "" {
+		if !format.pattern.MatchString(*a.PostalCode) {
+			violations = append(violations, FieldViolation{Field: prefix + "postal_code", Rule: ruleAddressPostalCode, Param: country})
+		} else if format.format != nil {
+			*a.PostalCode = format.format(*a.PostalCode)
+		}
+	}
+
+	if states, ok := subdivisions[country]; ok && a.State != nil && *a.State != "" {
+		code, ok := subdivisionCode(states, *a.State)
+		if !ok {
+			violations = append(violations, FieldViolation{Field: prefix + "state", Rule: ruleAddressState, Param: country})
+		} else {
+			*a.State = code
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return &AddressError{Violations: violations}
+}
+
+func normalizeField(field *string, normalize func(string) string) {
+	if field != nil {
+		*field = normalize(*field)
+	}
+}
+
+// collapseSpaces trims s and replaces every run of white space with one space
+func collapseSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// normalizeCity title cases a city written entirely in lower or upper case,
+// mixed case is kept since it is most likely intended, e.g. McAllen
+func normalizeCity(city string) string {
+	city = collapseSpaces(city)
+	if city != strings.ToLower(city) && city != strings.ToUpper(city) {
+		return city
+	}
+
+	runes := []rune(strings.ToLower(city))
+	for i, r := range runes {
+		if i == 0 || runes[i-1] == ' ' || runes[i-1] == '-' {
+			runes[i] = unicode.ToUpper(r)
+		}
+	}
+	return string(runes)
+}
+
+// countryCode accepts an ISO 3166-1 alpha-2, alpha-3 or numeric code in any
+// case and returns the alpha-2 code
+func countryCode(value string) (string, bool) {
+	region, err := language.ParseRegion(value)
+	// regions with no alpha-3 code are reserved ones such as UK
+	if err != nil || !region.IsCountry() || region.ISO3() == "ZZZ" {
+		return "", false
+	}
+	return region.String(), true
+}
+
+// subdivisionCode matches value against the codes and names of a country's
+// subdivisions ignoring case
+func subdivisionCode(states map[string]string, value string) (string, bool) {
+	if _, ok := states[strings.ToUpper(value)]; ok {
+		return strings.ToUpper(value), true
+	}
+	for code, name := range states {
+		if strings.EqualFold(name, value) {
+			return code, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,33 @@
+package userauth
+
+import "testing"
+
+// the cases run in a row against the shared postalFormats, a format must not
+// keep state from one code to the next
+func TestNormalizeAddressPostalCodes(t *testing.T) {
+	tests := []struct {
+		country, code, want string
+	}{
+		{"GB", "SW1A1AA", "SW1A 1AA"},
+		{"GB", "M11AE", "M1 1AE"},
+		{"GB", "b33 8th", "B33 8TH"},
+		{"GB", "CR2 6XH", "CR2 6XH"},
+		{"GB", "DN551PT", "DN55 1PT"},
+		{"CA", "k1a0b1", "K1A 0B1"},
+		{"NL", "1234AB", "1234 AB"},
+		{"IN", "110 001", "110001"},
+		{"JP", "1000001", "100-0001"},
+		{"US", "12345-6789", "12345-6789"},
+	}
+	for _, tt := range tests {
+		country, code := tt.country, tt.code
+		a := Address{Country: &country, PostalCode: &code}
+		if err := NormalizeAddress(&a, ""); err != nil {
+			t.Errorf("%s %q: %v", tt.country, tt.code, err)
+			continue
+		}
+		if *a.PostalCode != tt.want {
+			t.Errorf("%s %q: got %q, want %q", tt.country, tt.code, *a.PostalCode, tt.want)
+		}
+	}
+}
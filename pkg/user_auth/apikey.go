@@ -0,0 +1,94 @@
+package userauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// apiKeyHeader carries an API key, scripts send it instead of a Bearer token
+const apiKeyHeader = "X-API-Key"
+
+// apiKeyPrefix starts every API key so leaked keys are easy to search for
+const apiKeyPrefix = "cck_"
+
+const (
+	// defaultAPIKeyDays is the lifetime of a key created without expires_in_days
+	defaultAPIKeyDays = 90
+	maxAPIKeysPerUser = 20
+	// apiKeyTouchInterval limits the last used writes to one a minute per key
+	apiKeyTouchInterval = time.Minute
+)
+
+// API key scopes, a key is only accepted on the routes of its scopes
+const (
+	ScopeProfileRead    = "profile:read"
+	ScopeProfileWrite   = "profile:write"
+	ScopeVehiclesRead   = "vehicles:read"
+	ScopeVehiclesWrite  = "vehicles:write"
+	ScopeAddressesRead  = "addresses:read"
+	ScopeAddressesWrite = "addresses:write"
+	ScopeDocumentsWrite = "documents:write"
+)
+
+var APIKeyScopes = []string{
+	ScopeProfileRead, ScopeProfileWrite, ScopeVehiclesRead, ScopeVehiclesWrite,
+	ScopeAddressesRead, ScopeAddressesWrite, ScopeDocumentsWrite,
+}
+
+// apiKeyRouteScopes is the scope each route needs from an API key by method
+// and route path. Routes missing here, like the password, session, API key
+// and admin routes, need a login token.
+var apiKeyRouteScopes = map[string]string{
+	"GET /" + basePath + "profile":                  ScopeProfileRead,
+	"PATCH /" + basePath + "profile":                ScopeProfileWrite,
+	"GET /" + basePath + "profile/identity-changes": ScopeProfileRead,
+	"GET /" + basePath + "profile/history":          ScopeProfileRead,
+	"GET /" + basePath + "profile/vehicles":         ScopeVehiclesRead,
+	"GET /" + basePath + "profile/vehicles/:id":     ScopeVehiclesRead,
+	"POST /" + basePath + "profile/vehicles":        ScopeVehiclesWrite,
+	"PUT /" + basePath + "profile/vehicles/:id":     ScopeVehiclesWrite,
+	"DELETE /" + basePath + "profile/vehicles/:id":  ScopeVehiclesWrite,
+	"GET /" + basePath + "profile/addresses":        ScopeAddressesRead,
+	"GET /" + basePath + "profile/addresses/:id":    ScopeAddressesRead,
+	"POST /" + basePath + "profile/addresses":       ScopeAddressesWrite,
+	"PUT /" + basePath + "profile/addresses/:id":    ScopeAddressesWrite,
+	"DELETE /" + basePath + "profile/addresses/:id": ScopeAddressesWrite,
+	"POST /" + basePath + "upload-pdf":              ScopeDocumentsWrite,
+}
+
+// APIKeyRequest creates an API key of the logged in user
+type APIKeyRequest struct {
+	Name   string   `json:"name" example:"nightly export" validate:"required,max=100"`
+	Scopes []string `json:"scopes" example:"profile:read,vehicles:read" validate:"required,min=1,dive,oneof=profile:read profile:write vehicles:read vehicles:write addresses:read addresses:write documents:write"`
+	// ExpiresInDays defaults to 90
+	ExpiresInDays int `json:"expires_in_days" example:"30" validate:"omitempty,min=1,max=365"`
+}
+
+// newAPIKey returns the ID and secret of a new key and the key handed to the
+// user, which is both joined behind apiKeyPrefix
+func newAPIKey() (id, secret, key string, err error) {
+	b := make([]byte, 40)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	id, secret = hex.EncodeToString(b[:8]), hex.EncodeToString(b[8:])
+	return id, secret, apiKeyPrefix + id + "_" + secret, nil
+}
+
+// parseAPIKey splits a key into its ID and secret
+func parseAPIKey(key string) (id, secret string, ok bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return "", "", false
+	}
+	id, secret, ok = strings.Cut(rest, "_")
+	return id, secret, ok && id != "" && secret != ""
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
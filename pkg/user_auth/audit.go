@@ -0,0 +1,176 @@
+package userauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/abhiii71/clean-code-abhi/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultAuditPageSize and maxAuditPageSize bound ListAuditEvents
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+type clientIPKey struct{}
+
+type userAgentKey struct{}
+
+type actorKey struct{}
+
+// AuditContext stores the client IP and user agent in the request context so
+// the service can add them to the audit events and sessions it records
+func AuditContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), clientIPKey{}, c.ClientIP())
+		ctx = context.WithValue(ctx, userAgentKey{}, c.Request.UserAgent())
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// withActor returns a copy of ctx carrying the email of the authenticated user
+func withActor(ctx context.Context, email string) context.Context {
+	return context.WithValue(ctx, actorKey{}, email)
+}
+
+func clientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+func userAgentFromContext(ctx context.Context) string {
+	userAgent, _ := ctx.Value(userAgentKey{}).(string)
+	return userAgent
+}
+
+func actorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// audit appends event to the audit log. The log is best effort: a failure is
+// logged and does not fail the operation that was audited.
+func (s *service) audit(ctx context.Context, event AuditEvent) {
+	if event.Actor == "" {
+		event.Actor = actorFromContext(ctx)
+	}
+	event.IP = clientIPFromContext(ctx)
+	event.RequestID = logger.RequestIDFromContext(ctx)
+	// the stored timestamps keep microseconds, the hash has to survive the round trip
+	event.OccurredAt = time.Now().UTC().Truncate(time.Microsecond)
+	if event.Details == nil {
+		event.Details = map[string]string{}
+	}
+
+	err := s.repo.WithTx(ctx, func(tx Repository) error {
+		last, err := tx.LastAuditEvent(ctx)
+		if err != nil {
+			return err
+		}
+		event.PrevHash = ""
+		if last != nil {
+			event.PrevHash = last.Hash
+		}
+		if event.Hash, err = hashAuditEvent(event); err != nil {
+			return err
+		}
+		_, err = tx.CreateAuditEvent(ctx, event)
+		return err
+	})
+	if err != nil {
+		s.log.ErrorContext(ctx, "[Audit] error recording audit event", "type", event.Type, "user_id", event.UserID, "err", err)
+	}
+}
+
+// hashAuditEvent is the hex SHA-256 of the event fields and PrevHash in a
+// fixed JSON layout, the ID is left out as it is only known once stored
+func hashAuditEvent(e AuditEvent) (string, error) {
+	b, err := json.Marshal(struct {
+		PrevHash   string            `json:"prev_hash"`
+		OccurredAt string            `json:"occurred_at"`
+		Type       AuditEventType    `json:"type"`
+		Actor      string            `json:"actor"`
+		UserID     int               `json:"user_id"`
+		IP         string            `json:"ip"`
+		RequestID  string            `json:"request_id"`
+		Details    map[string]string `json:"details"`
+	}{e.PrevHash, e.OccurredAt.UTC().Format(time.RFC3339Nano), e.Type, e.Actor, e.UserID, e.IP, e.RequestID, e.Details})
+	if err != nil {
+		return "", fmt.Errorf("marshal audit event: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditVerification is the outcome of VerifyAuditLog, BrokenAt is the id of
+// the first event that does not fit the chain or of the last event when the
+// expected head is missing
+type AuditVerification struct {
+	Events   int
+	Head     string
+	Valid    bool
+	BrokenAt int
+	Reason   string
+}
+
+// VerifyAuditLog walks the whole audit log in id order and checks that every
+// event links to the one before it and still matches its hash. Removing the
+// newest events can't be detected from the chain alone, pass the Head of an
+// earlier verification as head to check it is still part of the chain.
+func VerifyAuditLog(ctx context.Context, repo Repository, head string) (AuditVerification, error) {
+	result := AuditVerification{Valid: true}
+	seenHead := head == ""
+	filter := AuditEventFilter{Limit: maxAuditPageSize}
+	for {
+		events, err := repo.ListAuditEvents(ctx, filter)
+		if err != nil {
+			return result, err
+		}
+
+		for _, event := range events {
+			hash, err := hashAuditEvent(event)
+			if err != nil {
+				return result, err
+			}
+			switch {
+			case event.PrevHash != result.Head:
+				result.Reason = "prev_hash does not match the hash of the previous event"
+			case hash != event.Hash:
+				result.Reason = "hash does not match the event"
+			}
+			if result.Reason != "" {
+				result.Valid, result.BrokenAt = false, event.ID
+				return result, nil
+			}
+			result.Events++
+			result.Head = event.Hash
+			seenHead = seenHead || event.Hash == head
+			filter.AfterID = event.ID
+		}
+
+		if len(events) < filter.Limit {
+			break
+		}
+	}
+
+	if !seenHead {
+		result.Valid, result.BrokenAt = false, filter.AfterID
+		result.Reason = "the expected head is not part of the chain, events were removed"
+	}
+	return result, nil
+}
+
+// auditPageSize applies the default and the maximum to a requested page size
+func auditPageSize(limit int) int {
+	if limit <= 0 {
+		return defaultAuditPageSize
+	}
+	return min(limit, maxAuditPageSize)
+}
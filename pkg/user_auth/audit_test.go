@@ -0,0 +1,159 @@
+package userauth
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// tamperAuditLog changes the stored audit log of a memory repository, the
+// way someone with write access to the table could
+func tamperAuditLog(t *testing.T, repo Repository, tamper func(events []AuditEvent) []AuditEvent) {
+	t.Helper()
+	err := repo.(*memoryRepository).run(func(state *memoryState) error {
+		state.auditEvents = tamper(slices.Clone(state.auditEvents))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("tamper with the audit log: %v", err)
+	}
+}
+
+// newAuditLog records n events through the service into a memory repository
+func newAuditLog(t *testing.T, n int) Repository {
+	t.Helper()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := &service{repo: NewMemoryRepository(log), log: log}
+	for i := 1; i <= n; i++ {
+		s.audit(context.Background(), AuditEvent{
+			Type:    AuditLoginSucceeded,
+			Actor:   "user" + strconv.Itoa(i) + "@example.com",
+			UserID:  i,
+			Details: map[string]string{"n": strconv.Itoa(i)},
+		})
+	}
+	return s.repo
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	keep := func(e []AuditEvent) []AuditEvent { return e }
+	tests := []struct {
+		name string
+		// head picks the head of an earlier run from the intact log, nil passes none
+		head         func(events []AuditEvent) string
+		tamper       func(events []AuditEvent) []AuditEvent
+		wantValid    bool
+		wantBrokenAt int
+	}{
+		{"intact chain", nil, keep, true, 0},
+		{"intact chain with its head", func(e []AuditEvent) string { return e[4].Hash }, keep, true, 0},
+		{"intact chain with an older head", func(e []AuditEvent) string { return e[2].Hash }, keep, true, 0},
+		{"changed event", nil, func(e []AuditEvent) []AuditEvent {
+			e[2].Actor = "mallory@example.com"
+			return e
+		}, false, 3},
+		{"changed event with its hash recomputed", nil, func(e []AuditEvent) []AuditEvent {
+			e[2].Details = map[string]string{"n": "changed"}
+			e[2].Hash, _ = hashAuditEvent(e[2])
+			return e
+		}, false, 4},
+		{"removed middle event", nil, func(e []AuditEvent) []AuditEvent {
+			return slices.Delete(e, 2, 3)
+		}, false, 4},
+		{"removed first event", nil, func(e []AuditEvent) []AuditEvent {
+			return e[1:]
+		}, false, 2},
+		// the chain alone can't tell, only the head of an earlier run can
+		{"removed tail without a head", nil, func(e []AuditEvent) []AuditEvent {
+			return e[:3]
+		}, true, 0},
+		{"removed tail detected with the head", func(e []AuditEvent) string { return e[4].Hash }, func(e []AuditEvent) []AuditEvent {
+			return e[:3]
+		}, false, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newAuditLog(t, 5)
+			events, err := repo.ListAuditEvents(ctx, AuditEventFilter{Limit: maxAuditPageSize})
+			if err != nil {
+				t.Fatalf("ListAuditEvents: %v", err)
+			}
+			head := ""
+			if tt.head != nil {
+				head = tt.head(events)
+			}
+			tamperAuditLog(t, repo, tt.tamper)
+
+			got, err := VerifyAuditLog(ctx, repo, head)
+			if err != nil {
+				t.Fatalf("VerifyAuditLog: %v", err)
+			}
+			if got.Valid != tt.wantValid || got.BrokenAt != tt.wantBrokenAt {
+				t.Errorf("got valid %v broken at %d (%s), want valid %v broken at %d", got.Valid, got.BrokenAt, got.Reason, tt.wantValid, tt.wantBrokenAt)
+			}
+			if got.Valid && got.Head == "" {
+				t.Errorf("got %+v, want the head of the chain", got)
+			}
+			if !got.Valid && got.Reason == "" {
+				t.Error("a broken chain has no reason")
+			}
+		})
+	}
+}
+
+func TestVerifyAuditLogAcrossPages(t *testing.T) {
+	ctx := context.Background()
+	repo := newAuditLog(t, maxAuditPageSize+5)
+	got, err := VerifyAuditLog(ctx, repo, "")
+	if err != nil {
+		t.Fatalf("VerifyAuditLog: %v", err)
+	}
+	if !got.Valid || got.Events != maxAuditPageSize+5 {
+		t.Fatalf("got %+v, want an intact chain of %d events", got, maxAuditPageSize+5)
+	}
+
+	// the first event of the second page links to the last one of the first page
+	tamperAuditLog(t, repo, func(e []AuditEvent) []AuditEvent {
+		return slices.DeleteFunc(e, func(event AuditEvent) bool { return event.ID == maxAuditPageSize })
+	})
+	got, err = VerifyAuditLog(ctx, repo, "")
+	if err != nil {
+		t.Fatalf("VerifyAuditLog: %v", err)
+	}
+	if got.Valid || got.BrokenAt != maxAuditPageSize+1 {
+		t.Errorf("got valid %v broken at %d, want broken at %d", got.Valid, got.BrokenAt, maxAuditPageSize+1)
+	}
+}
+
+func TestHashAuditEventCoversEveryField(t *testing.T) {
+	base := AuditEvent{Type: AuditLoginSucceeded, Actor: "jane@example.com", UserID: 1, IP: "203.0.113.7", RequestID: "req", PrevHash: "prev", Details: map[string]string{"a": "b"}}
+	want, err := hashAuditEvent(base)
+	if err != nil {
+		t.Fatalf("hashAuditEvent: %v", err)
+	}
+	changes := map[string]func(e *AuditEvent){
+		"type":        func(e *AuditEvent) { e.Type = AuditLoginFailed },
+		"actor":       func(e *AuditEvent) { e.Actor = "john@example.com" },
+		"user_id":     func(e *AuditEvent) { e.UserID = 2 },
+		"ip":          func(e *AuditEvent) { e.IP = "198.51.100.1" },
+		"request_id":  func(e *AuditEvent) { e.RequestID = "other" },
+		"prev_hash":   func(e *AuditEvent) { e.PrevHash = "other" },
+		"details":     func(e *AuditEvent) { e.Details = map[string]string{"a": "c"} },
+		"occurred_at": func(e *AuditEvent) { e.OccurredAt = e.OccurredAt.Add(1) },
+	}
+	for field, change := range changes {
+		event := base
+		change(&event)
+		if got, _ := hashAuditEvent(event); got == want {
+			t.Errorf("changing %s keeps the hash", field)
+		}
+	}
+	base.ID = 42
+	if got, _ := hashAuditEvent(base); got != want {
+		t.Error("the ID changes the hash, it is only known once stored")
+	}
+}
@@ -1,8 +1,6 @@
 package userauth
 
 import (
-	"net/url"
-	"regexp"
 	"strings"
 	"time"
 )
@@ -12,59 +10,108 @@ type Date time.Time
 // UserRegisterRequest represents the data required for user registration
 type UserRegisterRequest struct {
 	ID        int    `json:"id" example:"101"` // optional
-	FirstName string `json:"first_name" example:"Abhishek"`
-	LastName  string `json:"last_name" example:"Verma"`
-	Email     string `json:"email" example:"abhishek@example.com"`
-	Password  string `json:"password" example:"Password@123"`
-	DOB       Date   `json:"dob" example:"2000-01-01"`
-	Gender    string `json:"gender" example:"male/female"`
+	FirstName string `json:"first_name" example:"Abhishek" validate:"required,min=2,max=100"`
+	LastName  string `json:"last_name" example:"Verma" validate:"max=100"`
+	Email     string `json:"email" example:"abhishek@example.com" validate:"required,max=255,email_format"`
+	// Password is checked against the PasswordPolicy by the service
+	Password string `json:"password" example:"Password@123" validate:"required"`
+	DOB      Date   `json:"dob" example:"2000-01-01" validate:"required,adult"`
+	Gender   string `json:"gender" example:"female" validate:"required,gender"`
+	// GenderDescription is the free text of a self_described gender, it is
+	// dropped for the other genders
+	GenderDescription string `json:"gender_description" example:"genderfluid" validate:"max=64"`
 }
 
-// for user_information
+// UserInformationRequest is the patchable part of the profile. PATCH /profile
+// applies its JSON merge patch or JSON patch to the stored profile, so every
+// field holds the value the profile should have afterwards.
 type UserInformationRequest struct {
-	ID        int     `json:"id"`
-	Address   Address `json:"address"`
-	Vehicle   Vehicle `json:"vehicle"`
-	CreatedAt string  `json:"created_at"`
-	UpdatedAt string  `json:"updated_at"`
+	ID int `json:"-"`
+	// The identity fields follow the UserRegisterRequest rules
+	FirstName string `json:"first_name" example:"Abhishek" validate:"required,min=2,max=100"`
+	LastName  string `json:"last_name" example:"Verma" validate:"max=100"`
+	DOB       Date   `json:"dob" swaggertype:"string" example:"2000-01-01" validate:"required,adult"`
+	Gender    string `json:"gender" example:"female" validate:"required,gender"`
+	// GenderDescription follows the UserRegisterRequest rules
+	GenderDescription string  `json:"gender_description" example:"genderfluid" validate:"max=64"`
+	Address           Address `json:"address"`
+	// Version is the profile version the client last read, taken from If-Match.
+	// AnyVersion skips the check.
+	Version int `json:"-"`
 }
 
-// for address field inside userinformation
+// AnyVersion is used when the client sends "If-Match: *"
+const AnyVersion = -1
+
+// Address is the profile address, it is stored as the user's primary address
 type Address struct {
-	City       *string `json:"city"`
-	State      *string `json:"state"`
-	PostalCode *string `json:"postal_code"`
-	Country    *string `json:"country"`
+	City       *string `json:"city" validate:"omitempty,max=100"`
+	State      *string `json:"state" validate:"omitempty,max=100"`
+	PostalCode *string `json:"postal_code" validate:"omitempty,max=20"`
+	Country    *string `json:"country" validate:"omitempty,max=100"`
+}
+
+// AddressRequest creates or replaces an address of the logged in user
+type AddressRequest struct {
+	Type AddressType `json:"type" example:"home" validate:"required,oneof=home mailing previous"`
+	Address
+	ValidFrom *Date `json:"valid_from" swaggertype:"string" example:"2018-04-01"`
+	// ValidUntil is empty while the user still lives at the address
+	ValidUntil *Date `json:"valid_until" swaggertype:"string" example:"2022-03-31"`
+	// IsPrimary makes this the profile address, the previous primary one is demoted
+	IsPrimary bool `json:"is_primary" example:"true"`
 }
 
-// for address field inside userinformation
-type Vehicle struct {
-	Car  *bool `json:"car"`
-	Bike *bool `json:"bike"`
+// VehicleRequest creates or replaces a vehicle of the logged in user
+type VehicleRequest struct {
+	Type               VehicleType `json:"type" example:"car" validate:"required,oneof=car bike truck scooter other"`
+	Make               string      `json:"make" example:"Maruti Suzuki" validate:"max=100"`
+	Model              string      `json:"model" example:"Swift" validate:"max=100"`
+	Year               int         `json:"year" example:"2019" validate:"omitempty,min=1886,max=2100"`
+	RegistrationNumber string      `json:"registration_number" example:"MH12AB1234" validate:"max=20"`
+	OwnedFrom          *Date       `json:"owned_from" swaggertype:"string" example:"2019-06-01"`
+	// OwnedUntil is empty while the user still owns the vehicle
+	OwnedUntil *Date `json:"owned_until" swaggertype:"string" example:"2023-01-31"`
 }
 
-// enum types
+// Gender is the gender a user identifies with, the options offered to users
+// are configured with GENDER_OPTIONS
 type Gender string
 
 const (
-	Male    Gender = "M"
-	Female  Gender = "F"
-	Shemale Gender = "S"
+	GenderFemale         Gender = "female"
+	GenderMale           Gender = "male"
+	GenderNonBinary      Gender = "non_binary"
+	GenderSelfDescribed  Gender = "self_described"
+	GenderPreferNotToSay Gender = "prefer_not_to_say"
 )
 
+// Genders are all the genders a profile can store, in the order they are offered
+var Genders = []Gender{GenderFemale, GenderMale, GenderNonBinary, GenderSelfDescribed, GenderPreferNotToSay}
+
+// ChangePasswordRequest replaces the password of the logged in user
+type ChangePasswordRequest struct {
+	// CurrentPassword may be left out by users who signed up with an identity
+	// provider and have not set a password yet
+	CurrentPassword string `json:"current_password" example:"Password@123" validate:"max=1024"`
+	// NewPassword is checked against the PasswordPolicy by the service
+	NewPassword string `json:"new_password" example:"N3w-Password" validate:"required"`
+}
+
 // for login
 // these examples are not necessary but we follow
 type UserLoginRequest struct {
-	Email    string `json:"email" example:"user@example.com"`
-	Password string `json:"password" example:"securePassword123"`
+	Email    string `json:"email" example:"user@example.com" validate:"required,max=255,email_format"`
+	Password string `json:"password" example:"securePassword123" validate:"required,max=1024"`
 }
 
-// emailregex
-var emailregex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z.-]+\.[a-zA-Z]{2,}$`)
-
 // function to handle the dob time
 func (d *Date) UnmarshalJSON(b []byte) error {
 	s := string(b)
+	// null leaves the date as is, like encoding/json does for its own types
+	if s == "null" {
+		return nil
+	}
 	// remove quotes from JSON string input
 	s = strings.Trim(s, `"`)
 
@@ -82,62 +129,3 @@ func (d Date) MarshalJSON() ([]byte, error) {
 	t := time.Time(d)
 	return []byte(`"` + t.Format("2006-01-02") + `"`), nil
 }
-
-func (u *UserRegisterRequest) Valid() url.Values {
-	err := url.Values{}
-
-	// first name
-	if len(u.FirstName) == 0 {
-		err.Add("first_name", " name cannot be empty")
-	} else if len(u.FirstName) < 2 {
-		err.Add("first_name", "name too short")
-	}
-
-	// email regex
-	if len(u.Email) == 0 {
-		err.Add("email: ", "email is required")
-	} else {
-		if !emailregex.MatchString(u.Email) {
-			err.Add("email", "invalid email format")
-		}
-		if regexp.MustCompile(`\.\.`).MatchString(u.Email) {
-			err.Add("email", "email cannot contains double dots")
-		}
-	}
-
-	// password
-	if len(u.Password) == 0 {
-		err.Add("password", "password is required")
-	} else if len(u.Password) < 8 {
-		err.Add("password", "must be at least 8 characters")
-	} else {
-		hasUpper := regexp.MustCompile(`[A-Z]`).MatchString(u.Password)
-		hasLower := regexp.MustCompile(`[a-z]`).MatchString(u.Password)
-		hasSpecial := regexp.MustCompile(`[^a-zA-Z0-9]`).MatchString(u.Password)
-		if !hasUpper || !hasLower || !hasSpecial {
-			err.Add("password", "must include 1 uppercase, 1 lowercase, 1 special character")
-			// regex for strong password
-			// regex := `^(?=*[a-z])(?=.*[A-Z])(?=.*\W{8,}$)`
-		}
-	}
-	// dob
-	today := time.Now()
-	dob := time.Time(u.DOB)
-	age := today.Year() - dob.Year()
-	if today.Month() < dob.Month() || today.Month() == dob.Month() && today.Day() < dob.Day() {
-		age--
-	}
-	if age < 18 {
-		err.Add("dob", "must be at least 18 years old")
-	}
-
-	// gender
-	switch Gender(u.Gender) {
-	case Male, Female, Shemale:
-		// ok
-	default:
-		err.Add("gender", "must be one of M, F or S")
-	}
-
-	return err
-}
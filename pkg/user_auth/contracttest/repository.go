@@ -0,0 +1,981 @@
+// Package contracttest holds the behaviour every userauth.Repository
+// implementation must share. Implementations run it from their own tests:
+//
+//	contracttest.RunRepository(t, func(t *testing.T) userauth.Repository {
+//		return userauth.NewMemoryRepository(slog.Default())
+//	})
+package contracttest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	userauth "github.com/abhiii71/clean-code-abhi/pkg/user_auth"
+)
+
+// RunRepository runs the contract suite, newRepo must return an empty repository
+func RunRepository(t *testing.T, newRepo func(t *testing.T) userauth.Repository) {
+	t.Run("register and find by email case-insensitively", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+
+		id := register(t, repo, "Jane.Doe@Example.com")
+
+		user, err := repo.FindUserByEmail(ctx, "jane.doe@example.COM")
+		if err != nil {
+			t.Fatalf("FindUserByEmail: %v", err)
+		}
+		if strconv.Itoa(user.ID) != id {
+			t.Errorf("got user id %d, want %s", user.ID, id)
+		}
+		if user.Password != "hashed" {
+			t.Errorf("got password %q, want the stored hash", user.Password)
+		}
+	})
+
+	t.Run("email is unique regardless of case", func(t *testing.T) {
+		repo := newRepo(t)
+		register(t, repo, "jane@example.com")
+
+		_, err := repo.UserRegister(context.Background(), registerRequest("JANE@example.com"))
+		if !errors.Is(err, userauth.ErrEmailTaken) {
+			t.Fatalf("got %v, want ErrEmailTaken", err)
+		}
+	})
+
+	t.Run("unknown email is ErrUserNotFound", func(t *testing.T) {
+		repo := newRepo(t)
+
+		if _, err := repo.FindUserByEmail(context.Background(), "nobody@example.com"); !errors.Is(err, userauth.ErrUserNotFound) {
+			t.Fatalf("got %v, want ErrUserNotFound", err)
+		}
+	})
+
+	t.Run("find by id without user information", func(t *testing.T) {
+		repo := newRepo(t)
+		id := register(t, repo, "jane@example.com")
+
+		user, err := repo.FindUserByID(context.Background(), id)
+		if err != nil {
+			t.Fatalf("FindUserByID: %v", err)
+		}
+		if user.FirstName != "Jane" || user.Gender != "female" {
+			t.Errorf("got %+v, want the registered user", user)
+		}
+		if !user.DOB.Equal(time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("got dob %v", user.DOB)
+		}
+		if user.Address != (userauth.Address{}) {
+			t.Errorf("got address %+v, want the zero value", user.Address)
+		}
+		if user.Version != 0 {
+			t.Errorf("got version %d, want 0", user.Version)
+		}
+	})
+
+	t.Run("unknown id is ErrUserNotFound", func(t *testing.T) {
+		repo := newRepo(t)
+
+		_, err := repo.FindUserByID(context.Background(), "4242")
+		if !errors.Is(err, userauth.ErrUserNotFound) {
+			t.Fatalf("got %v, want ErrUserNotFound", err)
+		}
+	})
+
+	t.Run("update user information bumps the version", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		id := register(t, repo, "jane@example.com")
+		req := infoRequest(t, id, 0)
+
+		version, err := repo.UpdateUserInfo(ctx, req)
+		if err != nil {
+			t.Fatalf("first UpdateUserInfo: %v", err)
+		}
+		if version != 1 {
+			t.Errorf("got version %d after insert, want 1", version)
+		}
+
+		req.Version = version
+		version, err = repo.UpdateUserInfo(ctx, req)
+		if err != nil {
+			t.Fatalf("second UpdateUserInfo: %v", err)
+		}
+		if version != 2 {
+			t.Errorf("got version %d after update, want 2", version)
+		}
+
+		user, err := repo.FindUserByID(ctx, id)
+		if err != nil {
+			t.Fatalf("FindUserByID: %v", err)
+		}
+		if user.Version != 2 {
+			t.Errorf("got stored version %d, want 2", user.Version)
+		}
+	})
+
+	t.Run("stale version is rejected", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		id := register(t, repo, "jane@example.com")
+		req := infoRequest(t, id, 0)
+
+		if _, err := repo.UpdateUserInfo(ctx, req); err != nil {
+			t.Fatalf("UpdateUserInfo: %v", err)
+		}
+
+		req.Version = 7
+		if _, err := repo.UpdateUserInfo(ctx, req); !errors.Is(err, userauth.ErrVersionMismatch) {
+			t.Fatalf("got %v, want ErrVersionMismatch", err)
+		}
+
+		req.Version = userauth.AnyVersion
+		if _, err := repo.UpdateUserInfo(ctx, req); err != nil {
+			t.Fatalf("AnyVersion should skip the check: %v", err)
+		}
+	})
+
+	t.Run("update password", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		id := register(t, repo, "jane@example.com")
+
+		if err := repo.UpdatePassword(ctx, id, "rehashed"); err != nil {
+			t.Fatalf("UpdatePassword: %v", err)
+		}
+		user, err := repo.FindUserByEmail(ctx, "jane@example.com")
+		if err != nil {
+			t.Fatalf("FindUserByEmail: %v", err)
+		}
+		if user.Password != "rehashed" {
+			t.Errorf("got password %q, want the new hash", user.Password)
+		}
+
+		if err := repo.UpdatePassword(ctx, "4242", "rehashed"); !errors.Is(err, userauth.ErrUserNotFound) {
+			t.Fatalf("got %v, want ErrUserNotFound", err)
+		}
+	})
+
+	t.Run("vehicles belong to their user", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		jane := register(t, repo, "jane@example.com")
+		john := register(t, repo, "john@example.com")
+
+		from := userauth.Date(time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC))
+		created, err := repo.CreateVehicle(ctx, userauth.UserVehicle{
+			UserID:             atoi(t, jane),
+			Type:               userauth.VehicleCar,
+			Make:               "Maruti Suzuki",
+			Year:               2019,
+			RegistrationNumber: "MH12AB1234",
+			OwnedFrom:          &from,
+		})
+		if err != nil {
+			t.Fatalf("CreateVehicle: %v", err)
+		}
+		if created.ID == 0 || created.CreatedAt.IsZero() {
+			t.Errorf("got %+v, want the stored row", created)
+		}
+		if _, err := repo.CreateVehicle(ctx, userauth.UserVehicle{UserID: atoi(t, jane), Type: userauth.VehicleBike}); err != nil {
+			t.Fatalf("CreateVehicle: %v", err)
+		}
+
+		vehicles, err := repo.ListVehicles(ctx, jane)
+		if err != nil {
+			t.Fatalf("ListVehicles: %v", err)
+		}
+		if len(vehicles) != 2 || vehicles[0].ID != created.ID || vehicles[1].Type != userauth.VehicleBike {
+			t.Fatalf("got %+v, want the car then the bike", vehicles)
+		}
+		if vehicles[0].Make != "Maruti Suzuki" || vehicles[0].Model != "" || vehicles[0].OwnedUntil != nil {
+			t.Errorf("got %+v, want the fields as created", vehicles[0])
+		}
+		if vehicles[0].OwnedFrom == nil || !time.Time(*vehicles[0].OwnedFrom).Equal(time.Time(from)) {
+			t.Errorf("got owned_from %v, want %v", vehicles[0].OwnedFrom, time.Time(from))
+		}
+
+		if _, err := repo.FindVehicle(ctx, john, created.ID); !errors.Is(err, userauth.ErrVehicleNotFound) {
+			t.Errorf("FindVehicle of another user: got %v, want ErrVehicleNotFound", err)
+		}
+		if _, err := repo.UpdateVehicle(ctx, userauth.UserVehicle{ID: created.ID, UserID: atoi(t, john), Type: userauth.VehicleCar}); !errors.Is(err, userauth.ErrVehicleNotFound) {
+			t.Errorf("UpdateVehicle of another user: got %v, want ErrVehicleNotFound", err)
+		}
+		if err := repo.DeleteVehicle(ctx, john, created.ID); !errors.Is(err, userauth.ErrVehicleNotFound) {
+			t.Errorf("DeleteVehicle of another user: got %v, want ErrVehicleNotFound", err)
+		}
+		if _, err := repo.CreateVehicle(ctx, userauth.UserVehicle{UserID: 4242, Type: userauth.VehicleCar}); !errors.Is(err, userauth.ErrUserNotFound) {
+			t.Errorf("CreateVehicle for unknown user: got %v, want ErrUserNotFound", err)
+		}
+	})
+
+	t.Run("update and delete a vehicle", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		id := register(t, repo, "jane@example.com")
+
+		created, err := repo.CreateVehicle(ctx, userauth.UserVehicle{UserID: atoi(t, id), Type: userauth.VehicleCar, Make: "Tata"})
+		if err != nil {
+			t.Fatalf("CreateVehicle: %v", err)
+		}
+
+		updated, err := repo.UpdateVehicle(ctx, userauth.UserVehicle{ID: created.ID, UserID: atoi(t, id), Type: userauth.VehicleTruck, Model: "Ace"})
+		if err != nil {
+			t.Fatalf("UpdateVehicle: %v", err)
+		}
+		if updated.Type != userauth.VehicleTruck || updated.Make != "" || updated.Model != "Ace" {
+			t.Errorf("got %+v, want every field replaced", updated)
+		}
+
+		if err := repo.DeleteVehicle(ctx, id, created.ID); err != nil {
+			t.Fatalf("DeleteVehicle: %v", err)
+		}
+		if _, err := repo.FindVehicle(ctx, id, created.ID); !errors.Is(err, userauth.ErrVehicleNotFound) {
+			t.Errorf("got %v after delete, want ErrVehicleNotFound", err)
+		}
+		vehicles, err := repo.ListVehicles(ctx, id)
+		if err != nil || len(vehicles) != 0 {
+			t.Errorf("got %v, %v, want an empty list", vehicles, err)
+		}
+	})
+
+	t.Run("primary address is the profile address", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		id := register(t, repo, "jane@example.com")
+
+		mailing, err := repo.CreateAddress(ctx, userauth.UserAddress{UserID: atoi(t, id), Type: userauth.AddressMailing, Address: inCity("Mumbai")})
+		if err != nil {
+			t.Fatalf("CreateAddress: %v", err)
+		}
+		from := userauth.Date(time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC))
+		home, err := repo.CreateAddress(ctx, userauth.UserAddress{UserID: atoi(t, id), Type: userauth.AddressHome, Address: inCity("Pune"), ValidFrom: &from, IsPrimary: true})
+		if err != nil {
+			t.Fatalf("CreateAddress: %v", err)
+		}
+		if home.ID == 0 || home.CreatedAt.IsZero() || !home.IsPrimary {
+			t.Errorf("got %+v, want the stored primary row", home)
+		}
+
+		user, err := repo.FindUserByID(ctx, id)
+		if err != nil {
+			t.Fatalf("FindUserByID: %v", err)
+		}
+		if user.Address.City == nil || *user.Address.City != "Pune" || user.Address.Country != nil {
+			t.Errorf("got address %+v, want the primary one", user.Address)
+		}
+
+		addresses, err := repo.ListAddresses(ctx, id)
+		if err != nil {
+			t.Fatalf("ListAddresses: %v", err)
+		}
+		if len(addresses) != 2 || addresses[0].ID != mailing.ID || addresses[1].ID != home.ID {
+			t.Fatalf("got %+v, want the mailing then the home address", addresses)
+		}
+		if addresses[1].ValidFrom == nil || !time.Time(*addresses[1].ValidFrom).Equal(time.Time(from)) || addresses[1].ValidUntil != nil {
+			t.Errorf("got validity %v to %v, want from %v", addresses[1].ValidFrom, addresses[1].ValidUntil, time.Time(from))
+		}
+	})
+
+	t.Run("a user has one primary address", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		jane := register(t, repo, "jane@example.com")
+		john := register(t, repo, "john@example.com")
+
+		home, err := repo.CreateAddress(ctx, userauth.UserAddress{UserID: atoi(t, jane), Type: userauth.AddressHome, IsPrimary: true})
+		if err != nil {
+			t.Fatalf("CreateAddress: %v", err)
+		}
+		if _, err := repo.CreateAddress(ctx, userauth.UserAddress{UserID: atoi(t, john), Type: userauth.AddressHome, IsPrimary: true}); err != nil {
+			t.Fatalf("primary address of another user: %v", err)
+		}
+		_, err = repo.CreateAddress(ctx, userauth.UserAddress{UserID: atoi(t, jane), Type: userauth.AddressMailing, IsPrimary: true})
+		if !errors.Is(err, userauth.ErrPrimaryAddressTaken) {
+			t.Fatalf("second primary address: got %v, want ErrPrimaryAddressTaken", err)
+		}
+
+		if err := repo.ClearPrimaryAddress(ctx, jane); err != nil {
+			t.Fatalf("ClearPrimaryAddress: %v", err)
+		}
+		stored, err := repo.FindAddress(ctx, jane, home.ID)
+		if err != nil {
+			t.Fatalf("FindAddress: %v", err)
+		}
+		if stored.IsPrimary {
+			t.Error("address is still primary after ClearPrimaryAddress")
+		}
+		if _, err := repo.CreateAddress(ctx, userauth.UserAddress{UserID: atoi(t, jane), Type: userauth.AddressMailing, IsPrimary: true}); err != nil {
+			t.Fatalf("primary address after clearing: %v", err)
+		}
+
+		addresses, err := repo.ListAddresses(ctx, john)
+		if err != nil || len(addresses) != 1 || !addresses[0].IsPrimary {
+			t.Errorf("got %+v, %v, want the other user's primary address untouched", addresses, err)
+		}
+	})
+
+	t.Run("addresses belong to their user", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		jane := register(t, repo, "jane@example.com")
+		john := register(t, repo, "john@example.com")
+
+		created, err := repo.CreateAddress(ctx, userauth.UserAddress{UserID: atoi(t, jane), Type: userauth.AddressHome, Address: inCity("Pune")})
+		if err != nil {
+			t.Fatalf("CreateAddress: %v", err)
+		}
+
+		if _, err := repo.FindAddress(ctx, john, created.ID); !errors.Is(err, userauth.ErrAddressNotFound) {
+			t.Errorf("FindAddress of another user: got %v, want ErrAddressNotFound", err)
+		}
+		if _, err := repo.UpdateAddress(ctx, userauth.UserAddress{ID: created.ID, UserID: atoi(t, john), Type: userauth.AddressHome}); !errors.Is(err, userauth.ErrAddressNotFound) {
+			t.Errorf("UpdateAddress of another user: got %v, want ErrAddressNotFound", err)
+		}
+		if err := repo.DeleteAddress(ctx, john, created.ID); !errors.Is(err, userauth.ErrAddressNotFound) {
+			t.Errorf("DeleteAddress of another user: got %v, want ErrAddressNotFound", err)
+		}
+		if _, err := repo.CreateAddress(ctx, userauth.UserAddress{UserID: 4242, Type: userauth.AddressHome}); !errors.Is(err, userauth.ErrUserNotFound) {
+			t.Errorf("CreateAddress for unknown user: got %v, want ErrUserNotFound", err)
+		}
+
+		country := "IN"
+		updated, err := repo.UpdateAddress(ctx, userauth.UserAddress{ID: created.ID, UserID: atoi(t, jane), Type: userauth.AddressPrevious, Address: userauth.Address{Country: &country}})
+		if err != nil {
+			t.Fatalf("UpdateAddress: %v", err)
+		}
+		if updated.Type != userauth.AddressPrevious || updated.City != nil || updated.Country == nil || *updated.Country != "IN" {
+			t.Errorf("got %+v, want every field replaced", updated)
+		}
+
+		if err := repo.DeleteAddress(ctx, jane, created.ID); err != nil {
+			t.Fatalf("DeleteAddress: %v", err)
+		}
+		addresses, err := repo.ListAddresses(ctx, jane)
+		if err != nil || len(addresses) != 0 {
+			t.Errorf("got %v, %v, want an empty list", addresses, err)
+		}
+	})
+
+	t.Run("update user identity", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		id := register(t, repo, "jane@example.com")
+
+		user, err := repo.FindUserByID(ctx, id)
+		if err != nil {
+			t.Fatalf("FindUserByID: %v", err)
+		}
+		user.FirstName, user.LastName, user.Gender = "Janet", "Roe", "male"
+		user.DOB = time.Date(1991, 2, 3, 0, 0, 0, 0, time.UTC)
+		if err := repo.UpdateUserIdentity(ctx, *user); err != nil {
+			t.Fatalf("UpdateUserIdentity: %v", err)
+		}
+
+		stored, err := repo.FindUserByID(ctx, id)
+		if err != nil {
+			t.Fatalf("FindUserByID: %v", err)
+		}
+		if stored.FirstName != "Janet" || stored.LastName != "Roe" || stored.Gender != "male" || !stored.DOB.Equal(user.DOB) {
+			t.Errorf("got %+v, want the new identity", stored)
+		}
+
+		if err := repo.UpdateUserIdentity(ctx, userauth.User{ID: 4242, FirstName: "Nobody"}); !errors.Is(err, userauth.ErrUserNotFound) {
+			t.Errorf("got %v, want ErrUserNotFound", err)
+		}
+	})
+
+	t.Run("identity changes are recorded and decided once", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		jane := register(t, repo, "jane@example.com")
+		john := register(t, repo, "john@example.com")
+
+		applied, err := repo.CreateIdentityChange(ctx, userauth.IdentityChange{
+			UserID: atoi(t, jane), Field: userauth.IdentityFirstName, OldValue: "Jane", NewValue: "Janet", Status: userauth.IdentityChangeApplied,
+		})
+		if err != nil {
+			t.Fatalf("CreateIdentityChange: %v", err)
+		}
+		if applied.ID == 0 || applied.RequestedAt.IsZero() || applied.DecidedAt != nil || applied.DecidedBy != "" {
+			t.Errorf("got %+v, want the stored undecided row", applied)
+		}
+
+		pending := userauth.IdentityChange{
+			UserID: atoi(t, jane), Field: userauth.IdentityDOB, OldValue: "1990-05-17", NewValue: "1991-02-03", Status: userauth.IdentityChangePending,
+		}
+		created, err := repo.CreateIdentityChange(ctx, pending)
+		if err != nil {
+			t.Fatalf("CreateIdentityChange: %v", err)
+		}
+		if _, err := repo.CreateIdentityChange(ctx, pending); !errors.Is(err, userauth.ErrIdentityChangePending) {
+			t.Fatalf("second pending change: got %v, want ErrIdentityChangePending", err)
+		}
+		pending.UserID = atoi(t, john)
+		if _, err := repo.CreateIdentityChange(ctx, pending); err != nil {
+			t.Fatalf("pending change of another user: %v", err)
+		}
+		pending.UserID = 4242
+		if _, err := repo.CreateIdentityChange(ctx, pending); !errors.Is(err, userauth.ErrUserNotFound) {
+			t.Errorf("change of unknown user: got %v, want ErrUserNotFound", err)
+		}
+
+		changes, err := repo.ListIdentityChanges(ctx, userauth.IdentityChangeFilter{UserID: atoi(t, jane)})
+		if err != nil {
+			t.Fatalf("ListIdentityChanges: %v", err)
+		}
+		if len(changes) != 2 || changes[0].ID != applied.ID || changes[1].ID != created.ID {
+			t.Fatalf("got %+v, want the applied then the pending change", changes)
+		}
+		changes, err = repo.ListIdentityChanges(ctx, userauth.IdentityChangeFilter{Status: userauth.IdentityChangePending})
+		if err != nil || len(changes) != 2 {
+			t.Fatalf("got %+v, %v, want the pending changes of both users", changes, err)
+		}
+
+		decided, err := repo.DecideIdentityChange(ctx, created.ID, userauth.IdentityChangeApproved, "admin@example.com")
+		if err != nil {
+			t.Fatalf("DecideIdentityChange: %v", err)
+		}
+		if decided.Status != userauth.IdentityChangeApproved || decided.DecidedAt == nil || decided.DecidedBy != "admin@example.com" {
+			t.Errorf("got %+v, want the approved change", decided)
+		}
+		if _, err := repo.DecideIdentityChange(ctx, created.ID, userauth.IdentityChangeRejected, "admin@example.com"); !errors.Is(err, userauth.ErrIdentityChangeDecided) {
+			t.Errorf("second decision: got %v, want ErrIdentityChangeDecided", err)
+		}
+
+		found, err := repo.FindIdentityChange(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("FindIdentityChange: %v", err)
+		}
+		if found.Status != userauth.IdentityChangeApproved || found.NewValue != "1991-02-03" {
+			t.Errorf("got %+v, want the approved change", found)
+		}
+		if _, err := repo.FindIdentityChange(ctx, 4242); !errors.Is(err, userauth.ErrIdentityChangeNotFound) {
+			t.Errorf("got %v, want ErrIdentityChangeNotFound", err)
+		}
+	})
+
+	t.Run("profile history is kept per version", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		jane := register(t, repo, "jane@example.com")
+
+		entry := userauth.ProfileHistoryEntry{
+			UserID:    atoi(t, jane),
+			Version:   1,
+			Actor:     "jane@example.com",
+			RequestID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			Changes:   []userauth.ProfileChange{{Field: "address.city", Old: nil, New: "Pune"}},
+			Profile:   userauth.ProfileSnapshot{FirstName: "Jane", DOB: "1990-05-17", Gender: "female", Address: inCity("Pune")},
+		}
+		created, err := repo.CreateProfileHistory(ctx, entry)
+		if err != nil {
+			t.Fatalf("CreateProfileHistory: %v", err)
+		}
+		if created.ID == 0 || created.CreatedAt.IsZero() || created.RevertedFrom != nil {
+			t.Errorf("got %+v, want the stored entry", created)
+		}
+
+		revertedFrom := 1
+		entry.Version, entry.Actor, entry.RequestID, entry.RevertedFrom = 2, "admin@example.com", "", &revertedFrom
+		if _, err := repo.CreateProfileHistory(ctx, entry); err != nil {
+			t.Fatalf("CreateProfileHistory: %v", err)
+		}
+		if _, err := repo.CreateProfileHistory(ctx, entry); err == nil {
+			t.Errorf("second entry of version 2 was stored")
+		}
+		entry.UserID = 4242
+		if _, err := repo.CreateProfileHistory(ctx, entry); !errors.Is(err, userauth.ErrUserNotFound) {
+			t.Errorf("entry of unknown user: got %v, want ErrUserNotFound", err)
+		}
+
+		history, err := repo.ListProfileHistory(ctx, atoi(t, jane))
+		if err != nil {
+			t.Fatalf("ListProfileHistory: %v", err)
+		}
+		if len(history) != 2 || history[0].Version != 2 || history[1].Version != 1 {
+			t.Fatalf("got %+v, want versions 2 and 1", history)
+		}
+		if history[0].RevertedFrom == nil || *history[0].RevertedFrom != 1 || history[0].RequestID != "" {
+			t.Errorf("got %+v, want the revert to version 1", history[0])
+		}
+
+		found, err := repo.FindProfileHistory(ctx, atoi(t, jane), 1)
+		if err != nil {
+			t.Fatalf("FindProfileHistory: %v", err)
+		}
+		if found.Actor != "jane@example.com" || found.RequestID != created.RequestID ||
+			found.Profile.Address.City == nil || *found.Profile.Address.City != "Pune" ||
+			len(found.Changes) != 1 || found.Changes[0].Field != "address.city" || found.Changes[0].New != "Pune" {
+			t.Errorf("got %+v, want the stored entry", found)
+		}
+		if _, err := repo.FindProfileHistory(ctx, atoi(t, jane), 3); !errors.Is(err, userauth.ErrProfileVersionNotFound) {
+			t.Errorf("got %v, want ErrProfileVersionNotFound", err)
+		}
+	})
+
+	t.Run("audit events are chained and filtered", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		id := atoi(t, register(t, repo, "jane@example.com"))
+
+		last, err := repo.LastAuditEvent(ctx)
+		if err != nil || last != nil {
+			t.Fatalf("LastAuditEvent on an empty log = %+v, %v, want nil", last, err)
+		}
+
+		at := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+		events := []userauth.AuditEvent{
+			{OccurredAt: at, Type: userauth.AuditLoginFailed, Actor: "Jane@example.com", UserID: id, Details: map[string]string{"reason": "wrong_password"}, Hash: "h1"},
+			{OccurredAt: at.Add(time.Hour), Type: userauth.AuditLoginSucceeded, Actor: "jane@example.com", UserID: id, IP: "10.0.0.1", Details: map[string]string{}, PrevHash: "h1", Hash: "h2"},
+			{OccurredAt: at.Add(2 * time.Hour), Type: userauth.AuditProfileReverted, Actor: "admin@example.com", Details: map[string]string{}, PrevHash: "h2", Hash: "h3"},
+		}
+		for i, event := range events {
+			created, err := repo.CreateAuditEvent(ctx, event)
+			if err != nil {
+				t.Fatalf("CreateAuditEvent(%s): %v", event.Hash, err)
+			}
+			events[i].ID = created.ID
+		}
+		if _, err := repo.CreateAuditEvent(ctx, userauth.AuditEvent{OccurredAt: at, Type: userauth.AuditLoginFailed, Details: map[string]string{}, PrevHash: "h1", Hash: "fork"}); err == nil {
+			t.Error("second event linked to the same previous event was stored")
+		}
+
+		last, err = repo.LastAuditEvent(ctx)
+		if err != nil || last == nil || last.Hash != "h3" {
+			t.Fatalf("LastAuditEvent = %+v, %v, want h3", last, err)
+		}
+
+		first, err := repo.ListAuditEvents(ctx, userauth.AuditEventFilter{Limit: 1})
+		if err != nil || len(first) != 1 {
+			t.Fatalf("ListAuditEvents = %+v, %v, want one event", first, err)
+		}
+		if got := first[0]; got.ID != events[0].ID || !got.OccurredAt.Equal(at) || got.Details["reason"] != "wrong_password" || got.UserID != id {
+			t.Errorf("got %+v, want %+v", got, events[0])
+		}
+
+		to := at.Add(2 * time.Hour)
+		for name, tc := range map[string]struct {
+			filter userauth.AuditEventFilter
+			want   []string
+		}{
+			"type":     {userauth.AuditEventFilter{Type: userauth.AuditLoginSucceeded}, []string{"h2"}},
+			"actor":    {userauth.AuditEventFilter{Actor: "JANE@example.com"}, []string{"h1", "h2"}},
+			"user":     {userauth.AuditEventFilter{UserID: id}, []string{"h1", "h2"}},
+			"range":    {userauth.AuditEventFilter{From: &events[1].OccurredAt, To: &to}, []string{"h2"}},
+			"after id": {userauth.AuditEventFilter{AfterID: events[0].ID}, []string{"h2", "h3"}},
+		} {
+			tc.filter.Limit = 10
+			got, err := repo.ListAuditEvents(ctx, tc.filter)
+			if err != nil {
+				t.Fatalf("%s: ListAuditEvents: %v", name, err)
+			}
+			hashes := []string{}
+			for _, event := range got {
+				hashes = append(hashes, event.Hash)
+			}
+			if !slices.Equal(hashes, tc.want) {
+				t.Errorf("%s: got %v, want %v", name, hashes, tc.want)
+			}
+		}
+	})
+
+	t.Run("sessions are listed newest first and revoked once", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		jane := atoi(t, register(t, repo, "jane@example.com"))
+		john := atoi(t, register(t, repo, "john@example.com"))
+
+		at := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+		for i, id := range []string{"s1", "s2"} {
+			created, err := repo.CreateSession(ctx, userauth.Session{
+				ID: id, UserID: jane, CreatedAt: at.Add(time.Duration(i) * time.Hour), ExpiresAt: at.Add(24 * time.Hour),
+				IP: "10.0.0.1", UserAgent: "curl/8.0", Device: "curl",
+			})
+			if err != nil {
+				t.Fatalf("CreateSession(%s): %v", id, err)
+			}
+			if created.ID != id || created.UserID != jane || created.RevokedAt != nil {
+				t.Errorf("got %+v, want session %s of user %d", created, id, jane)
+			}
+		}
+		if _, err := repo.CreateSession(ctx, userauth.Session{ID: "s3", UserID: 9999, CreatedAt: at, ExpiresAt: at, Device: "curl"}); !errors.Is(err, userauth.ErrUserNotFound) {
+			t.Errorf("session of an unknown user: got %v, want ErrUserNotFound", err)
+		}
+
+		found, err := repo.FindSession(ctx, "s1")
+		if err != nil {
+			t.Fatalf("FindSession: %v", err)
+		}
+		if !found.CreatedAt.Equal(at) || found.IP != "10.0.0.1" || found.UserAgent != "curl/8.0" {
+			t.Errorf("got %+v, want the stored session", found)
+		}
+		if _, err := repo.FindSession(ctx, "nope"); !errors.Is(err, userauth.ErrSessionNotFound) {
+			t.Errorf("unknown session: got %v, want ErrSessionNotFound", err)
+		}
+
+		if _, err := repo.RevokeSession(ctx, john, "s1"); !errors.Is(err, userauth.ErrSessionNotFound) {
+			t.Errorf("revoking the session of another user: got %v, want ErrSessionNotFound", err)
+		}
+		revoked, err := repo.RevokeSession(ctx, jane, "s1")
+		if err != nil || revoked.RevokedAt == nil {
+			t.Fatalf("RevokeSession = %+v, %v, want a revoked session", revoked, err)
+		}
+		if _, err := repo.RevokeSession(ctx, jane, "s1"); !errors.Is(err, userauth.ErrSessionNotFound) {
+			t.Errorf("revoking twice: got %v, want ErrSessionNotFound", err)
+		}
+
+		sessions, err := repo.ListSessions(ctx, jane)
+		if err != nil {
+			t.Fatalf("ListSessions: %v", err)
+		}
+		if len(sessions) != 2 || sessions[0].ID != "s2" || sessions[1].ID != "s1" || sessions[1].RevokedAt == nil {
+			t.Errorf("got %+v, want s2 then the revoked s1", sessions)
+		}
+		if sessions, err := repo.ListSessions(ctx, john); err != nil || len(sessions) != 0 {
+			t.Errorf("ListSessions of another user = %+v, %v, want none", sessions, err)
+		}
+	})
+
+	t.Run("identities are linked once per provider and account", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		jane := atoi(t, register(t, repo, "jane@example.com"))
+		john := atoi(t, register(t, repo, "john@example.com"))
+
+		for _, identity := range []userauth.UserIdentity{
+			{UserID: jane, Provider: "google", Subject: "g-1", Email: "jane@example.com"},
+			{UserID: jane, Provider: "github", Subject: "gh-1"},
+		} {
+			created, err := repo.CreateIdentity(ctx, identity)
+			if err != nil {
+				t.Fatalf("CreateIdentity(%s): %v", identity.Provider, err)
+			}
+			if created.ID == 0 || created.UserID != jane || created.Subject != identity.Subject || created.Email != identity.Email || created.CreatedAt.IsZero() {
+				t.Errorf("got %+v, want %+v", created, identity)
+			}
+		}
+		if _, err := repo.CreateIdentity(ctx, userauth.UserIdentity{UserID: john, Provider: "google", Subject: "g-1"}); !errors.Is(err, userauth.ErrIdentityLinked) {
+			t.Errorf("account linked to another user: got %v, want ErrIdentityLinked", err)
+		}
+		if _, err := repo.CreateIdentity(ctx, userauth.UserIdentity{UserID: jane, Provider: "google", Subject: "g-2"}); !errors.Is(err, userauth.ErrIdentityLinked) {
+			t.Errorf("second account of a provider: got %v, want ErrIdentityLinked", err)
+		}
+		if _, err := repo.CreateIdentity(ctx, userauth.UserIdentity{UserID: 9999, Provider: "google", Subject: "g-3"}); !errors.Is(err, userauth.ErrUserNotFound) {
+			t.Errorf("identity of an unknown user: got %v, want ErrUserNotFound", err)
+		}
+
+		found, err := repo.FindIdentity(ctx, "google", "g-1")
+		if err != nil || found.UserID != jane {
+			t.Errorf("FindIdentity = %+v, %v, want the identity of jane", found, err)
+		}
+		if _, err := repo.FindIdentity(ctx, "github", "g-1"); !errors.Is(err, userauth.ErrIdentityNotFound) {
+			t.Errorf("subject of another provider: got %v, want ErrIdentityNotFound", err)
+		}
+
+		if err := repo.DeleteIdentity(ctx, john, "google"); !errors.Is(err, userauth.ErrIdentityNotFound) {
+			t.Errorf("unlinking a provider the user did not link: got %v, want ErrIdentityNotFound", err)
+		}
+		if err := repo.DeleteIdentity(ctx, jane, "google"); err != nil {
+			t.Fatalf("DeleteIdentity: %v", err)
+		}
+		identities, err := repo.ListIdentities(ctx, jane)
+		if err != nil {
+			t.Fatalf("ListIdentities: %v", err)
+		}
+		if len(identities) != 1 || identities[0].Provider != "github" {
+			t.Errorf("got %+v, want only github", identities)
+		}
+		// the account can be linked again once it is unlinked
+		if _, err := repo.CreateIdentity(ctx, userauth.UserIdentity{UserID: john, Provider: "google", Subject: "g-1"}); err != nil {
+			t.Errorf("linking an unlinked account: %v", err)
+		}
+	})
+
+	t.Run("oauth clients, consents and single use codes", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		jane := atoi(t, register(t, repo, "jane@example.com"))
+
+		client, err := repo.CreateOAuthClient(ctx, userauth.OAuthClient{
+			ID: "payroll", Name: "Payroll", SecretHash: "abc",
+			RedirectURIs: []string{"https://payroll.example.com/cb", "http://localhost:3000/cb"}, Scopes: []string{"openid", "email"},
+		})
+		if err != nil {
+			t.Fatalf("CreateOAuthClient: %v", err)
+		}
+		if client.CreatedAt.IsZero() || len(client.RedirectURIs) != 2 || client.Public() {
+			t.Errorf("got %+v, want the stored confidential client", client)
+		}
+		if _, err := repo.CreateOAuthClient(ctx, userauth.OAuthClient{ID: "spa", Name: "SPA", RedirectURIs: []string{"https://spa.example.com/cb"}, Scopes: []string{"openid"}}); err != nil {
+			t.Fatalf("CreateOAuthClient: %v", err)
+		}
+		found, err := repo.FindOAuthClient(ctx, "spa")
+		if err != nil || !found.Public() || !slices.Equal(found.Scopes, []string{"openid"}) {
+			t.Errorf("FindOAuthClient = %+v, %v, want the public client", found, err)
+		}
+		if _, err := repo.FindOAuthClient(ctx, "nope"); !errors.Is(err, userauth.ErrOAuthClientNotFound) {
+			t.Errorf("unknown client: got %v, want ErrOAuthClientNotFound", err)
+		}
+		clients, err := repo.ListOAuthClients(ctx)
+		if err != nil || len(clients) != 2 {
+			t.Errorf("ListOAuthClients = %+v, %v, want both clients", clients, err)
+		}
+
+		if _, err := repo.FindOAuthConsent(ctx, jane, "payroll"); !errors.Is(err, userauth.ErrOAuthConsentNotFound) {
+			t.Errorf("no consent yet: got %v, want ErrOAuthConsentNotFound", err)
+		}
+		at := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+		for _, scopes := range [][]string{{"openid"}, {"openid", "email"}} {
+			if err := repo.SaveOAuthConsent(ctx, userauth.OAuthConsent{UserID: jane, ClientID: "payroll", Scopes: scopes, GrantedAt: at}); err != nil {
+				t.Fatalf("SaveOAuthConsent: %v", err)
+			}
+		}
+		consent, err := repo.FindOAuthConsent(ctx, jane, "payroll")
+		if err != nil || !slices.Equal(consent.Scopes, []string{"openid", "email"}) || !consent.GrantedAt.Equal(at) {
+			t.Errorf("FindOAuthConsent = %+v, %v, want the replaced consent", consent, err)
+		}
+
+		code := userauth.OAuthAuthorizationCode{
+			CodeHash: "h1", ClientID: "payroll", UserID: jane, RedirectURI: "https://payroll.example.com/cb",
+			Scopes: []string{"openid", "email"}, Nonce: "n", ExpiresAt: at,
+		}
+		if err := repo.CreateAuthorizationCode(ctx, code); err != nil {
+			t.Fatalf("CreateAuthorizationCode: %v", err)
+		}
+		consumed, err := repo.ConsumeAuthorizationCode(ctx, "h1")
+		if err != nil {
+			t.Fatalf("ConsumeAuthorizationCode: %v", err)
+		}
+		if consumed.UsedAt == nil || consumed.UserID != jane || consumed.Nonce != "n" || consumed.CodeChallenge != "" || !consumed.ExpiresAt.Equal(at) {
+			t.Errorf("got %+v, want the used code", consumed)
+		}
+		if _, err := repo.ConsumeAuthorizationCode(ctx, "h1"); !errors.Is(err, userauth.ErrAuthorizationCodeNotFound) {
+			t.Errorf("consuming twice: got %v, want ErrAuthorizationCodeNotFound", err)
+		}
+
+		if err := repo.DeleteOAuthClient(ctx, "payroll"); err != nil {
+			t.Fatalf("DeleteOAuthClient: %v", err)
+		}
+		if _, err := repo.FindOAuthConsent(ctx, jane, "payroll"); !errors.Is(err, userauth.ErrOAuthConsentNotFound) {
+			t.Errorf("consent of a deleted client: got %v, want ErrOAuthConsentNotFound", err)
+		}
+		if err := repo.DeleteOAuthClient(ctx, "payroll"); !errors.Is(err, userauth.ErrOAuthClientNotFound) {
+			t.Errorf("deleting twice: got %v, want ErrOAuthClientNotFound", err)
+		}
+	})
+
+	t.Run("signing keys are listed newest first", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+
+		at := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+		for i, id := range []string{"k1", "k2", "k3"} {
+			if err := repo.CreateSigningKey(ctx, userauth.OAuthSigningKey{ID: id, PrivateKey: "pem-" + id, CreatedAt: at.Add(time.Duration(i) * time.Hour)}); err != nil {
+				t.Fatalf("CreateSigningKey(%s): %v", id, err)
+			}
+		}
+		keys, err := repo.ListSigningKeys(ctx, at)
+		if err != nil {
+			t.Fatalf("ListSigningKeys: %v", err)
+		}
+		if len(keys) != 2 || keys[0].ID != "k3" || keys[1].ID != "k2" || keys[0].PrivateKey != "pem-k3" || !keys[1].CreatedAt.Equal(at.Add(time.Hour)) {
+			t.Errorf("got %+v, want k3 then k2", keys)
+		}
+	})
+
+	t.Run("api keys are listed newest first, touched and deleted by their owner", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		jane := atoi(t, register(t, repo, "jane@example.com"))
+		john := atoi(t, register(t, repo, "john@example.com"))
+
+		at := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+		for i, id := range []string{"a1", "a2"} {
+			created, err := repo.CreateAPIKey(ctx, userauth.APIKey{
+				ID: id, UserID: jane, Name: "key " + id, SecretHash: "hash-" + id, Scopes: []string{"profile:read", "vehicles:read"},
+				CreatedAt: at.Add(time.Duration(i) * time.Hour), ExpiresAt: at.AddDate(0, 0, 90),
+			})
+			if err != nil {
+				t.Fatalf("CreateAPIKey(%s): %v", id, err)
+			}
+			if created.ID != id || created.UserID != jane || created.LastUsedAt != nil || len(created.Scopes) != 2 {
+				t.Errorf("got %+v, want key %s of user %d", created, id, jane)
+			}
+		}
+		if _, err := repo.CreateAPIKey(ctx, userauth.APIKey{ID: "a3", UserID: 9999, Name: "x", SecretHash: "x", CreatedAt: at, ExpiresAt: at}); !errors.Is(err, userauth.ErrUserNotFound) {
+			t.Errorf("key of an unknown user: got %v, want ErrUserNotFound", err)
+		}
+
+		usedAt := at.Add(2 * time.Hour)
+		if err := repo.TouchAPIKey(ctx, "a1", usedAt); err != nil {
+			t.Fatalf("TouchAPIKey: %v", err)
+		}
+		found, err := repo.FindAPIKey(ctx, "a1")
+		if err != nil {
+			t.Fatalf("FindAPIKey: %v", err)
+		}
+		if found.SecretHash != "hash-a1" || found.Name != "key a1" || !found.ExpiresAt.Equal(at.AddDate(0, 0, 90)) ||
+			found.LastUsedAt == nil || !found.LastUsedAt.Equal(usedAt) || !slices.Equal(found.Scopes, []string{"profile:read", "vehicles:read"}) {
+			t.Errorf("got %+v, want the stored key used at %v", found, usedAt)
+		}
+		if _, err := repo.FindAPIKey(ctx, "nope"); !errors.Is(err, userauth.ErrAPIKeyNotFound) {
+			t.Errorf("unknown key: got %v, want ErrAPIKeyNotFound", err)
+		}
+
+		keys, err := repo.ListAPIKeys(ctx, jane)
+		if err != nil {
+			t.Fatalf("ListAPIKeys: %v", err)
+		}
+		if len(keys) != 2 || keys[0].ID != "a2" || keys[1].ID != "a1" {
+			t.Errorf("got %+v, want a2 then a1", keys)
+		}
+
+		if err := repo.DeleteAPIKey(ctx, john, "a1"); !errors.Is(err, userauth.ErrAPIKeyNotFound) {
+			t.Errorf("deleting the key of another user: got %v, want ErrAPIKeyNotFound", err)
+		}
+		if err := repo.DeleteAPIKey(ctx, jane, "a1"); err != nil {
+			t.Fatalf("DeleteAPIKey: %v", err)
+		}
+		if _, err := repo.FindAPIKey(ctx, "a1"); !errors.Is(err, userauth.ErrAPIKeyNotFound) {
+			t.Errorf("deleted key: got %v, want ErrAPIKeyNotFound", err)
+		}
+		if keys, err := repo.ListAPIKeys(ctx, john); err != nil || len(keys) != 0 {
+			t.Errorf("ListAPIKeys of another user = %+v, %v, want none", keys, err)
+		}
+	})
+
+	t.Run("magic links are counted and consumed once", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		jane := atoi(t, register(t, repo, "jane@example.com"))
+
+		at := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+		for i, id := range []string{"m1", "m2"} {
+			created := at.Add(time.Duration(i) * 10 * time.Minute)
+			if err := repo.CreateMagicLink(ctx, userauth.MagicLink{ID: id, UserID: jane, CreatedAt: created, ExpiresAt: created.Add(15 * time.Minute)}); err != nil {
+				t.Fatalf("CreateMagicLink(%s): %v", id, err)
+			}
+		}
+		if err := repo.CreateMagicLink(ctx, userauth.MagicLink{ID: "m3", UserID: 9999, CreatedAt: at, ExpiresAt: at}); !errors.Is(err, userauth.ErrUserNotFound) {
+			t.Errorf("link of an unknown user: got %v, want ErrUserNotFound", err)
+		}
+
+		if n, err := repo.CountMagicLinks(ctx, jane, at.Add(5*time.Minute)); err != nil || n != 1 {
+			t.Errorf("CountMagicLinks since the first link = %d, %v, want 1", n, err)
+		}
+		if n, err := repo.CountMagicLinks(ctx, jane, at.Add(-time.Minute)); err != nil || n != 2 {
+			t.Errorf("CountMagicLinks = %d, %v, want 2", n, err)
+		}
+
+		link, err := repo.ConsumeMagicLink(ctx, "m1")
+		if err != nil {
+			t.Fatalf("ConsumeMagicLink: %v", err)
+		}
+		if link.ID != "m1" || link.UserID != jane || !link.ExpiresAt.Equal(at.Add(15*time.Minute)) || link.UsedAt == nil {
+			t.Errorf("got %+v, want link m1 of user %d marked used", link, jane)
+		}
+		if _, err := repo.ConsumeMagicLink(ctx, "m1"); !errors.Is(err, userauth.ErrMagicLinkNotFound) {
+			t.Errorf("consuming a used link: got %v, want ErrMagicLinkNotFound", err)
+		}
+		if _, err := repo.ConsumeMagicLink(ctx, "nope"); !errors.Is(err, userauth.ErrMagicLinkNotFound) {
+			t.Errorf("unknown link: got %v, want ErrMagicLinkNotFound", err)
+		}
+	})
+
+	t.Run("failed transaction is rolled back", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		errAbort := errors.New("abort")
+
+		err := repo.WithTx(ctx, func(tx userauth.Repository) error {
+			if _, err := tx.UserRegister(ctx, registerRequest("jane@example.com")); err != nil {
+				return err
+			}
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Fatalf("got %v, want the error returned by fn", err)
+		}
+
+		if _, err := repo.FindUserByEmail(ctx, "jane@example.com"); err == nil {
+			t.Fatal("user registered in a rolled back transaction is visible")
+		}
+	})
+
+	t.Run("committed transaction is visible", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		id := register(t, repo, "jane@example.com")
+
+		err := repo.WithTx(ctx, func(tx userauth.Repository) error {
+			if _, err := tx.FindUserByIDForUpdate(ctx, id); err != nil {
+				return err
+			}
+			_, err := tx.CreateAddress(ctx, userauth.UserAddress{UserID: atoi(t, id), Type: userauth.AddressHome, Address: inCity("Pune"), IsPrimary: true})
+			return err
+		})
+		if err != nil {
+			t.Fatalf("WithTx: %v", err)
+		}
+
+		user, err := repo.FindUserByID(ctx, id)
+		if err != nil {
+			t.Fatalf("FindUserByID: %v", err)
+		}
+		if user.Address.City == nil || *user.Address.City != "Pune" {
+			t.Errorf("got address %+v, want city Pune", user.Address)
+		}
+	})
+
+	t.Run("locking read needs a transaction", func(t *testing.T) {
+		repo := newRepo(t)
+		id := register(t, repo, "jane@example.com")
+
+		if _, err := repo.FindUserByIDForUpdate(context.Background(), id); err == nil {
+			t.Fatal("FindUserByIDForUpdate outside WithTx succeeded")
+		}
+	})
+}
+
+func registerRequest(email string) userauth.UserRegisterRequest {
+	return userauth.UserRegisterRequest{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     email,
+		Password:  "hashed",
+		DOB:       userauth.Date(time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC)),
+		Gender:    "female",
+	}
+}
+
+func register(t *testing.T, repo userauth.Repository, email string) string {
+	t.Helper()
+	id, err := repo.UserRegister(context.Background(), registerRequest(email))
+	if err != nil {
+		t.Fatalf("UserRegister(%s): %v", email, err)
+	}
+	return id
+}
+
+func infoRequest(t *testing.T, userID string, version int) userauth.UserInformationRequest {
+	t.Helper()
+	return userauth.UserInformationRequest{ID: atoi(t, userID), Version: version}
+}
+
+func atoi(t *testing.T, userID string) int {
+	t.Helper()
+	id, err := strconv.Atoi(userID)
+	if err != nil {
+		t.Fatalf("user id %q is not numeric: %v", userID, err)
+	}
+	return id
+}
+
+func inCity(city string) userauth.Address {
+	return userauth.Address{City: &city}
+}
@@ -0,0 +1,133 @@
+package userauth
+
+import (
+	"errors"
+	"net/url"
+)
+
+// Error kinds, every error the service returns to the handler either is or
+// wraps one of these, anything else is treated as an internal error
+var (
+	ErrNotFound             = errors.New("not found")
+	ErrConflict             = errors.New("conflict")
+	ErrUnauthorized         = errors.New("unauthorized")
+	ErrValidation           = errors.New("validation failed")
+	ErrBadRequest           = errors.New("bad request")
+	ErrPreconditionFailed   = errors.New("precondition failed")
+	ErrPreconditionRequired = errors.New("precondition required")
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	ErrPayloadTooLarge      = errors.New("payload too large")
+	// ErrBadGateway is a failure of a service this one depends on
+	ErrBadGateway = errors.New("bad gateway")
+)
+
+// Domain errors returned by every Repository implementation and the service,
+// storage specific errors are translated into these so the handler never sees
+// driver errors
+var (
+	ErrUserNotFound       = newError(ErrNotFound, "user_not_found", "user not found")
+	ErrEmailTaken         = newError(ErrConflict, "email_taken", "email already registered")
+	ErrInvalidCredentials = newError(ErrUnauthorized, "invalid_credentials", "invalid credentials")
+	ErrVehicleNotFound    = newError(ErrNotFound, "vehicle_not_found", "vehicle not found")
+	ErrAddressNotFound    = newError(ErrNotFound, "address_not_found", "address not found")
+	// ErrPrimaryAddressTaken is returned when a concurrent request made another address primary first
+	ErrPrimaryAddressTaken    = newError(ErrConflict, "primary_address_taken", "user already has a primary address")
+	ErrIdentityChangeNotFound = newError(ErrNotFound, "identity_change_not_found", "identity change not found")
+	// ErrIdentityChangePending is returned when the field already has a change awaiting approval
+	ErrIdentityChangePending = newError(ErrConflict, "identity_change_pending", "a change of this field is already awaiting approval")
+	ErrIdentityChangeDecided = newError(ErrConflict, "identity_change_decided", "identity change was already approved or rejected")
+	// ErrProfileVersionNotFound is returned when the history has no entry for a version
+	ErrProfileVersionNotFound = newError(ErrNotFound, "profile_version_not_found", "profile version not found in history")
+	ErrSessionNotFound        = newError(ErrNotFound, "session_not_found", "session not found")
+	// ErrSessionRevoked is returned for a token whose session was revoked or has expired
+	ErrInvalidToken            = newError(ErrUnauthorized, "invalid_token", "invalid or expired token")
+	ErrSessionRevoked          = newError(ErrUnauthorized, "session_revoked", "session was revoked or has expired")
+	ErrOIDCProviderNotFound    = newError(ErrNotFound, "oidc_provider_not_found", "identity provider not found")
+	ErrOIDCProviderUnavailable = newError(ErrBadGateway, "oidc_provider_unavailable", "identity provider could not be reached")
+	// ErrOIDCLoginFailed is returned when the callback of a provider login can't be trusted
+	ErrOIDCLoginFailed = newError(ErrUnauthorized, "oidc_login_failed", "sign in with the identity provider failed, please try again")
+	// ErrOIDCAccountExists is returned when an unlinked identity has the email of an existing user
+	ErrOIDCAccountExists = newError(ErrConflict, "account_exists", "an account with this email exists, log in and link the identity provider from the profile")
+	// ErrOIDCEmailUnverified is returned when a new user signs in with a provider that did not verify the email
+	ErrOIDCEmailUnverified = newError(ErrUnauthorized, "oidc_email_unverified", "the identity provider did not verify the email of the account")
+	ErrIdentityNotFound    = newError(ErrNotFound, "identity_not_found", "identity provider is not linked")
+	// ErrIdentityLinked is returned when the identity already belongs to a user or the user already linked the provider
+	ErrIdentityLinked = newError(ErrConflict, "identity_linked", "identity provider is already linked")
+	// ErrLastLoginMethod is returned when unlinking would leave a user without a way to log in
+	ErrLastLoginMethod = newError(ErrConflict, "last_login_method", "set a password before unlinking the last identity provider")
+
+	ErrOAuthClientNotFound = newError(ErrNotFound, "oauth_client_not_found", "OAuth client not found")
+	// ErrOAuthRedirectURI is returned when the redirect_uri of an authorization
+	// request is not registered, the user is not sent there
+	ErrOAuthRedirectURI          = newError(ErrBadRequest, "invalid_redirect_uri", "redirect_uri is not registered for the client")
+	ErrOAuthConsentNotFound      = newError(ErrNotFound, "oauth_consent_not_found", "the user did not authorize the client")
+	ErrAuthorizationCodeNotFound = newError(ErrNotFound, "authorization_code_invalid", "authorization code is unknown or was used")
+	// ErrConsentExpired is returned when the consent screen was left open too long or tampered with
+	ErrConsentExpired = newError(ErrUnauthorized, "consent_expired", "the consent screen expired, please log in again")
+	ErrAPIKeyNotFound = newError(ErrNotFound, "api_key_not_found", "API key not found")
+	// ErrInvalidAPIKey is returned for an unknown, expired or revoked API key
+	ErrInvalidAPIKey     = newError(ErrUnauthorized, "invalid_api_key", "invalid, expired or revoked API key")
+	ErrAPIKeyLimit       = newError(ErrConflict, "api_key_limit", "too many API keys, revoke one first")
+	ErrMagicLinkNotFound = newError(ErrNotFound, "magic_link_not_found", "login link is unknown or was used")
+	// ErrMagicLinkInvalid is returned for a forged, expired or used login link
+	ErrMagicLinkInvalid = newError(ErrUnauthorized, "magic_link_invalid", "the login link is invalid, expired or was used, please ask for a new one")
+	// ErrMagicLinkDevice is returned when a login link is opened in another browser than the one it was asked for in
+	ErrMagicLinkDevice = newError(ErrUnauthorized, "magic_link_device", "open the login link in the browser you asked for it in")
+	// ErrVersionMismatch is returned when a profile update was based on a stale version
+	ErrVersionMismatch = newError(ErrPreconditionFailed, "version_mismatch", "profile was modified by another request")
+	ErrIfMatchRequired = newError(ErrPreconditionRequired, "if_match_required", "If-Match header is required")
+	ErrInvalidPatch    = newError(ErrBadRequest, "invalid_patch", "patch document is malformed")
+	// ErrPatchConflict is returned when a JSON patch operation doesn't apply to the current profile
+	ErrPatchConflict    = newError(ErrConflict, "patch_conflict", "patch does not apply to the current profile")
+	ErrPatchTooLarge    = newError(ErrPayloadTooLarge, "patch_too_large", "patch document is larger than 64 KiB")
+	ErrUnsupportedPatch = newError(ErrUnsupportedMediaType, "unsupported_patch_format", "patch must be application/merge-patch+json or application/json-patch+json")
+)
+
+// domainError is an error with a stable machine readable code and a client
+// safe message that matches its kind with errors.Is
+type domainError struct {
+	kind error
+	code string
+	msg  string
+}
+
+func newError(kind error, code, msg string) error {
+	return &domainError{kind: kind, code: code, msg: msg}
+}
+
+func (e *domainError) Error() string { return e.msg }
+
+func (e *domainError) Is(target error) bool { return target == e.kind }
+
+// ValidationError carries the per field messages of a rejected request
+type ValidationError struct {
+	Fields url.Values
+}
+
+// NewValidationError returns nil when there are no field errors
+func NewValidationError(fields url.Values) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: fields}
+}
+
+func (e *ValidationError) Error() string { return ErrValidation.Error() }
+
+func (e *ValidationError) Is(target error) bool { return target == ErrValidation }
+
+// FieldViolation is a rule a field broke in a check the service makes after
+// the request passed the Validator, Rule is the message key and Param its
+// argument if any
+type FieldViolation struct {
+	Field string
+	Rule  string
+	Param string
+}
+
+// violationError is implemented by service errors the Validator turns into a
+// localized ValidationError
+type violationError interface {
+	error
+	fieldViolations() []FieldViolation
+}
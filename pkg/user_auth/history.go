@@ -0,0 +1,133 @@
+package userauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/abhiii71/clean-code-abhi/pkg/logger"
+)
+
+// profileSnapshot is the profile document of user as recorded in its history
+func profileSnapshot(user *User) ProfileSnapshot {
+	return ProfileSnapshot{
+		FirstName:         user.FirstName,
+		LastName:          user.LastName,
+		DOB:               user.DOB.Format(time.DateOnly),
+		Gender:            user.Gender,
+		GenderDescription: user.GenderDescription,
+		Address:           *cloneAddress(&user.Address),
+	}
+}
+
+// recordHistory stores the change of the profile of userID from before to
+// what it is now under version, the profile version the change produced.
+// Nothing is recorded when the profile is unchanged unless it is a revert.
+func recordHistory(ctx context.Context, tx Repository, before ProfileSnapshot, userID string, version int, actor string, revertedFrom *int) error {
+	user, err := tx.FindUserByIDForUpdate(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	after := profileSnapshot(user)
+	changes, err := diffProfiles(before, after)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 && revertedFrom == nil {
+		return nil
+	}
+
+	_, err = tx.CreateProfileHistory(ctx, ProfileHistoryEntry{
+		UserID:       user.ID,
+		Version:      version,
+		Actor:        actor,
+		RequestID:    logger.RequestIDFromContext(ctx),
+		RevertedFrom: revertedFrom,
+		Changes:      changes,
+		Profile:      after,
+	})
+	return err
+}
+
+// diffProfiles lists the fields that differ between before and after ordered
+// by their JSON path
+func diffProfiles(before, after ProfileSnapshot) ([]ProfileChange, error) {
+	old, err := flattenJSON(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := flattenJSON(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []ProfileChange{}
+	for field, value := range updated {
+		if !reflect.DeepEqual(old[field], value) {
+			changes = append(changes, ProfileChange{Field: field, Old: old[field], New: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+// flattenJSON maps the JSON path of every leaf of v to its value
+func flattenJSON(v any) (map[string]any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal profile: %w", err)
+	}
+	var doc any
+	if err := decodeJSON(b, &doc); err != nil {
+		return nil, err
+	}
+
+	leaves := map[string]any{}
+	var walk func(prefix string, node any)
+	walk = func(prefix string, node any) {
+		switch n := node.(type) {
+		case map[string]any:
+			for key, value := range n {
+				walk(joinPath(prefix, key), value)
+			}
+		case []any:
+			for i, value := range n {
+				walk(joinPath(prefix, strconv.Itoa(i)), value)
+			}
+		default:
+			leaves[prefix] = n
+		}
+	}
+	walk("", doc)
+	return leaves, nil
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// revertRequest is the update that brings the profile of user back to snapshot
+func revertRequest(user *User, snapshot ProfileSnapshot) (UserInformationRequest, error) {
+	dob, err := time.Parse(time.DateOnly, snapshot.DOB)
+	if err != nil {
+		return UserInformationRequest{}, fmt.Errorf("invalid dob %q in profile history: %w", snapshot.DOB, err)
+	}
+	return UserInformationRequest{
+		ID:                user.ID,
+		FirstName:         snapshot.FirstName,
+		LastName:          snapshot.LastName,
+		DOB:               Date(dob),
+		Gender:            snapshot.Gender,
+		GenderDescription: snapshot.GenderDescription,
+		Address:           snapshot.Address,
+		Version:           user.Version,
+	}, nil
+}
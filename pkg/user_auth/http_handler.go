@@ -1,43 +1,96 @@
 package userauth
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type Handler struct {
-	service Service
+	service   Service
+	validator *Validator
+	// admins are the emails allowed to use the admin routes
+	admins []string
+	log    *slog.Logger
 }
 
-func NewHandler(service Service) *Handler {
+func NewHandler(service Service, validator *Validator, admins []string, log *slog.Logger) *Handler {
 	return &Handler{
-		service: service,
+		service:   service,
+		validator: validator,
+		admins:    admins,
+		log:       log,
 	}
 }
 
 func (h *Handler) MountRoutes(engine *gin.Engine) {
-	applicantApi := engine.Group(basePath)
+	applicantApi := engine.Group(basePath, AuditContext())
 
 	applicantApi.POST("/register", h.Register)
 	applicantApi.POST("/login", h.Login)
-	applicantApi.Use(AuthMiddleware())
+	applicantApi.POST("/login/magic-link", h.RequestMagicLink)
+	applicantApi.POST("/login/magic-link/consume", h.LoginWithMagicLink)
+	applicantApi.GET("/genders", h.ListGenders)
+	applicantApi.GET("/oidc/providers", h.ListOIDCProviders)
+	applicantApi.GET("/oidc/:provider/login", h.StartOIDCLogin)
+	applicantApi.GET("/oidc/:provider/callback", h.FinishOIDCLogin)
+	applicantApi.Use(AuthMiddleware(h.service, h.log))
 	applicantApi.PATCH("/profile", h.UpdateUserInformation)
 	applicantApi.GET("/profile", h.GetProfile)
+	applicantApi.PUT("/password", h.ChangePassword)
+	applicantApi.GET("/profile/vehicles", h.ListVehicles)
+	applicantApi.POST("/profile/vehicles", h.CreateVehicle)
+	applicantApi.GET("/profile/vehicles/:id", h.GetVehicle)
+	applicantApi.PUT("/profile/vehicles/:id", h.UpdateVehicle)
+	applicantApi.DELETE("/profile/vehicles/:id", h.DeleteVehicle)
+	applicantApi.GET("/profile/addresses", h.ListAddresses)
+	applicantApi.POST("/profile/addresses", h.CreateAddress)
+	applicantApi.GET("/profile/addresses/:id", h.GetAddress)
+	applicantApi.PUT("/profile/addresses/:id", h.UpdateAddress)
+	applicantApi.DELETE("/profile/addresses/:id", h.DeleteAddress)
+	applicantApi.GET("/profile/identity-changes", h.ListOwnIdentityChanges)
+	applicantApi.GET("/profile/history", h.ListOwnProfileHistory)
+	applicantApi.GET("/profile/identities", h.ListIdentities)
+	applicantApi.POST("/profile/identities/:provider", h.LinkIdentity)
+	applicantApi.DELETE("/profile/identities/:provider", h.UnlinkIdentity)
+	applicantApi.GET("/sessions", h.ListSessions)
+	applicantApi.DELETE("/sessions/:id", h.RevokeSession)
+	applicantApi.GET("/login-history", h.ListLoginHistory)
+	applicantApi.GET("/api-keys", h.ListAPIKeys)
+	applicantApi.POST("/api-keys", h.CreateAPIKey)
+	applicantApi.DELETE("/api-keys/:id", h.RevokeAPIKey)
 	applicantApi.POST("/upload-pdf", h.UploadPDF)
 
-}
-
-func (h *Handler) respondWithError(c *gin.Context, code int, msg interface{}) {
-	resp := gin.H{
-		"msg": msg,
-	}
+	adminApi := engine.Group(basePath+"admin", AuditContext(), AuthMiddleware(h.service, h.log), AdminMiddleware(h.admins, h.log))
+	adminApi.GET("/identity-changes", h.ListIdentityChanges)
+	adminApi.POST("/identity-changes/:id/approve", h.ApproveIdentityChange)
+	adminApi.POST("/identity-changes/:id/reject", h.RejectIdentityChange)
+	adminApi.GET("/users/:id/history", h.ListProfileHistory)
+	adminApi.POST("/users/:id/history/:version/revert", h.RevertProfile)
+	adminApi.GET("/audit-events", h.ListAuditEvents)
+	adminApi.GET("/oauth-clients", h.ListOAuthClients)
+	adminApi.POST("/oauth-clients", h.RegisterOAuthClient)
+	adminApi.DELETE("/oauth-clients/:id", h.DeleteOAuthClient)
 
-	c.JSON(code, resp)
-	c.Abort()
+	// the authorization server speaks OAuth 2.0 instead of problem details
+	oauthApi := engine.Group(basePath+"oauth", AuditContext())
+	oauthApi.GET("/.well-known/openid-configuration", h.OAuthMetadata)
+	oauthApi.GET("/jwks", h.OAuthKeys)
+	oauthApi.GET("/authorize", h.Authorize)
+	oauthApi.POST("/authorize", h.Authorize)
+	oauthApi.POST("/token", h.OAuthToken)
+	oauthApi.GET("/userinfo", h.OAuthUserInfo)
+	oauthApi.POST("/userinfo", h.OAuthUserInfo)
 }
 
 func (h *Handler) respondWithData(c *gin.Context, code int, message interface{}, data interface{}) {
@@ -54,33 +107,67 @@ func (h *Handler) respondWithSuccess(c *gin.Context, status int, msg string) {
 	})
 }
 
+// respondWithError is the single place errors are turned into responses.
+// Known kinds become a problem+json body with their status and code,
+// anything else is logged and hidden behind a 500.
+func (h *Handler) respondWithError(c *gin.Context, err error) {
+	err = h.validator.translateError(err, c.GetHeader("Accept-Language"))
+	p, ok := problemFromError(c, err)
+	if !ok {
+		h.log.ErrorContext(c.Request.Context(), "unhandled error", "method", c.Request.Method, "path", c.FullPath(), "err", err)
+	}
+	writeProblem(c, p)
+}
+
+// formatETag renders a profile version as a strong entity tag
+func formatETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// parseETag reads the version out of an If-Match value, "*" matches any version
+func parseETag(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "*" {
+		return AnyVersion, nil
+	}
+	if strings.HasPrefix(value, "W/") {
+		return 0, errors.New("weak entity tags cannot be used with If-Match")
+	}
+	version, err := strconv.Atoi(strings.Trim(value, `"`))
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid entity tag %q", value)
+	}
+	return version, nil
+}
+
 // Register handles user registration
 // @Summary			Register a new user
 // @Description		Registers a user with required details
 // @Tags			Auth
 // @Accept			json
-// @Produce			json
+// @Produce			json,application/problem+json
+// @Param			Accept-Language header string false "language of the validation messages (en, es, fr, de)"
 // @Param			request body userauth.UserRegisterRequest true "UserRegisterRequest"
 // @Success			200 {object} map[string]string
-// @Failure			400 {object} map[string]interface{}
+// @Failure			400 {object} Problem "request body is not valid JSON"
+// @Failure			409 {object} Problem "email already registered"
+// @Failure			422 {object} Problem "invalid fields"
 // @Router			/applicant/external/v1/register [post]
 func (h *Handler) Register(c *gin.Context) {
 	var request UserRegisterRequest
 
 	if err := c.ShouldBindJSON(&request); err != nil {
-		h.respondWithError(c, http.StatusBadRequest, map[string]string{"request-parse": err.Error()})
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_json", err.Error()))
 		return
 	}
 
-	errVal := request.Valid()
-
-	if len(errVal) > 0 {
-		h.respondWithError(c, http.StatusBadRequest, map[string]interface{}{"invalid-request": errVal})
+	if err := h.validator.Validate(&request, c.GetHeader("Accept-Language")); err != nil {
+		h.respondWithError(c, err)
 		return
 	}
-	userID, err := h.service.UserRegister(c, request)
+	userID, err := h.service.UserRegister(c.Request.Context(), request)
 	if err != nil {
-		h.respondWithError(c, http.StatusBadRequest, map[string]string{"err": err.Error()})
+		h.respondWithError(c, err)
 		return
 	}
 	h.respondWithData(c, http.StatusOK, "success", map[string]string{"user_id": userID})
@@ -91,73 +178,209 @@ func (h *Handler) Register(c *gin.Context) {
 // @Description	Authenticate a user with email and password and returns JWT Token
 // @Tags	Auth
 // @Accept	json
-// @Produce	json
+// @Produce	json,application/problem+json
+// @Param	Accept-Language header string false "language of the validation messages (en, es, fr, de)"
 // @Param	request body UserLoginRequest  true "Login Credentials"
 // @Success	200 {object} map[string]interface{} "login success with token"
-// @Failure	400 {object} map[string]string "invalid request"
-// @Failure	401 {object} map[string]string "unauthorized"
+// @Failure	400 {object} Problem "invalid request"
+// @Failure	401 {object} Problem "unauthorized"
+// @Failure	422 {object} Problem "invalid fields"
 // @Router	/applicant/external/v1/login [post]
 func (h *Handler) Login(c *gin.Context) {
 	var request UserLoginRequest
 
 	if err := c.ShouldBindJSON(&request); err != nil {
-		h.respondWithError(c, http.StatusBadRequest, "invalid request")
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_json", "invalid request"))
+		return
+	}
+
+	if err := h.validator.Validate(&request, c.GetHeader("Accept-Language")); err != nil {
+		h.respondWithError(c, err)
 		return
 	}
 
 	token, err := h.service.GetUserProfile(c.Request.Context(), request)
 	if err != nil {
-		h.respondWithError(c, http.StatusUnauthorized, err.Error())
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "login success", gin.H{"token": token})
+}
+
+// magicLinkDeviceCookie holds the secret of the browser a login link is bound
+// to, it is only sent to the magic link routes
+const magicLinkDeviceCookie = "magic_link_device"
+
+// magicLinkDeviceMaxAge is in seconds, every request for a link renews it
+const magicLinkDeviceMaxAge = 7 * 24 * 60 * 60
+
+// @Summary Email a login link
+// @Description Emails a link to log in without a password. The link opens MAGIC_LINK_URL with a token
+// @Description to post to /login/magic-link/consume from the same browser, the magic_link_device cookie
+// @Description set here binds the link to it. The response is the same whether the email is registered
+// @Description or not, at most 3 links are sent to a user every 15 minutes.
+// @Tags Auth
+// @Accept json
+// @Produce json,application/problem+json
+// @Param Accept-Language header string false "language of the validation messages (en, es, fr, de)"
+// @Param request body MagicLinkRequest true "Email"
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} Problem "request body is not valid JSON"
+// @Failure 422 {object} Problem "invalid fields"
+// @Router /applicant/external/v1/login/magic-link [post]
+func (h *Handler) RequestMagicLink(c *gin.Context) {
+	var req MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_json", "invalid request body"))
+		return
+	}
+	if err := h.validator.Validate(&req, c.GetHeader("Accept-Language")); err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+
+	device, _ := c.Cookie(magicLinkDeviceCookie)
+	device, err := h.service.RequestMagicLink(c.Request.Context(), req.Email, device)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(magicLinkDeviceCookie, device, magicLinkDeviceMaxAge, "/"+basePath+"login/magic-link", "", c.Request.TLS != nil, true)
+	h.respondWithSuccess(c, http.StatusAccepted, "if the email is registered a login link was sent to it")
+}
+
+// @Summary Log in with an emailed link
+// @Description Logs in with the token of a link from /login/magic-link and returns the same token as /login.
+// @Description A link works once and only with the magic_link_device cookie of the browser that asked for it.
+// @Tags Auth
+// @Accept json
+// @Produce json,application/problem+json
+// @Param Accept-Language header string false "language of the validation messages (en, es, fr, de)"
+// @Param request body MagicLinkLogin true "Token of the link"
+// @Success 200 {object} map[string]interface{} "login success with token"
+// @Failure 400 {object} Problem "request body is not valid JSON"
+// @Failure 401 {object} Problem "link invalid, expired, used or opened in another browser"
+// @Failure 422 {object} Problem "invalid fields"
+// @Router /applicant/external/v1/login/magic-link/consume [post]
+func (h *Handler) LoginWithMagicLink(c *gin.Context) {
+	var req MagicLinkLogin
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_json", "invalid request body"))
+		return
+	}
+	if err := h.validator.Validate(&req, c.GetHeader("Accept-Language")); err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+
+	device, _ := c.Cookie(magicLinkDeviceCookie)
+	token, err := h.service.LoginWithMagicLink(c.Request.Context(), req.Token, device)
+	if err != nil {
+		h.respondWithError(c, err)
 		return
 	}
 	h.respondWithData(c, http.StatusOK, "login success", gin.H{"token": token})
 }
 
 // @Summary Update user profile
-// @Description Updates the user profile info
+// @Description Updates the user profile info. Identity fields that need admin approval are
+// @Description recorded as pending changes and the response is 202 listing them.
 // @Tags Profile
 // @Security BearerAuth
-// @Accept json
-// @Produce json
+// @Security ApiKeyAuth
+// @Description The body is a JSON merge patch (RFC 7386), null clears a field and absent
+// @Description members are left as they are. A JSON patch (RFC 6902) is accepted too.
+// @Accept json,application/merge-patch+json,application/json-patch+json
+// @Produce json,application/problem+json
+// @Param If-Match header string true "ETag returned by GET /profile, or * to skip the check"
+// @Param Accept-Language header string false "language of the validation messages (en, es, fr, de)"
 // @Param request body UserInformationRequest true "User Information Request"
 // @Success 200 {object} map[string]interface{}
-// @Failure 400 {object} map[string]interface{}
-// @Failure 401 {object} map[string]interface{}
+// @Header 200 {string} ETag "new profile version"
+// @Success 202 {object} map[string]interface{} "pending_changes wait for an admin"
+// @Header 202 {string} ETag "new profile version"
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 404 {object} Problem "user not found"
+// @Failure 409 {object} Problem "a change of the field is already awaiting approval, or a JSON patch operation does not apply"
+// @Failure 412 {object} Problem "profile was modified since it was read"
+// @Failure 413 {object} Problem "patch is larger than 64 KiB"
+// @Failure 415 {object} Problem "unsupported patch format"
+// @Failure 422 {object} Problem "invalid fields"
+// @Failure 428 {object} Problem "If-Match header is required"
 // @Router /applicant/external/v1/profile [patch]
 func (h *Handler) UpdateUserInformation(c *gin.Context) {
-	var req UserInformationRequest
+	// Get user ID from JWT context (set by middleware)
+	userID := c.GetString("user_id")
+	if userID == "" {
+		h.respondWithError(c, newError(ErrUnauthorized, "invalid_token", "user ID missing from token"))
+		return
+	}
 
-	// Bind JSON after resetting body
-	if err := c.ShouldBindJSON(&req); err != nil {
-		h.respondWithError(c, http.StatusBadRequest, "invalid request body")
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		h.respondWithError(c, ErrIfMatchRequired)
+		return
+	}
+	version, err := parseETag(ifMatch)
+	if err != nil {
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_if_match", "invalid If-Match header"))
 		return
 	}
 
-	// Get user ID from JWT context (set by middleware)
-	userID := c.GetString("user_id")
-	if userID == "" {
-		h.respondWithError(c, http.StatusUnauthorized, "user ID missing from token")
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxPatchBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		h.respondWithError(c, ErrPatchTooLarge)
+		return
+	}
+	if err != nil {
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_json", "invalid request body"))
+		return
+	}
+	patch, err := newPatch(c.ContentType(), body)
+	if err != nil {
+		h.respondWithError(c, err)
 		return
 	}
 
-	// Convert userID string to int (or whatever type UserInformationRequest.ID expects)
-	idInt, err := strconv.Atoi(userID)
+	// The patch applies to the profile as it is now, the request keeps the
+	// version it was read at so a concurrent update still fails with 412
+	user, err := h.service.GetProfile(c.Request.Context(), userID)
 	if err != nil {
-		log.Println("[InsertUserInformation] Invalid user_id in context:", err)
-		h.respondWithError(c, http.StatusInternalServerError, "internal error")
+		h.respondWithError(c, err)
+		return
+	}
+	if version != AnyVersion && version != user.Version {
+		h.respondWithError(c, ErrVersionMismatch)
+		return
+	}
+
+	req, err := patchUserInformation(user, patch)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+
+	if err := h.validator.Validate(&req, c.GetHeader("Accept-Language")); err != nil {
+		h.respondWithError(c, err)
 		return
 	}
-	req.ID = idInt
 
 	// Call service
-	err = h.service.UpdateUserInfo(c.Request.Context(), req)
+	update, err := h.service.UpdateUserInfo(c.Request.Context(), req)
 	if err != nil {
-		log.Println("[InsertUserInformation] Service Error:", err)
-		h.respondWithError(c, http.StatusInternalServerError, "could not upsert user info")
+		h.respondWithError(c, err)
+		return
+	}
+	c.Header("ETag", formatETag(update.Version))
+	if len(update.Pending) > 0 {
+		h.respondWithData(c, http.StatusAccepted, "user info upserted, some changes await approval", gin.H{
+			"pending_changes": update.Pending,
+		})
 		return
 	}
-	// log.Println("user_id from token:", req.ID)
-	// log.Printf("request body: %+v\n", req)
 	h.respondWithSuccess(c, http.StatusOK, "user info upserted successfully")
 }
 
@@ -165,21 +388,24 @@ func (h *Handler) UpdateUserInformation(c *gin.Context) {
 // @Description Get the user profile info
 // @Tags Profile
 // @Security BearerAuth
-// @Produce json
+// @Security ApiKeyAuth
+// @Produce json,application/problem+json
 // @Success 200 {object} map[string]interface{}
-// @Failure 400 {object} map[string]interface{}
-// @Failure 401 {object} map[string]interface{}
+// @Header 200 {string} ETag "profile version, send it back in If-Match when patching"
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 404 {object} Problem "user not found"
 // @Router /applicant/external/v1/profile [get]
 func (h *Handler) GetProfile(c *gin.Context) {
 	userID := c.GetString("user_id")
 
 	user, err := h.service.GetProfile(c.Request.Context(), userID)
 	if err != nil {
-		log.Println("[GetProfile] Error fetching user: ", err)
-		h.respondWithError(c, http.StatusInternalServerError, "failed to fetch profile")
+		h.respondWithError(c, err)
 		return
 	}
 
+	c.Header("ETag", formatETag(user.Version))
 	h.respondWithData(c, http.StatusOK, "profile fetched successfully", gin.H{
 		"user_id":    user.ID,
 		"email":      user.Email,
@@ -187,46 +413,932 @@ func (h *Handler) GetProfile(c *gin.Context) {
 		"first_name": user.FirstName,
 		"last_name":  user.LastName,
 		"gender":     user.Gender,
-		"age":        user.Age,
-		"address":    user.Address,
-		"vehicle":    user.Vehicle,
+		// only set for a self_described gender
+		"gender_description": user.GenderDescription,
+		"age":                user.Age,
+		"address":            user.Address,
+		"vehicles":           user.Vehicles,
 	})
 }
 
+// ListGenders returns the genders users can pick when registering or
+// updating their profile
+// @Summary List gender options
+// @Description Lists the genders offered to users in the order they should be shown.
+// @Description A self_described gender needs a gender_description.
+// @Tags Profile
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /applicant/external/v1/genders [get]
+func (h *Handler) ListGenders(c *gin.Context) {
+	h.respondWithData(c, http.StatusOK, "genders fetched successfully", h.validator.genders)
+}
+
+// ChangePassword replaces the password of the logged in user
+// @Summary Change password
+// @Description Checks the current password and stores the new one if it satisfies the password policy
+// @Tags Auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json,application/problem+json
+// @Param Accept-Language header string false "language of the validation messages (en, es, fr, de)"
+// @Param request body ChangePasswordRequest true "Current and new password"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} Problem "request body is not valid JSON"
+// @Failure 401 {object} Problem "current password is wrong"
+// @Failure 422 {object} Problem "new password violates the password policy"
+// @Router /applicant/external/v1/password [put]
+func (h *Handler) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_json", "invalid request body"))
+		return
+	}
+
+	if err := h.validator.Validate(&req, c.GetHeader("Accept-Language")); err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+
+	err := h.service.ChangePassword(c.Request.Context(), c.GetString("user_id"), req)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithSuccess(c, http.StatusOK, "password changed successfully")
+}
+
+// @Summary List active sessions
+// @Description Lists where the logged in user is logged in, the session of the token used is marked as current
+// @Tags Sessions
+// @Security BearerAuth
+// @Produce json,application/problem+json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} Problem
+// @Router /applicant/external/v1/sessions [get]
+func (h *Handler) ListSessions(c *gin.Context) {
+	sessions, err := h.service.ListSessions(c.Request.Context(), c.GetString("user_id"), c.GetString("session_id"))
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "sessions fetched successfully", sessions)
+}
+
+// @Summary Revoke a session
+// @Description Logs the user out of a session, its token is rejected from then on. Revoking the current session logs out.
+// @Tags Sessions
+// @Security BearerAuth
+// @Produce json,application/problem+json
+// @Param id path string true "Session ID"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} Problem
+// @Failure 404 {object} Problem "session not found or already revoked"
+// @Router /applicant/external/v1/sessions/{id} [delete]
+func (h *Handler) RevokeSession(c *gin.Context) {
+	err := h.service.RevokeSession(c.Request.Context(), c.GetString("user_id"), c.Param("id"))
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithSuccess(c, http.StatusOK, "session revoked")
+}
+
+// @Summary Login history
+// @Description Lists every successful login of the logged in user with its time, IP, user agent and device, newest first
+// @Tags Sessions
+// @Security BearerAuth
+// @Produce json,application/problem+json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} Problem
+// @Router /applicant/external/v1/login-history [get]
+func (h *Handler) ListLoginHistory(c *gin.Context) {
+	logins, err := h.service.ListLoginHistory(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "login history fetched successfully", logins)
+}
+
+// @Summary List API keys
+// @Description Lists the API keys of the logged in user including the expired ones, newest first
+// @Tags API keys
+// @Security BearerAuth
+// @Produce json,application/problem+json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} Problem
+// @Router /applicant/external/v1/api-keys [get]
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.service.ListAPIKeys(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "API keys fetched successfully", keys)
+}
+
+// @Summary Create an API key
+// @Description Creates a personal access token for scripts, send it in the X-API-Key header instead of a Bearer token.
+// @Description A key only works on the routes of its scopes and expires after expires_in_days, 90 by default.
+// @Description The key is only returned here.
+// @Tags API keys
+// @Security BearerAuth
+// @Accept json
+// @Produce json,application/problem+json
+// @Param Accept-Language header string false "language of the validation messages (en, es, fr, de)"
+// @Param request body APIKeyRequest true "API key"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} Problem "request body is not valid JSON"
+// @Failure 401 {object} Problem
+// @Failure 409 {object} Problem "too many API keys"
+// @Failure 422 {object} Problem "invalid fields"
+// @Router /applicant/external/v1/api-keys [post]
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	var req APIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_json", "invalid request body"))
+		return
+	}
+	if err := h.validator.Validate(&req, c.GetHeader("Accept-Language")); err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+
+	key, secret, err := h.service.CreateAPIKey(c.Request.Context(), c.GetString("user_id"), req)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusCreated, "API key created, store it now, it is not shown again", gin.H{
+		"api_key": key,
+		"key":     secret,
+	})
+}
+
+// @Summary Revoke an API key
+// @Description Deletes an API key of the logged in user, requests made with it are rejected from then on
+// @Tags API keys
+// @Security BearerAuth
+// @Produce json,application/problem+json
+// @Param id path string true "API key ID"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} Problem
+// @Failure 404 {object} Problem "API key not found"
+// @Router /applicant/external/v1/api-keys/{id} [delete]
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	if err := h.service.RevokeAPIKey(c.Request.Context(), c.GetString("user_id"), c.Param("id")); err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithSuccess(c, http.StatusOK, "API key revoked")
+}
+
+// oidcStateCookie carries the state of a provider login from its start to the
+// callback, it is only sent to the callback
+const oidcStateCookie = "oidc_state"
+
+func (h *Handler) setOIDCState(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, "/"+basePath+"oidc", "", c.Request.TLS != nil, true)
+}
+
+// @Summary List identity providers
+// @Description Lists the OpenID Connect providers users can log in with
+// @Tags OIDC
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /applicant/external/v1/oidc/providers [get]
+func (h *Handler) ListOIDCProviders(c *gin.Context) {
+	h.respondWithData(c, http.StatusOK, "identity providers fetched successfully", h.service.OIDCProviders())
+}
+
+// @Summary Log in with an identity provider
+// @Description Redirects to the provider to sign in, the provider redirects back to the callback.
+// @Description Users who never logged in with the provider are registered, unless their email is taken.
+// @Tags OIDC
+// @Produce json,application/problem+json
+// @Param provider path string true "Provider name"
+// @Success 302
+// @Failure 404 {object} Problem "provider not found"
+// @Failure 502 {object} Problem "provider could not be reached"
+// @Router /applicant/external/v1/oidc/{provider}/login [get]
+func (h *Handler) StartOIDCLogin(c *gin.Context) {
+	start, err := h.service.StartOIDCLogin(c.Request.Context(), c.Param("provider"), "")
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.setOIDCState(c, start.State, int(oidcStateTTL.Seconds()))
+	c.Redirect(http.StatusFound, start.AuthURL)
+}
+
+// @Summary Identity provider callback
+// @Description Completes a login or link started with the provider. A login returns the token,
+// @Description created tells whether the user was registered. A link returns the linked identity.
+// @Tags OIDC
+// @Produce json,application/problem+json
+// @Param provider path string true "Provider name"
+// @Param code query string false "authorization code"
+// @Param state query string true "state sent to the provider"
+// @Param error query string false "error returned by the provider"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} Problem "sign in failed or the callback does not match the login started"
+// @Failure 404 {object} Problem "provider not found"
+// @Failure 409 {object} Problem "an account with the email exists, or the identity is linked to another user"
+// @Failure 502 {object} Problem "provider could not be reached"
+// @Router /applicant/external/v1/oidc/{provider}/callback [get]
+func (h *Handler) FinishOIDCLogin(c *gin.Context) {
+	cookie, _ := c.Cookie(oidcStateCookie)
+	// the state is single use
+	h.setOIDCState(c, "", -1)
+
+	result, err := h.service.FinishOIDCLogin(c.Request.Context(), c.Param("provider"), OIDCCallback{
+		Code:   c.Query("code"),
+		State:  c.Query("state"),
+		Error:  c.Query("error"),
+		Cookie: cookie,
+	})
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	if result.Identity != nil {
+		h.respondWithData(c, http.StatusOK, "identity provider linked", result.Identity)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "login success", gin.H{"token": result.Token, "created": result.Created})
+}
+
+// @Summary List linked identity providers
+// @Description Lists the identity providers the logged in user can log in with
+// @Tags OIDC
+// @Security BearerAuth
+// @Produce json,application/problem+json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} Problem
+// @Router /applicant/external/v1/profile/identities [get]
+func (h *Handler) ListIdentities(c *gin.Context) {
+	identities, err := h.service.ListIdentities(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "identities fetched successfully", identities)
+}
+
+// @Summary Link an identity provider
+// @Description Starts signing in with the provider to link it to the logged in user. The client
+// @Description opens authorization_url, the provider redirects to the callback which links it.
+// @Tags OIDC
+// @Security BearerAuth
+// @Produce json,application/problem+json
+// @Param provider path string true "Provider name"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} Problem
+// @Failure 404 {object} Problem "provider not found"
+// @Failure 502 {object} Problem "provider could not be reached"
+// @Router /applicant/external/v1/profile/identities/{provider} [post]
+func (h *Handler) LinkIdentity(c *gin.Context) {
+	start, err := h.service.StartOIDCLogin(c.Request.Context(), c.Param("provider"), c.GetString("user_id"))
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.setOIDCState(c, start.State, int(oidcStateTTL.Seconds()))
+	h.respondWithData(c, http.StatusOK, "continue at the identity provider", gin.H{"authorization_url": start.AuthURL})
+}
+
+// @Summary Unlink an identity provider
+// @Description Stops the logged in user from logging in with the provider. The last provider
+// @Description of a user without a password can't be unlinked.
+// @Tags OIDC
+// @Security BearerAuth
+// @Produce json,application/problem+json
+// @Param provider path string true "Provider name"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} Problem
+// @Failure 404 {object} Problem "provider is not linked"
+// @Failure 409 {object} Problem "user has no password and no other provider"
+// @Router /applicant/external/v1/profile/identities/{provider} [delete]
+func (h *Handler) UnlinkIdentity(c *gin.Context) {
+	err := h.service.UnlinkIdentity(c.Request.Context(), c.GetString("user_id"), c.Param("provider"))
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithSuccess(c, http.StatusOK, "identity provider unlinked")
+}
+
+// @Summary List vehicles
+// @Description Lists the vehicles of the logged in user
+// @Tags Vehicles
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Produce json,application/problem+json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} Problem
+// @Router /applicant/external/v1/profile/vehicles [get]
+func (h *Handler) ListVehicles(c *gin.Context) {
+	vehicles, err := h.service.ListVehicles(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "vehicles fetched successfully", vehicles)
+}
+
+// @Summary Add a vehicle
+// @Description Adds a vehicle to the logged in user
+// @Tags Vehicles
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json,application/problem+json
+// @Param Accept-Language header string false "language of the validation messages (en, es, fr, de)"
+// @Param request body VehicleRequest true "Vehicle"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} Problem "request body is not valid JSON"
+// @Failure 401 {object} Problem
+// @Failure 422 {object} Problem "invalid fields"
+// @Router /applicant/external/v1/profile/vehicles [post]
+func (h *Handler) CreateVehicle(c *gin.Context) {
+	req, ok := h.bindVehicle(c)
+	if !ok {
+		return
+	}
+
+	vehicle, err := h.service.CreateVehicle(c.Request.Context(), c.GetString("user_id"), req)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusCreated, "vehicle added successfully", vehicle)
+}
+
+// @Summary Get a vehicle
+// @Description Gets one vehicle of the logged in user
+// @Tags Vehicles
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Produce json,application/problem+json
+// @Param id path int true "Vehicle ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} Problem "invalid vehicle id"
+// @Failure 401 {object} Problem
+// @Failure 404 {object} Problem "vehicle not found"
+// @Router /applicant/external/v1/profile/vehicles/{id} [get]
+func (h *Handler) GetVehicle(c *gin.Context) {
+	vehicleID, ok := h.vehicleID(c)
+	if !ok {
+		return
+	}
+
+	vehicle, err := h.service.GetVehicle(c.Request.Context(), c.GetString("user_id"), vehicleID)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "vehicle fetched successfully", vehicle)
+}
+
+// @Summary Replace a vehicle
+// @Description Replaces every field of a vehicle of the logged in user
+// @Tags Vehicles
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json,application/problem+json
+// @Param id path int true "Vehicle ID"
+// @Param Accept-Language header string false "language of the validation messages (en, es, fr, de)"
+// @Param request body VehicleRequest true "Vehicle"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} Problem "invalid vehicle id or JSON"
+// @Failure 401 {object} Problem
+// @Failure 404 {object} Problem "vehicle not found"
+// @Failure 422 {object} Problem "invalid fields"
+// @Router /applicant/external/v1/profile/vehicles/{id} [put]
+func (h *Handler) UpdateVehicle(c *gin.Context) {
+	vehicleID, ok := h.vehicleID(c)
+	if !ok {
+		return
+	}
+	req, ok := h.bindVehicle(c)
+	if !ok {
+		return
+	}
+
+	vehicle, err := h.service.UpdateVehicle(c.Request.Context(), c.GetString("user_id"), vehicleID, req)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "vehicle updated successfully", vehicle)
+}
+
+// @Summary Remove a vehicle
+// @Description Removes a vehicle of the logged in user
+// @Tags Vehicles
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Produce json,application/problem+json
+// @Param id path int true "Vehicle ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} Problem "invalid vehicle id"
+// @Failure 401 {object} Problem
+// @Failure 404 {object} Problem "vehicle not found"
+// @Router /applicant/external/v1/profile/vehicles/{id} [delete]
+func (h *Handler) DeleteVehicle(c *gin.Context) {
+	vehicleID, ok := h.vehicleID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteVehicle(c.Request.Context(), c.GetString("user_id"), vehicleID); err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithSuccess(c, http.StatusOK, "vehicle removed successfully")
+}
+
+// vehicleID reads the :id path parameter, responding with a problem when it is not a positive number
+func (h *Handler) vehicleID(c *gin.Context) (int, bool) {
+	vehicleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || vehicleID <= 0 {
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_vehicle_id", "vehicle id must be a positive number"))
+		return 0, false
+	}
+	return vehicleID, true
+}
+
+// bindVehicle decodes and validates a VehicleRequest, responding with a problem when it fails
+func (h *Handler) bindVehicle(c *gin.Context) (VehicleRequest, bool) {
+	var req VehicleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_json", "invalid request body"))
+		return req, false
+	}
+	if err := h.validator.Validate(&req, c.GetHeader("Accept-Language")); err != nil {
+		h.respondWithError(c, err)
+		return req, false
+	}
+	return req, true
+}
+
+// @Summary List addresses
+// @Description Lists the addresses of the logged in user
+// @Tags Addresses
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Produce json,application/problem+json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} Problem
+// @Router /applicant/external/v1/profile/addresses [get]
+func (h *Handler) ListAddresses(c *gin.Context) {
+	addresses, err := h.service.ListAddresses(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "addresses fetched successfully", addresses)
+}
+
+// @Summary Add an address
+// @Description Adds an address to the logged in user, the first home or mailing address becomes primary
+// @Tags Addresses
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json,application/problem+json
+// @Param Accept-Language header string false "language of the validation messages (en, es, fr, de)"
+// @Param request body AddressRequest true "Address"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} Problem "request body is not valid JSON"
+// @Failure 401 {object} Problem
+// @Failure 409 {object} Problem "another address was made primary concurrently"
+// @Failure 422 {object} Problem "invalid fields"
+// @Router /applicant/external/v1/profile/addresses [post]
+func (h *Handler) CreateAddress(c *gin.Context) {
+	req, ok := h.bindAddress(c)
+	if !ok {
+		return
+	}
+
+	address, err := h.service.CreateAddress(c.Request.Context(), c.GetString("user_id"), req)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusCreated, "address added successfully", address)
+}
+
+// @Summary Get an address
+// @Description Gets one address of the logged in user
+// @Tags Addresses
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Produce json,application/problem+json
+// @Param id path int true "Address ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} Problem "invalid address id"
+// @Failure 401 {object} Problem
+// @Failure 404 {object} Problem "address not found"
+// @Router /applicant/external/v1/profile/addresses/{id} [get]
+func (h *Handler) GetAddress(c *gin.Context) {
+	addressID, ok := h.addressID(c)
+	if !ok {
+		return
+	}
+
+	address, err := h.service.GetAddress(c.Request.Context(), c.GetString("user_id"), addressID)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "address fetched successfully", address)
+}
+
+// @Summary Replace an address
+// @Description Replaces every field of an address of the logged in user, is_primary demotes the current primary address
+// @Tags Addresses
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json,application/problem+json
+// @Param id path int true "Address ID"
+// @Param Accept-Language header string false "language of the validation messages (en, es, fr, de)"
+// @Param request body AddressRequest true "Address"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} Problem "invalid address id or JSON"
+// @Failure 401 {object} Problem
+// @Failure 404 {object} Problem "address not found"
+// @Failure 409 {object} Problem "another address was made primary concurrently"
+// @Failure 422 {object} Problem "invalid fields"
+// @Router /applicant/external/v1/profile/addresses/{id} [put]
+func (h *Handler) UpdateAddress(c *gin.Context) {
+	addressID, ok := h.addressID(c)
+	if !ok {
+		return
+	}
+	req, ok := h.bindAddress(c)
+	if !ok {
+		return
+	}
+
+	address, err := h.service.UpdateAddress(c.Request.Context(), c.GetString("user_id"), addressID, req)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "address updated successfully", address)
+}
+
+// @Summary Remove an address
+// @Description Removes an address of the logged in user, removing the primary address promotes the oldest remaining one
+// @Tags Addresses
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Produce json,application/problem+json
+// @Param id path int true "Address ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} Problem "invalid address id"
+// @Failure 401 {object} Problem
+// @Failure 404 {object} Problem "address not found"
+// @Router /applicant/external/v1/profile/addresses/{id} [delete]
+func (h *Handler) DeleteAddress(c *gin.Context) {
+	addressID, ok := h.addressID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteAddress(c.Request.Context(), c.GetString("user_id"), addressID); err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithSuccess(c, http.StatusOK, "address removed successfully")
+}
+
+// addressID reads the :id path parameter, responding with a problem when it is not a positive number
+func (h *Handler) addressID(c *gin.Context) (int, bool) {
+	addressID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || addressID <= 0 {
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_address_id", "address id must be a positive number"))
+		return 0, false
+	}
+	return addressID, true
+}
+
+// bindAddress decodes and validates an AddressRequest, responding with a problem when it fails
+func (h *Handler) bindAddress(c *gin.Context) (AddressRequest, bool) {
+	var req AddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_json", "invalid request body"))
+		return req, false
+	}
+	if err := h.validator.Validate(&req, c.GetHeader("Accept-Language")); err != nil {
+		h.respondWithError(c, err)
+		return req, false
+	}
+	return req, true
+}
+
+// @Summary List own identity changes
+// @Description Lists the history of name, dob and gender changes of the logged in user
+// @Tags Profile
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Produce json,application/problem+json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} Problem
+// @Router /applicant/external/v1/profile/identity-changes [get]
+func (h *Handler) ListOwnIdentityChanges(c *gin.Context) {
+	userID, err := strconv.Atoi(c.GetString("user_id"))
+	if err != nil {
+		h.respondWithError(c, newError(ErrUnauthorized, "invalid_token", "user ID missing from token"))
+		return
+	}
+
+	changes, err := h.service.ListIdentityChanges(c.Request.Context(), IdentityChangeFilter{UserID: userID})
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "identity changes fetched successfully", changes)
+}
+
+// @Summary List identity changes
+// @Description Lists the identity changes of every user, filtered by status and user
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json,application/problem+json
+// @Param status query string false "applied, pending, approved or rejected"
+// @Param user_id query int false "only the changes of this user"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} Problem "invalid filter"
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem "not an admin"
+// @Router /applicant/external/v1/admin/identity-changes [get]
+func (h *Handler) ListIdentityChanges(c *gin.Context) {
+	filter := IdentityChangeFilter{Status: IdentityChangeStatus(c.Query("status"))}
+	switch filter.Status {
+	case "", IdentityChangeApplied, IdentityChangePending, IdentityChangeApproved, IdentityChangeRejected:
+	default:
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_status", "status must be applied, pending, approved or rejected"))
+		return
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		var err error
+		if filter.UserID, err = strconv.Atoi(userID); err != nil || filter.UserID <= 0 {
+			h.respondWithError(c, newError(ErrBadRequest, "invalid_user_id", "user_id must be a positive number"))
+			return
+		}
+	}
+
+	changes, err := h.service.ListIdentityChanges(c.Request.Context(), filter)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "identity changes fetched successfully", changes)
+}
+
+// @Summary Approve an identity change
+// @Description Applies a pending identity change to the user
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json,application/problem+json
+// @Param id path int true "Identity change ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} Problem "invalid identity change id"
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem "not an admin"
+// @Failure 404 {object} Problem "identity change not found"
+// @Failure 409 {object} Problem "identity change was already decided"
+// @Router /applicant/external/v1/admin/identity-changes/{id}/approve [post]
+func (h *Handler) ApproveIdentityChange(c *gin.Context) {
+	h.decideIdentityChange(c, true)
+}
+
+// @Summary Reject an identity change
+// @Description Rejects a pending identity change, the user keeps the stored value
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json,application/problem+json
+// @Param id path int true "Identity change ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} Problem "invalid identity change id"
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem "not an admin"
+// @Failure 404 {object} Problem "identity change not found"
+// @Failure 409 {object} Problem "identity change was already decided"
+// @Router /applicant/external/v1/admin/identity-changes/{id}/reject [post]
+func (h *Handler) RejectIdentityChange(c *gin.Context) {
+	h.decideIdentityChange(c, false)
+}
+
+func (h *Handler) decideIdentityChange(c *gin.Context, approve bool) {
+	changeID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || changeID <= 0 {
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_identity_change_id", "identity change id must be a positive number"))
+		return
+	}
+
+	change, err := h.service.DecideIdentityChange(c.Request.Context(), changeID, approve, c.GetString("email"))
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "identity change "+string(change.Status), change)
+}
+
+// @Summary List own profile history
+// @Description Lists the changes of the profile of the logged in user, newest version first.
+// @Description Every entry has the changed fields with their old and new values and the profile as it was afterwards.
+// @Tags Profile
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Produce json,application/problem+json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} Problem
+// @Router /applicant/external/v1/profile/history [get]
+func (h *Handler) ListOwnProfileHistory(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		h.respondWithError(c, newError(ErrUnauthorized, "invalid_token", "user ID missing from token"))
+		return
+	}
+
+	history, err := h.service.ListProfileHistory(c.Request.Context(), userID)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "profile history fetched successfully", history)
+}
+
+// @Summary List the profile history of a user
+// @Description Lists the changes of the profile of a user, newest version first
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json,application/problem+json
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} Problem "invalid user id"
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem "not an admin"
+// @Router /applicant/external/v1/admin/users/{id}/history [get]
+func (h *Handler) ListProfileHistory(c *gin.Context) {
+	userID, ok := h.userIDParam(c)
+	if !ok {
+		return
+	}
+
+	history, err := h.service.ListProfileHistory(c.Request.Context(), userID)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "profile history fetched successfully", history)
+}
+
+// @Summary Revert a profile
+// @Description Restores the profile of a user to how it was at a version of its history. The revert is
+// @Description recorded as a new version, identity fields are applied without approval.
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json,application/problem+json
+// @Param id path int true "User ID"
+// @Param version path int true "Profile version to restore"
+// @Success 200 {object} map[string]interface{} "history entry of the revert"
+// @Failure 400 {object} Problem "invalid user id or version"
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem "not an admin"
+// @Failure 404 {object} Problem "user or profile version not found"
+// @Failure 422 {object} Problem "the recorded address is not valid anymore"
+// @Router /applicant/external/v1/admin/users/{id}/history/{version}/revert [post]
+func (h *Handler) RevertProfile(c *gin.Context) {
+	userID, ok := h.userIDParam(c)
+	if !ok {
+		return
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version <= 0 {
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_version", "version must be a positive number"))
+		return
+	}
+
+	entry, err := h.service.RevertProfile(c.Request.Context(), userID, version, c.GetString("email"))
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "profile reverted", entry)
+}
+
+// userIDParam reads the :id of the admin user routes, responding with a problem when it is invalid
+func (h *Handler) userIDParam(c *gin.Context) (string, bool) {
+	userID := c.Param("id")
+	if id, err := strconv.Atoi(userID); err != nil || id <= 0 {
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_user_id", "user id must be a positive number"))
+		return "", false
+	}
+	return userID, true
+}
+
+// @Summary List audit events
+// @Description Lists the audit log oldest first. Pages are continued by passing next_after_id as after_id.
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json,application/problem+json
+// @Param type query string false "event type, e.g. auth.login_failed"
+// @Param actor query string false "email of the user or admin that caused the event"
+// @Param user_id query int false "only the events about this user"
+// @Param from query string false "RFC 3339 time, only events at or after it"
+// @Param to query string false "RFC 3339 time, only events before it"
+// @Param after_id query int false "only events with a greater id"
+// @Param limit query int false "page size, 100 by default and at most 1000"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} Problem "invalid filter"
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem "not an admin"
+// @Router /applicant/external/v1/admin/audit-events [get]
+func (h *Handler) ListAuditEvents(c *gin.Context) {
+	filter := AuditEventFilter{Type: AuditEventType(c.Query("type")), Actor: c.Query("actor")}
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{{"user_id", &filter.UserID}, {"after_id", &filter.AfterID}, {"limit", &filter.Limit}} {
+		if raw := c.Query(param.name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				h.respondWithError(c, newError(ErrBadRequest, "invalid_"+param.name, param.name+" must be a positive number"))
+				return
+			}
+			*param.value = n
+		}
+	}
+	for _, param := range []struct {
+		name  string
+		value **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if raw := c.Query(param.name); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				h.respondWithError(c, newError(ErrBadRequest, "invalid_"+param.name, param.name+" must be an RFC 3339 time"))
+				return
+			}
+			*param.value = &t
+		}
+	}
+
+	events, err := h.service.ListAuditEvents(c.Request.Context(), filter)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	page := gin.H{"events": events, "next_after_id": nil}
+	if len(events) == auditPageSize(filter.Limit) {
+		page["next_after_id"] = events[len(events)-1].ID
+	}
+	h.respondWithData(c, http.StatusOK, "audit events fetched successfully", page)
+}
+
 // UploadPDF godoc
 // @Summary      Upload a PDF file for a user
 // @Description  Accepts email and PDF file to upload and store it.
 // @Tags         Applicant
 // @Accept       multipart/form-data
-// @Produce      json
+// @Produce      json,application/problem+json
 // @Param        email formData string true "User email"
 // @Param        pdf formData file true "PDF file to upload"
 // @Success      200 {object} map[string]string "upload successful"
-// @Failure      400 {object} map[string]string "bad request or invalid file"
-// @Failure      500 {object} map[string]string "internal server error"
+// @Failure      400 {object} Problem "bad request or invalid file"
+// @Failure      500 {object} Problem "internal server error"
 // @Router       /applicant/external/v1/upload-pdf [post]
 // @Security BearerAuth
+// @Security ApiKeyAuth
 func (h *Handler) UploadPDF(c *gin.Context) {
 	email := c.PostForm("email")
 	file, err := c.FormFile("pdf")
 
 	if email == "" || err != nil {
-		log.Printf("Email: %s, FormFile error: %v\n", email, err)
-		h.respondWithError(c, http.StatusBadRequest, map[string]string{"request-parse": "email and pdf are required"})
+		h.log.WarnContext(c.Request.Context(), "[UploadPDF] invalid form", "err", err)
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_form", "email and pdf are required"))
 		return
 	}
 
 	if filepath.Ext(file.Filename) != ".pdf" {
-		h.respondWithError(c, http.StatusBadRequest, map[string]string{"invalid-file": "only PDF files are allowed"})
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_file_type", "only PDF files are allowed"))
 		return
 	}
 
-	err = h.service.SaveUserPDF(c, email, file)
+	err = h.service.SaveUserPDF(c.Request.Context(), email, file)
 	if err != nil {
-		h.respondWithError(c, http.StatusInternalServerError, map[string]string{"upload-failed": err.Error()})
+		h.respondWithError(c, err)
 		return
 	}
-	log.Println("uploading file successfull")
+	h.log.InfoContext(c.Request.Context(), "[UploadPDF] file uploaded", "size", file.Size)
 
 	h.respondWithData(c, http.StatusOK, "upload successful", map[string]string{"email": email})
 }
@@ -255,3 +1367,319 @@ func (h *Handler) UploadPDF(c *gin.Context) {
 // 	}
 // 	c.JSON(http.StatusOK, gin.H{"msg": "user info updated"})
 // }
+
+// @Summary List OAuth clients
+// @Description Lists the apps that delegate their login to this service
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json,application/problem+json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem "not an admin"
+// @Router /applicant/external/v1/admin/oauth-clients [get]
+func (h *Handler) ListOAuthClients(c *gin.Context) {
+	clients, err := h.service.ListOAuthClients(c.Request.Context())
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusOK, "OAuth clients fetched successfully", clients)
+}
+
+// @Summary Register an OAuth client
+// @Description Registers an app that delegates its login to this service. The client_secret of a
+// @Description confidential client is only returned here, public clients get none and must use PKCE.
+// @Tags Admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json,application/problem+json
+// @Param Accept-Language header string false "language of the validation messages (en, es, fr, de)"
+// @Param request body OAuthClientRequest true "Client"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} Problem "request body is not valid JSON"
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem "not an admin"
+// @Failure 422 {object} Problem "invalid fields"
+// @Router /applicant/external/v1/admin/oauth-clients [post]
+func (h *Handler) RegisterOAuthClient(c *gin.Context) {
+	var req OAuthClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondWithError(c, newError(ErrBadRequest, "invalid_json", "invalid request body"))
+		return
+	}
+	if err := h.validator.Validate(&req, c.GetHeader("Accept-Language")); err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+
+	client, secret, err := h.service.RegisterOAuthClient(c.Request.Context(), req)
+	if err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithData(c, http.StatusCreated, "OAuth client registered, store the secret now, it is not shown again", gin.H{
+		"client":        client,
+		"client_secret": secret,
+	})
+}
+
+// @Summary Delete an OAuth client
+// @Description Deletes a client with the consents given to it, its access tokens stop working
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json,application/problem+json
+// @Param id path string true "Client ID"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem "not an admin"
+// @Failure 404 {object} Problem "client not found"
+// @Router /applicant/external/v1/admin/oauth-clients/{id} [delete]
+func (h *Handler) DeleteOAuthClient(c *gin.Context) {
+	if err := h.service.DeleteOAuthClient(c.Request.Context(), c.Param("id")); err != nil {
+		h.respondWithError(c, err)
+		return
+	}
+	h.respondWithSuccess(c, http.StatusOK, "OAuth client deleted")
+}
+
+// @Summary OpenID Connect discovery
+// @Description The metadata of the authorization server
+// @Tags OAuth
+// @Produce json
+// @Success 200 {object} OAuthServerMetadata
+// @Router /applicant/external/v1/oauth/.well-known/openid-configuration [get]
+func (h *Handler) OAuthMetadata(c *gin.Context) {
+	c.JSON(http.StatusOK, h.service.OAuthMetadata())
+}
+
+// @Summary Signing keys
+// @Description The public keys ID and access tokens are signed with
+// @Tags OAuth
+// @Produce json
+// @Success 200 {object} JSONWebKeySet
+// @Router /applicant/external/v1/oauth/jwks [get]
+func (h *Handler) OAuthKeys(c *gin.Context) {
+	keys, err := h.service.OAuthKeys(c.Request.Context())
+	if err != nil {
+		h.respondWithOAuthError(c, err)
+		return
+	}
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(oauthKeysCacheTTL.Seconds())))
+	c.JSON(http.StatusOK, keys)
+}
+
+// authorizePage is the login and consent screen of the authorization server,
+// the consent form is shown once Ticket is set
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width">
+<title>{{if .Prompt}}Sign in to {{.Prompt.Client.Name}}{{else}}Sign in{{end}}</title>
+<style>body{font-family:sans-serif;max-width:24rem;margin:3rem auto;padding:0 1rem}label,input,button{display:block;width:100%;margin:.5rem 0}.error{color:#b00020}</style>
+</head>
+<body>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .Prompt}}
+<form method="post" action="authorize">
+{{range $name, $values := .Request}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}">
+{{end}}{{end}}
+{{if .Ticket}}
+<h1>{{.Prompt.Client.Name}} wants to</h1>
+<ul>{{range .Prompt.ScopeDescriptions}}<li>{{.}}</li>{{end}}</ul>
+<input type="hidden" name="ticket" value="{{.Ticket}}">
+<button name="action" value="allow">Allow</button>
+<button name="action" value="deny">Deny</button>
+{{else}}
+<h1>Sign in to continue to {{.Prompt.Client.Name}}</h1>
+<label>Email <input name="email" type="email" autocomplete="username" required autofocus></label>
+<label>Password <input name="password" type="password" autocomplete="current-password" required></label>
+<button name="action" value="login">Sign in</button>
+<button name="action" value="deny" formnovalidate>Cancel</button>
+{{end}}
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// @Summary Authorization endpoint
+// @Description Shows the login screen, and the consent screen for scopes the user did not allow the
+// @Description client yet, then redirects to the redirect_uri with a code. PKCE with S256 is required
+// @Description for public clients. Errors are sent to the redirect_uri once it is known to be registered.
+// @Tags OAuth
+// @Accept x-www-form-urlencoded
+// @Produce html
+// @Param response_type query string true "code"
+// @Param client_id query string true "Client ID"
+// @Param redirect_uri query string true "registered redirect URI"
+// @Param scope query string true "space separated scopes, openid for an ID token"
+// @Param state query string false "returned to the client"
+// @Param nonce query string false "put in the ID token"
+// @Param code_challenge query string false "PKCE challenge"
+// @Param code_challenge_method query string false "S256"
+// @Success 200 {string} string "login or consent screen"
+// @Success 303 "redirect to the client"
+// @Failure 400 {string} string "invalid client or redirect_uri"
+// @Router /applicant/external/v1/oauth/authorize [get]
+// @Router /applicant/external/v1/oauth/authorize [post]
+func (h *Handler) Authorize(c *gin.Context) {
+	var req AuthorizationRequest
+	// the screens post the request back as hidden fields
+	if err := c.ShouldBind(&req); err != nil {
+		h.renderAuthorizePage(c, nil, req, "", newError(ErrBadRequest, "invalid_request", "invalid authorization request"))
+		return
+	}
+	ctx := c.Request.Context()
+
+	prompt, err := h.service.PrepareAuthorization(ctx, req)
+	if err != nil {
+		h.renderAuthorizePage(c, nil, req, "", err)
+		return
+	}
+	if c.Request.Method == http.MethodGet {
+		h.renderAuthorizePage(c, prompt, req, "", nil)
+		return
+	}
+
+	var step AuthorizationStep
+	switch c.PostForm("action") {
+	case "deny":
+		step, err = h.service.AuthorizeConsent(ctx, req, "", false)
+	case "allow":
+		step, err = h.service.AuthorizeConsent(ctx, req, c.PostForm("ticket"), true)
+	default:
+		step, err = h.service.AuthorizeLogin(ctx, req, c.PostForm("email"), c.PostForm("password"))
+	}
+	if err != nil {
+		h.renderAuthorizePage(c, prompt, req, "", err)
+		return
+	}
+	if step.RedirectURL != "" {
+		c.Redirect(http.StatusSeeOther, step.RedirectURL)
+		return
+	}
+	h.renderAuthorizePage(c, prompt, req, step.ConsentTicket, nil)
+}
+
+// renderAuthorizePage shows the login screen, or the consent screen with a
+// ticket. Errors of a request with a trusted redirect_uri go to the client,
+// the others are shown on the page, without the form when prompt is nil.
+func (h *Handler) renderAuthorizePage(c *gin.Context, prompt *AuthorizationPrompt, req AuthorizationRequest, ticket string, err error) {
+	var oauthErr *OAuthError
+	if errors.As(err, &oauthErr) {
+		c.Redirect(http.StatusSeeOther, req.errorRedirect(oauthErr))
+		return
+	}
+
+	status, message := http.StatusOK, ""
+	if err != nil {
+		p, ok := problemFromError(c, h.validator.translateError(err, c.GetHeader("Accept-Language")))
+		if !ok {
+			h.log.ErrorContext(c.Request.Context(), "unhandled error", "method", c.Request.Method, "path", c.FullPath(), "err", err)
+		}
+		status, message = p.Status, p.Detail
+	}
+
+	// the screens must not be framed by other sites or cached
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	c.Header("Cache-Control", "no-store")
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	err = authorizePage.Execute(c.Writer, gin.H{
+		"Prompt": prompt,
+		"Request": url.Values{
+			"response_type":         {req.ResponseType},
+			"client_id":             {req.ClientID},
+			"redirect_uri":          {req.RedirectURI},
+			"scope":                 {req.Scope},
+			"state":                 {req.State},
+			"nonce":                 {req.Nonce},
+			"code_challenge":        {req.CodeChallenge},
+			"code_challenge_method": {req.CodeChallengeMethod},
+		},
+		"Ticket": ticket,
+		"Error":  message,
+	})
+	if err != nil {
+		h.log.ErrorContext(c.Request.Context(), "error rendering the authorize page", "err", err)
+	}
+}
+
+// @Summary Token endpoint
+// @Description Exchanges an authorization code for an access token, and an ID token for the openid
+// @Description scope. Confidential clients authenticate with HTTP basic or client_secret in the form.
+// @Tags OAuth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "authorization_code"
+// @Param code formData string true "authorization code"
+// @Param redirect_uri formData string true "redirect URI the code was sent to"
+// @Param code_verifier formData string false "PKCE verifier"
+// @Param client_id formData string false "Client ID, unless sent with HTTP basic"
+// @Param client_secret formData string false "client secret, unless sent with HTTP basic"
+// @Success 200 {object} TokenResponse
+// @Failure 400 {object} OAuthError
+// @Failure 401 {object} OAuthError "client authentication failed"
+// @Router /applicant/external/v1/oauth/token [post]
+func (h *Handler) OAuthToken(c *gin.Context) {
+	var req TokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		h.respondWithOAuthError(c, newOAuthError(http.StatusBadRequest, "invalid_request", "invalid form"))
+		return
+	}
+	if id, secret, ok := c.Request.BasicAuth(); ok {
+		// RFC 6749 section 2.3.1 form-encodes the credentials
+		req.ClientID, _ = url.QueryUnescape(id)
+		req.ClientSecret, _ = url.QueryUnescape(secret)
+	}
+
+	response, err := h.service.ExchangeAuthorizationCode(c.Request.Context(), req)
+	c.Header("Cache-Control", "no-store")
+	if err != nil {
+		h.respondWithOAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary UserInfo endpoint
+// @Description Returns the claims of the user the access token's scopes reveal
+// @Tags OAuth
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} OAuthError "missing or invalid access token"
+// @Router /applicant/external/v1/oauth/userinfo [get]
+// @Router /applicant/external/v1/oauth/userinfo [post]
+func (h *Handler) OAuthUserInfo(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		c.Header("WWW-Authenticate", `Bearer realm="userinfo"`)
+		c.JSON(http.StatusUnauthorized, newOAuthError(http.StatusUnauthorized, "invalid_request", "bearer access token required"))
+		return
+	}
+
+	info, err := h.service.OAuthUserInfo(c.Request.Context(), token)
+	if err != nil {
+		h.respondWithOAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// respondWithOAuthError writes err as an OAuth 2.0 error response
+func (h *Handler) respondWithOAuthError(c *gin.Context, err error) {
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) {
+		h.log.ErrorContext(c.Request.Context(), "unhandled error", "method", c.Request.Method, "path", c.FullPath(), "err", err)
+		c.JSON(http.StatusInternalServerError, newOAuthError(http.StatusInternalServerError, "server_error", ""))
+		return
+	}
+	switch {
+	case oauthErr.Code == "invalid_token":
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	case oauthErr.Code == "invalid_client" && c.GetHeader("Authorization") != "":
+		c.Header("WWW-Authenticate", `Basic realm="token"`)
+	}
+	c.JSON(oauthErr.Status, oauthErr)
+}
@@ -0,0 +1,122 @@
+package userauth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testPassword = "Passw0rd!"
+	// testAdmin may use the admin routes of a testServer
+	testAdmin = "admin@example.com"
+)
+
+var testTokenSecret = []byte("test-token-secret-of-at-least-32-bytes")
+
+// testServer mounts the handler of a service backed by the memory repository
+type testServer struct {
+	engine *gin.Engine
+	svc    Service
+	repo   Repository
+}
+
+// newTestServer sends emails with mailer, nil drops them
+func newTestServer(t *testing.T, mailer Mailer) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if mailer == nil {
+		mailer = NewLogMailer(log)
+	}
+	repo := NewMemoryRepository(log)
+	svc := NewService(repo, &PasswordPolicy{}, NewPasswordHasher(NewBcryptHasher(4)), testTokenSecret, nil, nil,
+		OAuthServerConfig{Issuer: "http://auth.example.com/oauth", KeyRotation: time.Hour},
+		MagicLinkConfig{URL: "https://app.example.com/magic", TTL: 15 * time.Minute}, mailer, log)
+	validator, err := NewValidator(nil)
+	if err != nil {
+		t.Fatalf("NewValidator: %v", err)
+	}
+	engine := gin.New()
+	NewHandler(svc, validator, []string{testAdmin}, log).MountRoutes(engine)
+	return &testServer{engine: engine, svc: svc, repo: repo}
+}
+
+// register stores a user with testPassword and returns its ID
+func (s *testServer) register(t *testing.T, email string) string {
+	t.Helper()
+	hash, err := NewBcryptHasher(4).Hash(testPassword)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	id, err := s.repo.UserRegister(context.Background(), UserRegisterRequest{FirstName: "Jane", Email: email, Password: hash, Gender: "female"})
+	if err != nil {
+		t.Fatalf("UserRegister: %v", err)
+	}
+	return id
+}
+
+// login returns the bearer token of a password login
+func (s *testServer) login(t *testing.T, email string) string {
+	t.Helper()
+	token, err := s.svc.GetUserProfile(context.Background(), UserLoginRequest{Email: email, Password: testPassword})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	return token
+}
+
+// do serves req and returns the recorded response
+func (s *testServer) do(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.engine.ServeHTTP(rec, req)
+	return rec
+}
+
+// problemCode is the code of a problem response, empty for other bodies
+func problemCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var p Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	return p.Code
+}
+
+func TestUpdateUserInformationBodyLimit(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.register(t, "jane@example.com")
+	token := s.login(t, "jane@example.com")
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/"+basePath+"profile", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", mergePatchContentType)
+		req.Header.Set("If-Match", "*")
+		return s.do(req)
+	}
+	// trailing whitespace pads the document to size bytes
+	sized := func(size int) string {
+		doc := `{"last_name":"Doe","dob":"1990-01-01"}`
+		return doc + strings.Repeat(" ", size-len(doc))
+	}
+
+	if rec := patch(sized(maxPatchBytes)); rec.Code != http.StatusOK {
+		t.Errorf("patch of %d bytes: status %d %s, want 200", maxPatchBytes, rec.Code, rec.Body)
+	}
+	rec := patch(sized(maxPatchBytes + 1))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("patch of %d bytes: status %d %s, want 413", maxPatchBytes+1, rec.Code, rec.Body)
+	}
+	if code := problemCode(t, rec); code != "patch_too_large" {
+		t.Errorf("code = %q, want patch_too_large", code)
+	}
+}
@@ -1,14 +1,39 @@
 package userauth
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"errors"
-	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecretKey = []byte("super_secret_key")
+// signingKeys are the HS256 keys of the tokens the service signs for itself.
+// Each is derived from the secret for one purpose, so a token of one kind
+// never verifies as another even with its audience forged.
+type signingKeys struct {
+	login     []byte
+	magicLink []byte
+	consent   []byte
+	oidcState []byte
+}
+
+func newSigningKeys(secret []byte) signingKeys {
+	return signingKeys{
+		login:     deriveKey(secret, loginAudience),
+		magicLink: deriveKey(secret, magicLinkAudience),
+		consent:   deriveKey(secret, consentTicketAudience),
+		oidcState: deriveKey(secret, oidcStateAudience),
+	}
+}
+
+// deriveKey is HMAC-SHA256 of purpose keyed with secret
+func deriveKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
 
 type JWTClaims struct {
 	UserUUID string `json:"user_uuid"`
@@ -17,29 +42,37 @@ type JWTClaims struct {
 	jwt.RegisteredClaims
 }
 
-// GenerateToken creates a new token for a user
-func GenerateToken(userUUID, email string) (string, error) {
+const (
+	// tokenTTL is how long a token and the session it was issued for are valid
+	tokenTTL = 24 * time.Hour
+	// loginAudience is the audience of the tokens a login issues
+	loginAudience = "login"
+)
+
+// GenerateToken creates a new token signed with key for a session of a user,
+// the session ID is the jti claim
+func GenerateToken(key []byte, userUUID, email string, session Session) (string, error) {
 	claims := JWTClaims{
 		UserUUID: userUUID,
 		Email:    email,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        session.ID,
+			Audience:  jwt.ClaimStrings{loginAudience},
+			ExpiresAt: jwt.NewNumericDate(session.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(session.CreatedAt),
 		},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecretKey)
+	return token.SignedString(key)
 }
 
-// ValidateToken parse and validates a JWT Token
-func ValidateToken(tokenStr string) (*JWTClaims, error) {
+// ValidateToken parse and validates a JWT Token signed with key, only tokens
+// issued by a login are accepted
+func ValidateToken(key []byte, tokenStr string) (*JWTClaims, error) {
 	claims := &JWTClaims{}
 	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSecretKey, nil
-	})
+		return key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(loginAudience), jwt.WithExpirationRequired())
 	if err != nil || !token.Valid {
 		return nil, errors.New("invalid token")
 	}
@@ -0,0 +1,66 @@
+package userauth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestValidateToken(t *testing.T) {
+	keys := newSigningKeys(testTokenSecret)
+	now := time.Now()
+	session := Session{ID: "session-1", CreatedAt: now, ExpiresAt: now.Add(tokenTTL)}
+	valid, err := GenerateToken(keys.login, "7", "jane@example.com", session)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	// sign signs claims of a login token changed by change with key
+	sign := func(key []byte, change func(c *JWTClaims)) string {
+		claims := JWTClaims{UserUUID: "7", Email: "jane@example.com", RegisteredClaims: jwt.RegisteredClaims{
+			ID: session.ID, Audience: jwt.ClaimStrings{loginAudience}, ExpiresAt: jwt.NewNumericDate(session.ExpiresAt),
+		}}
+		if change != nil {
+			change(&claims)
+		}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+		if err != nil {
+			t.Fatalf("SignedString: %v", err)
+		}
+		return token
+	}
+
+	claims, err := ValidateToken(keys.login, valid)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if claims.UserUUID != "7" || claims.ID != session.ID {
+		t.Errorf("claims = %+v, want user 7 and session %s", claims, session.ID)
+	}
+
+	for name, token := range map[string]string{
+		"other secret":   sign(newSigningKeys([]byte("another-secret-of-at-least-32-bytes")).login, nil),
+		"magic link key": sign(keys.magicLink, nil),
+		"no audience":    sign(keys.login, func(c *JWTClaims) { c.Audience = nil }),
+		"other audience": sign(keys.login, func(c *JWTClaims) { c.Audience = jwt.ClaimStrings{magicLinkAudience} }),
+		"no expiry":      sign(keys.login, func(c *JWTClaims) { c.ExpiresAt = nil }),
+		"expired":        sign(keys.login, func(c *JWTClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute)) }),
+		"cut signature":  valid[:len(valid)-10],
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ValidateToken(keys.login, token); err == nil {
+				t.Error("ValidateToken accepted the token")
+			}
+		})
+	}
+}
+
+func TestDeriveKeyPerPurpose(t *testing.T) {
+	keys := newSigningKeys(testTokenSecret)
+	if string(keys.login) == string(keys.magicLink) {
+		t.Error("login and magic link keys are the same")
+	}
+	if string(keys.login) != string(newSigningKeys(testTokenSecret).login) {
+		t.Error("keys derived from the same secret differ")
+	}
+}
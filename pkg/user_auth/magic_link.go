@@ -0,0 +1,118 @@
+package userauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	magicLinkAudience = "magic-link"
+	// magicLinkLimit links are emailed to a user per magicLinkWindow at most,
+	// further requests are dropped
+	magicLinkLimit  = 3
+	magicLinkWindow = 15 * time.Minute
+	// magicLinkSendTimeout bounds the delivery, which runs after the request returned
+	magicLinkSendTimeout = 30 * time.Second
+)
+
+// MagicLinkConfig configures the passwordless login
+type MagicLinkConfig struct {
+	// URL is the page the emailed link opens, it gets the token as the token
+	// query parameter and posts it to the consume endpoint
+	URL string
+	// TTL is how long a link can be used
+	TTL time.Duration
+}
+
+// MagicLinkRequest asks for a login link
+type MagicLinkRequest struct {
+	Email string `json:"email" example:"user@example.com" validate:"required,max=255,email_format"`
+}
+
+// MagicLinkLogin logs in with the token of an emailed link
+type MagicLinkLogin struct {
+	Token string `json:"token" validate:"required,max=2048"`
+}
+
+// magicLinkClaims are the claims of the token in a link, Device is the hash
+// of the device secret of the browser that asked for the link
+type magicLinkClaims struct {
+	Device string `json:"dev"`
+	jwt.RegisteredClaims
+}
+
+func newMagicLinkToken(key []byte, link MagicLink, device string) (string, error) {
+	claims := magicLinkClaims{
+		Device: hashDeviceSecret(device),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        link.ID,
+			Subject:   strconv.Itoa(link.UserID),
+			Audience:  jwt.ClaimStrings{magicLinkAudience},
+			IssuedAt:  jwt.NewNumericDate(link.CreatedAt),
+			ExpiresAt: jwt.NewNumericDate(link.ExpiresAt),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+}
+
+// parseMagicLinkToken returns the claims of a token signed with key,
+// ErrMagicLinkDevice is returned with the claims when the token was issued to
+// another device
+func parseMagicLinkToken(key []byte, raw, device string) (*magicLinkClaims, error) {
+	claims := &magicLinkClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(*jwt.Token) (any, error) {
+		return key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(magicLinkAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if claims.ID == "" || claims.Subject == "" {
+		return nil, errors.New("magic link token lacks its ID or subject")
+	}
+	if device == "" || claims.Device != hashDeviceSecret(device) {
+		return claims, ErrMagicLinkDevice
+	}
+	return claims, nil
+}
+
+// magicLinkURL is the link emailed for token
+func magicLinkURL(base, token string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid magic link URL %q: %w", base, err)
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// randomHex returns size random bytes hex encoded, 16 bytes make a link ID
+// and 32 the device secret a browser keeps in a cookie to prove it is the
+// one that asked for a link
+func randomHex(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validDeviceSecret tells whether a cookie holds a device secret
+func validDeviceSecret(device string) bool {
+	b, err := hex.DecodeString(device)
+	return err == nil && len(b) == 32
+}
+
+func hashDeviceSecret(device string) string {
+	sum := sha256.Sum256([]byte(device))
+	return hex.EncodeToString(sum[:])
+}
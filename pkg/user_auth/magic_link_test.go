@@ -0,0 +1,231 @@
+package userauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// chanMailer hands every sent mail to the test, links are sent in the background
+type chanMailer chan Mail
+
+func (m chanMailer) Send(ctx context.Context, mail Mail) error {
+	m <- mail
+	return nil
+}
+
+var mailLink = regexp.MustCompile(`https?://\S+`)
+
+// receiveLink waits for the next mail to email and returns the token of its link
+func (m chanMailer) receiveLink(t *testing.T, email string) string {
+	t.Helper()
+	select {
+	case mail := <-m:
+		if mail.To != email {
+			t.Fatalf("mail sent to %q, want %q", mail.To, email)
+		}
+		u, err := url.Parse(mailLink.FindString(mail.Body))
+		if err != nil {
+			t.Fatalf("parsing the link of %q: %v", mail.Body, err)
+		}
+		token := u.Query().Get("token")
+		if token == "" {
+			t.Fatalf("no token in the mail %q", mail.Body)
+		}
+		return token
+	case <-time.After(5 * time.Second):
+		t.Fatal("no login link was sent")
+		return ""
+	}
+}
+
+// assertNoMail fails when a mail arrives shortly
+func (m chanMailer) assertNoMail(t *testing.T) {
+	t.Helper()
+	select {
+	case mail := <-m:
+		t.Fatalf("unexpected mail to %s", mail.To)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLoginWithMagicLink(t *testing.T) {
+	ctx := context.Background()
+	mailer := make(chanMailer, magicLinkLimit+1)
+	s := newTestServer(t, mailer)
+	userID := s.register(t, "jane@example.com")
+	keys := newSigningKeys(testTokenSecret)
+
+	device, err := s.svc.RequestMagicLink(ctx, "Jane@Example.com", "")
+	if err != nil {
+		t.Fatalf("RequestMagicLink: %v", err)
+	}
+	if !validDeviceSecret(device) {
+		t.Fatalf("device secret %q is not 32 hex encoded bytes", device)
+	}
+	token := mailer.receiveLink(t, "jane@example.com")
+
+	otherDevice, err := randomHex(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, d := range map[string]string{"another device": otherDevice, "no device": ""} {
+		if _, err := s.svc.LoginWithMagicLink(ctx, token, d); !errors.Is(err, ErrMagicLinkDevice) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrMagicLinkDevice)
+		}
+	}
+
+	// the link still works on the device that asked for it
+	loginToken, err := s.svc.LoginWithMagicLink(ctx, token, device)
+	if err != nil {
+		t.Fatalf("LoginWithMagicLink: %v", err)
+	}
+	claims, err := s.svc.AuthenticateToken(ctx, loginToken)
+	if err != nil {
+		t.Fatalf("AuthenticateToken: %v", err)
+	}
+	if claims.UserUUID != userID || claims.Email != "jane@example.com" {
+		t.Errorf("claims = %+v, want user %s", claims, userID)
+	}
+
+	if _, err := s.svc.LoginWithMagicLink(ctx, token, device); !errors.Is(err, ErrMagicLinkInvalid) {
+		t.Errorf("second use: got %v, want %v", err, ErrMagicLinkInvalid)
+	}
+
+	// sign signs the claims of an unused link of the user changed by change
+	sign := func(key []byte, change func(c *magicLinkClaims)) string {
+		now := time.Now()
+		claims := magicLinkClaims{Device: hashDeviceSecret(device), RegisteredClaims: jwt.RegisteredClaims{
+			ID: "0123456789abcdef", Subject: userID, Audience: jwt.ClaimStrings{magicLinkAudience},
+			IssuedAt: jwt.NewNumericDate(now), ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		}}
+		if change != nil {
+			change(&claims)
+		}
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	for name, token := range map[string]string{
+		"expired":        sign(keys.magicLink, func(c *magicLinkClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Second)) }),
+		"login audience": sign(keys.magicLink, func(c *magicLinkClaims) { c.Audience = jwt.ClaimStrings{loginAudience} }),
+		"no audience":    sign(keys.magicLink, func(c *magicLinkClaims) { c.Audience = nil }),
+		"login key":      sign(keys.login, nil),
+		"login token":    loginToken,
+		"unknown link":   sign(keys.magicLink, nil),
+	} {
+		if _, err := s.svc.LoginWithMagicLink(ctx, token, device); !errors.Is(err, ErrMagicLinkInvalid) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrMagicLinkInvalid)
+		}
+	}
+}
+
+func TestRequestMagicLinkThrottle(t *testing.T) {
+	ctx := context.Background()
+	mailer := make(chanMailer, magicLinkLimit+1)
+	s := newTestServer(t, mailer)
+	userID := s.register(t, "jane@example.com")
+
+	device := ""
+	for i := 0; i <= magicLinkLimit; i++ {
+		var err error
+		if device, err = s.svc.RequestMagicLink(ctx, "jane@example.com", device); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+	}
+	for range magicLinkLimit {
+		mailer.receiveLink(t, "jane@example.com")
+	}
+	mailer.assertNoMail(t)
+
+	id, _ := strconv.Atoi(userID)
+	count, err := s.repo.CountMagicLinks(ctx, id, time.Now().Add(-magicLinkWindow))
+	if err != nil {
+		t.Fatalf("CountMagicLinks: %v", err)
+	}
+	if count != magicLinkLimit {
+		t.Errorf("%d links recorded, want %d", count, magicLinkLimit)
+	}
+
+	if _, err := s.svc.RequestMagicLink(ctx, "nobody@example.com", device); err != nil {
+		t.Fatalf("unknown email: %v", err)
+	}
+	mailer.assertNoMail(t)
+}
+
+func TestMagicLinkHandlers(t *testing.T) {
+	mailer := make(chanMailer, 1)
+	s := newTestServer(t, mailer)
+	s.register(t, "jane@example.com")
+
+	post := func(path, body string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/"+basePath+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		return s.do(req)
+	}
+	// tokenOf is the login token of a successful login response
+	tokenOf := func(rec *httptest.ResponseRecorder) string {
+		t.Helper()
+		var resp struct {
+			Data struct {
+				Token string `json:"token"`
+			} `json:"data"`
+		}
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &resp) != nil || resp.Data.Token == "" {
+			t.Fatalf("login: status %d %s, want 200 with a token", rec.Code, rec.Body)
+		}
+		return resp.Data.Token
+	}
+	getProfile := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/"+basePath+"profile", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return s.do(req).Code
+	}
+
+	rec := post("login/magic-link", `{"email":"jane@example.com"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("request link: status %d %s, want 202", rec.Code, rec.Body)
+	}
+	var device *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == magicLinkDeviceCookie {
+			device = c
+		}
+	}
+	if device == nil || !device.HttpOnly || device.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("device cookie = %+v, want an HttpOnly SameSite=Strict cookie", device)
+	}
+	body := `{"token":"` + mailer.receiveLink(t, "jane@example.com") + `"}`
+
+	rec = post("login/magic-link/consume", body)
+	if rec.Code != http.StatusUnauthorized || problemCode(t, rec) != "magic_link_device" {
+		t.Fatalf("consume without the device cookie: status %d %s, want 401 magic_link_device", rec.Code, rec.Body)
+	}
+	magicToken := tokenOf(post("login/magic-link/consume", body, device))
+	if rec := post("login/magic-link/consume", body, device); rec.Code != http.StatusUnauthorized || problemCode(t, rec) != "magic_link_invalid" {
+		t.Errorf("second consume: status %d %s, want 401 magic_link_invalid", rec.Code, rec.Body)
+	}
+
+	// the token of a link is accepted like the one of a password login
+	passwordToken := tokenOf(post("login", `{"email":"jane@example.com","password":"`+testPassword+`"}`))
+	for name, token := range map[string]string{"magic link": magicToken, "password": passwordToken} {
+		if code := getProfile(token); code != http.StatusOK {
+			t.Errorf("profile with the %s login token: status %d, want 200", name, code)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package userauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Mail is a plain text email to a user
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails to users
+type Mailer interface {
+	Send(ctx context.Context, mail Mail) error
+}
+
+// LogMailer logs emails instead of sending them, for development without a
+// mail server. The body is logged, links in it are usable by whoever reads
+// the logs.
+type LogMailer struct {
+	log *slog.Logger
+}
+
+func NewLogMailer(log *slog.Logger) *LogMailer {
+	return &LogMailer{log: log}
+}
+
+func (m *LogMailer) Send(ctx context.Context, mail Mail) error {
+	m.log.InfoContext(ctx, "[LogMailer] email not sent, no SMTP server configured", "to", mail.To, "subject", mail.Subject, "body", mail.Body)
+	return nil
+}
+
+// SMTPConfig is the server a SMTPMailer sends through, the username and
+// password are optional
+type SMTPConfig struct {
+	// Addr is the host:port of the server
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPMailer sends emails through an SMTP server, upgrading the connection
+// with STARTTLS when the server offers it
+type SMTPMailer struct {
+	config SMTPConfig
+}
+
+func NewSMTPMailer(config SMTPConfig) *SMTPMailer {
+	return &SMTPMailer{config: config}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, mail Mail) error {
+	host, _, err := net.SplitHostPort(m.config.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP address %q: %w", m.config.Addr, err)
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", m.config.Addr)
+	if err != nil {
+		return fmt.Errorf("connect to the SMTP server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("greet the SMTP server: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("start TLS: %w", err)
+		}
+	}
+	if m.config.Username != "" {
+		// PlainAuth refuses to send the password unencrypted except to localhost
+		if err := client.Auth(smtp.PlainAuth("", m.config.Username, m.config.Password, host)); err != nil {
+			return fmt.Errorf("authenticate: %w", err)
+		}
+	}
+	if err := client.Mail(m.config.From); err != nil {
+		return fmt.Errorf("set sender: %w", err)
+	}
+	if err := client.Rcpt(mail.To); err != nil {
+		return fmt.Errorf("set recipient: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("start message: %w", err)
+	}
+	if _, err := w.Write(m.message(mail)); err != nil {
+		return fmt.Errorf("write message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("send message: %w", err)
+	}
+	return client.Quit()
+}
+
+// message renders mail with its headers, line breaks are dropped from the
+// header values so they can't add headers
+func (m *SMTPMailer) message(mail Mail) []byte {
+	header := strings.NewReplacer("\r", "", "\n", "")
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", header.Replace(m.config.From))
+	fmt.Fprintf(&b, "To: %s\r\n", header.Replace(mail.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", header.Replace(mail.Subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(mail.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
@@ -1,37 +1,109 @@
 package userauth
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-// AuthMiddleware validates the JWT token and sets the claims in the context
-func AuthMiddleware() gin.HandlerFunc {
+// Authenticator checks the credentials AuthMiddleware accepts
+type Authenticator interface {
+	// AuthenticateToken returns the claims of a token whose session is still active
+	AuthenticateToken(ctx context.Context, token string) (*JWTClaims, error)
+	AuthenticateAPIKey(ctx context.Context, key string) (*APIKey, *User, error)
+}
+
+// AuthMiddleware validates the JWT token and its session and sets the claims
+// in the context. Without a token an API key is accepted from the X-API-Key
+// header on the routes it has the scope for.
+func AuthMiddleware(auth Authenticator, log *slog.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" && c.GetHeader(apiKeyHeader) != "" {
+			authenticateAPIKey(c, auth, log)
+			return
+		}
 		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-			c.JSON(http.StatusUnauthorized, gin.H{"msg": "missing or malformed tokens"})
-			c.Abort()
+			writeProblem(c, newProblem(c, http.StatusUnauthorized, "missing_token", "missing or malformed tokens"))
 			return
 		}
 
 		tokenStr := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
-		claims, err := ValidateToken(tokenStr)
+		claims, err := auth.AuthenticateToken(c.Request.Context(), tokenStr)
 		if err != nil {
-			log.Println("[JWT Error] Token validation failed:", err)
-			c.JSON(http.StatusUnauthorized, gin.H{"msg": "invalid or expired token"})
-			c.Abort()
+			p, ok := problemFromError(c, err)
+			if ok {
+				log.WarnContext(c.Request.Context(), "[AuthMiddleware] token rejected", "err", err)
+			} else {
+				log.ErrorContext(c.Request.Context(), "[AuthMiddleware] error checking token", "err", err)
+			}
+			writeProblem(c, p)
 			return
 		}
 
-		// Set user_uuid and email in context for use in handlers
+		// Set user_uuid, email and the session in context for use in handlers
 		c.Set("user_id", claims.UserUUID)
 		c.Set("email", claims.Email)
 		c.Set("age", claims.Age)
+		c.Set("session_id", claims.ID)
+		c.Request = c.Request.WithContext(withActor(c.Request.Context(), claims.Email))
 
 		c.Next()
 	}
 }
+
+// authenticateAPIKey is AuthMiddleware for a request made with an API key,
+// routes without an entry in apiKeyRouteScopes refuse every key
+func authenticateAPIKey(c *gin.Context, auth Authenticator, log *slog.Logger) {
+	scope, allowed := apiKeyRouteScopes[c.Request.Method+" "+c.FullPath()]
+	if !allowed {
+		writeProblem(c, newProblem(c, http.StatusForbidden, "api_key_not_allowed", "API keys can't be used here, log in instead"))
+		return
+	}
+
+	key, user, err := auth.AuthenticateAPIKey(c.Request.Context(), c.GetHeader(apiKeyHeader))
+	if err != nil {
+		p, ok := problemFromError(c, err)
+		if ok {
+			log.WarnContext(c.Request.Context(), "[AuthMiddleware] API key rejected")
+		} else {
+			log.ErrorContext(c.Request.Context(), "[AuthMiddleware] error checking API key", "err", err)
+		}
+		writeProblem(c, p)
+		return
+	}
+	if !key.HasScope(scope) {
+		log.WarnContext(c.Request.Context(), "[AuthMiddleware] API key lacks scope", "api_key_id", key.ID, "scope", scope)
+		writeProblem(c, newProblem(c, http.StatusForbidden, "insufficient_scope", "the API key lacks the "+scope+" scope"))
+		return
+	}
+
+	c.Set("user_id", strconv.Itoa(user.ID))
+	c.Set("email", user.Email)
+	c.Set("age", user.Age)
+	c.Set("api_key_id", key.ID)
+	c.Request = c.Request.WithContext(withActor(c.Request.Context(), user.Email))
+
+	c.Next()
+}
+
+// AdminMiddleware only lets through the users whose email is in admins, it
+// runs after AuthMiddleware which sets the email
+func AdminMiddleware(admins []string, log *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		email := c.GetString("email")
+		for _, admin := range admins {
+			if email != "" && strings.EqualFold(admin, email) {
+				c.Next()
+				return
+			}
+		}
+
+		log.WarnContext(c.Request.Context(), "[AdminMiddleware] admin route denied", "user_id", c.GetString("user_id"))
+		writeProblem(c, newProblem(c, http.StatusForbidden, "admin_required", "administrator access required"))
+	}
+}
@@ -0,0 +1,141 @@
+package userauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// storeAPIKey stores a key of the user and returns it, change adjusts the
+// stored key before it is saved
+func storeAPIKey(t *testing.T, repo Repository, userID string, change func(k *APIKey)) string {
+	t.Helper()
+	id, secret, key, err := newAPIKey()
+	if err != nil {
+		t.Fatalf("newAPIKey: %v", err)
+	}
+	idInt, _ := strconv.Atoi(userID)
+	now := time.Now().UTC().Truncate(time.Second)
+	apiKey := APIKey{
+		ID: id, UserID: idInt, Name: "script", SecretHash: hashAPIKeySecret(secret),
+		Scopes: []string{ScopeProfileRead}, CreatedAt: now, ExpiresAt: now.Add(time.Hour),
+	}
+	if change != nil {
+		change(&apiKey)
+	}
+	if _, err := repo.CreateAPIKey(context.Background(), apiKey); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	return key
+}
+
+func TestAuthMiddlewareAPIKey(t *testing.T) {
+	s := newTestServer(t, nil)
+	// the admin holds every scope, keys are still refused on the admin routes
+	userID := s.register(t, testAdmin)
+	key := storeAPIKey(t, s.repo, userID, func(k *APIKey) {
+		k.Scopes = []string{ScopeProfileRead, ScopeProfileWrite, ScopeVehiclesRead, ScopeVehiclesWrite,
+			ScopeAddressesRead, ScopeAddressesWrite, ScopeDocumentsWrite}
+	})
+	readOnly := storeAPIKey(t, s.repo, userID, nil)
+	expired := storeAPIKey(t, s.repo, userID, func(k *APIKey) { k.ExpiresAt = time.Now().Add(-time.Second) })
+	revoked := storeAPIKey(t, s.repo, userID, nil)
+	revokedID, _, _ := parseAPIKey(revoked)
+	if err := s.svc.RevokeAPIKey(context.Background(), userID, revokedID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	keyID, _, _ := parseAPIKey(key)
+	_, otherSecret, _, err := newAPIKey()
+	if err != nil {
+		t.Fatalf("newAPIKey: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		method, path string
+		key          string
+		status       int
+		code         string
+	}{
+		{"key with the scope", http.MethodGet, "profile", readOnly, http.StatusOK, ""},
+		{"key without the scope", http.MethodGet, "profile/vehicles", readOnly, http.StatusForbidden, "insufficient_scope"},
+		{"read scope on a write route", http.MethodPost, "profile/addresses", readOnly, http.StatusForbidden, "insufficient_scope"},
+		{"password change", http.MethodPut, "password", key, http.StatusForbidden, "api_key_not_allowed"},
+		{"API key listing", http.MethodGet, "api-keys", key, http.StatusForbidden, "api_key_not_allowed"},
+		{"API key creation", http.MethodPost, "api-keys", key, http.StatusForbidden, "api_key_not_allowed"},
+		{"sessions", http.MethodGet, "sessions", key, http.StatusForbidden, "api_key_not_allowed"},
+		{"admin route", http.MethodGet, "admin/audit-events", key, http.StatusForbidden, "api_key_not_allowed"},
+		{"expired key", http.MethodGet, "profile", expired, http.StatusUnauthorized, "invalid_api_key"},
+		{"revoked key", http.MethodGet, "profile", revoked, http.StatusUnauthorized, "invalid_api_key"},
+		{"wrong secret", http.MethodGet, "profile", apiKeyPrefix + keyID + "_" + otherSecret, http.StatusUnauthorized, "invalid_api_key"},
+		{"unknown key", http.MethodGet, "profile", apiKeyPrefix + "0000_" + otherSecret, http.StatusUnauthorized, "invalid_api_key"},
+		{"prefix only", http.MethodGet, "profile", apiKeyPrefix, http.StatusUnauthorized, "invalid_api_key"},
+		{"no secret", http.MethodGet, "profile", apiKeyPrefix + keyID, http.StatusUnauthorized, "invalid_api_key"},
+		{"empty secret", http.MethodGet, "profile", apiKeyPrefix + keyID + "_", http.StatusUnauthorized, "invalid_api_key"},
+		{"empty id", http.MethodGet, "profile", apiKeyPrefix + "_" + otherSecret, http.StatusUnauthorized, "invalid_api_key"},
+		{"no prefix", http.MethodGet, "profile", keyID + "_" + otherSecret, http.StatusUnauthorized, "invalid_api_key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/"+basePath+tt.path, nil)
+			req.Header.Set(apiKeyHeader, tt.key)
+			rec := s.do(req)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d %s, want %d", rec.Code, rec.Body, tt.status)
+			}
+			if tt.code != "" {
+				if code := problemCode(t, rec); code != tt.code {
+					t.Errorf("code = %q, want %q", code, tt.code)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthenticateAPIKeyThrottlesLastUsed(t *testing.T) {
+	s := newTestServer(t, nil)
+	ctx := context.Background()
+	userID := s.register(t, "jane@example.com")
+	lastUsed := func(t *testing.T, key string) *time.Time {
+		t.Helper()
+		id, _, _ := parseAPIKey(key)
+		apiKey, err := s.repo.FindAPIKey(ctx, id)
+		if err != nil {
+			t.Fatalf("FindAPIKey: %v", err)
+		}
+		return apiKey.LastUsedAt
+	}
+
+	tests := []struct {
+		name string
+		// usedAgo is when the key was last used, 0 for never
+		usedAgo time.Duration
+		touched bool
+	}{
+		{"never used", 0, true},
+		{"used within the interval", apiKeyTouchInterval / 2, false},
+		{"used before the interval", 2 * apiKeyTouchInterval, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := storeAPIKey(t, s.repo, userID, nil)
+			if tt.usedAgo > 0 {
+				id, _, _ := parseAPIKey(key)
+				if err := s.repo.TouchAPIKey(ctx, id, time.Now().Add(-tt.usedAgo).UTC().Truncate(time.Second)); err != nil {
+					t.Fatalf("TouchAPIKey: %v", err)
+				}
+			}
+			before := lastUsed(t, key)
+			if _, _, err := s.svc.AuthenticateAPIKey(ctx, key); err != nil {
+				t.Fatalf("AuthenticateAPIKey: %v", err)
+			}
+			after := lastUsed(t, key)
+			if touched := after != nil && (before == nil || !after.Equal(*before)); touched != tt.touched {
+				t.Errorf("last used went from %v to %v, touched = %v, want %v", before, after, touched, tt.touched)
+			}
+		})
+	}
+}
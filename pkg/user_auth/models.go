@@ -1,6 +1,7 @@
 package userauth
 
 import (
+	"slices"
 	"time"
 )
 
@@ -13,6 +14,297 @@ type User struct {
 	Age       int       `json:"age"`
 	DOB       time.Time `json:"-"`
 	Gender    string    `json:"gender"`
-	Address   Address   `json:"address"`
-	Vehicle   Vehicle   `json:"vehicle"`
+	// GenderDescription is only set for a self_described gender
+	GenderDescription string  `json:"gender_description,omitempty"`
+	Address           Address `json:"address"`
+	// Vehicles are not loaded by the Repository user lookups
+	Vehicles []UserVehicle `json:"vehicles"`
+	// Version of the user_information row, 0 when the profile was never updated
+	Version int `json:"version"`
+}
+
+type VehicleType string
+
+const (
+	VehicleCar     VehicleType = "car"
+	VehicleBike    VehicleType = "bike"
+	VehicleTruck   VehicleType = "truck"
+	VehicleScooter VehicleType = "scooter"
+	VehicleOther   VehicleType = "other"
+)
+
+// UserVehicle is a row of user_vehicles, a user can own any number of vehicles
+type UserVehicle struct {
+	ID                 int         `json:"id"`
+	UserID             int         `json:"-"`
+	Type               VehicleType `json:"type"`
+	Make               string      `json:"make"`
+	Model              string      `json:"model"`
+	Year               int         `json:"year,omitempty"`
+	RegistrationNumber string      `json:"registration_number"`
+	OwnedFrom          *Date       `json:"owned_from" swaggertype:"string" example:"2019-06-01"`
+	OwnedUntil         *Date       `json:"owned_until" swaggertype:"string" example:"2023-01-31"`
+	CreatedAt          time.Time   `json:"created_at"`
+	UpdatedAt          time.Time   `json:"updated_at"`
+}
+
+type AddressType string
+
+const (
+	AddressHome     AddressType = "home"
+	AddressMailing  AddressType = "mailing"
+	AddressPrevious AddressType = "previous"
+)
+
+// UserAddress is a row of user_addresses. At most one address of a user is
+// primary, it is the one returned as the profile address.
+type UserAddress struct {
+	ID     int         `json:"id"`
+	UserID int         `json:"-"`
+	Type   AddressType `json:"type"`
+	Address
+	ValidFrom  *Date     `json:"valid_from" swaggertype:"string" example:"2018-04-01"`
+	ValidUntil *Date     `json:"valid_until" swaggertype:"string" example:"2022-03-31"`
+	IsPrimary  bool      `json:"is_primary"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// IdentityField is a core identity field of the users table a user may change
+type IdentityField string
+
+const (
+	IdentityFirstName IdentityField = "first_name"
+	IdentityLastName  IdentityField = "last_name"
+	IdentityDOB       IdentityField = "dob"
+	IdentityGender    IdentityField = "gender"
+)
+
+type IdentityChangeStatus string
+
+const (
+	// IdentityChangeApplied changes took effect right away, no approval was needed
+	IdentityChangeApplied  IdentityChangeStatus = "applied"
+	IdentityChangePending  IdentityChangeStatus = "pending"
+	IdentityChangeApproved IdentityChangeStatus = "approved"
+	IdentityChangeRejected IdentityChangeStatus = "rejected"
+)
+
+// IdentityChange is a row of user_identity_changes, the history of every
+// change of an identity field. Values are stored as text, dates as 2006-01-02.
+type IdentityChange struct {
+	ID          int                  `json:"id"`
+	UserID      int                  `json:"user_id"`
+	Field       IdentityField        `json:"field"`
+	OldValue    string               `json:"old_value"`
+	NewValue    string               `json:"new_value"`
+	Status      IdentityChangeStatus `json:"status"`
+	RequestedAt time.Time            `json:"requested_at"`
+	DecidedAt   *time.Time           `json:"decided_at,omitempty"`
+	// DecidedBy is the email of the admin who approved or rejected the change
+	DecidedBy string `json:"decided_by,omitempty"`
+}
+
+// IdentityChangeFilter narrows ListIdentityChanges, zero values match everything
+type IdentityChangeFilter struct {
+	UserID int
+	Status IdentityChangeStatus
+}
+
+// ProfileSnapshot is the profile document of a user as recorded in its history
+type ProfileSnapshot struct {
+	FirstName         string  `json:"first_name"`
+	LastName          string  `json:"last_name"`
+	DOB               string  `json:"dob" example:"2000-01-01"`
+	Gender            string  `json:"gender"`
+	GenderDescription string  `json:"gender_description"`
+	Address           Address `json:"address"`
+}
+
+// ProfileChange is a field of the profile that changed, Field is its JSON path
+// e.g. address.city
+type ProfileChange struct {
+	Field string `json:"field" example:"address.city"`
+	Old   any    `json:"old" swaggertype:"string" example:"Pune"`
+	New   any    `json:"new" swaggertype:"string" example:"Mumbai"`
+}
+
+// ProfileHistoryEntry records a change of the profile and the profile as it
+// was afterwards, Version is the profile version the change produced
+type ProfileHistoryEntry struct {
+	ID      int `json:"id"`
+	UserID  int `json:"user_id"`
+	Version int `json:"version"`
+	// Actor is the email of the user or admin who made the change
+	Actor     string `json:"actor"`
+	RequestID string `json:"request_id,omitempty"`
+	// RevertedFrom is the version an admin reverted the profile to
+	RevertedFrom *int            `json:"reverted_from,omitempty"`
+	Changes      []ProfileChange `json:"changes"`
+	Profile      ProfileSnapshot `json:"profile"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// AuditEventType names a security relevant event
+type AuditEventType string
+
+const (
+	AuditUserRegistered         AuditEventType = "user.registered"
+	AuditLoginSucceeded         AuditEventType = "auth.login_succeeded"
+	AuditLoginFailed            AuditEventType = "auth.login_failed"
+	AuditPasswordChanged        AuditEventType = "auth.password_changed"
+	AuditDocumentUploaded       AuditEventType = "document.uploaded"
+	AuditIdentityChangeApproved AuditEventType = "admin.identity_change_approved"
+	AuditIdentityChangeRejected AuditEventType = "admin.identity_change_rejected"
+	AuditProfileReverted        AuditEventType = "admin.profile_reverted"
+	AuditTokenRevoked           AuditEventType = "auth.token_revoked"
+	AuditIdentityLinked         AuditEventType = "auth.identity_linked"
+	AuditIdentityUnlinked       AuditEventType = "auth.identity_unlinked"
+	AuditOAuthClientRegistered  AuditEventType = "admin.oauth_client_registered"
+	AuditOAuthClientDeleted     AuditEventType = "admin.oauth_client_deleted"
+	AuditOAuthAuthorized        AuditEventType = "oauth.authorized"
+	AuditAPIKeyCreated          AuditEventType = "auth.api_key_created"
+	AuditAPIKeyRevoked          AuditEventType = "auth.api_key_revoked"
+	AuditMagicLinkSent          AuditEventType = "auth.magic_link_sent"
+)
+
+// AuditEvent is an entry of the append-only audit log. Hash covers the event
+// and PrevHash, the hash of the entry before it, so changing or removing an
+// entry breaks the chain.
+type AuditEvent struct {
+	ID         int            `json:"id"`
+	OccurredAt time.Time      `json:"occurred_at"`
+	Type       AuditEventType `json:"type" example:"auth.login_failed"`
+	// Actor is the email of who acted, for a failed login the email tried
+	Actor string `json:"actor" example:"jane@example.com"`
+	// UserID is the user the event is about, 0 when there is none
+	UserID    int               `json:"user_id,omitempty"`
+	IP        string            `json:"ip,omitempty" example:"203.0.113.7"`
+	RequestID string            `json:"request_id,omitempty"`
+	Details   map[string]string `json:"details"`
+	// PrevHash is empty for the first entry
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// AuditEventFilter narrows ListAuditEvents, zero values match everything.
+// Events come in id order starting after AfterID, at most Limit of them.
+type AuditEventFilter struct {
+	Type    AuditEventType
+	Actor   string
+	UserID  int
+	From    *time.Time
+	To      *time.Time
+	AfterID int
+	Limit   int
+}
+
+// Session is a login of a user, the token issued by the login carries its ID.
+// Sessions are kept once revoked or expired and make up the login history.
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    int       `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	IP        string    `json:"ip,omitempty" example:"203.0.113.7"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	// Device is a label derived from the user agent
+	Device    string     `json:"device" example:"Firefox on Linux"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	// Current marks the session of the token the list was requested with
+	Current bool `json:"current"`
+}
+
+// Active reports whether tokens of the session are still accepted at now
+func (s Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// UserIdentity links a user to their account at an OpenID Connect provider
+type UserIdentity struct {
+	ID       int    `json:"id"`
+	UserID   int    `json:"-"`
+	Provider string `json:"provider" example:"google"`
+	// Subject is the stable ID of the account at the provider
+	Subject string `json:"subject"`
+	// Email is what the provider reported when the identity was linked
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OAuthClient is an app that delegates the login of its users to this service
+type OAuthClient struct {
+	ID   string `json:"client_id" example:"3f9c2a7d1e6b4c08"`
+	Name string `json:"name" example:"Payroll"`
+	// SecretHash is the SHA-256 of the client secret, empty for a public client
+	// which can't keep a secret and has to use PKCE instead
+	SecretHash   string    `json:"-"`
+	RedirectURIs []string  `json:"redirect_uris" example:"https://payroll.example.com/callback"`
+	Scopes       []string  `json:"scopes" example:"openid,profile,email"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Public tells whether the client authenticates without a secret
+func (c OAuthClient) Public() bool {
+	return c.SecretHash == ""
+}
+
+// OAuthConsent is what a user allowed a client to read, the consent screen is
+// skipped while a request asks for no more
+type OAuthConsent struct {
+	UserID    int
+	ClientID  string
+	Scopes    []string
+	GrantedAt time.Time
+}
+
+// OAuthAuthorizationCode is an authorization code issued to a client, only
+// the SHA-256 of the code is stored
+type OAuthAuthorizationCode struct {
+	CodeHash      string
+	ClientID      string
+	UserID        int
+	RedirectURI   string
+	Scopes        []string
+	Nonce         string
+	CodeChallenge string
+	ExpiresAt     time.Time
+	UsedAt        *time.Time
+}
+
+// OAuthSigningKey is a key the authorization server signs tokens with, a new
+// one replaces it every OAUTH_KEY_ROTATION
+type OAuthSigningKey struct {
+	ID string
+	// PrivateKey is the PKCS #8 PEM of an RSA key
+	PrivateKey string
+	CreatedAt  time.Time
+}
+
+// APIKey is a personal access token a user created for scripts. The key is
+// cck_<ID>_<secret>, only the SHA-256 of the secret is stored.
+type APIKey struct {
+	ID         string     `json:"id" example:"9b1d2f60c4a83e57"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name" example:"nightly export"`
+	SecretHash string     `json:"-"`
+	Scopes     []string   `json:"scopes" example:"profile:read,vehicles:read"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// HasScope tells whether the key may be used on the routes of scope
+func (k APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// MagicLink is an emailed login link, the signed token in the link carries
+// its ID. Links are kept once used to throttle how many a user gets.
+type MagicLink struct {
+	ID        string
+	UserID    int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
 }
@@ -0,0 +1,483 @@
+package userauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OAuthServerConfig configures the OAuth 2.0 and OpenID Connect authorization
+// server other apps delegate the login of their users to
+type OAuthServerConfig struct {
+	// Issuer is the URL the oauth routes are reached at, the iss claim of the
+	// issued tokens
+	Issuer string
+	// KeyRotation is how long a signing key signs new tokens before a new one
+	// replaces it
+	KeyRotation time.Duration
+}
+
+const (
+	// authorizationCodeTTL is how long a client has to exchange a code
+	authorizationCodeTTL = 5 * time.Minute
+	// oauthTokenTTL is the lifetime of the access and ID tokens
+	oauthTokenTTL = time.Hour
+	// consentTicketTTL is how long the consent screen can stay open
+	consentTicketTTL = 10 * time.Minute
+	// oauthKeysCacheTTL is how long the signing keys are used before they are
+	// read again, other instances may have rotated them
+	oauthKeysCacheTTL = time.Minute
+	// accessTokenType is the typ header of access tokens (RFC 9068), it keeps
+	// ID tokens from being used as access tokens
+	accessTokenType = "at+jwt"
+)
+
+// OAuthScopes are the scopes clients can ask for, each one but openid reveals
+// part of the profile
+var OAuthScopes = []string{"openid", "profile", "email", "address"}
+
+// oauthScopeDescriptions are shown on the consent screen
+var oauthScopeDescriptions = map[string]string{
+	"openid":  "Know who you are",
+	"profile": "See your name, gender and date of birth",
+	"email":   "See your email address",
+	"address": "See your address",
+}
+
+// OAuthError is an error response of the authorization server (RFC 6749
+// section 4.1.2.1 and 5.2), it is sent as is instead of a Problem
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	// Status is the HTTP status of a token or userinfo response
+	Status int `json:"-"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+func newOAuthError(status int, code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description, Status: status}
+}
+
+// OAuthServerMetadata is the discovery document (OpenID Connect Discovery 1.0)
+type OAuthServerMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// JSONWebKeySet is the jwks_uri document
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// OAuthClientRequest registers a client, the secret of a confidential client
+// is returned once
+type OAuthClientRequest struct {
+	Name         string   `json:"name" example:"Payroll" validate:"required,max=100"`
+	RedirectURIs []string `json:"redirect_uris" example:"https://payroll.example.com/callback" validate:"required,min=1,max=10,dive,url,max=2000"`
+	// Scopes the client may ask for, openid is always allowed
+	Scopes []string `json:"scopes" example:"profile,email" validate:"dive,oneof=openid profile email address"`
+	// Public clients, like single page and mobile apps, get no secret and have to use PKCE
+	Public bool `json:"public" example:"false"`
+}
+
+// AuthorizationRequest is an authorization request of a client (RFC 6749
+// section 4.1.1 with PKCE, RFC 7636)
+type AuthorizationRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	Nonce               string `form:"nonce"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+}
+
+// redirect is the redirect_uri with params and the state of the request
+func (r AuthorizationRequest) redirect(params url.Values) string {
+	// the redirect_uri was matched against the registered ones, it parses
+	u, _ := url.Parse(r.RedirectURI)
+	query := u.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	if r.State != "" {
+		query.Set("state", r.State)
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// errorRedirect sends an error of a request with a valid client and
+// redirect_uri back to the client
+func (r AuthorizationRequest) errorRedirect(err *OAuthError) string {
+	params := url.Values{"error": {err.Code}}
+	if err.Description != "" {
+		params.Set("error_description", err.Description)
+	}
+	return r.redirect(params)
+}
+
+// AuthorizationPrompt is what the login and consent screens show
+type AuthorizationPrompt struct {
+	Client OAuthClient
+	Scopes []string
+}
+
+// ScopeDescriptions describes the scopes for the consent screen
+func (p AuthorizationPrompt) ScopeDescriptions() []string {
+	descriptions := make([]string, len(p.Scopes))
+	for i, scope := range p.Scopes {
+		descriptions[i] = oauthScopeDescriptions[scope]
+	}
+	return descriptions
+}
+
+// AuthorizationStep is the outcome of the login or consent screen, the user
+// is either sent back to the client or has to consent with the ticket first
+type AuthorizationStep struct {
+	RedirectURL   string
+	ConsentTicket string
+}
+
+// TokenRequest is a token request (RFC 6749 section 4.1.3), the client
+// credentials come from the form or HTTP basic authentication
+type TokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+// TokenResponse is a successful token response, the ID token is only issued
+// for the openid scope
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token,omitempty"`
+	Scope       string `json:"scope"`
+}
+
+// consentTicket carries the logged in user from the login screen to the
+// consent screen, it is bound to the authorization request
+type consentTicket struct {
+	ClientID    string `json:"client_id"`
+	RedirectURI string `json:"redirect_uri"`
+	Scope       string `json:"scope"`
+	jwt.RegisteredClaims
+}
+
+const consentTicketAudience = "oauth-consent"
+
+func newConsentTicket(key []byte, userID int, req AuthorizationRequest) (string, error) {
+	now := time.Now()
+	ticket := consentTicket{
+		ClientID:    req.ClientID,
+		RedirectURI: req.RedirectURI,
+		Scope:       req.Scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   fmt.Sprint(userID),
+			Audience:  jwt.ClaimStrings{consentTicketAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(consentTicketTTL)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, ticket).SignedString(key)
+}
+
+// parseConsentTicket returns the user a ticket signed with key was issued to for req
+func parseConsentTicket(key []byte, raw string, req AuthorizationRequest) (string, error) {
+	ticket := &consentTicket{}
+	_, err := jwt.ParseWithClaims(raw, ticket, func(*jwt.Token) (any, error) {
+		return key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(consentTicketAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return "", err
+	}
+	if ticket.ClientID != req.ClientID || ticket.RedirectURI != req.RedirectURI || ticket.Scope != req.Scope {
+		return "", errors.New("consent ticket was issued for another request")
+	}
+	return ticket.Subject, nil
+}
+
+// accessTokenClaims are the claims of an access token (RFC 9068)
+type accessTokenClaims struct {
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+	jwt.RegisteredClaims
+}
+
+// oauthKey is a signing key of the authorization server
+type oauthKey struct {
+	id        string
+	key       *rsa.PrivateKey
+	createdAt time.Time
+}
+
+func (k oauthKey) publicJWK() JSONWebKey {
+	return JSONWebKey{
+		Kty: "RSA",
+		Kid: k.id,
+		Use: "sig",
+		Alg: jwt.SigningMethodRS256.Alg(),
+		N:   base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+	}
+}
+
+// oauthServer holds the signing keys, they are shared with the other
+// instances through the repository
+type oauthServer struct {
+	config OAuthServerConfig
+
+	mu       sync.Mutex
+	keys     []oauthKey // newest first
+	loadedAt time.Time
+}
+
+func (o *oauthServer) metadata() OAuthServerMetadata {
+	return OAuthServerMetadata{
+		Issuer:                            o.config.Issuer,
+		AuthorizationEndpoint:             o.config.Issuer + "/authorize",
+		TokenEndpoint:                     o.config.Issuer + "/token",
+		UserinfoEndpoint:                  o.config.Issuer + "/userinfo",
+		JWKSURI:                           o.config.Issuer + "/jwks",
+		ScopesSupported:                   OAuthScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{jwt.SigningMethodRS256.Alg()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "name", "given_name", "family_name",
+			"gender", "birthdate", "email", "email_verified", "address"},
+	}
+}
+
+// signingKeys returns the keys tokens may still be signed with, newest first.
+// The newest one signs new tokens, a new key is created when it is older
+// than KeyRotation. Older keys are published until the tokens they signed
+// expire.
+func (o *oauthServer) signingKeys(ctx context.Context, repo Repository) ([]oauthKey, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(o.loadedAt) < oauthKeysCacheTTL && len(o.keys) > 0 && now.Sub(o.keys[0].createdAt) < o.config.KeyRotation {
+		return o.keys, nil
+	}
+
+	keys, err := o.loadKeys(ctx, repo, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 || now.Sub(keys[0].createdAt) >= o.config.KeyRotation {
+		key, err := newOAuthKey(now)
+		if err != nil {
+			return nil, err
+		}
+		if err := repo.CreateSigningKey(ctx, key); err != nil {
+			return nil, err
+		}
+		// read back, another instance may have rotated at the same time
+		if keys, err = o.loadKeys(ctx, repo, now); err != nil {
+			return nil, err
+		}
+	}
+	o.keys, o.loadedAt = keys, now
+	return keys, nil
+}
+
+func (o *oauthServer) loadKeys(ctx context.Context, repo Repository, now time.Time) ([]oauthKey, error) {
+	stored, err := repo.ListSigningKeys(ctx, now.Add(-o.config.KeyRotation-oauthTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]oauthKey, 0, len(stored))
+	for _, s := range stored {
+		block, _ := pem.Decode([]byte(s.PrivateKey))
+		if block == nil {
+			return nil, fmt.Errorf("signing key %s is not PEM encoded", s.ID)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse signing key %s: %w", s.ID, err)
+		}
+		key, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("signing key %s is not an RSA key", s.ID)
+		}
+		keys = append(keys, oauthKey{id: s.ID, key: key, createdAt: s.CreatedAt})
+	}
+	return keys, nil
+}
+
+func newOAuthKey(now time.Time) (OAuthSigningKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return OAuthSigningKey{}, fmt.Errorf("generate signing key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return OAuthSigningKey{}, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return OAuthSigningKey{}, err
+	}
+	return OAuthSigningKey{
+		ID:         hex.EncodeToString(id),
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		CreatedAt:  now.UTC(),
+	}, nil
+}
+
+// sign signs claims with the newest key
+func (o *oauthServer) sign(ctx context.Context, repo Repository, claims jwt.Claims, typ string) (string, error) {
+	keys, err := o.signingKeys(ctx, repo)
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keys[0].id
+	if typ != "" {
+		token.Header["typ"] = typ
+	}
+	return token.SignedString(keys[0].key)
+}
+
+// parseAccessToken verifies an access token issued by the server
+func (o *oauthServer) parseAccessToken(ctx context.Context, repo Repository, raw string) (*accessTokenClaims, error) {
+	keys, err := o.signingKeys(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	claims := &accessTokenClaims{}
+	_, err = jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (any, error) {
+		if token.Header["typ"] != accessTokenType {
+			return nil, errors.New("not an access token")
+		}
+		for _, k := range keys {
+			if k.id == token.Header["kid"] {
+				return &k.key.PublicKey, nil
+			}
+		}
+		return nil, errors.New("unknown signing key")
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}), jwt.WithIssuer(o.config.Issuer),
+		jwt.WithAudience(o.config.Issuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, newOAuthError(http.StatusUnauthorized, "invalid_token", err.Error())
+	}
+	return claims, nil
+}
+
+// profileClaims are the standard claims (OpenID Connect Core section 5.1) of
+// the profile the scopes reveal
+func profileClaims(user User, scopes []string) map[string]any {
+	claims := map[string]any{}
+	if slices.Contains(scopes, "profile") {
+		claims["name"] = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		claims["given_name"] = user.FirstName
+		if user.LastName != "" {
+			claims["family_name"] = user.LastName
+		}
+		gender := user.Gender
+		if user.GenderDescription != "" {
+			gender = user.GenderDescription
+		}
+		claims["gender"] = gender
+		if !user.DOB.IsZero() {
+			claims["birthdate"] = user.DOB.Format(time.DateOnly)
+		}
+	}
+	if slices.Contains(scopes, "email") {
+		claims["email"] = user.Email
+		// registration does not verify the email
+		claims["email_verified"] = false
+	}
+	if slices.Contains(scopes, "address") {
+		address := map[string]string{}
+		for name, value := range map[string]*string{
+			"locality":    user.Address.City,
+			"region":      user.Address.State,
+			"postal_code": user.Address.PostalCode,
+			"country":     user.Address.Country,
+		} {
+			if value != nil {
+				address[name] = *value
+			}
+		}
+		if len(address) > 0 {
+			claims["address"] = address
+		}
+	}
+	return claims
+}
+
+// validRedirectURI accepts absolute https URIs without a fragment, plain http
+// only on the loopback interface for clients in development
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Fragment != "" || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
+
+func hashOAuthSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newOAuthSecret returns a random value for a client ID, client secret or
+// authorization code
+func newOAuthSecret(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
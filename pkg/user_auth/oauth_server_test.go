@@ -0,0 +1,145 @@
+package userauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExchangeAuthorizationCode(t *testing.T) {
+	ctx := context.Background()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repo := NewMemoryRepository(log)
+	svc := NewService(repo, &PasswordPolicy{}, NewPasswordHasher(NewBcryptHasher(4)), testTokenSecret, nil, nil,
+		OAuthServerConfig{Issuer: "http://auth.example.com/oauth", KeyRotation: time.Hour}, MagicLinkConfig{}, NewLogMailer(log), log)
+
+	registered, err := repo.UserRegister(ctx, UserRegisterRequest{FirstName: "Jane", Email: "jane@example.com", Password: "hashed", Gender: "female"})
+	if err != nil {
+		t.Fatalf("UserRegister: %v", err)
+	}
+	userID, _ := strconv.Atoi(registered)
+	const redirectURI = "https://app.example.com/callback"
+	for _, client := range []OAuthClient{
+		{ID: "spa", Name: "SPA", RedirectURIs: []string{redirectURI}, Scopes: []string{"openid"}, CreatedAt: time.Now()},
+		{ID: "backend", Name: "Backend", SecretHash: hashOAuthSecret("s3cret"), RedirectURIs: []string{redirectURI}, Scopes: []string{"openid"}, CreatedAt: time.Now()},
+	} {
+		if _, err := repo.CreateOAuthClient(ctx, client); err != nil {
+			t.Fatalf("CreateOAuthClient: %v", err)
+		}
+	}
+
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gXk7FXAAA"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	// issue stores a code of the spa client for the user, change adjusts it
+	issue := func(t *testing.T, code string, change func(c *OAuthAuthorizationCode)) {
+		t.Helper()
+		c := OAuthAuthorizationCode{
+			CodeHash: hashOAuthSecret(code), ClientID: "spa", UserID: userID, RedirectURI: redirectURI,
+			Scopes: []string{"openid"}, CodeChallenge: challenge, ExpiresAt: time.Now().Add(authorizationCodeTTL),
+		}
+		if change != nil {
+			change(&c)
+		}
+		if err := repo.CreateAuthorizationCode(ctx, c); err != nil {
+			t.Fatalf("CreateAuthorizationCode: %v", err)
+		}
+	}
+	exchange := func(code string, change func(r *TokenRequest)) (*TokenResponse, error) {
+		req := TokenRequest{GrantType: "authorization_code", Code: code, RedirectURI: redirectURI, CodeVerifier: verifier, ClientID: "spa"}
+		if change != nil {
+			change(&req)
+		}
+		return svc.ExchangeAuthorizationCode(ctx, req)
+	}
+
+	t.Run("valid code then reused", func(t *testing.T) {
+		issue(t, "code-1", nil)
+		resp, err := exchange("code-1", nil)
+		if err != nil {
+			t.Fatalf("ExchangeAuthorizationCode: %v", err)
+		}
+		if resp.AccessToken == "" || resp.IDToken == "" || resp.TokenType != "Bearer" {
+			t.Errorf("got %+v, want an access and an ID token", resp)
+		}
+		if _, err := exchange("code-1", nil); !isOAuthError(err, "invalid_grant") {
+			t.Errorf("reused code: got %v, want invalid_grant", err)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		code     func(c *OAuthAuthorizationCode)
+		request  func(r *TokenRequest)
+		wantCode string
+	}{
+		{"verifier without a challenge", func(c *OAuthAuthorizationCode) { c.CodeChallenge = "" }, nil, "invalid_grant"},
+		{"challenge without a verifier", nil, func(r *TokenRequest) { r.CodeVerifier = "" }, "invalid_grant"},
+		{"wrong verifier", nil, func(r *TokenRequest) { r.CodeVerifier = verifier + "x" }, "invalid_grant"},
+		{"plain challenge sent as verifier", nil, func(r *TokenRequest) { r.CodeVerifier = challenge }, "invalid_grant"},
+		{"another redirect URI", nil, func(r *TokenRequest) { r.RedirectURI = "https://evil.example.com/callback" }, "invalid_grant"},
+		{"code of another client", func(c *OAuthAuthorizationCode) { c.ClientID = "backend" }, nil, "invalid_grant"},
+		{"expired code", func(c *OAuthAuthorizationCode) { c.ExpiresAt = time.Now().Add(-time.Second) }, nil, "invalid_grant"},
+		{"unknown code", nil, func(r *TokenRequest) { r.Code = "nope" }, "invalid_grant"},
+		{"another grant type", nil, func(r *TokenRequest) { r.GrantType = "password" }, "unsupported_grant_type"},
+		{"unknown client", nil, func(r *TokenRequest) { r.ClientID = "nope" }, "invalid_client"},
+		{"confidential client with a wrong secret", nil, func(r *TokenRequest) { r.ClientID, r.ClientSecret = "backend", "wrong" }, "invalid_client"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := "code-case-" + strconv.Itoa(i)
+			issue(t, code, tt.code)
+			resp, err := exchange(code, tt.request)
+			if !isOAuthError(err, tt.wantCode) {
+				t.Errorf("got %+v, %v, want %s", resp, err, tt.wantCode)
+			}
+		})
+	}
+
+	t.Run("confidential client without PKCE", func(t *testing.T) {
+		issue(t, "code-2", func(c *OAuthAuthorizationCode) { c.ClientID, c.CodeChallenge = "backend", "" })
+		if _, err := exchange("code-2", func(r *TokenRequest) { r.ClientID, r.ClientSecret, r.CodeVerifier = "backend", "s3cret", "" }); err != nil {
+			t.Errorf("ExchangeAuthorizationCode: %v", err)
+		}
+	})
+}
+
+// isOAuthError tells whether err is the OAuth error code, every rejection is
+// a client error
+func isOAuthError(err error, code string) bool {
+	var oauthErr *OAuthError
+	return errors.As(err, &oauthErr) && oauthErr.Code == code && oauthErr.Status < http.StatusInternalServerError
+}
+
+func TestParseConsentTicket(t *testing.T) {
+	keys := newSigningKeys(testTokenSecret)
+	req := AuthorizationRequest{ClientID: "spa", RedirectURI: "https://app.example.com/callback", Scope: "openid"}
+	ticket, err := newConsentTicket(keys.consent, 7, req)
+	if err != nil {
+		t.Fatalf("newConsentTicket: %v", err)
+	}
+
+	if userID, err := parseConsentTicket(keys.consent, ticket, req); err != nil || userID != "7" {
+		t.Errorf("parseConsentTicket = %q, %v, want user 7", userID, err)
+	}
+	other := req
+	other.Scope = "openid email"
+	if _, err := parseConsentTicket(keys.consent, ticket, other); err == nil {
+		t.Error("parseConsentTicket accepted a ticket of another request")
+	}
+	for name, key := range map[string][]byte{
+		"login key":    keys.login,
+		"other secret": newSigningKeys([]byte("another-secret-of-at-least-32-bytes")).consent,
+	} {
+		if _, err := parseConsentTicket(key, ticket, req); err == nil {
+			t.Errorf("parseConsentTicket with the %s accepted the ticket", name)
+		}
+	}
+}
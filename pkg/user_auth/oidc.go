@@ -0,0 +1,444 @@
+package userauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// oidcStateTTL is how long a login started at the provider can be finished
+	oidcStateTTL = 10 * time.Minute
+	// oidcStateAudience is the audience of the signed state cookie
+	oidcStateAudience = "oidc-state"
+	// oidcKeysRefreshInterval limits how often an unknown key ID refetches the JWKS
+	oidcKeysRefreshInterval = time.Minute
+	// oidcMaxResponseSize bounds the discovery, JWKS and token responses
+	oidcMaxResponseSize = 1 << 20
+)
+
+// oidcDefaultScopes are always requested, Scopes of a provider are added to them
+var oidcDefaultScopes = []string{"openid", "email", "profile"}
+
+// OIDCProviderConfig is an OpenID Connect provider users can sign in with
+type OIDCProviderConfig struct {
+	// Name identifies the provider in the routes, e.g. /oidc/google/login
+	Name         string `json:"name"`
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	// RedirectURL is the callback route of the provider as registered with it
+	RedirectURL string   `json:"redirect_url"`
+	Scopes      []string `json:"scopes"`
+}
+
+// LoadOIDCProviders reads a JSON array of OIDCProviderConfig
+func LoadOIDCProviders(path string) ([]OIDCProviderConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []OIDCProviderConfig
+	if err := json.Unmarshal(b, &configs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	names := map[string]bool{}
+	for i, c := range configs {
+		switch {
+		case c.Name == "" || c.Issuer == "" || c.ClientID == "" || c.RedirectURL == "":
+			return nil, fmt.Errorf("%s: provider %d needs a name, issuer, client_id and redirect_url", path, i)
+		case names[c.Name]:
+			return nil, fmt.Errorf("%s: provider %q is listed twice", path, c.Name)
+		}
+		names[c.Name] = true
+	}
+	return configs, nil
+}
+
+// OIDCClaims are the claims of a verified ID token the service uses
+type OIDCClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	// Birthdate is YYYY-MM-DD, or 0000-MM-DD when the year is withheld
+	Birthdate       string `json:"birthdate"`
+	Nonce           string `json:"nonce"`
+	AuthorizedParty string `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// OIDCProviders are the configured providers, the discovery document and the
+// keys of each are fetched on first use and cached
+type OIDCProviders struct {
+	providers map[string]*oidcProvider
+	names     []string
+}
+
+// NewOIDCProviders returns the providers of configs, client makes the calls to them
+func NewOIDCProviders(configs []OIDCProviderConfig, client *http.Client) *OIDCProviders {
+	p := &OIDCProviders{providers: map[string]*oidcProvider{}}
+	for _, c := range configs {
+		p.providers[c.Name] = &oidcProvider{config: c, client: client}
+		p.names = append(p.names, c.Name)
+	}
+	return p
+}
+
+// Names lists the providers in the order they were configured
+func (p *OIDCProviders) Names() []string {
+	if p == nil {
+		return []string{}
+	}
+	return slices.Clone(p.names)
+}
+
+func (p *OIDCProviders) get(name string) (*oidcProvider, error) {
+	if p == nil || p.providers[name] == nil {
+		return nil, ErrOIDCProviderNotFound
+	}
+	return p.providers[name], nil
+}
+
+// oidcMetadata is the part of the discovery document the client uses
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcProvider struct {
+	config OIDCProviderConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	metadata      *oidcMetadata
+	keys          map[string]any
+	keysFetchedAt time.Time
+}
+
+// discover returns the discovery document of the issuer, it is fetched once
+// and must name the configured issuer
+func (p *oidcProvider) discover(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var metadata oidcMetadata
+	discoveryURL := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, discoveryURL, &metadata); err != nil {
+		return nil, err
+	}
+	if metadata.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("%w: discovery document of %s is for issuer %q", ErrOIDCProviderUnavailable, p.config.Issuer, metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery document of %s lacks an endpoint", ErrOIDCProviderUnavailable, p.config.Issuer)
+	}
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// authURL is where the user is sent to sign in, the code challenge is the
+// S256 PKCE challenge of the verifier kept for exchange
+func (p *oidcProvider) authURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	endpoint, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid authorization endpoint: %v", ErrOIDCProviderUnavailable, err)
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := endpoint.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	scopes := slices.Clone(oidcDefaultScopes)
+	for _, scope := range p.config.Scopes {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	query.Set("scope", strings.Join(scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	endpoint.RawQuery = query.Encode()
+	return endpoint.String(), nil
+}
+
+// exchange trades an authorization code for the ID token
+func (p *oidcProvider) exchange(ctx context.Context, code, verifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		// client_secret_basic, the default authentication of OpenID Connect clients
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrOIDCProviderUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseSize)).Decode(&token); err != nil {
+		return "", fmt.Errorf("%w: token response: %v", ErrOIDCProviderUnavailable, err)
+	}
+	if token.Error != "" {
+		// an expired or replayed code, the user has to sign in again
+		return "", fmt.Errorf("%w: %s %s", ErrOIDCLoginFailed, token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || token.IDToken == "" {
+		return "", fmt.Errorf("%w: token endpoint answered %d without an ID token", ErrOIDCProviderUnavailable, resp.StatusCode)
+	}
+	return token.IDToken, nil
+}
+
+// verifyIDToken checks the signature, issuer, audience, lifetime and nonce of
+// an ID token
+func (p *oidcProvider) verifyIDToken(ctx context.Context, raw, nonce string) (*OIDCClaims, error) {
+	claims := &OIDCClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "PS256"}),
+		jwt.WithIssuer(p.config.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ID token: %v", ErrOIDCLoginFailed, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: ID token has no subject", ErrOIDCLoginFailed)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID {
+		return nil, fmt.Errorf("%w: ID token was issued to %q", ErrOIDCLoginFailed, claims.AuthorizedParty)
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: ID token nonce does not match", ErrOIDCLoginFailed)
+	}
+	return claims, nil
+}
+
+// key returns the public key with kid from the JWKS of the provider, the set
+// is fetched again when it does not have the key as providers rotate them
+func (p *oidcProvider) key(ctx context.Context, kid string) (any, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < oidcKeysRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	var set struct {
+		Keys []JSONWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, metadata.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	p.keys, p.keysFetchedAt = map[string]any{}, time.Now()
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// keys the client does not support are skipped, tokens signed with them fail
+		if key, err := k.publicKey(); err == nil {
+			p.keys[k.Kid] = key
+		}
+	}
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOIDCProviderUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: GET %s answered %d", ErrOIDCProviderUnavailable, url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseSize)).Decode(v); err != nil {
+		return fmt.Errorf("%w: GET %s: %v", ErrOIDCProviderUnavailable, url, err)
+	}
+	return nil
+}
+
+// JSONWebKey is an RSA or EC public key of a JWKS (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+func (k JSONWebKey) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// oidcState is what the client keeps between sending the user to the provider
+// and the callback. It travels signed in a cookie so the server keeps nothing.
+type oidcState struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	// LinkUserID is set when a logged in user links the provider to their account
+	LinkUserID string `json:"link_user_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func newOIDCState(provider, linkUserID string) (*oidcState, error) {
+	values := make([]string, 3)
+	for i := range values {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("generate oidc state: %w", err)
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return &oidcState{
+		Provider:   provider,
+		State:      values[0],
+		Nonce:      values[1],
+		Verifier:   values[2],
+		LinkUserID: linkUserID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{oidcStateAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(oidcStateTTL)),
+		},
+	}, nil
+}
+
+func (s *oidcState) sign(key []byte) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, s).SignedString(key)
+}
+
+// parseOIDCState verifies the cookie value signed with key of a login started
+// for provider and that the provider sent back its state
+func parseOIDCState(key []byte, cookie, provider, state string) (*oidcState, error) {
+	s := &oidcState{}
+	_, err := jwt.ParseWithClaims(cookie, s, func(*jwt.Token) (any, error) {
+		return key, nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithAudience(oidcStateAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid state cookie: %v", ErrOIDCLoginFailed, err)
+	}
+	if s.Provider != provider || s.State == "" || s.State != state {
+		return nil, fmt.Errorf("%w: state does not match", ErrOIDCLoginFailed)
+	}
+	return s, nil
+}
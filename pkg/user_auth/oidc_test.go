@@ -0,0 +1,185 @@
+package userauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newTestProvider starts an identity provider serving its discovery document
+// and a JWKS with key under kid, the client is registered as "app"
+func newTestProvider(t *testing.T, key *rsa.PrivateKey, kid string) *oidcProvider {
+	t.Helper()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcMetadata{
+			Issuer:                srv.URL,
+			AuthorizationEndpoint: srv.URL + "/authorize",
+			TokenEndpoint:         srv.URL + "/token",
+			JWKSURI:               srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]JSONWebKey{"keys": {{
+			Kty: "RSA", Kid: kid, Use: "sig", Alg: "RS256",
+			N: base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	return &oidcProvider{
+		config: OIDCProviderConfig{Name: "test", Issuer: srv.URL, ClientID: "app", RedirectURL: "http://app/callback"},
+		client: srv.Client(),
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := newTestProvider(t, key, "k1")
+	now := time.Now()
+
+	// claims returns valid claims of the ID token issued for nonce "n1"
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss": provider.config.Issuer, "sub": "user-1", "aud": "app", "nonce": "n1",
+			"iat": now.Unix(), "exp": now.Add(time.Hour).Unix(), "email": "jane@example.com", "email_verified": true,
+		}
+	}
+	sign := func(method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		raw, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	// with changes the valid claims, a nil value removes the claim
+	with := func(changes jwt.MapClaims) jwt.MapClaims {
+		c := claims()
+		for name, value := range changes {
+			if value == nil {
+				delete(c, name)
+			} else {
+				c[name] = value
+			}
+		}
+		return c
+	}
+	twoAudiences := []string{"app", "other"}
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+		ok    bool
+	}{
+		{"valid", sign(jwt.SigningMethodRS256, "k1", key, claims()), "n1", true},
+		{"wrong nonce", sign(jwt.SigningMethodRS256, "k1", key, claims()), "n2", false},
+		{"missing nonce", sign(jwt.SigningMethodRS256, "k1", key, with(jwt.MapClaims{"nonce": nil})), "n1", false},
+		{"wrong audience", sign(jwt.SigningMethodRS256, "k1", key, with(jwt.MapClaims{"aud": "other"})), "n1", false},
+		{"several audiences without azp", sign(jwt.SigningMethodRS256, "k1", key, with(jwt.MapClaims{"aud": twoAudiences})), "n1", false},
+		{"several audiences with azp of the client", sign(jwt.SigningMethodRS256, "k1", key, with(jwt.MapClaims{"aud": twoAudiences, "azp": "app"})), "n1", true},
+		{"several audiences with azp of another client", sign(jwt.SigningMethodRS256, "k1", key, with(jwt.MapClaims{"aud": twoAudiences, "azp": "other"})), "n1", false},
+		{"wrong issuer", sign(jwt.SigningMethodRS256, "k1", key, with(jwt.MapClaims{"iss": "https://evil.example.com"})), "n1", false},
+		{"expired", sign(jwt.SigningMethodRS256, "k1", key, with(jwt.MapClaims{"exp": now.Add(-time.Hour).Unix()})), "n1", false},
+		{"without expiry", sign(jwt.SigningMethodRS256, "k1", key, with(jwt.MapClaims{"exp": nil})), "n1", false},
+		{"without subject", sign(jwt.SigningMethodRS256, "k1", key, with(jwt.MapClaims{"sub": nil})), "n1", false},
+		{"unknown kid", sign(jwt.SigningMethodRS256, "k2", otherKey, claims()), "n1", false},
+		{"known kid signed by another key", sign(jwt.SigningMethodRS256, "k1", otherKey, claims()), "n1", false},
+		{"HMAC with the public key", sign(jwt.SigningMethodHS256, "k1", key.N.Bytes(), claims()), "n1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := provider.verifyIDToken(context.Background(), tt.token, tt.nonce)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("verifyIDToken: %v", err)
+				}
+				if got.Subject != "user-1" || got.Email != "jane@example.com" || !got.EmailVerified {
+					t.Errorf("got %+v, want the claims of user-1", got)
+				}
+				return
+			}
+			if !errors.Is(err, ErrOIDCLoginFailed) {
+				t.Errorf("got %+v, %v, want ErrOIDCLoginFailed", got, err)
+			}
+		})
+	}
+}
+
+func TestParseOIDCState(t *testing.T) {
+	keys := newSigningKeys(testTokenSecret)
+	state, err := newOIDCState("google", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie, err := state.sign(keys.oidcState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := *state
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	expiredCookie, err := expired.sign(keys.oidcState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, state).SignedString([]byte("another secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPurpose, err := state.sign(keys.login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noAudience := *state
+	noAudience.Audience = nil
+	noAudienceCookie, err := noAudience.sign(keys.oidcState)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := parseOIDCState(keys.oidcState, cookie, "google", state.State)
+	if err != nil {
+		t.Fatalf("parseOIDCState: %v", err)
+	}
+	if got.Nonce != state.Nonce || got.Verifier != state.Verifier {
+		t.Errorf("got %+v, want the state that was signed", got)
+	}
+
+	tests := []struct {
+		name, cookie, provider, state string
+	}{
+		{"mismatched state", cookie, "google", "another-state"},
+		{"empty state", cookie, "google", ""},
+		{"another provider", cookie, "github", state.State},
+		{"expired cookie", expiredCookie, "google", state.State},
+		{"forged cookie", forged, "google", state.State},
+		{"cookie signed with the login key", otherPurpose, "google", state.State},
+		{"cookie without audience", noAudienceCookie, "google", state.State},
+		{"no cookie", "", "google", state.State},
+	}
+	for _, tt := range tests {
+		if _, err := parseOIDCState(keys.oidcState, tt.cookie, tt.provider, tt.state); !errors.Is(err, ErrOIDCLoginFailed) {
+			t.Errorf("%s: got %v, want ErrOIDCLoginFailed", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package userauth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// errHashFormat is returned by Verify when the encoded hash belongs to another algorithm
+var errHashFormat = errors.New("unrecognized password hash format")
+
+// PasswordHasher hashes passwords into self describing strings that record
+// the algorithm and its parameters, so they can be verified after the
+// configuration changes and upgraded on the next login
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encoded
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash reports whether encoded was made with another algorithm or
+	// weaker parameters than the hasher currently uses
+	NeedsRehash(encoded string) bool
+}
+
+// hasherChain hashes with current and still verifies hashes made by legacy
+type hasherChain struct {
+	current PasswordHasher
+	legacy  []PasswordHasher
+}
+
+// NewPasswordHasher returns a PasswordHasher that hashes with current and
+// verifies hashes of current or any of legacy, anything not made by current
+// with its parameters needs a rehash
+func NewPasswordHasher(current PasswordHasher, legacy ...PasswordHasher) PasswordHasher {
+	return &hasherChain{current: current, legacy: legacy}
+}
+
+func (h *hasherChain) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+func (h *hasherChain) Verify(password, encoded string) (bool, error) {
+	for _, hasher := range append([]PasswordHasher{h.current}, h.legacy...) {
+		ok, err := hasher.Verify(password, encoded)
+		if errors.Is(err, errHashFormat) {
+			continue
+		}
+		return ok, err
+	}
+	return false, errHashFormat
+}
+
+func (h *hasherChain) NeedsRehash(encoded string) bool {
+	return h.current.NeedsRehash(encoded)
+}
+
+// BcryptMaxPasswordBytes is the longest password bcrypt hashes, in bytes
+const BcryptMaxPasswordBytes = 72
+
+// bcryptPrefix is shared by the $2a$, $2b$ and $2y$ variants
+const bcryptPrefix = "$2"
+
+type bcryptHasher struct {
+	cost int
+}
+
+// NewBcryptHasher returns a bcrypt PasswordHasher, the cost is stored in the hash
+func NewBcryptHasher(cost int) PasswordHasher {
+	return &bcryptHasher{cost: cost}
+}
+
+func (h *bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *bcryptHasher) Verify(password, encoded string) (bool, error) {
+	if !strings.HasPrefix(encoded, bcryptPrefix) {
+		return false, errHashFormat
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *bcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.cost
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+	argon2Prefix     = "$argon2id$"
+)
+
+// Argon2Params are the Argon2id cost parameters, Memory is in KiB
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+type argon2Hasher struct {
+	params Argon2Params
+}
+
+// NewArgon2idHasher returns an Argon2id PasswordHasher producing hashes in
+// the PHC string format: $argon2id$v=19$m=65536,t=3,p=2$salt$key
+func NewArgon2idHasher(params Argon2Params) PasswordHasher {
+	return &argon2Hasher{params: params}
+}
+
+func (h *argon2Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, argon2KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2Prefix, argon2.Version,
+		h.params.Memory, h.params.Iterations, h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *argon2Hasher) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *argon2Hasher) NeedsRehash(encoded string) bool {
+	params, salt, _, err := decodeArgon2(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory < h.params.Memory ||
+		params.Iterations < h.params.Iterations ||
+		params.Parallelism < h.params.Parallelism ||
+		len(salt) < argon2SaltLength
+}
+
+// decodeArgon2 parses a PHC formatted Argon2id hash
+func decodeArgon2(encoded string) (params Argon2Params, salt, key []byte, err error) {
+	if !strings.HasPrefix(encoded, argon2Prefix) {
+		return params, nil, nil, errHashFormat
+	}
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, errors.New("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id parameters: %w", err)
+	}
+	// argon2.IDKey panics without parallelism
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, errors.New("malformed argon2id parameters: m, t and p must be positive")
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id key: %w", err)
+	}
+	// a short key would match too many passwords, an empty one any password
+	if len(key) < argon2KeyLength {
+		return params, nil, nil, fmt.Errorf("argon2id key of %d bytes is shorter than %d", len(key), argon2KeyLength)
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,188 @@
+package userauth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cheap parameters, only the encoding matters to these tests
+var testArgon2Params = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func TestPasswordHasherRoundTrip(t *testing.T) {
+	for name, hasher := range map[string]PasswordHasher{
+		"bcrypt":   NewBcryptHasher(4),
+		"argon2id": NewArgon2idHasher(testArgon2Params),
+	} {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := hasher.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if ok, err := hasher.Verify("correct horse", encoded); !ok || err != nil {
+				t.Errorf("Verify(right password) = %v, %v, want true", ok, err)
+			}
+			if ok, err := hasher.Verify("correct horsf", encoded); ok || err != nil {
+				t.Errorf("Verify(wrong password) = %v, %v, want false without error", ok, err)
+			}
+			if hasher.NeedsRehash(encoded) {
+				t.Error("NeedsRehash of a fresh hash = true")
+			}
+		})
+	}
+}
+
+func TestArgon2RejectsMalformedHashes(t *testing.T) {
+	hasher := NewArgon2idHasher(testArgon2Params)
+	encoded, err := hasher.Hash("secret")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	parts := strings.Split(encoded, "$")
+	// with replaces part i of the valid hash
+	with := func(i int, part string) string {
+		changed := append([]string(nil), parts...)
+		changed[i] = part
+		return strings.Join(changed, "$")
+	}
+
+	for name, malformed := range map[string]string{
+		"empty key":      with(5, ""),
+		"short key":      with(5, parts[5][:20]),
+		"zero memory":    with(3, "m=0,t=1,p=1"),
+		"zero time":      with(3, "m=64,t=0,p=1"),
+		"zero threads":   with(3, "m=64,t=1,p=0"),
+		"other version":  with(2, "v=16"),
+		"bad salt":       with(4, "!!"),
+		"missing fields": strings.Join(parts[:5], "$"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ok, err := hasher.Verify("any password", malformed)
+			if ok || err == nil || errors.Is(err, errHashFormat) {
+				t.Errorf("Verify(%q) = %v, %v, want a malformed hash error", malformed, ok, err)
+			}
+			if !hasher.NeedsRehash(malformed) {
+				t.Errorf("NeedsRehash(%q) = false", malformed)
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	hash := func(t *testing.T, hasher PasswordHasher) string {
+		t.Helper()
+		encoded, err := hasher.Hash("secret")
+		if err != nil {
+			t.Fatalf("Hash: %v", err)
+		}
+		return encoded
+	}
+	bcryptHash := hash(t, NewBcryptHasher(4))
+	argon2Hash := hash(t, NewArgon2idHasher(testArgon2Params))
+
+	tests := []struct {
+		name    string
+		current PasswordHasher
+		encoded string
+		want    bool
+	}{
+		{"same bcrypt cost", NewBcryptHasher(4), bcryptHash, false},
+		{"higher bcrypt cost", NewBcryptHasher(5), bcryptHash, true},
+		{"lower bcrypt cost", NewBcryptHasher(4), hash(t, NewBcryptHasher(5)), false},
+		{"same argon2 parameters", NewArgon2idHasher(testArgon2Params), argon2Hash, false},
+		{"more memory", NewArgon2idHasher(Argon2Params{Memory: 128, Iterations: 1, Parallelism: 1}), argon2Hash, true},
+		{"more iterations", NewArgon2idHasher(Argon2Params{Memory: 64, Iterations: 2, Parallelism: 1}), argon2Hash, true},
+		{"more parallelism", NewArgon2idHasher(Argon2Params{Memory: 64, Iterations: 1, Parallelism: 2}), argon2Hash, true},
+		{"bcrypt hash under argon2", NewPasswordHasher(NewArgon2idHasher(testArgon2Params), NewBcryptHasher(4)), bcryptHash, true},
+		{"argon2 hash under bcrypt", NewPasswordHasher(NewBcryptHasher(4), NewArgon2idHasher(testArgon2Params)), argon2Hash, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.current.NeedsRehash(tt.encoded); got != tt.want {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasherChainVerifiesLegacyHashes(t *testing.T) {
+	legacy := NewBcryptHasher(4)
+	chain := NewPasswordHasher(NewArgon2idHasher(testArgon2Params), legacy)
+	legacyHash, err := legacy.Hash("secret")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	if ok, err := chain.Verify("secret", legacyHash); !ok || err != nil {
+		t.Errorf("Verify(legacy hash) = %v, %v, want true", ok, err)
+	}
+	if ok, err := chain.Verify("wrong", legacyHash); ok || err != nil {
+		t.Errorf("Verify(legacy hash, wrong password) = %v, %v, want false", ok, err)
+	}
+	if _, err := chain.Verify("secret", "plaintext"); !errors.Is(err, errHashFormat) {
+		t.Errorf("Verify(unknown format) error = %v, want %v", err, errHashFormat)
+	}
+
+	encoded, err := chain.Hash("secret")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !strings.HasPrefix(encoded, argon2Prefix) {
+		t.Errorf("Hash = %q, want the current argon2id hasher", encoded)
+	}
+}
+
+func TestLoginRehashesPassword(t *testing.T) {
+	ctx := context.Background()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repo := NewMemoryRepository(log)
+	legacy := NewBcryptHasher(4)
+	hasher := NewPasswordHasher(NewArgon2idHasher(testArgon2Params), legacy)
+	svc := NewService(repo, &PasswordPolicy{}, hasher, testTokenSecret, nil, nil,
+		OAuthServerConfig{Issuer: "http://auth.example.com/oauth", KeyRotation: time.Hour}, MagicLinkConfig{}, NewLogMailer(log), log)
+
+	legacyHash, err := legacy.Hash("secret")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if _, err := repo.UserRegister(ctx, UserRegisterRequest{FirstName: "Jane", Email: "jane@example.com", Password: legacyHash, Gender: "female"}); err != nil {
+		t.Fatalf("UserRegister: %v", err)
+	}
+	stored := func() string {
+		t.Helper()
+		user, err := repo.FindUserByEmail(ctx, "jane@example.com")
+		if err != nil {
+			t.Fatalf("FindUserByEmail: %v", err)
+		}
+		return user.Password
+	}
+
+	if _, err := svc.GetUserProfile(ctx, UserLoginRequest{Email: "jane@example.com", Password: "wrong"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("login with a wrong password: %v, want %v", err, ErrInvalidCredentials)
+	}
+	if stored() != legacyHash {
+		t.Fatal("a failed login replaced the stored hash")
+	}
+
+	if _, err := svc.GetUserProfile(ctx, UserLoginRequest{Email: "jane@example.com", Password: "secret"}); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	upgraded := stored()
+	if !strings.HasPrefix(upgraded, argon2Prefix) {
+		t.Fatalf("stored hash after login = %q, want an argon2id hash", upgraded)
+	}
+	if ok, err := hasher.Verify("secret", upgraded); !ok || err != nil {
+		t.Fatalf("Verify(upgraded hash) = %v, %v", ok, err)
+	}
+
+	if _, err := svc.GetUserProfile(ctx, UserLoginRequest{Email: "jane@example.com", Password: "secret"}); err != nil {
+		t.Fatalf("second login: %v", err)
+	}
+	if stored() != upgraded {
+		t.Error("a login with a current hash rehashed it again")
+	}
+}
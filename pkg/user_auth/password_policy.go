@@ -0,0 +1,211 @@
+package userauth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Password rules, they double as the translation keys of the messages
+const (
+	rulePasswordMinLength = "password_min_length"
+	rulePasswordMaxLength = "password_max_length"
+	rulePasswordMaxBytes  = "password_max_bytes"
+	rulePasswordUpper     = "password_upper"
+	rulePasswordLower     = "password_lower"
+	rulePasswordDigit     = "password_digit"
+	rulePasswordSpecial   = "password_special"
+	rulePasswordPersonal  = "password_personal"
+	rulePasswordBreached  = "password_breached"
+)
+
+// minPersonalLength is the shortest name or email part a password may not contain,
+// shorter parts would reject too many unrelated passwords
+const minPersonalLength = 3
+
+// PasswordPolicy is checked every time a password is set, on register and on change
+type PasswordPolicy struct {
+	MinLength      int
+	MaxLength      int
+	RequireUpper   bool
+	RequireLower   bool
+	RequireDigit   bool
+	RequireSpecial bool
+	// DisallowPersonal rejects passwords containing the user's email or name
+	DisallowPersonal bool
+	// MaxBytes is the most bytes the hasher takes, 0 means no limit. Letters
+	// outside ASCII take several bytes so it can be hit below MaxLength.
+	MaxBytes int
+	// Breached is optional, nil skips the breached password check
+	Breached BreachedPasswords
+}
+
+// PasswordPolicyError lists every rule the password failed, Param is the rule's limit if any
+type PasswordPolicyError struct {
+	Violations []FieldViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	rules := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		rules[i] = v.Rule
+	}
+	return "password violates the password policy: " + strings.Join(rules, ", ")
+}
+
+func (e *PasswordPolicyError) Is(target error) bool { return target == ErrValidation }
+
+func (e *PasswordPolicyError) fieldViolations() []FieldViolation { return e.Violations }
+
+// Check returns a PasswordPolicyError for field when password breaks the policy.
+// personal holds the email and names of the user the password is for.
+func (p *PasswordPolicy) Check(ctx context.Context, field, password string, personal ...string) error {
+	var violations []FieldViolation
+	add := func(rule string, param int) {
+		v := FieldViolation{Field: field, Rule: rule}
+		if param > 0 {
+			v.Param = strconv.Itoa(param)
+		}
+		violations = append(violations, v)
+	}
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		add(rulePasswordMinLength, p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		add(rulePasswordMaxLength, p.MaxLength)
+	} else if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		add(rulePasswordMaxBytes, p.MaxBytes)
+	}
+
+	var upper, lower, digit, special bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			special = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		add(rulePasswordUpper, 0)
+	}
+	if p.RequireLower && !lower {
+		add(rulePasswordLower, 0)
+	}
+	if p.RequireDigit && !digit {
+		add(rulePasswordDigit, 0)
+	}
+	if p.RequireSpecial && !special {
+		add(rulePasswordSpecial, 0)
+	}
+
+	if p.DisallowPersonal && containsPersonal(password, personal) {
+		add(rulePasswordPersonal, 0)
+	}
+
+	if p.Breached != nil {
+		breached, err := isBreached(ctx, p.Breached, password)
+		if err != nil {
+			return fmt.Errorf("breached password check: %w", err)
+		}
+		if breached {
+			add(rulePasswordBreached, 0)
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return &PasswordPolicyError{Violations: violations}
+}
+
+// containsPersonal reports whether password contains one of the values, or the
+// local part of an email among them, ignoring case
+func containsPersonal(password string, personal []string) bool {
+	password = strings.ToLower(password)
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if local, _, found := strings.Cut(value, "@"); found {
+			value = local
+		}
+		if len([]rune(value)) >= minPersonalLength && strings.Contains(password, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// BreachedPasswords looks up leaked passwords by k-anonymity: only the first
+// five hex characters of the password's SHA-1 are handed over and the
+// suffixes of every leaked hash sharing them come back, the same contract as
+// the Pwned Passwords range API
+type BreachedPasswords interface {
+	Range(ctx context.Context, prefix string) ([]string, error)
+}
+
+const hashPrefixLength = 5
+
+func isBreached(ctx context.Context, breached BreachedPasswords, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := breached.Range(ctx, hash[:hashPrefixLength])
+	if err != nil {
+		return false, err
+	}
+	for _, suffix := range suffixes {
+		if strings.EqualFold(suffix, hash[hashPrefixLength:]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// hashList is a BreachedPasswords loaded in memory, indexed by hash prefix
+type hashList map[string][]string
+
+// LoadBreachedPasswords reads a list of SHA-1 password hashes, one per line in
+// the Pwned Passwords download format "HASH" or "HASH:COUNT"
+func LoadBreachedPasswords(path string) (BreachedPasswords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := hashList{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		hash, _, _ := strings.Cut(text, ":")
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("%s:%d: not a SHA-1 hash", path, line)
+		}
+		hash = strings.ToUpper(hash)
+		prefix := hash[:hashPrefixLength]
+		list[prefix] = append(list[prefix], hash[hashPrefixLength:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (l hashList) Range(ctx context.Context, prefix string) ([]string, error) {
+	return l[strings.ToUpper(prefix)], nil
+}
@@ -0,0 +1,45 @@
+package userauth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyMaxBytes(t *testing.T) {
+	policy := &PasswordPolicy{MinLength: 8, MaxLength: 100, MaxBytes: BcryptMaxPasswordBytes}
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{"ascii at the byte limit", strings.Repeat("a", 72), nil},
+		{"ascii over the byte limit", strings.Repeat("a", 73), []string{rulePasswordMaxBytes}},
+		{"umlauts over the byte limit", strings.Repeat("ä", 40), []string{rulePasswordMaxBytes}},
+		{"over both limits", strings.Repeat("a", 101), []string{rulePasswordMaxLength}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(context.Background(), "password", tt.password)
+			var rules []string
+			var policyErr *PasswordPolicyError
+			if errors.As(err, &policyErr) {
+				for _, v := range policyErr.Violations {
+					rules = append(rules, v.Rule)
+				}
+			} else if err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+			if !slices.Equal(rules, tt.want) {
+				t.Errorf("got violations %v, want %v", rules, tt.want)
+			}
+			if err == nil {
+				if _, err := NewBcryptHasher(4).Hash(tt.password); err != nil {
+					t.Errorf("bcrypt rejected a password the policy accepted: %v", err)
+				}
+			}
+		})
+	}
+}
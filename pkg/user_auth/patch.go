@@ -0,0 +1,400 @@
+package userauth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+	// maxPatchBytes bounds a patch document, a profile is a few hundred bytes
+	maxPatchBytes = 64 << 10
+)
+
+// patchFunc applies a patch document to the JSON document doc
+type patchFunc func(doc []byte) ([]byte, error)
+
+// newPatch picks how body patches a document from its media type, plain JSON
+// is a merge patch so requests sent before patch media types were supported
+// keep working
+func newPatch(contentType string, body []byte) (patchFunc, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, ErrUnsupportedPatch
+	}
+
+	switch mediaType {
+	case mergePatchContentType, "application/json":
+		var patch any
+		if err := decodeJSON(body, &patch); err != nil {
+			return nil, ErrInvalidPatch
+		}
+		return func(doc []byte) ([]byte, error) { return applyMergePatch(doc, patch) }, nil
+	case jsonPatchContentType:
+		var ops []patchOperation
+		if err := decodeJSON(body, &ops); err != nil {
+			return nil, ErrInvalidPatch
+		}
+		return func(doc []byte) ([]byte, error) { return applyJSONPatch(doc, ops) }, nil
+	}
+	return nil, ErrUnsupportedPatch
+}
+
+// patchUserInformation applies patch to the profile document of user and
+// returns the resulting update request, based on the version user was read at
+func patchUserInformation(user User, patch patchFunc) (UserInformationRequest, error) {
+	doc, err := json.Marshal(UserInformationRequest{
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		DOB:       Date(user.DOB),
+		Gender:    user.Gender,
+		// kept so patching other fields leaves the description as it is
+		GenderDescription: user.GenderDescription,
+		Address:           user.Address,
+	})
+	if err != nil {
+		return UserInformationRequest{}, err
+	}
+
+	patched, err := patch(doc)
+	if err != nil {
+		return UserInformationRequest{}, err
+	}
+
+	// the patched document starts empty so a removed member ends up cleared
+	var req UserInformationRequest
+	if err := json.Unmarshal(patched, &req); err != nil {
+		return UserInformationRequest{}, newError(ErrBadRequest, "invalid_profile", "patched profile is not a valid profile document")
+	}
+	req.ID = user.ID
+	req.Version = user.Version
+	return req, nil
+}
+
+// applyMergePatch applies an RFC 7386 JSON merge patch: members of the patch
+// replace those of doc, null removes them and objects are merged recursively
+func applyMergePatch(doc []byte, patch any) ([]byte, error) {
+	var target any
+	if err := decodeJSON(doc, &target); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergePatch(target, patch))
+}
+
+func mergePatch(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], value)
+	}
+	return targetObj
+}
+
+// patchOperation is one operation of an RFC 6902 JSON patch, Value is nil
+// when the member is absent and the JSON null literal when it is null
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// applyJSONPatch applies the operations in order, the first failing one
+// aborts the patch. Malformed operations are an ErrInvalidPatch, operations
+// that don't fit doc, including a failed test, are an ErrPatchConflict.
+func applyJSONPatch(doc []byte, ops []patchOperation) ([]byte, error) {
+	var target any
+	if err := decodeJSON(doc, &target); err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		var err error
+		if target, err = op.apply(target); err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(target)
+}
+
+func (op patchOperation) apply(doc any) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, ErrInvalidPatch
+		}
+		var value any
+		if err := decodeJSON(op.Value, &value); err != nil {
+			return nil, ErrInvalidPatch
+		}
+		switch op.Op {
+		case "add":
+			return addValue(doc, path, value)
+		case "replace":
+			// the empty path replaces the whole document
+			if len(path) == 0 {
+				return value, nil
+			}
+			if _, err := getValue(doc, path); err != nil {
+				return nil, err
+			}
+			if doc, _, err = removeValue(doc, path); err != nil {
+				return nil, err
+			}
+			return addValue(doc, path, value)
+		default:
+			current, err := getValue(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			if !equalJSON(current, value) {
+				return nil, ErrPatchConflict
+			}
+			return doc, nil
+		}
+	case "remove":
+		doc, _, err = removeValue(doc, path)
+		return doc, err
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		var value any
+		if op.Op == "move" {
+			// a value can't be moved into one of its own children
+			if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				return nil, ErrInvalidPatch
+			}
+			if doc, value, err = removeValue(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			if value, err = getValue(doc, from); err != nil {
+				return nil, err
+			}
+			if value, err = cloneJSON(value); err != nil {
+				return nil, err
+			}
+		}
+		return addValue(doc, path, value)
+	}
+	return nil, ErrInvalidPatch
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into its unescaped reference
+// tokens, the empty pointer is the whole document
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, ErrInvalidPatch
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+func getValue(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, ErrPatchConflict
+			}
+			doc = value
+		case []any:
+			i, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, ErrPatchConflict
+		}
+	}
+	return doc, nil
+}
+
+// addValue sets the member or inserts the array element at path, its parent must exist
+func addValue(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return updateParent(doc, path, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			node[token] = value
+			return node, nil
+		case []any:
+			i := len(node)
+			if token != "-" {
+				var err error
+				if i, err = arrayIndex(token, len(node)); err != nil {
+					return nil, err
+				}
+			}
+			return append(node[:i], append([]any{value}, node[i:]...)...), nil
+		}
+		return nil, ErrPatchConflict
+	})
+}
+
+// removeValue deletes the member or array element at path and returns it
+func removeValue(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, ErrInvalidPatch
+	}
+
+	var removed any
+	doc, err := updateParent(doc, path, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, ErrPatchConflict
+			}
+			removed = value
+			delete(node, token)
+			return node, nil
+		case []any:
+			i, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			removed = node[i]
+			return append(node[:i:i], node[i+1:]...), nil
+		}
+		return nil, ErrPatchConflict
+	})
+	return doc, removed, err
+}
+
+// updateParent replaces the parent of the last token of path by what update
+// returns, arrays change length so every container on the way is rebuilt
+func updateParent(doc any, path []string, update func(parent any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return update(doc, path[0])
+	}
+
+	child, err := getValue(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err = updateParent(child, path[1:], update)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node := doc.(type) {
+	case map[string]any:
+		node[path[0]] = child
+	case []any:
+		i, _ := arrayIndex(path[0], len(node)-1)
+		node[i] = child
+	}
+	return doc, nil
+}
+
+// arrayIndex parses an array index token, it must be between 0 and max
+func arrayIndex(token string, max int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, ErrInvalidPatch
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, ErrInvalidPatch
+	}
+	if i > max {
+		return 0, ErrPatchConflict
+	}
+	return i, nil
+}
+
+// equalJSON compares decoded JSON values, numbers by their value
+func equalJSON(a, b any) bool {
+	an, aok := a.(json.Number)
+	bn, bok := b.(json.Number)
+	if aok && bok {
+		af, aerr := an.Float64()
+		bf, berr := bn.Float64()
+		return aerr == nil && berr == nil && af == bf
+	}
+
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for key, value := range av {
+			other, ok := bv[key]
+			if !ok || !equalJSON(value, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		bv, ok := b.([]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equalJSON(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func cloneJSON(value any) (any, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var clone any
+	err = decodeJSON(b, &clone)
+	return clone, err
+}
+
+// decodeJSON decodes a single JSON value keeping numbers exact
+func decodeJSON(b []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("unexpected data after the JSON value")
+	}
+	return nil
+}
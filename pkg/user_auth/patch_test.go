@@ -0,0 +1,139 @@
+package userauth
+
+import (
+	"errors"
+	"testing"
+)
+
+// mustDecode decodes a JSON test value the way the patch functions do
+func mustDecode(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := decodeJSON([]byte(s), &v); err != nil {
+		t.Fatalf("decode %s: %v", s, err)
+	}
+	return v
+}
+
+// the examples of RFC 7386 Appendix A
+func TestMergePatch(t *testing.T) {
+	tests := []struct{ target, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		got, err := applyMergePatch([]byte(tt.target), mustDecode(t, tt.patch))
+		if err != nil {
+			t.Errorf("%s + %s: %v", tt.target, tt.patch, err)
+			continue
+		}
+		if !equalJSON(mustDecode(t, string(got)), mustDecode(t, tt.want)) {
+			t.Errorf("%s + %s = %s, want %s", tt.target, tt.patch, got, tt.want)
+		}
+	}
+}
+
+// the examples of RFC 6902 Appendix A, then the cases they leave out
+func TestJSONPatch(t *testing.T) {
+	tests := []struct {
+		name, doc, patch, want string
+		wantErr                error
+	}{
+		{"A.1 add an object member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`, nil},
+		{"A.2 add an array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`, nil},
+		{"A.3 remove an object member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`, nil},
+		{"A.4 remove an array element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`, nil},
+		{"A.5 replace a value", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`, nil},
+		{"A.6 move a value", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`, nil},
+		{"A.7 move an array element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`, nil},
+		{"A.8 test a value, success", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`, nil},
+		{"A.9 test a value, error", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, "", ErrPatchConflict},
+		{"A.10 add a nested member object", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`, nil},
+		{"A.11 ignore unrecognized elements", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`, nil},
+		{"A.12 add to a nonexistent target", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, "", ErrPatchConflict},
+		// the RFC wants an error for the duplicate op, the last one wins here and fails
+		{"A.13 invalid JSON patch document", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","op":"remove"}]`, "", ErrPatchConflict},
+		{"A.14 ~ escape ordering", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`, nil},
+		{"A.15 compare strings and numbers", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, "", ErrPatchConflict},
+		{"A.16 add an array value", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`, nil},
+
+		{"replace the whole document", `{"foo":"bar"}`, `[{"op":"replace","path":"","value":{"baz":1}}]`, `{"baz":1}`, nil},
+		{"add the whole document", `{"foo":"bar"}`, `[{"op":"add","path":"","value":[1]}]`, `[1]`, nil},
+		{"remove the whole document", `{"foo":"bar"}`, `[{"op":"remove","path":""}]`, "", ErrInvalidPatch},
+		{"replace a missing member", `{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":1}]`, "", ErrPatchConflict},
+		{"copy a value", `{"foo":{"a":1}}`, `[{"op":"copy","from":"/foo","path":"/bar"},{"op":"add","path":"/bar/b","value":2}]`, `{"foo":{"a":1},"bar":{"a":1,"b":2}}`, nil},
+		{"move into a child", `{"foo":{"a":1}}`, `[{"op":"move","from":"/foo","path":"/foo/a"}]`, "", ErrInvalidPatch},
+		{"test numbers by value", `{"n":1}`, `[{"op":"test","path":"/n","value":1.0}]`, `{"n":1}`, nil},
+		{"index with a leading zero", `{"foo":["a","b"]}`, `[{"op":"remove","path":"/foo/01"}]`, "", ErrInvalidPatch},
+		{"index past the end", `{"foo":["a","b"]}`, `[{"op":"add","path":"/foo/3","value":"c"}]`, "", ErrPatchConflict},
+		{"pointer without a leading slash", `{"foo":"bar"}`, `[{"op":"remove","path":"foo"}]`, "", ErrInvalidPatch},
+		{"value missing", `{"foo":"bar"}`, `[{"op":"add","path":"/baz"}]`, "", ErrInvalidPatch},
+		{"unknown op", `{"foo":"bar"}`, `[{"op":"merge","path":"/foo","value":1}]`, "", ErrInvalidPatch},
+		{"failed op aborts the patch", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":1},{"op":"test","path":"/foo","value":"x"}]`, "", ErrPatchConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ops []patchOperation
+			if err := decodeJSON([]byte(tt.patch), &ops); err != nil {
+				t.Fatalf("decode the patch: %v", err)
+			}
+			got, err := applyJSONPatch([]byte(tt.doc), ops)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %s, %v, want %v", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyJSONPatch: %v", err)
+			}
+			if !equalJSON(mustDecode(t, string(got)), mustDecode(t, tt.want)) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		pointer string
+		want    []string
+	}{
+		{"", nil},
+		{"/", []string{""}},
+		{"/foo/0", []string{"foo", "0"}},
+		{"/a~1b/m~0n", []string{"a/b", "m~n"}},
+		{"/~01", []string{"~1"}},
+	}
+	for _, tt := range tests {
+		got, err := parsePointer(tt.pointer)
+		if err != nil {
+			t.Errorf("parsePointer(%q): %v", tt.pointer, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parsePointer(%q) = %q, want %q", tt.pointer, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parsePointer(%q) = %q, want %q", tt.pointer, got, tt.want)
+			}
+		}
+	}
+}
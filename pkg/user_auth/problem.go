@@ -0,0 +1,112 @@
+package userauth
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/abhiii71/clean-code-abhi/pkg/logger"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	problemContentType = "application/problem+json"
+	// problemTypeBase prefixes the code to build the problem type URI
+	problemTypeBase = "/problems/"
+)
+
+// Problem is an RFC 7807 error response body
+type Problem struct {
+	Type      string       `json:"type" example:"/problems/user_not_found"`
+	Title     string       `json:"title" example:"Not Found"`
+	Status    int          `json:"status" example:"404"`
+	Detail    string       `json:"detail,omitempty" example:"user not found"`
+	Instance  string       `json:"instance,omitempty" example:"/applicant/external/v1/profile"`
+	Code      string       `json:"code" example:"user_not_found"`
+	RequestID string       `json:"request_id,omitempty" example:"4bf92f3577b34da6a3ce929d0e0e4736"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is a single invalid field of a rejected request
+type FieldError struct {
+	Field   string `json:"field" example:"email"`
+	Message string `json:"message" example:"invalid email format"`
+}
+
+// errorKinds maps error kinds to their HTTP status and the code used when the
+// error carries no more specific one, the first match wins
+var errorKinds = []struct {
+	kind   error
+	status int
+	code   string
+}{
+	{ErrValidation, http.StatusUnprocessableEntity, "validation_failed"},
+	{ErrBadRequest, http.StatusBadRequest, "bad_request"},
+	{ErrNotFound, http.StatusNotFound, "not_found"},
+	{ErrConflict, http.StatusConflict, "conflict"},
+	{ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+	{ErrPreconditionFailed, http.StatusPreconditionFailed, "precondition_failed"},
+	{ErrPreconditionRequired, http.StatusPreconditionRequired, "precondition_required"},
+	{ErrUnsupportedMediaType, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+	{ErrPayloadTooLarge, http.StatusRequestEntityTooLarge, "payload_too_large"},
+	{ErrBadGateway, http.StatusBadGateway, "bad_gateway"},
+}
+
+// problemFromError builds the problem for err, unknown errors become an
+// opaque 500 and ok is false so the caller can log them
+func problemFromError(c *gin.Context, err error) (p Problem, ok bool) {
+	for _, e := range errorKinds {
+		if !errors.Is(err, e.kind) {
+			continue
+		}
+
+		code, detail := e.code, e.kind.Error()
+		var domainErr *domainError
+		if errors.As(err, &domainErr) {
+			code, detail = domainErr.code, domainErr.msg
+		}
+		p = newProblem(c, e.status, code, detail)
+
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			p.Errors = fieldErrors(validationErr)
+		}
+		return p, true
+	}
+	return newProblem(c, http.StatusInternalServerError, "internal_error", "internal server error"), false
+}
+
+func newProblem(c *gin.Context, status int, code, detail string) Problem {
+	return Problem{
+		Type:      problemTypeBase + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  c.Request.URL.Path,
+		Code:      code,
+		RequestID: logger.RequestIDFromContext(c.Request.Context()),
+	}
+}
+
+// writeProblem sends p as application/problem+json and stops the chain
+func writeProblem(c *gin.Context, p Problem) {
+	c.Header("Content-Type", problemContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
+
+// fieldErrors flattens the validation messages in a stable order
+func fieldErrors(e *ValidationError) []FieldError {
+	fields := make([]string, 0, len(e.Fields))
+	for field := range e.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var out []FieldError
+	for _, field := range fields {
+		for _, msg := range e.Fields[field] {
+			out = append(out, FieldError{Field: field, Message: msg})
+		}
+	}
+	return out
+}
@@ -2,43 +2,219 @@ package userauth
 
 import (
 	"context"
-	"database/sql"
+	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 type Repository interface {
 	UserRegister(ctx context.Context, request UserRegisterRequest) (string, error)
 	GetUserProfile(ctx context.Context, email string) (*User, error)
 	FindUserByEmail(ctx context.Context, email string) (*User, error)
-	FindUserByID(ctx context.Context, userID string) (*User, []byte, []byte, error)
-	// (User, error)
-	// InsertUserInformation(ctx context.Context, request UserInformationRequest) error
-	UpdateUserInfo(ctx context.Context, request UserInformationRequest, addressJSON, vehicleJSON []byte) error
+	// FindUserByID returns the user with its primary Address and the Version
+	// from user_information, zero values when it has none yet
+	FindUserByID(ctx context.Context, userID string) (*User, error)
+	// FindUserByIDForUpdate is FindUserByID that also locks the user row until the
+	// surrounding transaction ends, it must be called from within WithTx
+	FindUserByIDForUpdate(ctx context.Context, userID string) (*User, error)
+	// UpdateUserInfo bumps the profile version of request.ID and returns it.
+	// ErrVersionMismatch is returned when the stored version is not request.Version.
+	UpdateUserInfo(ctx context.Context, request UserInformationRequest) (int, error)
+	// UpdatePassword stores a new password hash, ErrUserNotFound when the user does not exist
+	UpdatePassword(ctx context.Context, userID string, passwordHash string) error
+
+	// ListVehicles returns the vehicles of a user ordered by id
+	ListVehicles(ctx context.Context, userID string) ([]UserVehicle, error)
+	// FindVehicle returns ErrVehicleNotFound unless the vehicle belongs to the user
+	FindVehicle(ctx context.Context, userID string, vehicleID int) (*UserVehicle, error)
+	// CreateVehicle inserts vehicle for vehicle.UserID and returns the stored row
+	CreateVehicle(ctx context.Context, vehicle UserVehicle) (*UserVehicle, error)
+	// UpdateVehicle replaces the vehicle with vehicle.ID owned by vehicle.UserID
+	UpdateVehicle(ctx context.Context, vehicle UserVehicle) (*UserVehicle, error)
+	DeleteVehicle(ctx context.Context, userID string, vehicleID int) error
+
+	// ListAddresses returns the addresses of a user ordered by id
+	ListAddresses(ctx context.Context, userID string) ([]UserAddress, error)
+	// FindAddress returns ErrAddressNotFound unless the address belongs to the user
+	FindAddress(ctx context.Context, userID string, addressID int) (*UserAddress, error)
+	// CreateAddress inserts address for address.UserID, a second primary
+	// address of the same user is rejected so ClearPrimaryAddress comes first
+	CreateAddress(ctx context.Context, address UserAddress) (*UserAddress, error)
+	// UpdateAddress replaces the address with address.ID owned by address.UserID
+	UpdateAddress(ctx context.Context, address UserAddress) (*UserAddress, error)
+	DeleteAddress(ctx context.Context, userID string, addressID int) error
+	// ClearPrimaryAddress demotes the primary address of the user if there is one
+	ClearPrimaryAddress(ctx context.Context, userID string) error
+
+	// UpdateUserIdentity stores the first name, last name, dob and gender of user.ID
+	UpdateUserIdentity(ctx context.Context, user User) error
+	// CreateIdentityChange records a change of an identity field, a second
+	// pending change of the same field is rejected with ErrIdentityChangePending
+	CreateIdentityChange(ctx context.Context, change IdentityChange) (*IdentityChange, error)
+	// ListIdentityChanges returns the changes matching filter ordered by id
+	ListIdentityChanges(ctx context.Context, filter IdentityChangeFilter) ([]IdentityChange, error)
+	FindIdentityChange(ctx context.Context, changeID int) (*IdentityChange, error)
+	// DecideIdentityChange moves a pending change to status, ErrIdentityChangeDecided
+	// is returned when the change is not pending anymore
+	DecideIdentityChange(ctx context.Context, changeID int, status IdentityChangeStatus, decidedBy string) (*IdentityChange, error)
+
+	// CreateProfileHistory records entry, a user has one entry per version
+	CreateProfileHistory(ctx context.Context, entry ProfileHistoryEntry) (*ProfileHistoryEntry, error)
+	// ListProfileHistory returns the history of a user, newest version first
+	ListProfileHistory(ctx context.Context, userID int) ([]ProfileHistoryEntry, error)
+	// FindProfileHistory returns ErrProfileVersionNotFound when the user has no entry for version
+	FindProfileHistory(ctx context.Context, userID, version int) (*ProfileHistoryEntry, error)
+
+	// LastAuditEvent returns the newest audit event, nil when the log is empty.
+	// In a transaction it holds off other appends until the transaction ends.
+	LastAuditEvent(ctx context.Context) (*AuditEvent, error)
+	// CreateAuditEvent appends event, the log is append-only and a second event
+	// linking to the same PrevHash is rejected
+	CreateAuditEvent(ctx context.Context, event AuditEvent) (*AuditEvent, error)
+	// ListAuditEvents returns the events matching filter ordered by id
+	ListAuditEvents(ctx context.Context, filter AuditEventFilter) ([]AuditEvent, error)
+
+	// CreateSession stores a login of session.UserID
+	CreateSession(ctx context.Context, session Session) (*Session, error)
+	// FindSession returns ErrSessionNotFound when there is no session with the ID
+	FindSession(ctx context.Context, sessionID string) (*Session, error)
+	// ListSessions returns every session of a user including the revoked and
+	// expired ones, newest first
+	ListSessions(ctx context.Context, userID int) ([]Session, error)
+	// RevokeSession marks a session of the user as revoked, ErrSessionNotFound
+	// is returned when the user has no such session or it is already revoked
+	RevokeSession(ctx context.Context, userID int, sessionID string) (*Session, error)
+
+	// CreateIdentity links identity.UserID to an account at a provider,
+	// ErrIdentityLinked is returned when the account belongs to a user already
+	// or the user has linked another account of the provider
+	CreateIdentity(ctx context.Context, identity UserIdentity) (*UserIdentity, error)
+	// FindIdentity returns ErrIdentityNotFound when no user linked the account
+	FindIdentity(ctx context.Context, provider, subject string) (*UserIdentity, error)
+	// ListIdentities returns the linked accounts of a user ordered by id
+	ListIdentities(ctx context.Context, userID int) ([]UserIdentity, error)
+	DeleteIdentity(ctx context.Context, userID int, provider string) error
 
-	// FindByUUID(ctx context.Context, request UserInformationRequest) (UserInformationRequest, error)
+	CreateOAuthClient(ctx context.Context, client OAuthClient) (*OAuthClient, error)
+	// FindOAuthClient returns ErrOAuthClientNotFound when there is no client with the ID
+	FindOAuthClient(ctx context.Context, clientID string) (*OAuthClient, error)
+	// ListOAuthClients returns every client ordered by creation
+	ListOAuthClients(ctx context.Context) ([]OAuthClient, error)
+	// DeleteOAuthClient removes a client with its consents and authorization codes
+	DeleteOAuthClient(ctx context.Context, clientID string) error
+	// SaveOAuthConsent creates or replaces the consent of a user to a client
+	SaveOAuthConsent(ctx context.Context, consent OAuthConsent) error
+	// FindOAuthConsent returns ErrOAuthConsentNotFound when the user never consented
+	FindOAuthConsent(ctx context.Context, userID int, clientID string) (*OAuthConsent, error)
+	CreateAuthorizationCode(ctx context.Context, code OAuthAuthorizationCode) error
+	// ConsumeAuthorizationCode marks a code as used and returns it, a code is
+	// only returned once, ErrAuthorizationCodeNotFound is returned after that
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*OAuthAuthorizationCode, error)
+	CreateSigningKey(ctx context.Context, key OAuthSigningKey) error
+	// ListSigningKeys returns the keys created after since, newest first
+	ListSigningKeys(ctx context.Context, since time.Time) ([]OAuthSigningKey, error)
+
+	CreateAPIKey(ctx context.Context, key APIKey) (*APIKey, error)
+	// FindAPIKey returns ErrAPIKeyNotFound when there is no key with the ID
+	FindAPIKey(ctx context.Context, keyID string) (*APIKey, error)
+	// ListAPIKeys returns the keys of a user including the expired ones, newest first
+	ListAPIKeys(ctx context.Context, userID int) ([]APIKey, error)
+	// DeleteAPIKey returns ErrAPIKeyNotFound when the user has no such key
+	DeleteAPIKey(ctx context.Context, userID int, keyID string) error
+	// TouchAPIKey records that a key was used at usedAt
+	TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) error
+
+	CreateMagicLink(ctx context.Context, link MagicLink) error
+	// CountMagicLinks counts the links of a user created after since
+	CountMagicLinks(ctx context.Context, userID int, since time.Time) (int, error)
+	// ConsumeMagicLink marks a link as used and returns it, a link is only
+	// returned once, ErrMagicLinkNotFound is returned after that
+	ConsumeMagicLink(ctx context.Context, linkID string) (*MagicLink, error)
+
+	WithTx(ctx context.Context, fn func(tx Repository) error) error
+}
+
+// Postgres error codes mapped to domain errors
+const (
+	pgUniqueViolation     = "23505"
+	pgForeignKeyViolation = "23503"
+)
+
+// DBTX is the subset of *pgxpool.Pool and pgx.Tx used by the repository, so
+// the same queries run either directly on the pool or inside a transaction
+type DBTX interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
 type repository struct {
-	db *sql.DB
+	db   DBTX
+	pool *pgxpool.Pool // nil when the repository is bound to a transaction
+	log  *slog.Logger
 }
 
-func NewRepository(db *sql.DB) Repository {
+// NewRepository returns the Postgres backed Repository
+func NewRepository(pool *pgxpool.Pool, log *slog.Logger) Repository {
 	return &repository{
-		db: db,
+		db:   pool,
+		pool: pool,
+		log:  log,
+	}
+}
+
+// WithTx runs fn inside a single transaction, passing it a Repository bound to
+// that transaction. The transaction is committed when fn returns nil and rolled
+// back otherwise. Calling WithTx on a repository that is already bound to a
+// transaction reuses it.
+func (r *repository) WithTx(ctx context.Context, fn func(tx Repository) error) error {
+	if r.pool == nil {
+		return fn(r)
+	}
+
+	ctx, span := tracer.Start(ctx, "Repository.WithTx")
+	defer span.End()
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+
+	if err := fn(&repository{db: tx, log: r.log}); err != nil {
+		if rbErr := tx.Rollback(ctx); rbErr != nil && !errors.Is(rbErr, pgx.ErrTxClosed) {
+			r.log.ErrorContext(ctx, "[WithTx] rollback failed", "err", rbErr)
+		}
+		recordError(span, err)
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		recordError(span, err)
+		return fmt.Errorf("commit transaction: %w", mapPgError(err))
 	}
+	return nil
 }
 
 func (r *repository) UserRegister(ctx context.Context, request UserRegisterRequest) (string, error) {
-	query := `INSERT INTO users 
-    (first_name, last_name, email, password, dob, gender)
-    VALUES ($1, $2, $3, $4, $5, $6)
+	query := `INSERT INTO users
+    (first_name, last_name, email, password, dob, gender, gender_description)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)
     RETURNING id`
-	var userID string
-	err := r.db.QueryRowContext(
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "UserRegister", query)
+	defer span.End()
+
+	var userID int
+	err := r.db.QueryRow(
 		ctx,
 		query,
 		request.FirstName,
@@ -47,32 +223,66 @@ func (r *repository) UserRegister(ctx context.Context, request UserRegisterReque
 		request.Password,
 		time.Time(request.DOB), // Convert custom Date to time.Time
 		request.Gender,
+		request.GenderDescription,
 	).Scan(&userID)
 
 	if err != nil {
-		log.Println("error from repo", err.Error())
-		return "", err
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[UserRegister] error inserting user", "err", err)
+		return "", mapPgError(err)
 	}
-	return userID, nil
+	return strconv.Itoa(userID), nil
 }
 
 // update user information
-func (r *repository) UpdateUserInfo(ctx context.Context, request UserInformationRequest, addressJSON, vehicleJSON []byte) error {
+func (r *repository) UpdateUserInfo(ctx context.Context, request UserInformationRequest) (int, error) {
 	query := `
-        INSERT INTO user_information (user_id, address, vehicle, created_at, updated_at)
-        VALUES ($1, $2, $3, NOW(), NOW())
+        INSERT INTO user_information (user_id, version, created_at, updated_at)
+        VALUES ($1, 1, NOW(), NOW())
         ON CONFLICT (user_id) DO UPDATE
-        SET 
-            address = COALESCE(EXCLUDED.address, user_information.address),
-            vehicle = COALESCE(EXCLUDED.vehicle, user_information.vehicle),
+        SET
+            version = user_information.version + 1,
             updated_at = NOW()
+        WHERE $2 = -1 OR user_information.version = $2
+        RETURNING version
     `
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "UpdateUserInfo", query)
+	defer span.End()
+
+	var version int
+	err := r.db.QueryRow(ctx, query, request.ID, request.Version).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// the conflicting row exists but its version did not match
+		return 0, ErrVersionMismatch
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[UpdateUserInfo] error executing query", "user_id", request.ID, "err", err)
+		return 0, mapPgError(err)
+	}
+	return version, nil
+}
+
+func (r *repository) UpdatePassword(ctx context.Context, userID string, passwordHash string) error {
+	query := "UPDATE users SET password = $2 WHERE id = $1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "UpdatePassword", query)
+	defer span.End()
 
-	_, err := r.db.ExecContext(ctx, query, request.ID, addressJSON, vehicleJSON)
+	idInt, err := strconv.Atoi(userID)
 	if err != nil {
-		log.Println("[UpdateUserInfo] Error executing query:", err)
+		r.log.WarnContext(ctx, "[UpdatePassword] invalid user id", "user_id", userID, "err", err)
 		return err
 	}
+
+	tag, err := r.db.Exec(ctx, query, idInt, passwordHash)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[UpdatePassword] error executing query", "user_id", userID, "err", err)
+		return mapPgError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
 	return nil
 }
 
@@ -80,43 +290,67 @@ func (r *repository) UpdateUserInfo(ctx context.Context, request UserInformation
 func (r *repository) FindUserByEmail(ctx context.Context, email string) (*User, error) {
 	var user User
 	query := "SELECT id, email, password FROM users WHERE LOWER(email) = $1"
-	row := r.db.QueryRowContext(ctx, query, strings.ToLower(email))
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "FindUserByEmail", query)
+	defer span.End()
+
+	row := r.db.QueryRow(ctx, query, strings.ToLower(email))
 	err := row.Scan(&user.ID, &user.Email, &user.Password)
 
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			log.Printf("[FindUserByEmail] No user found for email: %s\n", email)
-			return nil, errors.New("user not found")
+		if errors.Is(err, pgx.ErrNoRows) {
+			r.log.DebugContext(ctx, "[FindUserByEmail] no user found")
+			return nil, ErrUserNotFound
 		}
-		log.Printf("[FindUserByEmail] DB error: %v\n", err)
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindUserByEmail] db error", "err", err)
 		return nil, errors.New("internal database error")
 	}
 	return &user, nil
 }
 
-func (r *repository) FindUserByID(ctx context.Context, userID string) (*User, []byte, []byte, error) {
-	var user User
-	var addressBytes, vehicleBytes []byte
-
-	query := `
-    SELECT 
-      u.id, u.email, u.password, u.dob, u.first_name, u.last_name, u.gender,
-      ui.address, ui.vehicle
-    FROM users u
-    LEFT JOIN user_information ui ON u.id = ui.user_id
-    WHERE u.id = $1;
-    `
+func (r *repository) FindUserByID(ctx context.Context, userID string) (*User, error) {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[FindUserByID] invalid user id", "user_id", userID, "err", err)
+		return nil, err
+	}
+	return r.findUser(ctx, "FindUserByID", "u.id = $1", "", idInt)
+}
 
-	// Convert userID if needed
+func (r *repository) FindUserByIDForUpdate(ctx context.Context, userID string) (*User, error) {
+	if r.pool != nil {
+		return nil, errors.New("FindUserByIDForUpdate must run inside WithTx")
+	}
 	idInt, err := strconv.Atoi(userID)
 	if err != nil {
-		log.Println("[FindUserByID] Invalid user ID:", err)
-		return nil, nil, nil, err
+		r.log.WarnContext(ctx, "[FindUserByIDForUpdate] invalid user id", "user_id", userID, "err", err)
+		return nil, err
 	}
+	// only the users row can be locked, user_information is on the nullable side of the join
+	return r.findUser(ctx, "FindUserByIDForUpdate", "u.id = $1", "FOR UPDATE OF u", idInt)
+}
 
-	row := r.db.QueryRowContext(ctx, query, idInt)
+func (r *repository) GetUserProfile(ctx context.Context, email string) (*User, error) {
+	return r.findUser(ctx, "GetUserProfile", "LOWER(u.email) = $1", "", strings.ToLower(email))
+}
+
+// findUser loads a user joined with its user_information and primary address
+func (r *repository) findUser(ctx context.Context, operation, where, lockClause string, arg any) (*User, error) {
+	var user User
+
+	query := `
+    SELECT
+      u.id, u.email, u.password, u.dob, u.first_name, u.last_name, u.gender, u.gender_description,
+      ua.city, ua.state, ua.postal_code, ua.country, COALESCE(ui.version, 0)
+    FROM users u
+    LEFT JOIN user_information ui ON u.id = ui.user_id
+    LEFT JOIN user_addresses ua ON u.id = ua.user_id AND ua.is_primary
+    WHERE ` + where + `
+    ` + lockClause
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, operation, query)
+	defer span.End()
 
-	err = row.Scan(
+	err := r.db.QueryRow(ctx, query, arg).Scan(
 		&user.ID,
 		&user.Email,
 		&user.Password,
@@ -124,40 +358,1383 @@ func (r *repository) FindUserByID(ctx context.Context, userID string) (*User, []
 		&user.FirstName,
 		&user.LastName,
 		&user.Gender,
-		&addressBytes,
-		&vehicleBytes,
+		&user.GenderDescription,
+		&user.Address.City,
+		&user.Address.State,
+		&user.Address.PostalCode,
+		&user.Address.Country,
+		&user.Version,
 	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "["+operation+"] error scanning user", "err", err)
+		return nil, mapPgError(err)
+	}
+	return &user, nil
+}
+
+const addressColumns = `id, user_id, type, city, state, postal_code, country, valid_from, valid_until, is_primary, created_at, updated_at`
+
+func (r *repository) ListAddresses(ctx context.Context, userID string) ([]UserAddress, error) {
+	query := "SELECT " + addressColumns + " FROM user_addresses WHERE user_id = $1 ORDER BY id"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "ListAddresses", query)
+	defer span.End()
+
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[ListAddresses] invalid user id", "user_id", userID, "err", err)
+		return nil, err
+	}
+
+	rows, err := r.db.Query(ctx, query, idInt)
 	if err != nil {
-		log.Println("[FindUserByID] Error scanning user:", err)
-		return nil, nil, nil, err
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListAddresses] error executing query", "user_id", userID, "err", err)
+		return nil, err
 	}
+	defer rows.Close()
 
-	return &user, addressBytes, vehicleBytes, nil
+	addresses := []UserAddress{}
+	for rows.Next() {
+		address, err := scanAddress(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		addresses = append(addresses, *address)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return addresses, nil
 }
 
-func (r *repository) GetUserProfile(ctx context.Context, email string) (*User, error) {
-	var user User
-	var addressBytes, vehicleBytes []byte
-	query := `
-SELECT 
-    u.id, u.email, u.password,
-    ui.address, ui.vehicle, ui.dob, ui.first_name, ui.last_name, ui.gender
-FROM 
-    users u
-LEFT JOIN 
-    user_information ui ON u.id = ui.user_id
-WHERE 
-    u.id = $1
-`
-	row := r.db.QueryRowContext(ctx, query, user.ID)
-
-	err := row.Scan(
-		&user.ID, &user.Email, &user.Password,
-		&user.DOB, &user.FirstName, &user.LastName, &user.Gender, &addressBytes, &vehicleBytes,
-	)
+func (r *repository) FindAddress(ctx context.Context, userID string, addressID int) (*UserAddress, error) {
+	query := "SELECT " + addressColumns + " FROM user_addresses WHERE id = $1 AND user_id = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "FindAddress", query)
+	defer span.End()
+
+	idInt, err := strconv.Atoi(userID)
 	if err != nil {
+		r.log.WarnContext(ctx, "[FindAddress] invalid user id", "user_id", userID, "err", err)
 		return nil, err
 	}
-	return &user, nil
 
+	address, err := scanAddress(r.db.QueryRow(ctx, query, addressID, idInt))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAddressNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindAddress] error scanning address", "address_id", addressID, "err", err)
+		return nil, err
+	}
+	return address, nil
+}
+
+func (r *repository) CreateAddress(ctx context.Context, address UserAddress) (*UserAddress, error) {
+	query := `INSERT INTO user_addresses
+    (user_id, type, city, state, postal_code, country, valid_from, valid_until, is_primary, created_at, updated_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW(), NOW())
+    RETURNING ` + addressColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "CreateAddress", query)
+	defer span.End()
+
+	created, err := scanAddress(r.db.QueryRow(ctx, query, addressArgs(address)...))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateAddress] error inserting address", "user_id", address.UserID, "err", err)
+		return nil, mapPgError(err)
+	}
+	return created, nil
+}
+
+func (r *repository) UpdateAddress(ctx context.Context, address UserAddress) (*UserAddress, error) {
+	query := `UPDATE user_addresses
+    SET type = $2, city = $3, state = $4, postal_code = $5, country = $6,
+        valid_from = $7, valid_until = $8, is_primary = $9, updated_at = NOW()
+    WHERE user_id = $1 AND id = $10
+    RETURNING ` + addressColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "UpdateAddress", query)
+	defer span.End()
+
+	updated, err := scanAddress(r.db.QueryRow(ctx, query, append(addressArgs(address), address.ID)...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAddressNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[UpdateAddress] error updating address", "address_id", address.ID, "err", err)
+		return nil, mapPgError(err)
+	}
+	return updated, nil
+}
+
+func (r *repository) DeleteAddress(ctx context.Context, userID string, addressID int) error {
+	query := "DELETE FROM user_addresses WHERE id = $1 AND user_id = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "DeleteAddress", query)
+	defer span.End()
+
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[DeleteAddress] invalid user id", "user_id", userID, "err", err)
+		return err
+	}
+
+	tag, err := r.db.Exec(ctx, query, addressID, idInt)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[DeleteAddress] error executing query", "address_id", addressID, "err", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAddressNotFound
+	}
+	return nil
+}
+
+func (r *repository) ClearPrimaryAddress(ctx context.Context, userID string) error {
+	query := "UPDATE user_addresses SET is_primary = FALSE, updated_at = NOW() WHERE user_id = $1 AND is_primary"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "ClearPrimaryAddress", query)
+	defer span.End()
+
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[ClearPrimaryAddress] invalid user id", "user_id", userID, "err", err)
+		return err
+	}
+
+	if _, err := r.db.Exec(ctx, query, idInt); err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ClearPrimaryAddress] error executing query", "user_id", userID, "err", err)
+		return err
+	}
+	return nil
+}
+
+func (r *repository) UpdateUserIdentity(ctx context.Context, user User) error {
+	query := "UPDATE users SET first_name = $2, last_name = $3, dob = $4, gender = $5, gender_description = $6, updated_at = NOW() WHERE id = $1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "UpdateUserIdentity", query)
+	defer span.End()
+
+	tag, err := r.db.Exec(ctx, query, user.ID, user.FirstName, user.LastName, user.DOB, user.Gender, user.GenderDescription)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[UpdateUserIdentity] error executing query", "user_id", user.ID, "err", err)
+		return mapPgError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *repository) CreateIdentityChange(ctx context.Context, change IdentityChange) (*IdentityChange, error) {
+	query := `INSERT INTO user_identity_changes
+    (user_id, field, old_value, new_value, status, requested_at, decided_at, decided_by)
+    VALUES ($1, $2, $3, $4, $5, NOW(), $6, $7)
+    RETURNING ` + identityChangeColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "CreateIdentityChange", query)
+	defer span.End()
+
+	created, err := scanIdentityChange(r.db.QueryRow(ctx, query,
+		change.UserID, change.Field, change.OldValue, change.NewValue, change.Status, change.DecidedAt, nullIfZero(change.DecidedBy)))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateIdentityChange] error inserting identity change", "user_id", change.UserID, "err", err)
+		return nil, mapPgError(err)
+	}
+	return created, nil
+}
+
+func (r *repository) ListIdentityChanges(ctx context.Context, filter IdentityChangeFilter) ([]IdentityChange, error) {
+	query := "SELECT " + identityChangeColumns + ` FROM user_identity_changes
+    WHERE ($1 = 0 OR user_id = $1) AND ($2 = '' OR status = $2)
+    ORDER BY id`
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "ListIdentityChanges", query)
+	defer span.End()
+
+	rows, err := r.db.Query(ctx, query, filter.UserID, string(filter.Status))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListIdentityChanges] error executing query", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []IdentityChange{}
+	for rows.Next() {
+		change, err := scanIdentityChange(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		changes = append(changes, *change)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return changes, nil
+}
+
+func (r *repository) FindIdentityChange(ctx context.Context, changeID int) (*IdentityChange, error) {
+	query := "SELECT " + identityChangeColumns + " FROM user_identity_changes WHERE id = $1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "FindIdentityChange", query)
+	defer span.End()
+
+	change, err := scanIdentityChange(r.db.QueryRow(ctx, query, changeID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrIdentityChangeNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindIdentityChange] error scanning identity change", "change_id", changeID, "err", err)
+		return nil, err
+	}
+	return change, nil
+}
+
+func (r *repository) DecideIdentityChange(ctx context.Context, changeID int, status IdentityChangeStatus, decidedBy string) (*IdentityChange, error) {
+	query := `UPDATE user_identity_changes
+    SET status = $2, decided_at = NOW(), decided_by = $3
+    WHERE id = $1 AND status = 'pending'
+    RETURNING ` + identityChangeColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "DecideIdentityChange", query)
+	defer span.End()
+
+	change, err := scanIdentityChange(r.db.QueryRow(ctx, query, changeID, status, decidedBy))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrIdentityChangeDecided
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[DecideIdentityChange] error updating identity change", "change_id", changeID, "err", err)
+		return nil, err
+	}
+	return change, nil
+}
+
+const identityChangeColumns = `id, user_id, field, old_value, new_value, status, requested_at, decided_at, decided_by`
+
+// scanIdentityChange reads a row selected with identityChangeColumns
+func scanIdentityChange(row rowScanner) (*IdentityChange, error) {
+	var c IdentityChange
+	var decidedBy *string
+
+	err := row.Scan(&c.ID, &c.UserID, &c.Field, &c.OldValue, &c.NewValue, &c.Status, &c.RequestedAt, &c.DecidedAt, &decidedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	c.DecidedBy = deref(decidedBy)
+	return &c, nil
+}
+
+func (r *repository) CreateProfileHistory(ctx context.Context, entry ProfileHistoryEntry) (*ProfileHistoryEntry, error) {
+	query := `INSERT INTO user_profile_history
+    (user_id, version, actor, request_id, reverted_from, changes, profile)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)
+    RETURNING ` + profileHistoryColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "CreateProfileHistory", query)
+	defer span.End()
+
+	args, err := profileHistoryArgs(entry)
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	created, err := scanProfileHistory(r.db.QueryRow(ctx, query, args...))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateProfileHistory] error inserting history entry", "user_id", entry.UserID, "err", err)
+		return nil, mapPgError(err)
+	}
+	return created, nil
+}
+
+func (r *repository) ListProfileHistory(ctx context.Context, userID int) ([]ProfileHistoryEntry, error) {
+	query := "SELECT " + profileHistoryColumns + " FROM user_profile_history WHERE user_id = $1 ORDER BY version DESC"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "ListProfileHistory", query)
+	defer span.End()
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListProfileHistory] error executing query", "user_id", userID, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []ProfileHistoryEntry{}
+	for rows.Next() {
+		entry, err := scanProfileHistory(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (r *repository) FindProfileHistory(ctx context.Context, userID, version int) (*ProfileHistoryEntry, error) {
+	query := "SELECT " + profileHistoryColumns + " FROM user_profile_history WHERE user_id = $1 AND version = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "FindProfileHistory", query)
+	defer span.End()
+
+	entry, err := scanProfileHistory(r.db.QueryRow(ctx, query, userID, version))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrProfileVersionNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindProfileHistory] error scanning history entry", "user_id", userID, "version", version, "err", err)
+		return nil, err
+	}
+	return entry, nil
+}
+
+const profileHistoryColumns = `id, user_id, version, actor, request_id, reverted_from, changes, profile, created_at`
+
+// profileHistoryArgs are the $1 to $7 arguments of the history insert, the
+// changes and the profile are stored as JSON
+func profileHistoryArgs(e ProfileHistoryEntry) ([]any, error) {
+	changes, err := json.Marshal(e.Changes)
+	if err != nil {
+		return nil, fmt.Errorf("marshal profile changes: %w", err)
+	}
+	profile, err := json.Marshal(e.Profile)
+	if err != nil {
+		return nil, fmt.Errorf("marshal profile: %w", err)
+	}
+	return []any{e.UserID, e.Version, e.Actor, nullIfZero(e.RequestID), e.RevertedFrom, string(changes), string(profile)}, nil
+}
+
+// scanProfileHistory reads a row selected with profileHistoryColumns
+func scanProfileHistory(row rowScanner) (*ProfileHistoryEntry, error) {
+	var e ProfileHistoryEntry
+	var requestID *string
+	var changes, profile []byte
+
+	err := row.Scan(&e.ID, &e.UserID, &e.Version, &e.Actor, &requestID, &e.RevertedFrom, &changes, &profile, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(changes, &e.Changes); err != nil {
+		return nil, fmt.Errorf("unmarshal profile changes: %w", err)
+	}
+	if err := json.Unmarshal(profile, &e.Profile); err != nil {
+		return nil, fmt.Errorf("unmarshal profile: %w", err)
+	}
+	e.RequestID = deref(requestID)
+	return &e, nil
+}
+
+func (r *repository) LastAuditEvent(ctx context.Context) (*AuditEvent, error) {
+	query := "SELECT " + auditEventColumns + " FROM audit_events ORDER BY id DESC LIMIT 1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "LastAuditEvent", query)
+	defer span.End()
+
+	// serializes appends until the transaction ends, a row lock would not
+	// cover the empty log and the unique prev_hash would fail the second append
+	if _, err := r.db.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", auditLogLockKey); err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[LastAuditEvent] error locking the audit log", "err", err)
+		return nil, err
+	}
+
+	event, err := scanAuditEvent(r.db.QueryRow(ctx, query))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[LastAuditEvent] error scanning audit event", "err", err)
+		return nil, err
+	}
+	return event, nil
+}
+
+func (r *repository) CreateAuditEvent(ctx context.Context, event AuditEvent) (*AuditEvent, error) {
+	query := `INSERT INTO audit_events
+    (occurred_at, type, actor, user_id, ip, request_id, details, prev_hash, hash)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+    RETURNING ` + auditEventColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "CreateAuditEvent", query)
+	defer span.End()
+
+	args, err := auditEventArgs(event)
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	created, err := scanAuditEvent(r.db.QueryRow(ctx, query, args...))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateAuditEvent] error inserting audit event", "type", event.Type, "err", err)
+		return nil, mapPgError(err)
+	}
+	return created, nil
+}
+
+func (r *repository) ListAuditEvents(ctx context.Context, filter AuditEventFilter) ([]AuditEvent, error) {
+	query := "SELECT " + auditEventColumns + " FROM audit_events WHERE " + auditEventWhere + " ORDER BY id LIMIT $7"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "ListAuditEvents", query)
+	defer span.End()
+
+	rows, err := r.db.Query(ctx, query, auditEventFilterArgs(filter)...)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListAuditEvents] error executing query", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return events, nil
+}
+
+// auditLogLockKey is the advisory lock taken to append to the audit log
+const auditLogLockKey = 4507
+
+const auditEventColumns = `id, occurred_at, type, actor, user_id, ip, request_id, details, prev_hash, hash`
+
+// auditEventWhere applies the $1 to $6 arguments of auditEventFilterArgs
+const auditEventWhere = `($1 = '' OR type = $1) AND ($2 = '' OR LOWER(actor) = LOWER($2)) AND ($3 = 0 OR user_id = $3)
+    AND ($4 IS NULL OR occurred_at >= $4) AND ($5 IS NULL OR occurred_at < $5) AND id > $6`
+
+// auditEventFilterArgs are the $1 to $7 arguments of the audit event listing
+func auditEventFilterArgs(f AuditEventFilter) []any {
+	return []any{string(f.Type), f.Actor, f.UserID, f.From, f.To, f.AfterID, f.Limit}
+}
+
+// auditEventArgs are the $1 to $9 arguments of the audit event insert, the
+// details are stored as JSON
+func auditEventArgs(e AuditEvent) ([]any, error) {
+	details, err := json.Marshal(e.Details)
+	if err != nil {
+		return nil, fmt.Errorf("marshal audit details: %w", err)
+	}
+	return []any{
+		e.OccurredAt, e.Type, e.Actor, nullIfZero(e.UserID), nullIfZero(e.IP), nullIfZero(e.RequestID),
+		string(details), e.PrevHash, e.Hash,
+	}, nil
+}
+
+// scanAuditEvent reads a row selected with auditEventColumns
+func scanAuditEvent(row rowScanner) (*AuditEvent, error) {
+	var e AuditEvent
+	var userID *int
+	var ip, requestID *string
+	var details []byte
+
+	err := row.Scan(&e.ID, &e.OccurredAt, &e.Type, &e.Actor, &userID, &ip, &requestID, &details, &e.PrevHash, &e.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(details, &e.Details); err != nil {
+		return nil, fmt.Errorf("unmarshal audit details: %w", err)
+	}
+	e.OccurredAt = e.OccurredAt.UTC()
+	e.UserID, e.IP, e.RequestID = deref(userID), deref(ip), deref(requestID)
+	return &e, nil
+}
+
+func (r *repository) CreateSession(ctx context.Context, session Session) (*Session, error) {
+	query := `INSERT INTO user_sessions
+    (id, user_id, created_at, expires_at, ip, user_agent, device)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)
+    RETURNING ` + sessionColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "CreateSession", query)
+	defer span.End()
+
+	created, err := scanSession(r.db.QueryRow(ctx, query, sessionArgs(session)...))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateSession] error inserting session", "user_id", session.UserID, "err", err)
+		return nil, mapPgError(err)
+	}
+	return created, nil
+}
+
+func (r *repository) FindSession(ctx context.Context, sessionID string) (*Session, error) {
+	query := "SELECT " + sessionColumns + " FROM user_sessions WHERE id = $1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "FindSession", query)
+	defer span.End()
+
+	session, err := scanSession(r.db.QueryRow(ctx, query, sessionID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindSession] error scanning session", "err", err)
+		return nil, err
+	}
+	return session, nil
+}
+
+func (r *repository) ListSessions(ctx context.Context, userID int) ([]Session, error) {
+	query := "SELECT " + sessionColumns + " FROM user_sessions WHERE user_id = $1 ORDER BY created_at DESC, id"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "ListSessions", query)
+	defer span.End()
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListSessions] error executing query", "user_id", userID, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (r *repository) RevokeSession(ctx context.Context, userID int, sessionID string) (*Session, error) {
+	query := `UPDATE user_sessions SET revoked_at = CURRENT_TIMESTAMP
+    WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+    RETURNING ` + sessionColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "RevokeSession", query)
+	defer span.End()
+
+	session, err := scanSession(r.db.QueryRow(ctx, query, sessionID, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[RevokeSession] error executing query", "user_id", userID, "err", err)
+		return nil, err
+	}
+	return session, nil
+}
+
+const sessionColumns = `id, user_id, created_at, expires_at, ip, user_agent, device, revoked_at`
+
+// sessionArgs are the $1 to $7 arguments of the session insert
+func sessionArgs(s Session) []any {
+	return []any{s.ID, s.UserID, s.CreatedAt, s.ExpiresAt, nullIfZero(s.IP), nullIfZero(s.UserAgent), s.Device}
+}
+
+// scanSession reads a row selected with sessionColumns
+func scanSession(row rowScanner) (*Session, error) {
+	var s Session
+	var ip, userAgent *string
+
+	err := row.Scan(&s.ID, &s.UserID, &s.CreatedAt, &s.ExpiresAt, &ip, &userAgent, &s.Device, &s.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	s.CreatedAt, s.ExpiresAt = s.CreatedAt.UTC(), s.ExpiresAt.UTC()
+	if s.RevokedAt != nil {
+		revokedAt := s.RevokedAt.UTC()
+		s.RevokedAt = &revokedAt
+	}
+	s.IP, s.UserAgent = deref(ip), deref(userAgent)
+	return &s, nil
+}
+
+func (r *repository) CreateIdentity(ctx context.Context, identity UserIdentity) (*UserIdentity, error) {
+	query := `INSERT INTO user_identities (user_id, provider, subject, email)
+    VALUES ($1, $2, $3, $4)
+    RETURNING ` + identityColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "CreateIdentity", query)
+	defer span.End()
+
+	created, err := scanIdentity(r.db.QueryRow(ctx, query, identity.UserID, identity.Provider, identity.Subject, nullIfZero(identity.Email)))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateIdentity] error inserting identity", "user_id", identity.UserID, "provider", identity.Provider, "err", err)
+		return nil, mapPgError(err)
+	}
+	return created, nil
+}
+
+func (r *repository) FindIdentity(ctx context.Context, provider, subject string) (*UserIdentity, error) {
+	query := "SELECT " + identityColumns + " FROM user_identities WHERE provider = $1 AND subject = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "FindIdentity", query)
+	defer span.End()
+
+	identity, err := scanIdentity(r.db.QueryRow(ctx, query, provider, subject))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrIdentityNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindIdentity] error scanning identity", "provider", provider, "err", err)
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (r *repository) ListIdentities(ctx context.Context, userID int) ([]UserIdentity, error) {
+	query := "SELECT " + identityColumns + " FROM user_identities WHERE user_id = $1 ORDER BY id"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "ListIdentities", query)
+	defer span.End()
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListIdentities] error executing query", "user_id", userID, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []UserIdentity{}
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		identities = append(identities, *identity)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (r *repository) DeleteIdentity(ctx context.Context, userID int, provider string) error {
+	query := "DELETE FROM user_identities WHERE user_id = $1 AND provider = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "DeleteIdentity", query)
+	defer span.End()
+
+	tag, err := r.db.Exec(ctx, query, userID, provider)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[DeleteIdentity] error executing query", "user_id", userID, "provider", provider, "err", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
+
+const identityColumns = `id, user_id, provider, subject, email, created_at`
+
+// scanIdentity reads a row selected with identityColumns
+func scanIdentity(row rowScanner) (*UserIdentity, error) {
+	var i UserIdentity
+	var email *string
+
+	if err := row.Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &email, &i.CreatedAt); err != nil {
+		return nil, err
+	}
+	i.Email = deref(email)
+	return &i, nil
+}
+
+func (r *repository) CreateOAuthClient(ctx context.Context, client OAuthClient) (*OAuthClient, error) {
+	query := `INSERT INTO oauth_clients (id, name, secret_hash, redirect_uris, scopes)
+    VALUES ($1, $2, $3, $4, $5)
+    RETURNING ` + oauthClientColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "CreateOAuthClient", query)
+	defer span.End()
+
+	created, err := scanOAuthClient(r.db.QueryRow(ctx, query, oauthClientArgs(client)...))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateOAuthClient] error inserting client", "client_id", client.ID, "err", err)
+		return nil, mapPgError(err)
+	}
+	return created, nil
+}
+
+func (r *repository) FindOAuthClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	query := "SELECT " + oauthClientColumns + " FROM oauth_clients WHERE id = $1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "FindOAuthClient", query)
+	defer span.End()
+
+	client, err := scanOAuthClient(r.db.QueryRow(ctx, query, clientID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrOAuthClientNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindOAuthClient] error scanning client", "client_id", clientID, "err", err)
+		return nil, err
+	}
+	return client, nil
+}
+
+func (r *repository) ListOAuthClients(ctx context.Context) ([]OAuthClient, error) {
+	query := "SELECT " + oauthClientColumns + " FROM oauth_clients ORDER BY created_at, id"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "ListOAuthClients", query)
+	defer span.End()
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListOAuthClients] error executing query", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		clients = append(clients, *client)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return clients, nil
+}
+
+func (r *repository) DeleteOAuthClient(ctx context.Context, clientID string) error {
+	query := "DELETE FROM oauth_clients WHERE id = $1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "DeleteOAuthClient", query)
+	defer span.End()
+
+	tag, err := r.db.Exec(ctx, query, clientID)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[DeleteOAuthClient] error executing query", "client_id", clientID, "err", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrOAuthClientNotFound
+	}
+	return nil
+}
+
+func (r *repository) SaveOAuthConsent(ctx context.Context, consent OAuthConsent) error {
+	query := `INSERT INTO oauth_consents (user_id, client_id, scopes, granted_at)
+    VALUES ($1, $2, $3, $4)
+    ON CONFLICT (user_id, client_id) DO UPDATE SET scopes = EXCLUDED.scopes, granted_at = EXCLUDED.granted_at`
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "SaveOAuthConsent", query)
+	defer span.End()
+
+	_, err := r.db.Exec(ctx, query, consent.UserID, consent.ClientID, strings.Join(consent.Scopes, " "), consent.GrantedAt)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[SaveOAuthConsent] error executing query", "user_id", consent.UserID, "client_id", consent.ClientID, "err", err)
+		return mapPgError(err)
+	}
+	return nil
+}
+
+func (r *repository) FindOAuthConsent(ctx context.Context, userID int, clientID string) (*OAuthConsent, error) {
+	query := "SELECT " + oauthConsentColumns + " FROM oauth_consents WHERE user_id = $1 AND client_id = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "FindOAuthConsent", query)
+	defer span.End()
+
+	consent, err := scanOAuthConsent(r.db.QueryRow(ctx, query, userID, clientID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrOAuthConsentNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindOAuthConsent] error scanning consent", "user_id", userID, "client_id", clientID, "err", err)
+		return nil, err
+	}
+	return consent, nil
+}
+
+func (r *repository) CreateAuthorizationCode(ctx context.Context, code OAuthAuthorizationCode) error {
+	query := `INSERT INTO oauth_authorization_codes
+    (code_hash, client_id, user_id, redirect_uri, scopes, nonce, code_challenge, expires_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "CreateAuthorizationCode", query)
+	defer span.End()
+
+	_, err := r.db.Exec(ctx, query, authorizationCodeArgs(code)...)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateAuthorizationCode] error inserting code", "client_id", code.ClientID, "err", err)
+		return mapPgError(err)
+	}
+	return nil
+}
+
+func (r *repository) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*OAuthAuthorizationCode, error) {
+	query := `UPDATE oauth_authorization_codes SET used_at = CURRENT_TIMESTAMP
+    WHERE code_hash = $1 AND used_at IS NULL
+    RETURNING ` + authorizationCodeColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "ConsumeAuthorizationCode", query)
+	defer span.End()
+
+	code, err := scanAuthorizationCode(r.db.QueryRow(ctx, query, codeHash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAuthorizationCodeNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ConsumeAuthorizationCode] error executing query", "err", err)
+		return nil, err
+	}
+	return code, nil
+}
+
+func (r *repository) CreateSigningKey(ctx context.Context, key OAuthSigningKey) error {
+	query := "INSERT INTO oauth_signing_keys (id, private_key, created_at) VALUES ($1, $2, $3)"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "CreateSigningKey", query)
+	defer span.End()
+
+	if _, err := r.db.Exec(ctx, query, key.ID, key.PrivateKey, key.CreatedAt); err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateSigningKey] error inserting key", "kid", key.ID, "err", err)
+		return err
+	}
+	return nil
+}
+
+func (r *repository) ListSigningKeys(ctx context.Context, since time.Time) ([]OAuthSigningKey, error) {
+	query := "SELECT " + signingKeyColumns + " FROM oauth_signing_keys WHERE created_at > $1 ORDER BY created_at DESC, id"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "ListSigningKeys", query)
+	defer span.End()
+
+	rows, err := r.db.Query(ctx, query, since)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListSigningKeys] error executing query", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []OAuthSigningKey{}
+	for rows.Next() {
+		key, err := scanSigningKey(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return keys, nil
+}
+
+// redirect URIs and scopes are stored space separated like the scope parameter
+const (
+	oauthClientColumns       = `id, name, secret_hash, redirect_uris, scopes, created_at`
+	oauthConsentColumns      = `user_id, client_id, scopes, granted_at`
+	authorizationCodeColumns = `code_hash, client_id, user_id, redirect_uri, scopes, nonce, code_challenge, expires_at, used_at`
+	signingKeyColumns        = `id, private_key, created_at`
+)
+
+// oauthClientArgs are the $1 to $5 arguments of the client insert
+func oauthClientArgs(c OAuthClient) []any {
+	return []any{c.ID, c.Name, nullIfZero(c.SecretHash), strings.Join(c.RedirectURIs, " "), strings.Join(c.Scopes, " ")}
+}
+
+func scanOAuthClient(row rowScanner) (*OAuthClient, error) {
+	var c OAuthClient
+	var secretHash *string
+	var redirectURIs, scopes string
+
+	if err := row.Scan(&c.ID, &c.Name, &secretHash, &redirectURIs, &scopes, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+	c.SecretHash = deref(secretHash)
+	c.RedirectURIs, c.Scopes = strings.Fields(redirectURIs), strings.Fields(scopes)
+	return &c, nil
+}
+
+func scanOAuthConsent(row rowScanner) (*OAuthConsent, error) {
+	var c OAuthConsent
+	var scopes string
+
+	if err := row.Scan(&c.UserID, &c.ClientID, &scopes, &c.GrantedAt); err != nil {
+		return nil, err
+	}
+	c.Scopes = strings.Fields(scopes)
+	c.GrantedAt = c.GrantedAt.UTC()
+	return &c, nil
+}
+
+// authorizationCodeArgs are the $1 to $8 arguments of the code insert
+func authorizationCodeArgs(c OAuthAuthorizationCode) []any {
+	return []any{c.CodeHash, c.ClientID, c.UserID, c.RedirectURI, strings.Join(c.Scopes, " "),
+		nullIfZero(c.Nonce), nullIfZero(c.CodeChallenge), c.ExpiresAt}
+}
+
+func scanAuthorizationCode(row rowScanner) (*OAuthAuthorizationCode, error) {
+	var c OAuthAuthorizationCode
+	var scopes string
+	var nonce, codeChallenge *string
+
+	err := row.Scan(&c.CodeHash, &c.ClientID, &c.UserID, &c.RedirectURI, &scopes, &nonce, &codeChallenge, &c.ExpiresAt, &c.UsedAt)
+	if err != nil {
+		return nil, err
+	}
+	c.Scopes = strings.Fields(scopes)
+	c.Nonce, c.CodeChallenge = deref(nonce), deref(codeChallenge)
+	c.ExpiresAt = c.ExpiresAt.UTC()
+	if c.UsedAt != nil {
+		usedAt := c.UsedAt.UTC()
+		c.UsedAt = &usedAt
+	}
+	return &c, nil
+}
+
+func scanSigningKey(row rowScanner) (*OAuthSigningKey, error) {
+	var k OAuthSigningKey
+	if err := row.Scan(&k.ID, &k.PrivateKey, &k.CreatedAt); err != nil {
+		return nil, err
+	}
+	k.CreatedAt = k.CreatedAt.UTC()
+	return &k, nil
+}
+
+func (r *repository) CreateAPIKey(ctx context.Context, key APIKey) (*APIKey, error) {
+	query := `INSERT INTO api_keys (id, user_id, name, secret_hash, scopes, created_at, expires_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)
+    RETURNING ` + apiKeyColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "CreateAPIKey", query)
+	defer span.End()
+
+	created, err := scanAPIKey(r.db.QueryRow(ctx, query, apiKeyArgs(key)...))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateAPIKey] error inserting key", "user_id", key.UserID, "err", err)
+		return nil, mapPgError(err)
+	}
+	return created, nil
+}
+
+func (r *repository) FindAPIKey(ctx context.Context, keyID string) (*APIKey, error) {
+	query := "SELECT " + apiKeyColumns + " FROM api_keys WHERE id = $1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "FindAPIKey", query)
+	defer span.End()
+
+	key, err := scanAPIKey(r.db.QueryRow(ctx, query, keyID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindAPIKey] error scanning key", "err", err)
+		return nil, err
+	}
+	return key, nil
+}
+
+func (r *repository) ListAPIKeys(ctx context.Context, userID int) ([]APIKey, error) {
+	query := "SELECT " + apiKeyColumns + " FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC, id"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "ListAPIKeys", query)
+	defer span.End()
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListAPIKeys] error executing query", "user_id", userID, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *repository) DeleteAPIKey(ctx context.Context, userID int, keyID string) error {
+	query := "DELETE FROM api_keys WHERE id = $1 AND user_id = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "DeleteAPIKey", query)
+	defer span.End()
+
+	tag, err := r.db.Exec(ctx, query, keyID, userID)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[DeleteAPIKey] error executing query", "user_id", userID, "err", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (r *repository) TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) error {
+	query := "UPDATE api_keys SET last_used_at = $2 WHERE id = $1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "TouchAPIKey", query)
+	defer span.End()
+
+	if _, err := r.db.Exec(ctx, query, keyID, usedAt); err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[TouchAPIKey] error executing query", "err", err)
+		return err
+	}
+	return nil
+}
+
+func (r *repository) CreateMagicLink(ctx context.Context, link MagicLink) error {
+	query := "INSERT INTO magic_links (id, user_id, created_at, expires_at) VALUES ($1, $2, $3, $4)"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "CreateMagicLink", query)
+	defer span.End()
+
+	_, err := r.db.Exec(ctx, query, link.ID, link.UserID, link.CreatedAt, link.ExpiresAt)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateMagicLink] error inserting link", "user_id", link.UserID, "err", err)
+		return mapPgError(err)
+	}
+	return nil
+}
+
+func (r *repository) CountMagicLinks(ctx context.Context, userID int, since time.Time) (int, error) {
+	query := "SELECT COUNT(*) FROM magic_links WHERE user_id = $1 AND created_at > $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "CountMagicLinks", query)
+	defer span.End()
+
+	var count int
+	if err := r.db.QueryRow(ctx, query, userID, since).Scan(&count); err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CountMagicLinks] error executing query", "user_id", userID, "err", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *repository) ConsumeMagicLink(ctx context.Context, linkID string) (*MagicLink, error) {
+	query := `UPDATE magic_links SET used_at = CURRENT_TIMESTAMP
+    WHERE id = $1 AND used_at IS NULL
+    RETURNING ` + magicLinkColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "ConsumeMagicLink", query)
+	defer span.End()
+
+	link, err := scanMagicLink(r.db.QueryRow(ctx, query, linkID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMagicLinkNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ConsumeMagicLink] error executing query", "err", err)
+		return nil, err
+	}
+	return link, nil
+}
+
+const apiKeyColumns = `id, user_id, name, secret_hash, scopes, created_at, expires_at, last_used_at`
+
+// apiKeyArgs are the $1 to $7 arguments of the API key insert
+func apiKeyArgs(k APIKey) []any {
+	return []any{k.ID, k.UserID, k.Name, k.SecretHash, strings.Join(k.Scopes, " "), k.CreatedAt, k.ExpiresAt}
+}
+
+// scanAPIKey reads a row selected with apiKeyColumns
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var k APIKey
+	var scopes string
+
+	err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.SecretHash, &scopes, &k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	k.Scopes = strings.Fields(scopes)
+	k.CreatedAt, k.ExpiresAt = k.CreatedAt.UTC(), k.ExpiresAt.UTC()
+	if k.LastUsedAt != nil {
+		lastUsedAt := k.LastUsedAt.UTC()
+		k.LastUsedAt = &lastUsedAt
+	}
+	return &k, nil
+}
+
+const magicLinkColumns = `id, user_id, created_at, expires_at, used_at`
+
+// scanMagicLink reads a row selected with magicLinkColumns
+func scanMagicLink(row rowScanner) (*MagicLink, error) {
+	var l MagicLink
+	if err := row.Scan(&l.ID, &l.UserID, &l.CreatedAt, &l.ExpiresAt, &l.UsedAt); err != nil {
+		return nil, err
+	}
+	l.CreatedAt, l.ExpiresAt = l.CreatedAt.UTC(), l.ExpiresAt.UTC()
+	if l.UsedAt != nil {
+		usedAt := l.UsedAt.UTC()
+		l.UsedAt = &usedAt
+	}
+	return &l, nil
+}
+
+// addressArgs are the $1 to $9 arguments of the address insert and update,
+// empty fields are stored as NULL
+func addressArgs(a UserAddress) []any {
+	return []any{
+		a.UserID, a.Type, a.City, a.State, a.PostalCode, a.Country,
+		(*time.Time)(a.ValidFrom), (*time.Time)(a.ValidUntil), a.IsPrimary,
+	}
+}
+
+// scanAddress reads a row selected with addressColumns
+func scanAddress(row rowScanner) (*UserAddress, error) {
+	var a UserAddress
+	var validFrom, validUntil *time.Time
+
+	err := row.Scan(&a.ID, &a.UserID, &a.Type, &a.City, &a.State, &a.PostalCode, &a.Country, &validFrom, &validUntil, &a.IsPrimary, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	a.ValidFrom = (*Date)(validFrom)
+	a.ValidUntil = (*Date)(validUntil)
+	return &a, nil
+}
+
+const vehicleColumns = `id, user_id, type, make, model, year, registration_number, owned_from, owned_until, created_at, updated_at`
+
+func (r *repository) ListVehicles(ctx context.Context, userID string) ([]UserVehicle, error) {
+	query := "SELECT " + vehicleColumns + " FROM user_vehicles WHERE user_id = $1 ORDER BY id"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "ListVehicles", query)
+	defer span.End()
+
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[ListVehicles] invalid user id", "user_id", userID, "err", err)
+		return nil, err
+	}
+
+	rows, err := r.db.Query(ctx, query, idInt)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListVehicles] error executing query", "user_id", userID, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	vehicles := []UserVehicle{}
+	for rows.Next() {
+		vehicle, err := scanVehicle(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		vehicles = append(vehicles, *vehicle)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return vehicles, nil
+}
+
+func (r *repository) FindVehicle(ctx context.Context, userID string, vehicleID int) (*UserVehicle, error) {
+	query := "SELECT " + vehicleColumns + " FROM user_vehicles WHERE id = $1 AND user_id = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "FindVehicle", query)
+	defer span.End()
+
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[FindVehicle] invalid user id", "user_id", userID, "err", err)
+		return nil, err
+	}
+
+	vehicle, err := scanVehicle(r.db.QueryRow(ctx, query, vehicleID, idInt))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrVehicleNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindVehicle] error scanning vehicle", "vehicle_id", vehicleID, "err", err)
+		return nil, err
+	}
+	return vehicle, nil
+}
+
+func (r *repository) CreateVehicle(ctx context.Context, vehicle UserVehicle) (*UserVehicle, error) {
+	query := `INSERT INTO user_vehicles
+    (user_id, type, make, model, year, registration_number, owned_from, owned_until, created_at, updated_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW())
+    RETURNING ` + vehicleColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "CreateVehicle", query)
+	defer span.End()
+
+	created, err := scanVehicle(r.db.QueryRow(ctx, query, vehicleArgs(vehicle)...))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateVehicle] error inserting vehicle", "user_id", vehicle.UserID, "err", err)
+		return nil, mapPgError(err)
+	}
+	return created, nil
+}
+
+func (r *repository) UpdateVehicle(ctx context.Context, vehicle UserVehicle) (*UserVehicle, error) {
+	query := `UPDATE user_vehicles
+    SET type = $2, make = $3, model = $4, year = $5, registration_number = $6,
+        owned_from = $7, owned_until = $8, updated_at = NOW()
+    WHERE user_id = $1 AND id = $9
+    RETURNING ` + vehicleColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "UpdateVehicle", query)
+	defer span.End()
+
+	updated, err := scanVehicle(r.db.QueryRow(ctx, query, append(vehicleArgs(vehicle), vehicle.ID)...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrVehicleNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[UpdateVehicle] error updating vehicle", "vehicle_id", vehicle.ID, "err", err)
+		return nil, mapPgError(err)
+	}
+	return updated, nil
+}
+
+func (r *repository) DeleteVehicle(ctx context.Context, userID string, vehicleID int) error {
+	query := "DELETE FROM user_vehicles WHERE id = $1 AND user_id = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemPostgreSQL, "DeleteVehicle", query)
+	defer span.End()
+
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[DeleteVehicle] invalid user id", "user_id", userID, "err", err)
+		return err
+	}
+
+	tag, err := r.db.Exec(ctx, query, vehicleID, idInt)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[DeleteVehicle] error executing query", "vehicle_id", vehicleID, "err", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrVehicleNotFound
+	}
+	return nil
+}
+
+// vehicleArgs are the $1 to $8 arguments of the vehicle insert and update,
+// empty optional fields are stored as NULL
+func vehicleArgs(v UserVehicle) []any {
+	return []any{
+		v.UserID, v.Type, nullIfZero(v.Make), nullIfZero(v.Model), nullIfZero(v.Year), nullIfZero(v.RegistrationNumber),
+		(*time.Time)(v.OwnedFrom), (*time.Time)(v.OwnedUntil),
+	}
+}
+
+// rowScanner is satisfied by pgx.Row, pgx.Rows, *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanVehicle reads a row selected with vehicleColumns, empty text columns and
+// the year are nullable
+func scanVehicle(row rowScanner) (*UserVehicle, error) {
+	var v UserVehicle
+	var vehicleMake, model, registration *string
+	var year *int
+	var ownedFrom, ownedUntil *time.Time
+
+	err := row.Scan(&v.ID, &v.UserID, &v.Type, &vehicleMake, &model, &year, &registration, &ownedFrom, &ownedUntil, &v.CreatedAt, &v.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	v.Make = deref(vehicleMake)
+	v.Model = deref(model)
+	v.Year = deref(year)
+	v.RegistrationNumber = deref(registration)
+	v.OwnedFrom = (*Date)(ownedFrom)
+	v.OwnedUntil = (*Date)(ownedUntil)
+	return &v, nil
+}
+
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
+
+func nullIfZero[T comparable](v T) *T {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return &v
+}
+
+// mapPgError translates Postgres error codes into domain errors, anything
+// unknown is returned as is
+func mapPgError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch pgErr.Code {
+	case pgUniqueViolation:
+		if pgErr.ConstraintName == "users_email_key" || pgErr.ConstraintName == "users_email_lower_key" {
+			return ErrEmailTaken
+		}
+		if pgErr.ConstraintName == "user_addresses_primary_key" {
+			return ErrPrimaryAddressTaken
+		}
+		if pgErr.ConstraintName == "user_identity_changes_pending_key" {
+			return ErrIdentityChangePending
+		}
+		if pgErr.ConstraintName == "user_identities_subject_key" || pgErr.ConstraintName == "user_identities_provider_key" {
+			return ErrIdentityLinked
+		}
+	case pgForeignKeyViolation:
+		switch pgErr.ConstraintName {
+		case "oauth_consents_client_id_fkey", "oauth_authorization_codes_client_id_fkey":
+			return ErrOAuthClientNotFound
+		case "user_information_user_id_fkey", "user_vehicles_user_id_fkey", "user_addresses_user_id_fkey",
+			"user_identity_changes_user_id_fkey", "user_profile_history_user_id_fkey", "user_sessions_user_id_fkey",
+			"user_identities_user_id_fkey", "oauth_consents_user_id_fkey", "oauth_authorization_codes_user_id_fkey",
+			"api_keys_user_id_fkey", "magic_links_user_id_fkey":
+			return ErrUserNotFound
+		}
+	}
+	return err
 }
@@ -0,0 +1,1202 @@
+package userauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryInformation mirrors a user_information row
+type memoryInformation struct {
+	version int
+}
+
+// memoryConsentKey is the primary key of oauth_consents
+type memoryConsentKey struct {
+	userID   int
+	clientID string
+}
+
+// memoryState is everything the in-memory repository stores, it is cloned
+// when a transaction starts and swapped back in when it commits
+type memoryState struct {
+	users      map[int]User
+	info       map[int]memoryInformation
+	vehicles   map[int]UserVehicle
+	addresses  map[int]UserAddress
+	changes    map[int]IdentityChange
+	history    map[int]ProfileHistoryEntry
+	sessions   map[string]Session
+	identities map[int]UserIdentity
+	// OAuth clients and signing keys by ID, consents by user and client ID,
+	// authorization codes by hash
+	oauthClients  map[string]OAuthClient
+	oauthConsents map[memoryConsentKey]OAuthConsent
+	oauthCodes    map[string]OAuthAuthorizationCode
+	oauthKeys     map[string]OAuthSigningKey
+	apiKeys       map[string]APIKey
+	magicLinks    map[string]MagicLink
+	// auditEvents is append-only, the index of an event is its id minus one
+	auditEvents    []AuditEvent
+	nextID         int
+	nextVehicleID  int
+	nextAddressID  int
+	nextChangeID   int
+	nextHistoryID  int
+	nextIdentityID int
+}
+
+func (m *memoryState) clone() *memoryState {
+	c := &memoryState{
+		users:      make(map[int]User, len(m.users)),
+		info:       make(map[int]memoryInformation, len(m.info)),
+		vehicles:   make(map[int]UserVehicle, len(m.vehicles)),
+		addresses:  make(map[int]UserAddress, len(m.addresses)),
+		changes:    make(map[int]IdentityChange, len(m.changes)),
+		history:    make(map[int]ProfileHistoryEntry, len(m.history)),
+		sessions:   maps.Clone(m.sessions),
+		identities: maps.Clone(m.identities),
+		// none of the OAuth values, API keys and magic links are mutated in place either
+		oauthClients:  maps.Clone(m.oauthClients),
+		oauthConsents: maps.Clone(m.oauthConsents),
+		oauthCodes:    maps.Clone(m.oauthCodes),
+		oauthKeys:     maps.Clone(m.oauthKeys),
+		apiKeys:       maps.Clone(m.apiKeys),
+		magicLinks:    maps.Clone(m.magicLinks),
+		// clipped so an append in the transaction never writes into the committed array
+		auditEvents:    slices.Clip(m.auditEvents),
+		nextID:         m.nextID,
+		nextVehicleID:  m.nextVehicleID,
+		nextAddressID:  m.nextAddressID,
+		nextChangeID:   m.nextChangeID,
+		nextHistoryID:  m.nextHistoryID,
+		nextIdentityID: m.nextIdentityID,
+	}
+	for id, u := range m.users {
+		c.users[id] = u
+	}
+	for id, i := range m.info {
+		c.info[id] = i
+	}
+	// stored vehicles, addresses, changes, history entries, sessions and
+	// identities are never mutated in place, a shallow copy is enough
+	for id, v := range m.vehicles {
+		c.vehicles[id] = v
+	}
+	for id, a := range m.addresses {
+		c.addresses[id] = a
+	}
+	for id, ch := range m.changes {
+		c.changes[id] = ch
+	}
+	for id, e := range m.history {
+		c.history[id] = e
+	}
+	return c
+}
+
+type memoryDB struct {
+	mu    sync.Mutex
+	state *memoryState
+}
+
+type memoryRepository struct {
+	db  *memoryDB
+	tx  *memoryState // set when the repository is bound to a transaction
+	log *slog.Logger
+}
+
+// NewMemoryRepository returns a Repository that keeps everything in memory.
+// It follows the same rules as the SQL implementations: emails are unique and
+// matched case-insensitively, a user has at most one primary address and every
+// update bumps the profile version. Transactions are serialized.
+func NewMemoryRepository(log *slog.Logger) Repository {
+	return &memoryRepository{
+		db: &memoryDB{
+			state: &memoryState{
+				users:          map[int]User{},
+				info:           map[int]memoryInformation{},
+				vehicles:       map[int]UserVehicle{},
+				addresses:      map[int]UserAddress{},
+				changes:        map[int]IdentityChange{},
+				history:        map[int]ProfileHistoryEntry{},
+				sessions:       map[string]Session{},
+				identities:     map[int]UserIdentity{},
+				oauthClients:   map[string]OAuthClient{},
+				oauthConsents:  map[memoryConsentKey]OAuthConsent{},
+				oauthCodes:     map[string]OAuthAuthorizationCode{},
+				oauthKeys:      map[string]OAuthSigningKey{},
+				apiKeys:        map[string]APIKey{},
+				magicLinks:     map[string]MagicLink{},
+				nextID:         1,
+				nextVehicleID:  1,
+				nextAddressID:  1,
+				nextChangeID:   1,
+				nextHistoryID:  1,
+				nextIdentityID: 1,
+			},
+		},
+		log: log,
+	}
+}
+
+// run gives fn the state to work on, locking the store unless the repository
+// is already bound to a transaction which holds the lock
+func (r *memoryRepository) run(fn func(state *memoryState) error) error {
+	if r.tx != nil {
+		return fn(r.tx)
+	}
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	return fn(r.db.state)
+}
+
+func (r *memoryRepository) WithTx(ctx context.Context, fn func(tx Repository) error) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	txRepo := &memoryRepository{db: r.db, tx: r.db.state.clone(), log: r.log}
+	if err := fn(txRepo); err != nil {
+		return err
+	}
+	r.db.state = txRepo.tx
+	return nil
+}
+
+func (r *memoryRepository) UserRegister(ctx context.Context, request UserRegisterRequest) (string, error) {
+	var userID string
+	err := r.run(func(state *memoryState) error {
+		if findByEmail(state, request.Email) != nil {
+			return ErrEmailTaken
+		}
+
+		id := state.nextID
+		state.nextID++
+		state.users[id] = User{
+			ID:                id,
+			FirstName:         request.FirstName,
+			LastName:          request.LastName,
+			Email:             request.Email,
+			Password:          request.Password,
+			DOB:               time.Time(request.DOB),
+			Gender:            request.Gender,
+			GenderDescription: request.GenderDescription,
+		}
+		userID = strconv.Itoa(id)
+		return nil
+	})
+	return userID, err
+}
+
+func (r *memoryRepository) GetUserProfile(ctx context.Context, email string) (*User, error) {
+	var user *User
+	err := r.run(func(state *memoryState) error {
+		u := findByEmail(state, email)
+		if u == nil {
+			return ErrUserNotFound
+		}
+		user = loadUser(state, u.ID)
+		return nil
+	})
+	return user, err
+}
+
+func (r *memoryRepository) FindUserByEmail(ctx context.Context, email string) (*User, error) {
+	var user *User
+	err := r.run(func(state *memoryState) error {
+		u := findByEmail(state, email)
+		if u == nil {
+			r.log.DebugContext(ctx, "[FindUserByEmail] no user found")
+			return ErrUserNotFound
+		}
+		// only the columns the SQL query selects
+		user = &User{ID: u.ID, Email: u.Email, Password: u.Password}
+		return nil
+	})
+	return user, err
+}
+
+func (r *memoryRepository) FindUserByID(ctx context.Context, userID string) (*User, error) {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[FindUserByID] invalid user id", "user_id", userID, "err", err)
+		return nil, err
+	}
+
+	var user *User
+	err = r.run(func(state *memoryState) error {
+		user = loadUser(state, idInt)
+		if user == nil {
+			return ErrUserNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (r *memoryRepository) FindUserByIDForUpdate(ctx context.Context, userID string) (*User, error) {
+	if r.tx == nil {
+		return nil, errors.New("FindUserByIDForUpdate must run inside WithTx")
+	}
+	// the transaction already holds the store lock
+	return r.FindUserByID(ctx, userID)
+}
+
+func (r *memoryRepository) UpdateUserInfo(ctx context.Context, request UserInformationRequest) (int, error) {
+	var version int
+	err := r.run(func(state *memoryState) error {
+		if _, ok := state.users[request.ID]; !ok {
+			// same as the foreign key on user_information.user_id
+			return ErrUserNotFound
+		}
+
+		info, exists := state.info[request.ID]
+		if !exists {
+			state.info[request.ID] = memoryInformation{version: 1}
+			version = 1
+			return nil
+		}
+
+		if request.Version != AnyVersion && request.Version != info.version {
+			return ErrVersionMismatch
+		}
+		info.version++
+		state.info[request.ID] = info
+		version = info.version
+		return nil
+	})
+	return version, err
+}
+
+func (r *memoryRepository) UpdatePassword(ctx context.Context, userID string, passwordHash string) error {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[UpdatePassword] invalid user id", "user_id", userID, "err", err)
+		return err
+	}
+
+	return r.run(func(state *memoryState) error {
+		u, ok := state.users[idInt]
+		if !ok {
+			return ErrUserNotFound
+		}
+		u.Password = passwordHash
+		state.users[idInt] = u
+		return nil
+	})
+}
+
+func (r *memoryRepository) ListVehicles(ctx context.Context, userID string) ([]UserVehicle, error) {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[ListVehicles] invalid user id", "user_id", userID, "err", err)
+		return nil, err
+	}
+
+	vehicles := []UserVehicle{}
+	err = r.run(func(state *memoryState) error {
+		for _, v := range state.vehicles {
+			if v.UserID == idInt {
+				vehicles = append(vehicles, *cloneVehicle(v))
+			}
+		}
+		return nil
+	})
+	sort.Slice(vehicles, func(i, j int) bool { return vehicles[i].ID < vehicles[j].ID })
+	return vehicles, err
+}
+
+func (r *memoryRepository) FindVehicle(ctx context.Context, userID string, vehicleID int) (*UserVehicle, error) {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[FindVehicle] invalid user id", "user_id", userID, "err", err)
+		return nil, err
+	}
+
+	var vehicle *UserVehicle
+	err = r.run(func(state *memoryState) error {
+		v, ok := state.vehicles[vehicleID]
+		if !ok || v.UserID != idInt {
+			return ErrVehicleNotFound
+		}
+		vehicle = cloneVehicle(v)
+		return nil
+	})
+	return vehicle, err
+}
+
+func (r *memoryRepository) CreateVehicle(ctx context.Context, vehicle UserVehicle) (*UserVehicle, error) {
+	var created *UserVehicle
+	err := r.run(func(state *memoryState) error {
+		if _, ok := state.users[vehicle.UserID]; !ok {
+			// same as the foreign key on user_vehicles.user_id
+			return ErrUserNotFound
+		}
+
+		now := time.Now().UTC()
+		vehicle.ID = state.nextVehicleID
+		vehicle.CreatedAt, vehicle.UpdatedAt = now, now
+		state.nextVehicleID++
+		state.vehicles[vehicle.ID] = *cloneVehicle(vehicle)
+		created = cloneVehicle(vehicle)
+		return nil
+	})
+	return created, err
+}
+
+func (r *memoryRepository) UpdateVehicle(ctx context.Context, vehicle UserVehicle) (*UserVehicle, error) {
+	var updated *UserVehicle
+	err := r.run(func(state *memoryState) error {
+		stored, ok := state.vehicles[vehicle.ID]
+		if !ok || stored.UserID != vehicle.UserID {
+			return ErrVehicleNotFound
+		}
+
+		vehicle.CreatedAt = stored.CreatedAt
+		vehicle.UpdatedAt = time.Now().UTC()
+		state.vehicles[vehicle.ID] = *cloneVehicle(vehicle)
+		updated = cloneVehicle(vehicle)
+		return nil
+	})
+	return updated, err
+}
+
+func (r *memoryRepository) DeleteVehicle(ctx context.Context, userID string, vehicleID int) error {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[DeleteVehicle] invalid user id", "user_id", userID, "err", err)
+		return err
+	}
+
+	return r.run(func(state *memoryState) error {
+		v, ok := state.vehicles[vehicleID]
+		if !ok || v.UserID != idInt {
+			return ErrVehicleNotFound
+		}
+		delete(state.vehicles, vehicleID)
+		return nil
+	})
+}
+
+func (r *memoryRepository) ListAddresses(ctx context.Context, userID string) ([]UserAddress, error) {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[ListAddresses] invalid user id", "user_id", userID, "err", err)
+		return nil, err
+	}
+
+	addresses := []UserAddress{}
+	err = r.run(func(state *memoryState) error {
+		for _, a := range state.addresses {
+			if a.UserID == idInt {
+				addresses = append(addresses, *cloneUserAddress(a))
+			}
+		}
+		return nil
+	})
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].ID < addresses[j].ID })
+	return addresses, err
+}
+
+func (r *memoryRepository) FindAddress(ctx context.Context, userID string, addressID int) (*UserAddress, error) {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[FindAddress] invalid user id", "user_id", userID, "err", err)
+		return nil, err
+	}
+
+	var address *UserAddress
+	err = r.run(func(state *memoryState) error {
+		a, ok := state.addresses[addressID]
+		if !ok || a.UserID != idInt {
+			return ErrAddressNotFound
+		}
+		address = cloneUserAddress(a)
+		return nil
+	})
+	return address, err
+}
+
+func (r *memoryRepository) CreateAddress(ctx context.Context, address UserAddress) (*UserAddress, error) {
+	var created *UserAddress
+	err := r.run(func(state *memoryState) error {
+		if _, ok := state.users[address.UserID]; !ok {
+			// same as the foreign key on user_addresses.user_id
+			return ErrUserNotFound
+		}
+		if address.IsPrimary && primaryAddress(state, address.UserID) != nil {
+			// same as the user_addresses_primary_key unique index
+			return ErrPrimaryAddressTaken
+		}
+
+		now := time.Now().UTC()
+		address.ID = state.nextAddressID
+		address.CreatedAt, address.UpdatedAt = now, now
+		state.nextAddressID++
+		state.addresses[address.ID] = *cloneUserAddress(address)
+		created = cloneUserAddress(address)
+		return nil
+	})
+	return created, err
+}
+
+func (r *memoryRepository) UpdateAddress(ctx context.Context, address UserAddress) (*UserAddress, error) {
+	var updated *UserAddress
+	err := r.run(func(state *memoryState) error {
+		stored, ok := state.addresses[address.ID]
+		if !ok || stored.UserID != address.UserID {
+			return ErrAddressNotFound
+		}
+		if primary := primaryAddress(state, address.UserID); address.IsPrimary && primary != nil && primary.ID != address.ID {
+			return ErrPrimaryAddressTaken
+		}
+
+		address.CreatedAt = stored.CreatedAt
+		address.UpdatedAt = time.Now().UTC()
+		state.addresses[address.ID] = *cloneUserAddress(address)
+		updated = cloneUserAddress(address)
+		return nil
+	})
+	return updated, err
+}
+
+func (r *memoryRepository) DeleteAddress(ctx context.Context, userID string, addressID int) error {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[DeleteAddress] invalid user id", "user_id", userID, "err", err)
+		return err
+	}
+
+	return r.run(func(state *memoryState) error {
+		a, ok := state.addresses[addressID]
+		if !ok || a.UserID != idInt {
+			return ErrAddressNotFound
+		}
+		delete(state.addresses, addressID)
+		return nil
+	})
+}
+
+func (r *memoryRepository) ClearPrimaryAddress(ctx context.Context, userID string) error {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[ClearPrimaryAddress] invalid user id", "user_id", userID, "err", err)
+		return err
+	}
+
+	return r.run(func(state *memoryState) error {
+		if primary := primaryAddress(state, idInt); primary != nil {
+			primary.IsPrimary = false
+			primary.UpdatedAt = time.Now().UTC()
+			state.addresses[primary.ID] = *primary
+		}
+		return nil
+	})
+}
+
+func (r *memoryRepository) UpdateUserIdentity(ctx context.Context, user User) error {
+	return r.run(func(state *memoryState) error {
+		u, ok := state.users[user.ID]
+		if !ok {
+			return ErrUserNotFound
+		}
+		u.FirstName, u.LastName, u.DOB, u.Gender = user.FirstName, user.LastName, user.DOB, user.Gender
+		u.GenderDescription = user.GenderDescription
+		state.users[user.ID] = u
+		return nil
+	})
+}
+
+func (r *memoryRepository) CreateIdentityChange(ctx context.Context, change IdentityChange) (*IdentityChange, error) {
+	var created *IdentityChange
+	err := r.run(func(state *memoryState) error {
+		if _, ok := state.users[change.UserID]; !ok {
+			// same as the foreign key on user_identity_changes.user_id
+			return ErrUserNotFound
+		}
+		if change.Status == IdentityChangePending {
+			for _, c := range state.changes {
+				// same as the user_identity_changes_pending_key unique index
+				if c.UserID == change.UserID && c.Field == change.Field && c.Status == IdentityChangePending {
+					return ErrIdentityChangePending
+				}
+			}
+		}
+
+		change.ID = state.nextChangeID
+		change.RequestedAt = time.Now().UTC()
+		state.nextChangeID++
+		state.changes[change.ID] = *cloneIdentityChange(change)
+		created = cloneIdentityChange(change)
+		return nil
+	})
+	return created, err
+}
+
+func (r *memoryRepository) ListIdentityChanges(ctx context.Context, filter IdentityChangeFilter) ([]IdentityChange, error) {
+	changes := []IdentityChange{}
+	err := r.run(func(state *memoryState) error {
+		for _, c := range state.changes {
+			if (filter.UserID == 0 || c.UserID == filter.UserID) && (filter.Status == "" || c.Status == filter.Status) {
+				changes = append(changes, *cloneIdentityChange(c))
+			}
+		}
+		return nil
+	})
+	sort.Slice(changes, func(i, j int) bool { return changes[i].ID < changes[j].ID })
+	return changes, err
+}
+
+func (r *memoryRepository) FindIdentityChange(ctx context.Context, changeID int) (*IdentityChange, error) {
+	var change *IdentityChange
+	err := r.run(func(state *memoryState) error {
+		c, ok := state.changes[changeID]
+		if !ok {
+			return ErrIdentityChangeNotFound
+		}
+		change = cloneIdentityChange(c)
+		return nil
+	})
+	return change, err
+}
+
+func (r *memoryRepository) DecideIdentityChange(ctx context.Context, changeID int, status IdentityChangeStatus, decidedBy string) (*IdentityChange, error) {
+	var decided *IdentityChange
+	err := r.run(func(state *memoryState) error {
+		c, ok := state.changes[changeID]
+		if !ok || c.Status != IdentityChangePending {
+			return ErrIdentityChangeDecided
+		}
+
+		now := time.Now().UTC()
+		c.Status, c.DecidedAt, c.DecidedBy = status, &now, decidedBy
+		state.changes[changeID] = c
+		decided = cloneIdentityChange(c)
+		return nil
+	})
+	return decided, err
+}
+
+func (r *memoryRepository) CreateProfileHistory(ctx context.Context, entry ProfileHistoryEntry) (*ProfileHistoryEntry, error) {
+	var created *ProfileHistoryEntry
+	err := r.run(func(state *memoryState) error {
+		if _, ok := state.users[entry.UserID]; !ok {
+			// same as the foreign key on user_profile_history.user_id
+			return ErrUserNotFound
+		}
+		for _, e := range state.history {
+			// same as the user_profile_history_version_key unique index
+			if e.UserID == entry.UserID && e.Version == entry.Version {
+				return fmt.Errorf("profile history of user %d already has version %d", entry.UserID, entry.Version)
+			}
+		}
+
+		entry.ID = state.nextHistoryID
+		entry.CreatedAt = time.Now().UTC()
+		state.nextHistoryID++
+		state.history[entry.ID] = *cloneProfileHistory(entry)
+		created = cloneProfileHistory(entry)
+		return nil
+	})
+	return created, err
+}
+
+func (r *memoryRepository) ListProfileHistory(ctx context.Context, userID int) ([]ProfileHistoryEntry, error) {
+	entries := []ProfileHistoryEntry{}
+	err := r.run(func(state *memoryState) error {
+		for _, e := range state.history {
+			if e.UserID == userID {
+				entries = append(entries, *cloneProfileHistory(e))
+			}
+		}
+		return nil
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Version > entries[j].Version })
+	return entries, err
+}
+
+func (r *memoryRepository) FindProfileHistory(ctx context.Context, userID, version int) (*ProfileHistoryEntry, error) {
+	var entry *ProfileHistoryEntry
+	err := r.run(func(state *memoryState) error {
+		for _, e := range state.history {
+			if e.UserID == userID && e.Version == version {
+				entry = cloneProfileHistory(e)
+				return nil
+			}
+		}
+		return ErrProfileVersionNotFound
+	})
+	return entry, err
+}
+
+func (r *memoryRepository) LastAuditEvent(ctx context.Context) (*AuditEvent, error) {
+	var last *AuditEvent
+	err := r.run(func(state *memoryState) error {
+		if n := len(state.auditEvents); n > 0 {
+			last = cloneAuditEvent(state.auditEvents[n-1])
+		}
+		return nil
+	})
+	return last, err
+}
+
+func (r *memoryRepository) CreateAuditEvent(ctx context.Context, event AuditEvent) (*AuditEvent, error) {
+	var created *AuditEvent
+	err := r.run(func(state *memoryState) error {
+		for _, e := range state.auditEvents {
+			// same as the audit_events_prev_hash_key unique index
+			if e.PrevHash == event.PrevHash {
+				return fmt.Errorf("audit event linking to %q already exists", event.PrevHash)
+			}
+		}
+
+		event.ID = len(state.auditEvents) + 1
+		state.auditEvents = append(state.auditEvents, *cloneAuditEvent(event))
+		created = cloneAuditEvent(event)
+		return nil
+	})
+	return created, err
+}
+
+func (r *memoryRepository) ListAuditEvents(ctx context.Context, filter AuditEventFilter) ([]AuditEvent, error) {
+	events := []AuditEvent{}
+	err := r.run(func(state *memoryState) error {
+		for _, e := range state.auditEvents {
+			if len(events) == filter.Limit {
+				break
+			}
+			if e.ID <= filter.AfterID ||
+				filter.Type != "" && e.Type != filter.Type ||
+				filter.Actor != "" && !strings.EqualFold(e.Actor, filter.Actor) ||
+				filter.UserID != 0 && e.UserID != filter.UserID ||
+				filter.From != nil && e.OccurredAt.Before(*filter.From) ||
+				filter.To != nil && !e.OccurredAt.Before(*filter.To) {
+				continue
+			}
+			events = append(events, *cloneAuditEvent(e))
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (r *memoryRepository) CreateSession(ctx context.Context, session Session) (*Session, error) {
+	var created *Session
+	err := r.run(func(state *memoryState) error {
+		if _, ok := state.users[session.UserID]; !ok {
+			// same as the foreign key on user_sessions.user_id
+			return ErrUserNotFound
+		}
+		if _, ok := state.sessions[session.ID]; ok {
+			return fmt.Errorf("session %q already exists", session.ID)
+		}
+
+		session.RevokedAt, session.Current = nil, false
+		state.sessions[session.ID] = session
+		created = cloneSession(session)
+		return nil
+	})
+	return created, err
+}
+
+func (r *memoryRepository) FindSession(ctx context.Context, sessionID string) (*Session, error) {
+	var session *Session
+	err := r.run(func(state *memoryState) error {
+		s, ok := state.sessions[sessionID]
+		if !ok {
+			return ErrSessionNotFound
+		}
+		session = cloneSession(s)
+		return nil
+	})
+	return session, err
+}
+
+func (r *memoryRepository) ListSessions(ctx context.Context, userID int) ([]Session, error) {
+	sessions := []Session{}
+	err := r.run(func(state *memoryState) error {
+		for _, s := range state.sessions {
+			if s.UserID == userID {
+				sessions = append(sessions, *cloneSession(s))
+			}
+		}
+		return nil
+	})
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].CreatedAt.Equal(sessions[j].CreatedAt) {
+			return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions, err
+}
+
+func (r *memoryRepository) RevokeSession(ctx context.Context, userID int, sessionID string) (*Session, error) {
+	var revoked *Session
+	err := r.run(func(state *memoryState) error {
+		s, ok := state.sessions[sessionID]
+		if !ok || s.UserID != userID || s.RevokedAt != nil {
+			return ErrSessionNotFound
+		}
+
+		now := time.Now().UTC()
+		s.RevokedAt = &now
+		state.sessions[sessionID] = s
+		revoked = cloneSession(s)
+		return nil
+	})
+	return revoked, err
+}
+
+func (r *memoryRepository) CreateIdentity(ctx context.Context, identity UserIdentity) (*UserIdentity, error) {
+	var created *UserIdentity
+	err := r.run(func(state *memoryState) error {
+		if _, ok := state.users[identity.UserID]; !ok {
+			// same as the foreign key on user_identities.user_id
+			return ErrUserNotFound
+		}
+		for _, i := range state.identities {
+			// same as the user_identities_subject_key and user_identities_provider_key unique constraints
+			if i.Provider == identity.Provider && (i.Subject == identity.Subject || i.UserID == identity.UserID) {
+				return ErrIdentityLinked
+			}
+		}
+
+		identity.ID = state.nextIdentityID
+		identity.CreatedAt = time.Now().UTC()
+		state.nextIdentityID++
+		state.identities[identity.ID] = identity
+		created = &identity
+		return nil
+	})
+	return created, err
+}
+
+func (r *memoryRepository) FindIdentity(ctx context.Context, provider, subject string) (*UserIdentity, error) {
+	var identity *UserIdentity
+	err := r.run(func(state *memoryState) error {
+		for _, i := range state.identities {
+			if i.Provider == provider && i.Subject == subject {
+				identity = &i
+				return nil
+			}
+		}
+		return ErrIdentityNotFound
+	})
+	return identity, err
+}
+
+func (r *memoryRepository) ListIdentities(ctx context.Context, userID int) ([]UserIdentity, error) {
+	identities := []UserIdentity{}
+	err := r.run(func(state *memoryState) error {
+		for _, i := range state.identities {
+			if i.UserID == userID {
+				identities = append(identities, i)
+			}
+		}
+		return nil
+	})
+	sort.Slice(identities, func(i, j int) bool { return identities[i].ID < identities[j].ID })
+	return identities, err
+}
+
+func (r *memoryRepository) DeleteIdentity(ctx context.Context, userID int, provider string) error {
+	return r.run(func(state *memoryState) error {
+		for id, i := range state.identities {
+			if i.UserID == userID && i.Provider == provider {
+				delete(state.identities, id)
+				return nil
+			}
+		}
+		return ErrIdentityNotFound
+	})
+}
+
+func (r *memoryRepository) CreateOAuthClient(ctx context.Context, client OAuthClient) (*OAuthClient, error) {
+	var created *OAuthClient
+	err := r.run(func(state *memoryState) error {
+		if _, ok := state.oauthClients[client.ID]; ok {
+			return fmt.Errorf("OAuth client %q already exists", client.ID)
+		}
+
+		client.CreatedAt = time.Now().UTC()
+		client.RedirectURIs, client.Scopes = slices.Clone(client.RedirectURIs), slices.Clone(client.Scopes)
+		state.oauthClients[client.ID] = client
+		created = cloneOAuthClient(client)
+		return nil
+	})
+	return created, err
+}
+
+func (r *memoryRepository) FindOAuthClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	var client *OAuthClient
+	err := r.run(func(state *memoryState) error {
+		c, ok := state.oauthClients[clientID]
+		if !ok {
+			return ErrOAuthClientNotFound
+		}
+		client = cloneOAuthClient(c)
+		return nil
+	})
+	return client, err
+}
+
+func (r *memoryRepository) ListOAuthClients(ctx context.Context) ([]OAuthClient, error) {
+	clients := []OAuthClient{}
+	err := r.run(func(state *memoryState) error {
+		for _, c := range state.oauthClients {
+			clients = append(clients, *cloneOAuthClient(c))
+		}
+		return nil
+	})
+	sort.Slice(clients, func(i, j int) bool {
+		if !clients[i].CreatedAt.Equal(clients[j].CreatedAt) {
+			return clients[i].CreatedAt.Before(clients[j].CreatedAt)
+		}
+		return clients[i].ID < clients[j].ID
+	})
+	return clients, err
+}
+
+func (r *memoryRepository) DeleteOAuthClient(ctx context.Context, clientID string) error {
+	return r.run(func(state *memoryState) error {
+		if _, ok := state.oauthClients[clientID]; !ok {
+			return ErrOAuthClientNotFound
+		}
+		delete(state.oauthClients, clientID)
+		// same as the ON DELETE CASCADE of the consents and codes
+		maps.DeleteFunc(state.oauthConsents, func(k memoryConsentKey, _ OAuthConsent) bool { return k.clientID == clientID })
+		maps.DeleteFunc(state.oauthCodes, func(_ string, c OAuthAuthorizationCode) bool { return c.ClientID == clientID })
+		return nil
+	})
+}
+
+func (r *memoryRepository) SaveOAuthConsent(ctx context.Context, consent OAuthConsent) error {
+	return r.run(func(state *memoryState) error {
+		// same as the foreign keys on oauth_consents
+		if _, ok := state.users[consent.UserID]; !ok {
+			return ErrUserNotFound
+		}
+		if _, ok := state.oauthClients[consent.ClientID]; !ok {
+			return ErrOAuthClientNotFound
+		}
+		consent.Scopes = slices.Clone(consent.Scopes)
+		state.oauthConsents[memoryConsentKey{consent.UserID, consent.ClientID}] = consent
+		return nil
+	})
+}
+
+func (r *memoryRepository) FindOAuthConsent(ctx context.Context, userID int, clientID string) (*OAuthConsent, error) {
+	var consent *OAuthConsent
+	err := r.run(func(state *memoryState) error {
+		c, ok := state.oauthConsents[memoryConsentKey{userID, clientID}]
+		if !ok {
+			return ErrOAuthConsentNotFound
+		}
+		c.Scopes = slices.Clone(c.Scopes)
+		consent = &c
+		return nil
+	})
+	return consent, err
+}
+
+func (r *memoryRepository) CreateAuthorizationCode(ctx context.Context, code OAuthAuthorizationCode) error {
+	return r.run(func(state *memoryState) error {
+		// same as the foreign keys on oauth_authorization_codes
+		if _, ok := state.users[code.UserID]; !ok {
+			return ErrUserNotFound
+		}
+		if _, ok := state.oauthClients[code.ClientID]; !ok {
+			return ErrOAuthClientNotFound
+		}
+		if _, ok := state.oauthCodes[code.CodeHash]; ok {
+			return errors.New("authorization code already exists")
+		}
+		code.Scopes, code.UsedAt = slices.Clone(code.Scopes), nil
+		state.oauthCodes[code.CodeHash] = code
+		return nil
+	})
+}
+
+func (r *memoryRepository) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*OAuthAuthorizationCode, error) {
+	var consumed *OAuthAuthorizationCode
+	err := r.run(func(state *memoryState) error {
+		c, ok := state.oauthCodes[codeHash]
+		if !ok || c.UsedAt != nil {
+			return ErrAuthorizationCodeNotFound
+		}
+
+		now := time.Now().UTC()
+		c.UsedAt = &now
+		state.oauthCodes[codeHash] = c
+		c.Scopes = slices.Clone(c.Scopes)
+		consumed = &c
+		return nil
+	})
+	return consumed, err
+}
+
+func (r *memoryRepository) CreateSigningKey(ctx context.Context, key OAuthSigningKey) error {
+	return r.run(func(state *memoryState) error {
+		if _, ok := state.oauthKeys[key.ID]; ok {
+			return fmt.Errorf("signing key %q already exists", key.ID)
+		}
+		state.oauthKeys[key.ID] = key
+		return nil
+	})
+}
+
+func (r *memoryRepository) ListSigningKeys(ctx context.Context, since time.Time) ([]OAuthSigningKey, error) {
+	keys := []OAuthSigningKey{}
+	err := r.run(func(state *memoryState) error {
+		for _, k := range state.oauthKeys {
+			if k.CreatedAt.After(since) {
+				keys = append(keys, k)
+			}
+		}
+		return nil
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.After(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, err
+}
+
+func (r *memoryRepository) CreateAPIKey(ctx context.Context, key APIKey) (*APIKey, error) {
+	var created *APIKey
+	err := r.run(func(state *memoryState) error {
+		if _, ok := state.users[key.UserID]; !ok {
+			// same as the foreign key on api_keys.user_id
+			return ErrUserNotFound
+		}
+		if _, ok := state.apiKeys[key.ID]; ok {
+			return fmt.Errorf("API key %q already exists", key.ID)
+		}
+
+		key.Scopes, key.LastUsedAt = slices.Clone(key.Scopes), nil
+		state.apiKeys[key.ID] = key
+		created = cloneAPIKey(key)
+		return nil
+	})
+	return created, err
+}
+
+func (r *memoryRepository) FindAPIKey(ctx context.Context, keyID string) (*APIKey, error) {
+	var key *APIKey
+	err := r.run(func(state *memoryState) error {
+		k, ok := state.apiKeys[keyID]
+		if !ok {
+			return ErrAPIKeyNotFound
+		}
+		key = cloneAPIKey(k)
+		return nil
+	})
+	return key, err
+}
+
+func (r *memoryRepository) ListAPIKeys(ctx context.Context, userID int) ([]APIKey, error) {
+	keys := []APIKey{}
+	err := r.run(func(state *memoryState) error {
+		for _, k := range state.apiKeys {
+			if k.UserID == userID {
+				keys = append(keys, *cloneAPIKey(k))
+			}
+		}
+		return nil
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.After(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, err
+}
+
+func (r *memoryRepository) DeleteAPIKey(ctx context.Context, userID int, keyID string) error {
+	return r.run(func(state *memoryState) error {
+		if k, ok := state.apiKeys[keyID]; !ok || k.UserID != userID {
+			return ErrAPIKeyNotFound
+		}
+		delete(state.apiKeys, keyID)
+		return nil
+	})
+}
+
+func (r *memoryRepository) TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) error {
+	return r.run(func(state *memoryState) error {
+		k, ok := state.apiKeys[keyID]
+		if !ok {
+			// like an UPDATE matching no row
+			return nil
+		}
+		usedAt = usedAt.UTC()
+		k.LastUsedAt = &usedAt
+		state.apiKeys[keyID] = k
+		return nil
+	})
+}
+
+func (r *memoryRepository) CreateMagicLink(ctx context.Context, link MagicLink) error {
+	return r.run(func(state *memoryState) error {
+		if _, ok := state.users[link.UserID]; !ok {
+			// same as the foreign key on magic_links.user_id
+			return ErrUserNotFound
+		}
+		if _, ok := state.magicLinks[link.ID]; ok {
+			return fmt.Errorf("magic link %q already exists", link.ID)
+		}
+		link.UsedAt = nil
+		state.magicLinks[link.ID] = link
+		return nil
+	})
+}
+
+func (r *memoryRepository) CountMagicLinks(ctx context.Context, userID int, since time.Time) (int, error) {
+	count := 0
+	err := r.run(func(state *memoryState) error {
+		for _, l := range state.magicLinks {
+			if l.UserID == userID && l.CreatedAt.After(since) {
+				count++
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+func (r *memoryRepository) ConsumeMagicLink(ctx context.Context, linkID string) (*MagicLink, error) {
+	var consumed *MagicLink
+	err := r.run(func(state *memoryState) error {
+		l, ok := state.magicLinks[linkID]
+		if !ok || l.UsedAt != nil {
+			return ErrMagicLinkNotFound
+		}
+
+		now := time.Now().UTC()
+		l.UsedAt = &now
+		state.magicLinks[linkID] = l
+		consumed = &l
+		return nil
+	})
+	return consumed, err
+}
+
+func cloneOAuthClient(c OAuthClient) *OAuthClient {
+	c.RedirectURIs, c.Scopes = slices.Clone(c.RedirectURIs), slices.Clone(c.Scopes)
+	return &c
+}
+
+// loadUser returns a copy of the user joined with its user information
+func loadUser(state *memoryState, id int) *User {
+	u, ok := state.users[id]
+	if !ok {
+		return nil
+	}
+	u.Version = state.info[id].version
+	if primary := primaryAddress(state, id); primary != nil {
+		u.Address = *cloneAddress(&primary.Address)
+	}
+	return &u
+}
+
+func primaryAddress(state *memoryState, userID int) *UserAddress {
+	for _, a := range state.addresses {
+		if a.UserID == userID && a.IsPrimary {
+			return &a
+		}
+	}
+	return nil
+}
+
+func findByEmail(state *memoryState, email string) *User {
+	for _, u := range state.users {
+		if strings.EqualFold(u.Email, email) {
+			return &u
+		}
+	}
+	return nil
+}
+
+// cloneAddress deep copies an address so callers can't mutate stored data
+func cloneAddress(a *Address) *Address {
+	if a == nil {
+		return nil
+	}
+	return &Address{
+		City:       clonePtr(a.City),
+		State:      clonePtr(a.State),
+		PostalCode: clonePtr(a.PostalCode),
+		Country:    clonePtr(a.Country),
+	}
+}
+
+// cloneVehicle deep copies the ownership dates of a vehicle
+func cloneVehicle(v UserVehicle) *UserVehicle {
+	v.OwnedFrom = clonePtr(v.OwnedFrom)
+	v.OwnedUntil = clonePtr(v.OwnedUntil)
+	return &v
+}
+
+// cloneUserAddress deep copies the address fields and validity dates
+func cloneUserAddress(a UserAddress) *UserAddress {
+	a.Address = *cloneAddress(&a.Address)
+	a.ValidFrom = clonePtr(a.ValidFrom)
+	a.ValidUntil = clonePtr(a.ValidUntil)
+	return &a
+}
+
+// cloneIdentityChange deep copies the decision time of a change
+func cloneIdentityChange(c IdentityChange) *IdentityChange {
+	c.DecidedAt = clonePtr(c.DecidedAt)
+	return &c
+}
+
+func cloneProfileHistory(e ProfileHistoryEntry) *ProfileHistoryEntry {
+	e.RevertedFrom = clonePtr(e.RevertedFrom)
+	e.Changes = append([]ProfileChange(nil), e.Changes...)
+	e.Profile.Address = *cloneAddress(&e.Profile.Address)
+	return &e
+}
+
+// cloneSession deep copies the revocation time of a session
+func cloneSession(s Session) *Session {
+	s.RevokedAt = clonePtr(s.RevokedAt)
+	return &s
+}
+
+func cloneAuditEvent(e AuditEvent) *AuditEvent {
+	e.Details = maps.Clone(e.Details)
+	return &e
+}
+
+func cloneAPIKey(k APIKey) *APIKey {
+	k.Scopes, k.LastUsedAt = slices.Clone(k.Scopes), clonePtr(k.LastUsedAt)
+	return &k
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
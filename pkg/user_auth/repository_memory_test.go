@@ -0,0 +1,17 @@
+package userauth_test
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	userauth "github.com/abhiii71/clean-code-abhi/pkg/user_auth"
+	"github.com/abhiii71/clean-code-abhi/pkg/user_auth/contracttest"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	contracttest.RunRepository(t, func(t *testing.T) userauth.Repository {
+		return userauth.NewMemoryRepository(log)
+	})
+}
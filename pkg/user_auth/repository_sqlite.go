@@ -0,0 +1,1278 @@
+package userauth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqlDBTX is the subset of *sql.DB and *sql.Tx used by the SQLite repository
+type sqlDBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type sqliteRepository struct {
+	db   sqlDBTX
+	conn *sql.DB // nil when the repository is bound to a transaction
+	log  *slog.Logger
+}
+
+// NewSQLiteRepository returns a Repository backed by SQLite. There are no row
+// locks, SQLite allows a single writer at a time which serializes updates the
+// same way.
+func NewSQLiteRepository(db *sql.DB, log *slog.Logger) Repository {
+	return &sqliteRepository{
+		db:   db,
+		conn: db,
+		log:  log,
+	}
+}
+
+func (r *sqliteRepository) WithTx(ctx context.Context, fn func(tx Repository) error) error {
+	if r.conn == nil {
+		return fn(r)
+	}
+
+	ctx, span := tracer.Start(ctx, "Repository.WithTx")
+	defer span.End()
+
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+
+	if err := fn(&sqliteRepository{db: tx, log: r.log}); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			r.log.ErrorContext(ctx, "[WithTx] rollback failed", "err", rbErr)
+		}
+		recordError(span, err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		recordError(span, err)
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (r *sqliteRepository) UserRegister(ctx context.Context, request UserRegisterRequest) (string, error) {
+	query := `INSERT INTO users
+    (first_name, last_name, email, password, dob, gender, gender_description)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)
+    RETURNING id`
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "UserRegister", query)
+	defer span.End()
+
+	var userID int
+	err := r.db.QueryRowContext(
+		ctx,
+		query,
+		request.FirstName,
+		request.LastName,
+		request.Email,
+		request.Password,
+		time.Time(request.DOB),
+		request.Gender,
+		request.GenderDescription,
+	).Scan(&userID)
+
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[UserRegister] error inserting user", "err", err)
+		return "", mapSQLiteError(err)
+	}
+	return strconv.Itoa(userID), nil
+}
+
+func (r *sqliteRepository) UpdateUserInfo(ctx context.Context, request UserInformationRequest) (int, error) {
+	query := `
+        INSERT INTO user_information (user_id, version, created_at, updated_at)
+        VALUES ($1, 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+        ON CONFLICT (user_id) DO UPDATE
+        SET
+            version = user_information.version + 1,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE $2 = -1 OR user_information.version = $2
+        RETURNING version
+    `
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "UpdateUserInfo", query)
+	defer span.End()
+
+	var version int
+	err := r.db.QueryRowContext(ctx, query, request.ID, request.Version).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the conflicting row exists but its version did not match
+		return 0, ErrVersionMismatch
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[UpdateUserInfo] error executing query", "user_id", request.ID, "err", err)
+		return 0, mapSQLiteError(err)
+	}
+	return version, nil
+}
+
+func (r *sqliteRepository) UpdatePassword(ctx context.Context, userID string, passwordHash string) error {
+	query := "UPDATE users SET password = $2 WHERE id = $1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "UpdatePassword", query)
+	defer span.End()
+
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[UpdatePassword] invalid user id", "user_id", userID, "err", err)
+		return err
+	}
+
+	result, err := r.db.ExecContext(ctx, query, idInt, passwordHash)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[UpdatePassword] error executing query", "user_id", userID, "err", err)
+		return mapSQLiteError(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *sqliteRepository) FindUserByEmail(ctx context.Context, email string) (*User, error) {
+	var user User
+	query := "SELECT id, email, password FROM users WHERE LOWER(email) = $1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "FindUserByEmail", query)
+	defer span.End()
+
+	err := r.db.QueryRowContext(ctx, query, strings.ToLower(email)).Scan(&user.ID, &user.Email, &user.Password)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			r.log.DebugContext(ctx, "[FindUserByEmail] no user found")
+			return nil, ErrUserNotFound
+		}
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindUserByEmail] db error", "err", err)
+		return nil, errors.New("internal database error")
+	}
+	return &user, nil
+}
+
+func (r *sqliteRepository) FindUserByID(ctx context.Context, userID string) (*User, error) {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[FindUserByID] invalid user id", "user_id", userID, "err", err)
+		return nil, err
+	}
+	return r.findUser(ctx, "FindUserByID", "u.id = $1", idInt)
+}
+
+func (r *sqliteRepository) FindUserByIDForUpdate(ctx context.Context, userID string) (*User, error) {
+	if r.conn != nil {
+		return nil, errors.New("FindUserByIDForUpdate must run inside WithTx")
+	}
+	return r.FindUserByID(ctx, userID)
+}
+
+func (r *sqliteRepository) GetUserProfile(ctx context.Context, email string) (*User, error) {
+	return r.findUser(ctx, "GetUserProfile", "LOWER(u.email) = $1", strings.ToLower(email))
+}
+
+func (r *sqliteRepository) findUser(ctx context.Context, operation, where string, arg any) (*User, error) {
+	var user User
+
+	query := `
+    SELECT
+      u.id, u.email, u.password, u.dob, u.first_name, u.last_name, u.gender, u.gender_description,
+      ua.city, ua.state, ua.postal_code, ua.country, COALESCE(ui.version, 0)
+    FROM users u
+    LEFT JOIN user_information ui ON u.id = ui.user_id
+    LEFT JOIN user_addresses ua ON u.id = ua.user_id AND ua.is_primary
+    WHERE ` + where
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, operation, query)
+	defer span.End()
+
+	err := r.db.QueryRowContext(ctx, query, arg).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Password,
+		&user.DOB,
+		&user.FirstName,
+		&user.LastName,
+		&user.Gender,
+		&user.GenderDescription,
+		&user.Address.City,
+		&user.Address.State,
+		&user.Address.PostalCode,
+		&user.Address.Country,
+		&user.Version,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "["+operation+"] error scanning user", "err", err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *sqliteRepository) ListAddresses(ctx context.Context, userID string) ([]UserAddress, error) {
+	query := "SELECT " + addressColumns + " FROM user_addresses WHERE user_id = $1 ORDER BY id"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "ListAddresses", query)
+	defer span.End()
+
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[ListAddresses] invalid user id", "user_id", userID, "err", err)
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, idInt)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListAddresses] error executing query", "user_id", userID, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := []UserAddress{}
+	for rows.Next() {
+		address, err := scanAddress(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		addresses = append(addresses, *address)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return addresses, nil
+}
+
+func (r *sqliteRepository) FindAddress(ctx context.Context, userID string, addressID int) (*UserAddress, error) {
+	query := "SELECT " + addressColumns + " FROM user_addresses WHERE id = $1 AND user_id = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "FindAddress", query)
+	defer span.End()
+
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[FindAddress] invalid user id", "user_id", userID, "err", err)
+		return nil, err
+	}
+
+	address, err := scanAddress(r.db.QueryRowContext(ctx, query, addressID, idInt))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAddressNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindAddress] error scanning address", "address_id", addressID, "err", err)
+		return nil, err
+	}
+	return address, nil
+}
+
+func (r *sqliteRepository) CreateAddress(ctx context.Context, address UserAddress) (*UserAddress, error) {
+	query := `INSERT INTO user_addresses
+    (user_id, type, city, state, postal_code, country, valid_from, valid_until, is_primary, created_at, updated_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+    RETURNING ` + addressColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "CreateAddress", query)
+	defer span.End()
+
+	created, err := scanAddress(r.db.QueryRowContext(ctx, query, addressArgs(address)...))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateAddress] error inserting address", "user_id", address.UserID, "err", err)
+		return nil, mapSQLiteError(err)
+	}
+	return created, nil
+}
+
+func (r *sqliteRepository) UpdateAddress(ctx context.Context, address UserAddress) (*UserAddress, error) {
+	query := `UPDATE user_addresses
+    SET type = $2, city = $3, state = $4, postal_code = $5, country = $6,
+        valid_from = $7, valid_until = $8, is_primary = $9, updated_at = CURRENT_TIMESTAMP
+    WHERE user_id = $1 AND id = $10
+    RETURNING ` + addressColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "UpdateAddress", query)
+	defer span.End()
+
+	updated, err := scanAddress(r.db.QueryRowContext(ctx, query, append(addressArgs(address), address.ID)...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAddressNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[UpdateAddress] error updating address", "address_id", address.ID, "err", err)
+		return nil, mapSQLiteError(err)
+	}
+	return updated, nil
+}
+
+func (r *sqliteRepository) DeleteAddress(ctx context.Context, userID string, addressID int) error {
+	query := "DELETE FROM user_addresses WHERE id = $1 AND user_id = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "DeleteAddress", query)
+	defer span.End()
+
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[DeleteAddress] invalid user id", "user_id", userID, "err", err)
+		return err
+	}
+
+	result, err := r.db.ExecContext(ctx, query, addressID, idInt)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[DeleteAddress] error executing query", "address_id", addressID, "err", err)
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAddressNotFound
+	}
+	return nil
+}
+
+func (r *sqliteRepository) ClearPrimaryAddress(ctx context.Context, userID string) error {
+	query := "UPDATE user_addresses SET is_primary = FALSE, updated_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND is_primary"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "ClearPrimaryAddress", query)
+	defer span.End()
+
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[ClearPrimaryAddress] invalid user id", "user_id", userID, "err", err)
+		return err
+	}
+
+	if _, err := r.db.ExecContext(ctx, query, idInt); err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ClearPrimaryAddress] error executing query", "user_id", userID, "err", err)
+		return err
+	}
+	return nil
+}
+
+func (r *sqliteRepository) UpdateUserIdentity(ctx context.Context, user User) error {
+	query := "UPDATE users SET first_name = $2, last_name = $3, dob = $4, gender = $5, gender_description = $6, updated_at = CURRENT_TIMESTAMP WHERE id = $1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "UpdateUserIdentity", query)
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, query, user.ID, user.FirstName, user.LastName, user.DOB, user.Gender, user.GenderDescription)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[UpdateUserIdentity] error executing query", "user_id", user.ID, "err", err)
+		return mapSQLiteError(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *sqliteRepository) CreateIdentityChange(ctx context.Context, change IdentityChange) (*IdentityChange, error) {
+	query := `INSERT INTO user_identity_changes
+    (user_id, field, old_value, new_value, status, requested_at, decided_at, decided_by)
+    VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, $6, $7)
+    RETURNING ` + identityChangeColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "CreateIdentityChange", query)
+	defer span.End()
+
+	created, err := scanIdentityChange(r.db.QueryRowContext(ctx, query,
+		change.UserID, change.Field, change.OldValue, change.NewValue, change.Status, change.DecidedAt, nullIfZero(change.DecidedBy)))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateIdentityChange] error inserting identity change", "user_id", change.UserID, "err", err)
+		return nil, mapSQLiteError(err)
+	}
+	return created, nil
+}
+
+func (r *sqliteRepository) ListIdentityChanges(ctx context.Context, filter IdentityChangeFilter) ([]IdentityChange, error) {
+	query := "SELECT " + identityChangeColumns + ` FROM user_identity_changes
+    WHERE ($1 = 0 OR user_id = $1) AND ($2 = '' OR status = $2)
+    ORDER BY id`
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "ListIdentityChanges", query)
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, query, filter.UserID, string(filter.Status))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListIdentityChanges] error executing query", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []IdentityChange{}
+	for rows.Next() {
+		change, err := scanIdentityChange(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		changes = append(changes, *change)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return changes, nil
+}
+
+func (r *sqliteRepository) FindIdentityChange(ctx context.Context, changeID int) (*IdentityChange, error) {
+	query := "SELECT " + identityChangeColumns + " FROM user_identity_changes WHERE id = $1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "FindIdentityChange", query)
+	defer span.End()
+
+	change, err := scanIdentityChange(r.db.QueryRowContext(ctx, query, changeID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrIdentityChangeNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindIdentityChange] error scanning identity change", "change_id", changeID, "err", err)
+		return nil, err
+	}
+	return change, nil
+}
+
+func (r *sqliteRepository) DecideIdentityChange(ctx context.Context, changeID int, status IdentityChangeStatus, decidedBy string) (*IdentityChange, error) {
+	query := `UPDATE user_identity_changes
+    SET status = $2, decided_at = CURRENT_TIMESTAMP, decided_by = $3
+    WHERE id = $1 AND status = 'pending'
+    RETURNING ` + identityChangeColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "DecideIdentityChange", query)
+	defer span.End()
+
+	change, err := scanIdentityChange(r.db.QueryRowContext(ctx, query, changeID, status, decidedBy))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrIdentityChangeDecided
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[DecideIdentityChange] error updating identity change", "change_id", changeID, "err", err)
+		return nil, err
+	}
+	return change, nil
+}
+
+func (r *sqliteRepository) CreateProfileHistory(ctx context.Context, entry ProfileHistoryEntry) (*ProfileHistoryEntry, error) {
+	query := `INSERT INTO user_profile_history
+    (user_id, version, actor, request_id, reverted_from, changes, profile)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)
+    RETURNING ` + profileHistoryColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "CreateProfileHistory", query)
+	defer span.End()
+
+	args, err := profileHistoryArgs(entry)
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	created, err := scanProfileHistory(r.db.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateProfileHistory] error inserting history entry", "user_id", entry.UserID, "err", err)
+		return nil, mapSQLiteError(err)
+	}
+	return created, nil
+}
+
+func (r *sqliteRepository) ListProfileHistory(ctx context.Context, userID int) ([]ProfileHistoryEntry, error) {
+	query := "SELECT " + profileHistoryColumns + " FROM user_profile_history WHERE user_id = $1 ORDER BY version DESC"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "ListProfileHistory", query)
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListProfileHistory] error executing query", "user_id", userID, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []ProfileHistoryEntry{}
+	for rows.Next() {
+		entry, err := scanProfileHistory(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (r *sqliteRepository) FindProfileHistory(ctx context.Context, userID, version int) (*ProfileHistoryEntry, error) {
+	query := "SELECT " + profileHistoryColumns + " FROM user_profile_history WHERE user_id = $1 AND version = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "FindProfileHistory", query)
+	defer span.End()
+
+	entry, err := scanProfileHistory(r.db.QueryRowContext(ctx, query, userID, version))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProfileVersionNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindProfileHistory] error scanning history entry", "user_id", userID, "version", version, "err", err)
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (r *sqliteRepository) LastAuditEvent(ctx context.Context) (*AuditEvent, error) {
+	// the single connection already serializes the appends
+	query := "SELECT " + auditEventColumns + " FROM audit_events ORDER BY id DESC LIMIT 1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "LastAuditEvent", query)
+	defer span.End()
+
+	event, err := scanAuditEvent(r.db.QueryRowContext(ctx, query))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[LastAuditEvent] error scanning audit event", "err", err)
+		return nil, err
+	}
+	return event, nil
+}
+
+func (r *sqliteRepository) CreateAuditEvent(ctx context.Context, event AuditEvent) (*AuditEvent, error) {
+	query := `INSERT INTO audit_events
+    (occurred_at, type, actor, user_id, ip, request_id, details, prev_hash, hash)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+    RETURNING ` + auditEventColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "CreateAuditEvent", query)
+	defer span.End()
+
+	args, err := auditEventArgs(event)
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	created, err := scanAuditEvent(r.db.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateAuditEvent] error inserting audit event", "type", event.Type, "err", err)
+		return nil, mapSQLiteError(err)
+	}
+	return created, nil
+}
+
+func (r *sqliteRepository) ListAuditEvents(ctx context.Context, filter AuditEventFilter) ([]AuditEvent, error) {
+	query := "SELECT " + auditEventColumns + " FROM audit_events WHERE " + auditEventWhere + " ORDER BY id LIMIT $7"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "ListAuditEvents", query)
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, query, auditEventFilterArgs(filter)...)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListAuditEvents] error executing query", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return events, nil
+}
+
+func (r *sqliteRepository) CreateSession(ctx context.Context, session Session) (*Session, error) {
+	query := `INSERT INTO user_sessions
+    (id, user_id, created_at, expires_at, ip, user_agent, device)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)
+    RETURNING ` + sessionColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "CreateSession", query)
+	defer span.End()
+
+	created, err := scanSession(r.db.QueryRowContext(ctx, query, sessionArgs(session)...))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateSession] error inserting session", "user_id", session.UserID, "err", err)
+		return nil, mapSQLiteError(err)
+	}
+	return created, nil
+}
+
+func (r *sqliteRepository) FindSession(ctx context.Context, sessionID string) (*Session, error) {
+	query := "SELECT " + sessionColumns + " FROM user_sessions WHERE id = $1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "FindSession", query)
+	defer span.End()
+
+	session, err := scanSession(r.db.QueryRowContext(ctx, query, sessionID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindSession] error scanning session", "err", err)
+		return nil, err
+	}
+	return session, nil
+}
+
+func (r *sqliteRepository) ListSessions(ctx context.Context, userID int) ([]Session, error) {
+	query := "SELECT " + sessionColumns + " FROM user_sessions WHERE user_id = $1 ORDER BY created_at DESC, id"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "ListSessions", query)
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListSessions] error executing query", "user_id", userID, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (r *sqliteRepository) RevokeSession(ctx context.Context, userID int, sessionID string) (*Session, error) {
+	query := `UPDATE user_sessions SET revoked_at = CURRENT_TIMESTAMP
+    WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+    RETURNING ` + sessionColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "RevokeSession", query)
+	defer span.End()
+
+	session, err := scanSession(r.db.QueryRowContext(ctx, query, sessionID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[RevokeSession] error executing query", "user_id", userID, "err", err)
+		return nil, err
+	}
+	return session, nil
+}
+
+func (r *sqliteRepository) CreateIdentity(ctx context.Context, identity UserIdentity) (*UserIdentity, error) {
+	query := `INSERT INTO user_identities (user_id, provider, subject, email)
+    VALUES ($1, $2, $3, $4)
+    RETURNING ` + identityColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "CreateIdentity", query)
+	defer span.End()
+
+	created, err := scanIdentity(r.db.QueryRowContext(ctx, query, identity.UserID, identity.Provider, identity.Subject, nullIfZero(identity.Email)))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateIdentity] error inserting identity", "user_id", identity.UserID, "provider", identity.Provider, "err", err)
+		return nil, mapSQLiteError(err)
+	}
+	return created, nil
+}
+
+func (r *sqliteRepository) FindIdentity(ctx context.Context, provider, subject string) (*UserIdentity, error) {
+	query := "SELECT " + identityColumns + " FROM user_identities WHERE provider = $1 AND subject = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "FindIdentity", query)
+	defer span.End()
+
+	identity, err := scanIdentity(r.db.QueryRowContext(ctx, query, provider, subject))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrIdentityNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindIdentity] error scanning identity", "provider", provider, "err", err)
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (r *sqliteRepository) ListIdentities(ctx context.Context, userID int) ([]UserIdentity, error) {
+	query := "SELECT " + identityColumns + " FROM user_identities WHERE user_id = $1 ORDER BY id"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "ListIdentities", query)
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListIdentities] error executing query", "user_id", userID, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []UserIdentity{}
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		identities = append(identities, *identity)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (r *sqliteRepository) DeleteIdentity(ctx context.Context, userID int, provider string) error {
+	query := "DELETE FROM user_identities WHERE user_id = $1 AND provider = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "DeleteIdentity", query)
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, query, userID, provider)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[DeleteIdentity] error executing query", "user_id", userID, "provider", provider, "err", err)
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
+
+func (r *sqliteRepository) ListVehicles(ctx context.Context, userID string) ([]UserVehicle, error) {
+	query := "SELECT " + vehicleColumns + " FROM user_vehicles WHERE user_id = $1 ORDER BY id"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "ListVehicles", query)
+	defer span.End()
+
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[ListVehicles] invalid user id", "user_id", userID, "err", err)
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, idInt)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListVehicles] error executing query", "user_id", userID, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	vehicles := []UserVehicle{}
+	for rows.Next() {
+		vehicle, err := scanVehicle(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		vehicles = append(vehicles, *vehicle)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return vehicles, nil
+}
+
+func (r *sqliteRepository) FindVehicle(ctx context.Context, userID string, vehicleID int) (*UserVehicle, error) {
+	query := "SELECT " + vehicleColumns + " FROM user_vehicles WHERE id = $1 AND user_id = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "FindVehicle", query)
+	defer span.End()
+
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[FindVehicle] invalid user id", "user_id", userID, "err", err)
+		return nil, err
+	}
+
+	vehicle, err := scanVehicle(r.db.QueryRowContext(ctx, query, vehicleID, idInt))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrVehicleNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindVehicle] error scanning vehicle", "vehicle_id", vehicleID, "err", err)
+		return nil, err
+	}
+	return vehicle, nil
+}
+
+func (r *sqliteRepository) CreateVehicle(ctx context.Context, vehicle UserVehicle) (*UserVehicle, error) {
+	query := `INSERT INTO user_vehicles
+    (user_id, type, make, model, year, registration_number, owned_from, owned_until, created_at, updated_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+    RETURNING ` + vehicleColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "CreateVehicle", query)
+	defer span.End()
+
+	created, err := scanVehicle(r.db.QueryRowContext(ctx, query, vehicleArgs(vehicle)...))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateVehicle] error inserting vehicle", "user_id", vehicle.UserID, "err", err)
+		return nil, mapSQLiteError(err)
+	}
+	return created, nil
+}
+
+func (r *sqliteRepository) UpdateVehicle(ctx context.Context, vehicle UserVehicle) (*UserVehicle, error) {
+	query := `UPDATE user_vehicles
+    SET type = $2, make = $3, model = $4, year = $5, registration_number = $6,
+        owned_from = $7, owned_until = $8, updated_at = CURRENT_TIMESTAMP
+    WHERE user_id = $1 AND id = $9
+    RETURNING ` + vehicleColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "UpdateVehicle", query)
+	defer span.End()
+
+	updated, err := scanVehicle(r.db.QueryRowContext(ctx, query, append(vehicleArgs(vehicle), vehicle.ID)...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrVehicleNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[UpdateVehicle] error updating vehicle", "vehicle_id", vehicle.ID, "err", err)
+		return nil, mapSQLiteError(err)
+	}
+	return updated, nil
+}
+
+func (r *sqliteRepository) DeleteVehicle(ctx context.Context, userID string, vehicleID int) error {
+	query := "DELETE FROM user_vehicles WHERE id = $1 AND user_id = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "DeleteVehicle", query)
+	defer span.End()
+
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		r.log.WarnContext(ctx, "[DeleteVehicle] invalid user id", "user_id", userID, "err", err)
+		return err
+	}
+
+	result, err := r.db.ExecContext(ctx, query, vehicleID, idInt)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[DeleteVehicle] error executing query", "vehicle_id", vehicleID, "err", err)
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrVehicleNotFound
+	}
+	return nil
+}
+
+// mapSQLiteError translates SQLite constraint errors into domain errors
+func (r *sqliteRepository) CreateOAuthClient(ctx context.Context, client OAuthClient) (*OAuthClient, error) {
+	query := `INSERT INTO oauth_clients (id, name, secret_hash, redirect_uris, scopes)
+    VALUES ($1, $2, $3, $4, $5)
+    RETURNING ` + oauthClientColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "CreateOAuthClient", query)
+	defer span.End()
+
+	created, err := scanOAuthClient(r.db.QueryRowContext(ctx, query, oauthClientArgs(client)...))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateOAuthClient] error inserting client", "client_id", client.ID, "err", err)
+		return nil, mapSQLiteError(err)
+	}
+	return created, nil
+}
+
+func (r *sqliteRepository) FindOAuthClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	query := "SELECT " + oauthClientColumns + " FROM oauth_clients WHERE id = $1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "FindOAuthClient", query)
+	defer span.End()
+
+	client, err := scanOAuthClient(r.db.QueryRowContext(ctx, query, clientID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOAuthClientNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindOAuthClient] error scanning client", "client_id", clientID, "err", err)
+		return nil, err
+	}
+	return client, nil
+}
+
+func (r *sqliteRepository) ListOAuthClients(ctx context.Context) ([]OAuthClient, error) {
+	query := "SELECT " + oauthClientColumns + " FROM oauth_clients ORDER BY created_at, id"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "ListOAuthClients", query)
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListOAuthClients] error executing query", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		clients = append(clients, *client)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return clients, nil
+}
+
+func (r *sqliteRepository) DeleteOAuthClient(ctx context.Context, clientID string) error {
+	query := "DELETE FROM oauth_clients WHERE id = $1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "DeleteOAuthClient", query)
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, query, clientID)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[DeleteOAuthClient] error executing query", "client_id", clientID, "err", err)
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrOAuthClientNotFound
+	}
+	return nil
+}
+
+func (r *sqliteRepository) SaveOAuthConsent(ctx context.Context, consent OAuthConsent) error {
+	query := `INSERT INTO oauth_consents (user_id, client_id, scopes, granted_at)
+    VALUES ($1, $2, $3, $4)
+    ON CONFLICT (user_id, client_id) DO UPDATE SET scopes = EXCLUDED.scopes, granted_at = EXCLUDED.granted_at`
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "SaveOAuthConsent", query)
+	defer span.End()
+
+	_, err := r.db.ExecContext(ctx, query, consent.UserID, consent.ClientID, strings.Join(consent.Scopes, " "), consent.GrantedAt)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[SaveOAuthConsent] error executing query", "user_id", consent.UserID, "client_id", consent.ClientID, "err", err)
+		return mapSQLiteError(err)
+	}
+	return nil
+}
+
+func (r *sqliteRepository) FindOAuthConsent(ctx context.Context, userID int, clientID string) (*OAuthConsent, error) {
+	query := "SELECT " + oauthConsentColumns + " FROM oauth_consents WHERE user_id = $1 AND client_id = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "FindOAuthConsent", query)
+	defer span.End()
+
+	consent, err := scanOAuthConsent(r.db.QueryRowContext(ctx, query, userID, clientID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOAuthConsentNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindOAuthConsent] error scanning consent", "user_id", userID, "client_id", clientID, "err", err)
+		return nil, err
+	}
+	return consent, nil
+}
+
+func (r *sqliteRepository) CreateAuthorizationCode(ctx context.Context, code OAuthAuthorizationCode) error {
+	query := `INSERT INTO oauth_authorization_codes
+    (code_hash, client_id, user_id, redirect_uri, scopes, nonce, code_challenge, expires_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "CreateAuthorizationCode", query)
+	defer span.End()
+
+	_, err := r.db.ExecContext(ctx, query, authorizationCodeArgs(code)...)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateAuthorizationCode] error inserting code", "client_id", code.ClientID, "err", err)
+		return mapSQLiteError(err)
+	}
+	return nil
+}
+
+func (r *sqliteRepository) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*OAuthAuthorizationCode, error) {
+	query := `UPDATE oauth_authorization_codes SET used_at = CURRENT_TIMESTAMP
+    WHERE code_hash = $1 AND used_at IS NULL
+    RETURNING ` + authorizationCodeColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "ConsumeAuthorizationCode", query)
+	defer span.End()
+
+	code, err := scanAuthorizationCode(r.db.QueryRowContext(ctx, query, codeHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAuthorizationCodeNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ConsumeAuthorizationCode] error executing query", "err", err)
+		return nil, err
+	}
+	return code, nil
+}
+
+func (r *sqliteRepository) CreateSigningKey(ctx context.Context, key OAuthSigningKey) error {
+	query := "INSERT INTO oauth_signing_keys (id, private_key, created_at) VALUES ($1, $2, $3)"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "CreateSigningKey", query)
+	defer span.End()
+
+	if _, err := r.db.ExecContext(ctx, query, key.ID, key.PrivateKey, key.CreatedAt.UTC()); err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateSigningKey] error inserting key", "kid", key.ID, "err", err)
+		return err
+	}
+	return nil
+}
+
+func (r *sqliteRepository) ListSigningKeys(ctx context.Context, since time.Time) ([]OAuthSigningKey, error) {
+	query := "SELECT " + signingKeyColumns + " FROM oauth_signing_keys WHERE created_at > $1 ORDER BY created_at DESC, id"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "ListSigningKeys", query)
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, query, since.UTC())
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListSigningKeys] error executing query", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []OAuthSigningKey{}
+	for rows.Next() {
+		key, err := scanSigningKey(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *sqliteRepository) CreateAPIKey(ctx context.Context, key APIKey) (*APIKey, error) {
+	query := `INSERT INTO api_keys (id, user_id, name, secret_hash, scopes, created_at, expires_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)
+    RETURNING ` + apiKeyColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "CreateAPIKey", query)
+	defer span.End()
+
+	created, err := scanAPIKey(r.db.QueryRowContext(ctx, query, apiKeyArgs(key)...))
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateAPIKey] error inserting key", "user_id", key.UserID, "err", err)
+		return nil, mapSQLiteError(err)
+	}
+	return created, nil
+}
+
+func (r *sqliteRepository) FindAPIKey(ctx context.Context, keyID string) (*APIKey, error) {
+	query := "SELECT " + apiKeyColumns + " FROM api_keys WHERE id = $1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "FindAPIKey", query)
+	defer span.End()
+
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, query, keyID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[FindAPIKey] error scanning key", "err", err)
+		return nil, err
+	}
+	return key, nil
+}
+
+func (r *sqliteRepository) ListAPIKeys(ctx context.Context, userID int) ([]APIKey, error) {
+	query := "SELECT " + apiKeyColumns + " FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC, id"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "ListAPIKeys", query)
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ListAPIKeys] error executing query", "user_id", userID, "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *sqliteRepository) DeleteAPIKey(ctx context.Context, userID int, keyID string) error {
+	query := "DELETE FROM api_keys WHERE id = $1 AND user_id = $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "DeleteAPIKey", query)
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, query, keyID, userID)
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[DeleteAPIKey] error executing query", "user_id", userID, "err", err)
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (r *sqliteRepository) TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) error {
+	query := "UPDATE api_keys SET last_used_at = $2 WHERE id = $1"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "TouchAPIKey", query)
+	defer span.End()
+
+	if _, err := r.db.ExecContext(ctx, query, keyID, usedAt.UTC()); err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[TouchAPIKey] error executing query", "err", err)
+		return err
+	}
+	return nil
+}
+
+func (r *sqliteRepository) CreateMagicLink(ctx context.Context, link MagicLink) error {
+	query := "INSERT INTO magic_links (id, user_id, created_at, expires_at) VALUES ($1, $2, $3, $4)"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "CreateMagicLink", query)
+	defer span.End()
+
+	_, err := r.db.ExecContext(ctx, query, link.ID, link.UserID, link.CreatedAt.UTC(), link.ExpiresAt.UTC())
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CreateMagicLink] error inserting link", "user_id", link.UserID, "err", err)
+		return mapSQLiteError(err)
+	}
+	return nil
+}
+
+func (r *sqliteRepository) CountMagicLinks(ctx context.Context, userID int, since time.Time) (int, error) {
+	query := "SELECT COUNT(*) FROM magic_links WHERE user_id = $1 AND created_at > $2"
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "CountMagicLinks", query)
+	defer span.End()
+
+	var count int
+	if err := r.db.QueryRowContext(ctx, query, userID, since.UTC()).Scan(&count); err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[CountMagicLinks] error executing query", "user_id", userID, "err", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *sqliteRepository) ConsumeMagicLink(ctx context.Context, linkID string) (*MagicLink, error) {
+	query := `UPDATE magic_links SET used_at = CURRENT_TIMESTAMP
+    WHERE id = $1 AND used_at IS NULL
+    RETURNING ` + magicLinkColumns
+	ctx, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "ConsumeMagicLink", query)
+	defer span.End()
+
+	link, err := scanMagicLink(r.db.QueryRowContext(ctx, query, linkID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMagicLinkNotFound
+	}
+	if err != nil {
+		recordError(span, err)
+		r.log.ErrorContext(ctx, "[ConsumeMagicLink] error executing query", "err", err)
+		return nil, err
+	}
+	return link, nil
+}
+
+func mapSQLiteError(err error) error {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+
+	switch sqliteErr.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE:
+		// the primary address, pending identity change, profile history, audit
+		// chain, linked identity and email indexes are the only unique ones that
+		// are not upserted
+		if strings.Contains(sqliteErr.Error(), "user_profile_history") || strings.Contains(sqliteErr.Error(), "audit_events") {
+			// history entries and audit events are appended under a lock, a duplicate is a bug
+			return err
+		}
+		if strings.Contains(sqliteErr.Error(), "user_addresses") {
+			return ErrPrimaryAddressTaken
+		}
+		if strings.Contains(sqliteErr.Error(), "user_identity_changes") {
+			return ErrIdentityChangePending
+		}
+		if strings.Contains(sqliteErr.Error(), "user_identities") {
+			return ErrIdentityLinked
+		}
+		if strings.Contains(sqliteErr.Error(), "oauth_") || strings.Contains(sqliteErr.Error(), "api_keys") || strings.Contains(sqliteErr.Error(), "magic_links") {
+			// client IDs, codes and key IDs are random, a duplicate is a bug
+			return err
+		}
+		return ErrEmailTaken
+	case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+		return ErrUserNotFound
+	}
+	return err
+}
@@ -0,0 +1,26 @@
+package userauth_test
+
+import (
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/abhiii71/clean-code-abhi/pkg/config"
+	"github.com/abhiii71/clean-code-abhi/pkg/db"
+	userauth "github.com/abhiii71/clean-code-abhi/pkg/user_auth"
+	"github.com/abhiii71/clean-code-abhi/pkg/user_auth/contracttest"
+)
+
+func TestSQLiteRepository(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	contracttest.RunRepository(t, func(t *testing.T) userauth.Repository {
+		// a file per subtest, ":memory:" gives every pooled connection its own database
+		sqlDB, err := db.ConnectSQLite(config.Config{SQLitePath: filepath.Join(t.TempDir(), "test.db")}, log)
+		if err != nil {
+			t.Fatalf("ConnectSQLite: %v", err)
+		}
+		t.Cleanup(func() { sqlDB.Close() })
+		return userauth.NewSQLiteRepository(sqlDB, log)
+	})
+}
@@ -0,0 +1,52 @@
+package userauth_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	userauth "github.com/abhiii71/clean-code-abhi/pkg/user_auth"
+	"github.com/abhiii71/clean-code-abhi/pkg/user_auth/contracttest"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TestPostgresRepository runs against the database of TEST_PG_DSN, which must
+// have the migrations applied. Every table is emptied before each subtest.
+func TestPostgresRepository(t *testing.T) {
+	dsn := os.Getenv("TEST_PG_DSN")
+	if dsn == "" {
+		t.Skip("TEST_PG_DSN is not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer pool.Close()
+
+	rows, err := pool.Query(ctx, `SELECT tablename FROM pg_tables
+    WHERE schemaname = current_schema() AND tablename <> 'schema_migrations'`)
+	if err != nil {
+		t.Fatalf("list tables: %v", err)
+	}
+	tables, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		t.Fatalf("list tables: %v", err)
+	}
+	for i, table := range tables {
+		tables[i] = pgx.Identifier{table}.Sanitize()
+	}
+	truncate := "TRUNCATE " + strings.Join(tables, ", ") + " RESTART IDENTITY CASCADE"
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	contracttest.RunRepository(t, func(t *testing.T) userauth.Repository {
+		if _, err := pool.Exec(ctx, truncate); err != nil {
+			t.Fatalf("empty the tables: %v", err)
+		}
+		return userauth.NewRepository(pool, log)
+	})
+}
@@ -1,229 +1,1325 @@
 package userauth
 
 import (
+	"cmp"
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mime/multipart"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 type Service interface {
 	UserRegister(ctx context.Context, request UserRegisterRequest) (string, error)
 	GetUserProfile(ctx context.Context, request UserLoginRequest) (string, error)
 	GetProfile(ctx context.Context, userId string) (User, error)
-	UpdateUserInfo(ctx context.Context, req UserInformationRequest) error
+	UpdateUserInfo(ctx context.Context, req UserInformationRequest) (ProfileUpdate, error)
 	SaveUserPDF(ctx context.Context, email string, file *multipart.FileHeader) error
+	ChangePassword(ctx context.Context, userID string, req ChangePasswordRequest) error
+
+	ListVehicles(ctx context.Context, userID string) ([]UserVehicle, error)
+	GetVehicle(ctx context.Context, userID string, vehicleID int) (UserVehicle, error)
+	CreateVehicle(ctx context.Context, userID string, req VehicleRequest) (UserVehicle, error)
+	UpdateVehicle(ctx context.Context, userID string, vehicleID int, req VehicleRequest) (UserVehicle, error)
+	DeleteVehicle(ctx context.Context, userID string, vehicleID int) error
+
+	ListAddresses(ctx context.Context, userID string) ([]UserAddress, error)
+	GetAddress(ctx context.Context, userID string, addressID int) (UserAddress, error)
+	CreateAddress(ctx context.Context, userID string, req AddressRequest) (UserAddress, error)
+	UpdateAddress(ctx context.Context, userID string, addressID int, req AddressRequest) (UserAddress, error)
+	DeleteAddress(ctx context.Context, userID string, addressID int) error
+
+	ListIdentityChanges(ctx context.Context, filter IdentityChangeFilter) ([]IdentityChange, error)
+	// DecideIdentityChange approves or rejects a pending change on behalf of
+	// admin, an approved change is applied to the user
+	DecideIdentityChange(ctx context.Context, changeID int, approve bool, admin string) (IdentityChange, error)
+
+	// ListProfileHistory returns the recorded profile changes, newest first
+	ListProfileHistory(ctx context.Context, userID string) ([]ProfileHistoryEntry, error)
+	// RevertProfile restores the profile recorded for version on behalf of admin
+	// and returns the history entry of the revert
+	RevertProfile(ctx context.Context, userID string, version int, admin string) (ProfileHistoryEntry, error)
+
+	// ListAuditEvents returns a page of the audit log, see AuditEventFilter
+	ListAuditEvents(ctx context.Context, filter AuditEventFilter) ([]AuditEvent, error)
+
+	// AuthenticateToken returns the claims of a token a login issued,
+	// ErrInvalidToken is returned for a token that is not valid and
+	// ErrSessionRevoked unless its session is still active
+	AuthenticateToken(ctx context.Context, token string) (*JWTClaims, error)
+	// ListSessions returns the active sessions of the user, the one with
+	// currentSessionID is marked as Current
+	ListSessions(ctx context.Context, userID, currentSessionID string) ([]Session, error)
+	// ListLoginHistory returns every login of the user, newest first
+	ListLoginHistory(ctx context.Context, userID string) ([]Session, error)
+	// RevokeSession ends a session of the user, its token is rejected from then on
+	RevokeSession(ctx context.Context, userID, sessionID string) error
+
+	// RequestMagicLink emails a login link to the user with the email. device is
+	// the secret the browser kept from an earlier request, a new one is made
+	// when it is empty, and the secret to keep is returned. Unknown emails and
+	// requests over the limit get no email but the same result so emails can't
+	// be enumerated.
+	RequestMagicLink(ctx context.Context, email, device string) (string, error)
+	// LoginWithMagicLink logs in with the token of an emailed link, it only
+	// works with the device secret of the browser that asked for the link
+	LoginWithMagicLink(ctx context.Context, token, device string) (string, error)
+
+	// CreateAPIKey creates a personal access token, the key is only returned here
+	CreateAPIKey(ctx context.Context, userID string, req APIKeyRequest) (*APIKey, string, error)
+	// ListAPIKeys returns the keys of the user including the expired ones
+	ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error)
+	// RevokeAPIKey deletes a key of the user, it is rejected from then on
+	RevokeAPIKey(ctx context.Context, userID, keyID string) error
+	// AuthenticateAPIKey returns the key and its user, ErrInvalidAPIKey is
+	// returned for an unknown or expired key
+	AuthenticateAPIKey(ctx context.Context, key string) (*APIKey, *User, error)
+
+	// OIDCProviders lists the identity providers users can sign in with
+	OIDCProviders() []string
+	// StartOIDCLogin begins signing in with provider, linkUserID is set when a
+	// logged in user links the provider to their account instead
+	StartOIDCLogin(ctx context.Context, provider, linkUserID string) (OIDCLoginStart, error)
+	// FinishOIDCLogin handles the redirect back from the provider. It logs in
+	// the user the account is linked to, registers a new user or links the
+	// account when the login was started for that.
+	FinishOIDCLogin(ctx context.Context, provider string, callback OIDCCallback) (OIDCLoginResult, error)
+	ListIdentities(ctx context.Context, userID string) ([]UserIdentity, error)
+	// UnlinkIdentity removes a linked provider, a user without a password has
+	// to keep one
+	UnlinkIdentity(ctx context.Context, userID, provider string) error
+
+	// RegisterOAuthClient registers an app that delegates its login to this
+	// service, the secret of a confidential client is only returned here
+	RegisterOAuthClient(ctx context.Context, req OAuthClientRequest) (*OAuthClient, string, error)
+	ListOAuthClients(ctx context.Context) ([]OAuthClient, error)
+	DeleteOAuthClient(ctx context.Context, clientID string) error
+	OAuthMetadata() OAuthServerMetadata
+	// OAuthKeys are the public keys the ID and access tokens are signed with
+	OAuthKeys(ctx context.Context) (JSONWebKeySet, error)
+	// PrepareAuthorization checks an authorization request. An *OAuthError
+	// is sent back to the client, other errors are shown to the user because
+	// the client or redirect_uri can't be trusted.
+	PrepareAuthorization(ctx context.Context, req AuthorizationRequest) (*AuthorizationPrompt, error)
+	// AuthorizeLogin logs the user in on the login screen, the client gets a
+	// code right away when the user consented to the scopes before
+	AuthorizeLogin(ctx context.Context, req AuthorizationRequest, email, password string) (AuthorizationStep, error)
+	// AuthorizeConsent records the decision of the consent screen, a denial
+	// needs no ticket
+	AuthorizeConsent(ctx context.Context, req AuthorizationRequest, ticket string, approve bool) (AuthorizationStep, error)
+	// ExchangeAuthorizationCode is the token endpoint, errors are *OAuthError
+	ExchangeAuthorizationCode(ctx context.Context, req TokenRequest) (*TokenResponse, error)
+	// OAuthUserInfo returns the claims the access token's scopes reveal
+	OAuthUserInfo(ctx context.Context, accessToken string) (map[string]any, error)
 	// GetUserInfo(ctx context.Context, request UserInformationRequest) error
 }
 
 type service struct {
-	repo      Repository
-	uploadDir string
+	repo   Repository
+	policy *PasswordPolicy
+	hasher PasswordHasher
+	keys   signingKeys
+	// approvalFields are the identity fields whose changes wait for an admin
+	approvalFields map[IdentityField]bool
+	oidc           *OIDCProviders
+	oauth          *oauthServer
+	magicLink      MagicLinkConfig
+	mailer         Mailer
+	uploadDir      string
+	log            *slog.Logger
+}
+
+// ProfileUpdate is the outcome of UpdateUserInfo, Pending lists the identity
+// changes that wait for an admin instead of being applied
+type ProfileUpdate struct {
+	Version int
+	Pending []IdentityChange
 }
 
-func NewService(repo Repository) Service {
-	return &service{
-		repo: repo,
+// NewService returns the user service, changes of the approvalFields made
+// through UpdateUserInfo are only applied once an admin approves them. Users
+// can sign in with the oidc providers, nil when there are none. The keys of
+// the tokens the service issues are derived from tokenSecret.
+func NewService(repo Repository, policy *PasswordPolicy, hasher PasswordHasher, tokenSecret []byte, approvalFields []IdentityField, oidc *OIDCProviders, oauth OAuthServerConfig, magicLink MagicLinkConfig, mailer Mailer, log *slog.Logger) Service {
+	s := &service{
+		repo:           repo,
+		policy:         policy,
+		hasher:         hasher,
+		keys:           newSigningKeys(tokenSecret),
+		approvalFields: map[IdentityField]bool{},
+		oidc:           oidc,
+		oauth:          &oauthServer{config: oauth},
+		magicLink:      magicLink,
+		mailer:         mailer,
+		log:            log,
 	}
+	for _, field := range approvalFields {
+		s.approvalFields[field] = true
+	}
+	return s
 }
 
 func (s *service) UserRegister(ctx context.Context, request UserRegisterRequest) (string, error) {
-	// business logic
+	if err := s.policy.Check(ctx, "password", request.Password, request.Email, request.FirstName, request.LastName); err != nil {
+		return "", err
+	}
+
 	// password hash
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+	hashedPassword, err := s.hasher.Hash(request.Password)
 	if err != nil {
-		log.Println("[hashed password Error]:", err)
+		s.log.ErrorContext(ctx, "[UserRegister] error hashing password", "err", err)
 		return "", err
 	}
 
-	_, err = s.repo.FindUserByEmail(ctx, request.Email)
-	if err == nil {
-		return "", fmt.Errorf("email already regestered")
-	}
-	request.Password = string(hashedPassword)
+	request.Password = hashedPassword
+	request.GenderDescription = genderDescription(request.Gender, request.GenderDescription)
+
+	// check and insert in one transaction, the unique index on LOWER(email)
+	// rejects whichever concurrent registration commits second
+	var userID string
+	err = s.repo.WithTx(ctx, func(tx Repository) error {
+		_, err := tx.FindUserByEmail(ctx, request.Email)
+		if err == nil {
+			return ErrEmailTaken
+		}
+		if !errors.Is(err, ErrUserNotFound) {
+			return err
+		}
 
-	// store the user
-	userID, err := s.repo.UserRegister(ctx, request)
+		// store the user
+		userID, err = tx.UserRegister(ctx, request)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
+	idInt, _ := strconv.Atoi(userID)
+	s.audit(ctx, AuditEvent{Type: AuditUserRegistered, Actor: request.Email, UserID: idInt})
 
 	// user exists
 	return userID, nil
 }
 
-var ErrNoRowsAffected = errors.New("no rows affected")
+// ChangePassword replaces the password after checking the current one, the new
+// password goes through the same policy as on register
+func (s *service) ChangePassword(ctx context.Context, userID string, req ChangePasswordRequest) error {
+	user, err := s.repo.FindUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	// users who signed up with an identity provider set their first password
+	if user.Password != "" {
+		ok, err := s.hasher.Verify(req.CurrentPassword, user.Password)
+		if err != nil {
+			s.log.ErrorContext(ctx, "[ChangePassword] error verifying password", "user_id", user.ID, "err", err)
+			return err
+		}
+		if !ok {
+			s.log.InfoContext(ctx, "[ChangePassword] current password mismatch", "user_id", user.ID)
+			return ErrInvalidCredentials
+		}
+	}
+
+	if err := s.policy.Check(ctx, "new_password", req.NewPassword, user.Email, user.FirstName, user.LastName); err != nil {
+		return err
+	}
 
-func (s *service) UpdateUserInfo(ctx context.Context, req UserInformationRequest) error {
-	// Fetch existing data
-	existingUser, addressBytes, vehicleBytes, err := s.repo.FindUserByID(ctx, fmt.Sprintf("%d", req.ID))
+	hashedPassword, err := s.hasher.Hash(req.NewPassword)
 	if err != nil {
-		return fmt.Errorf("user not found or error fetching: %w", err)
+		s.log.ErrorContext(ctx, "[ChangePassword] error hashing password", "err", err)
+		return err
+	}
+	if err := s.repo.UpdatePassword(ctx, userID, hashedPassword); err != nil {
+		return err
 	}
+	s.audit(ctx, AuditEvent{Type: AuditPasswordChanged, Actor: user.Email, UserID: user.ID})
+	return nil
+}
+
+var ErrNoRowsAffected = errors.New("no rows affected")
+
+func (s *service) UpdateUserInfo(ctx context.Context, req UserInformationRequest) (ProfileUpdate, error) {
+	var update ProfileUpdate
+	err := s.repo.WithTx(ctx, func(tx Repository) error {
+		// Fetch existing data and lock the user so concurrent updates merge one after another
+		existingUser, err := tx.FindUserByIDForUpdate(ctx, fmt.Sprintf("%d", req.ID))
+		if err != nil {
+			return fmt.Errorf("user not found or error fetching: %w", err)
+		}
+
+		if req.Version != AnyVersion && req.Version != existingUser.Version {
+			return ErrVersionMismatch
+		}
+		before := profileSnapshot(existingUser)
+
+		if update.Pending, err = s.changeIdentity(ctx, tx, existingUser, req, s.approvalFields); err != nil {
+			return err
+		}
+
+		if !equalAddress(existingUser.Address, req.Address) {
+			if err := s.replacePrimaryAddress(ctx, tx, req.ID, req.Address); err != nil {
+				return err
+			}
+		}
 
-	// Unmarshal existing address and vehicle
-	if addressBytes != nil {
-		if err := json.Unmarshal(addressBytes, &existingUser.Address); err != nil {
-			return fmt.Errorf("failed to unmarshal existing address: %w", err)
+		// Save to DB
+		if update.Version, err = tx.UpdateUserInfo(ctx, req); err != nil {
+			return err
 		}
+		return recordHistory(ctx, tx, before, strconv.Itoa(req.ID), update.Version, existingUser.Email, nil)
+	})
+	return update, err
+}
+
+// identityFields is the order identity changes are recorded in
+var identityFields = []IdentityField{IdentityFirstName, IdentityLastName, IdentityDOB, IdentityGender}
+
+// changeIdentity records a change for every identity field of req that differs
+// from user. Fields in approvalFields are returned as pending, the others are
+// stored right away.
+func (s *service) changeIdentity(ctx context.Context, tx Repository, user *User, req UserInformationRequest, approvalFields map[IdentityField]bool) ([]IdentityChange, error) {
+	requested := map[IdentityField]string{
+		IdentityFirstName: req.FirstName,
+		IdentityLastName:  req.LastName,
+		IdentityDOB:       time.Time(req.DOB).Format(time.DateOnly),
+		IdentityGender:    genderValue(req.Gender, genderDescription(req.Gender, req.GenderDescription)),
 	}
-	if vehicleBytes != nil {
-		if err := json.Unmarshal(vehicleBytes, &existingUser.Vehicle); err != nil {
-			return fmt.Errorf("failed to unmarshal existing vehicle: %w", err)
+
+	var pending []IdentityChange
+	applied := false
+	for _, field := range identityFields {
+		value := requested[field]
+		if value == identityValue(user, field) {
+			continue
+		}
+
+		change := IdentityChange{
+			UserID:   user.ID,
+			Field:    field,
+			OldValue: identityValue(user, field),
+			NewValue: value,
+			Status:   IdentityChangeApplied,
+		}
+		if approvalFields[field] {
+			change.Status = IdentityChangePending
+		} else if err := setIdentityValue(user, field, value); err != nil {
+			return nil, err
+		} else {
+			applied = true
+		}
+
+		created, err := tx.CreateIdentityChange(ctx, change)
+		if err != nil {
+			return nil, err
 		}
+		if created.Status == IdentityChangePending {
+			pending = append(pending, *created)
+		}
+		s.log.InfoContext(ctx, "[UpdateUserInfo] identity change recorded", "user_id", user.ID, "field", field, "status", created.Status)
 	}
 
-	if req.Address.City != nil {
-		existingUser.Address.City = req.Address.City
+	if applied {
+		if err := tx.UpdateUserIdentity(ctx, *user); err != nil {
+			return nil, err
+		}
 	}
-	if req.Address.State != nil {
-		existingUser.Address.State = req.Address.State
+	return pending, nil
+}
+
+func (s *service) ListIdentityChanges(ctx context.Context, filter IdentityChangeFilter) ([]IdentityChange, error) {
+	return s.repo.ListIdentityChanges(ctx, filter)
+}
+
+func (s *service) DecideIdentityChange(ctx context.Context, changeID int, approve bool, admin string) (IdentityChange, error) {
+	status := IdentityChangeRejected
+	if approve {
+		status = IdentityChangeApproved
 	}
-	if req.Address.PostalCode != nil {
-		existingUser.Address.PostalCode = req.Address.PostalCode
+
+	var decided *IdentityChange
+	err := s.repo.WithTx(ctx, func(tx Repository) error {
+		change, err := tx.FindIdentityChange(ctx, changeID)
+		if err != nil {
+			return err
+		}
+		if change.Status != IdentityChangePending {
+			return ErrIdentityChangeDecided
+		}
+
+		if approve {
+			userID := strconv.Itoa(change.UserID)
+			user, err := tx.FindUserByIDForUpdate(ctx, userID)
+			if err != nil {
+				return err
+			}
+			before := profileSnapshot(user)
+			if err := setIdentityValue(user, change.Field, change.NewValue); err != nil {
+				return err
+			}
+			if err := tx.UpdateUserIdentity(ctx, *user); err != nil {
+				return err
+			}
+			version, err := bumpVersion(ctx, tx, userID)
+			if err != nil {
+				return err
+			}
+			if err := recordHistory(ctx, tx, before, userID, version, admin, nil); err != nil {
+				return err
+			}
+		}
+
+		decided, err = tx.DecideIdentityChange(ctx, changeID, status, admin)
+		return err
+	})
+	if err != nil {
+		return IdentityChange{}, err
 	}
-	if req.Address.Country != nil {
-		existingUser.Address.Country = req.Address.Country
+	s.log.InfoContext(ctx, "[DecideIdentityChange] identity change decided", "change_id", changeID, "status", status, "admin", admin)
+	eventType := AuditIdentityChangeRejected
+	if approve {
+		eventType = AuditIdentityChangeApproved
 	}
+	s.audit(ctx, AuditEvent{Type: eventType, Actor: admin, UserID: decided.UserID, Details: map[string]string{
+		"change_id": strconv.Itoa(decided.ID),
+		"field":     string(decided.Field),
+	}})
+	return *decided, nil
+}
 
-	if req.Vehicle.Car != nil {
-		existingUser.Vehicle.Car = req.Vehicle.Car
+func (s *service) ListProfileHistory(ctx context.Context, userID string) ([]ProfileHistoryEntry, error) {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user id %q: %w", userID, err)
 	}
-	if req.Vehicle.Bike != nil {
-		existingUser.Vehicle.Bike = req.Vehicle.Bike
+	return s.repo.ListProfileHistory(ctx, idInt)
+}
+
+// RevertProfile brings the profile back to how it was at version on behalf of
+// admin. Identity fields are applied without approval and changes still
+// awaiting approval are left alone.
+func (s *service) RevertProfile(ctx context.Context, userID string, version int, admin string) (ProfileHistoryEntry, error) {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return ProfileHistoryEntry{}, fmt.Errorf("invalid user id %q: %w", userID, err)
 	}
 
-	// Marshal merged data
-	addressJSON, err := json.Marshal(existingUser.Address)
+	var reverted *ProfileHistoryEntry
+	err = s.repo.WithTx(ctx, func(tx Repository) error {
+		user, err := tx.FindUserByIDForUpdate(ctx, userID)
+		if err != nil {
+			return err
+		}
+		target, err := tx.FindProfileHistory(ctx, idInt, version)
+		if err != nil {
+			return err
+		}
+		before := profileSnapshot(user)
+
+		req, err := revertRequest(user, target.Profile)
+		if err != nil {
+			return err
+		}
+		if _, err := s.changeIdentity(ctx, tx, user, req, nil); err != nil {
+			return err
+		}
+		if !equalAddress(user.Address, req.Address) {
+			if err := s.replacePrimaryAddress(ctx, tx, idInt, req.Address); err != nil {
+				return err
+			}
+		}
+
+		newVersion, err := bumpVersion(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		if err := recordHistory(ctx, tx, before, userID, newVersion, admin, &version); err != nil {
+			return err
+		}
+		reverted, err = tx.FindProfileHistory(ctx, idInt, newVersion)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to marshal address: %w", err)
+		return ProfileHistoryEntry{}, err
+	}
+	s.log.InfoContext(ctx, "[RevertProfile] profile reverted", "user_id", userID, "reverted_to", version, "version", reverted.Version, "admin", admin)
+	s.audit(ctx, AuditEvent{Type: AuditProfileReverted, Actor: admin, UserID: idInt, Details: map[string]string{
+		"reverted_to": strconv.Itoa(version),
+		"version":     strconv.Itoa(reverted.Version),
+	}})
+	return *reverted, nil
+}
+
+func (s *service) ListAuditEvents(ctx context.Context, filter AuditEventFilter) ([]AuditEvent, error) {
+	filter.Limit = auditPageSize(filter.Limit)
+	return s.repo.ListAuditEvents(ctx, filter)
+}
+
+// identityValue is the stored value of field in the text form of IdentityChange
+func identityValue(user *User, field IdentityField) string {
+	switch field {
+	case IdentityFirstName:
+		return user.FirstName
+	case IdentityLastName:
+		return user.LastName
+	case IdentityDOB:
+		return user.DOB.Format(time.DateOnly)
+	case IdentityGender:
+		return genderValue(user.Gender, user.GenderDescription)
+	}
+	return ""
+}
+
+func setIdentityValue(user *User, field IdentityField, value string) error {
+	switch field {
+	case IdentityFirstName:
+		user.FirstName = value
+	case IdentityLastName:
+		user.LastName = value
+	case IdentityDOB:
+		dob, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return fmt.Errorf("invalid dob %q: %w", value, err)
+		}
+		user.DOB = dob
+	case IdentityGender:
+		user.Gender, user.GenderDescription, _ = strings.Cut(value, genderValueSeparator)
+	default:
+		return fmt.Errorf("unknown identity field %q", field)
+	}
+	return nil
+}
+
+// genderValueSeparator joins a self_described gender and its description in
+// the text form of IdentityChange
+const genderValueSeparator = ": "
+
+func genderValue(gender, description string) string {
+	if description == "" {
+		return gender
+	}
+	return gender + genderValueSeparator + description
+}
+
+// genderDescription is the description stored with gender, only a
+// self_described gender keeps one
+func genderDescription(gender, description string) string {
+	if Gender(gender) != GenderSelfDescribed {
+		return ""
 	}
+	return strings.TrimSpace(description)
+}
 
-	vehicleJSON, err := json.Marshal(existingUser.Vehicle)
+// replacePrimaryAddress stores address as the primary address of the user, a
+// primary home address is created when there is none. Nil fields are cleared.
+func (s *service) replacePrimaryAddress(ctx context.Context, tx Repository, userID int, address Address) error {
+	addresses, err := tx.ListAddresses(ctx, strconv.Itoa(userID))
 	if err != nil {
-		return fmt.Errorf("failed to marshal vehicle: %w", err)
+		return err
+	}
+
+	primary := UserAddress{UserID: userID, Type: AddressHome, IsPrimary: true}
+	for _, a := range addresses {
+		if a.IsPrimary {
+			primary = a
+		}
+	}
+
+	// the whole address is checked so a postal code or state patched alone is
+	// validated against the stored country
+	primary.Address = address
+	if err := NormalizeAddress(&primary.Address, "address."); err != nil {
+		return err
+	}
+
+	s.log.DebugContext(ctx, "[UpdateUserInfo] replaced primary address", "user_id", userID, "address", primary.Address)
+
+	if primary.ID == 0 {
+		_, err = tx.CreateAddress(ctx, primary)
+	} else {
+		_, err = tx.UpdateAddress(ctx, primary)
 	}
+	return err
+}
 
-	log.Println("Final addressJSON:", string(addressJSON))
-	log.Println("Final vehicleJSON:", string(vehicleJSON))
+func equalAddress(a, b Address) bool {
+	return equalPtr(a.City, b.City) && equalPtr(a.State, b.State) &&
+		equalPtr(a.PostalCode, b.PostalCode) && equalPtr(a.Country, b.Country)
+}
 
-	// Save to DB
-	return s.repo.UpdateUserInfo(ctx, req, addressJSON, vehicleJSON)
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
 }
 
 func (s *service) GetUserProfile(ctx context.Context, req UserLoginRequest) (string, error) {
-	log.Println("[Login Service] started")
+	s.log.DebugContext(ctx, "[Login] started")
 
-	user, err := s.repo.FindUserByEmail(ctx, strings.ToLower(req.Email))
+	user, err := s.checkCredentials(ctx, req.Email, req.Password)
 	if err != nil {
-		log.Println("[FindByEmail Error]:", err)
-		return "", fmt.Errorf("user not found")
+		return "", err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
+	session, err := s.startSession(ctx, user.ID)
 	if err != nil {
-		log.Println("[Password Mismatch]", err)
-		return "", fmt.Errorf("invalid credentials")
+		s.log.ErrorContext(ctx, "[Login] error recording session", "user_id", user.ID, "err", err)
+		return "", err
 	}
-
-	token, err := GenerateToken(fmt.Sprintf("%d", user.ID), user.Email)
+	token, err := GenerateToken(s.keys.login, fmt.Sprintf("%d", user.ID), user.Email, *session)
 	if err != nil {
-		log.Println("[Token Generation Error]:", err)
+		s.log.ErrorContext(ctx, "[Login] error generating token", "user_id", user.ID, "err", err)
 		return "", err
 	}
+	s.audit(ctx, AuditEvent{Type: AuditLoginSucceeded, Actor: user.Email, UserID: user.ID, Details: map[string]string{"session_id": session.ID}})
 	return token, nil
 }
 
-// get profilefunc calculateAge(dob time.Time) int {
-func calculateAge(dob time.Time) int {
-	now := time.Now()
-	years := now.Year() - dob.Year()
-	if now.YearDay() < dob.YearDay() {
-		years--
+// checkCredentials returns the user with the email and password, failed
+// attempts are audited
+func (s *service) checkCredentials(ctx context.Context, email, password string) (*User, error) {
+	user, err := s.repo.FindUserByEmail(ctx, strings.ToLower(email))
+	if errors.Is(err, ErrUserNotFound) {
+		s.log.InfoContext(ctx, "[Login] unknown email")
+		s.audit(ctx, AuditEvent{Type: AuditLoginFailed, Actor: email, Details: map[string]string{"reason": "unknown_email"}})
+		// same error as a wrong password so emails can't be enumerated
+		return nil, ErrInvalidCredentials
 	}
-	return years
+	if err != nil {
+		s.log.ErrorContext(ctx, "[Login] user lookup failed", "err", err)
+		return nil, err
+	}
+
+	if user.Password == "" {
+		s.log.InfoContext(ctx, "[Login] user has no password", "user_id", user.ID)
+		s.audit(ctx, AuditEvent{Type: AuditLoginFailed, Actor: email, UserID: user.ID, Details: map[string]string{"reason": "no_password"}})
+		return nil, ErrInvalidCredentials
+	}
+	ok, err := s.hasher.Verify(password, user.Password)
+	if err != nil {
+		s.log.ErrorContext(ctx, "[Login] error verifying password", "user_id", user.ID, "err", err)
+		return nil, err
+	}
+	if !ok {
+		s.log.InfoContext(ctx, "[Login] password mismatch", "user_id", user.ID)
+		s.audit(ctx, AuditEvent{Type: AuditLoginFailed, Actor: email, UserID: user.ID, Details: map[string]string{"reason": "wrong_password"}})
+		return nil, ErrInvalidCredentials
+	}
+
+	if s.hasher.NeedsRehash(user.Password) {
+		s.rehash(ctx, user.ID, password)
+	}
+	return user, nil
 }
 
-func (s *service) GetProfile(ctx context.Context, userID string) (User, error) {
-	log.Println("[Service] GetProfile called with userID:", userID)
+// startSession records a login of the user from the client in ctx
+func (s *service) startSession(ctx context.Context, userID int) (*Session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	// whole seconds like the iat and exp claims of the token
+	now := time.Now().UTC().Truncate(time.Second)
+	userAgent := userAgentFromContext(ctx)
+	return s.repo.CreateSession(ctx, Session{
+		ID:        id,
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(tokenTTL),
+		IP:        clientIPFromContext(ctx),
+		UserAgent: userAgent,
+		Device:    deviceLabel(userAgent),
+	})
+}
 
-	user, addressJSON, vehicleJSON, err := s.repo.FindUserByID(ctx, userID)
+func (s *service) AuthenticateToken(ctx context.Context, token string) (*JWTClaims, error) {
+	claims, err := ValidateToken(s.keys.login, token)
 	if err != nil {
-		log.Println("[GetProfile] Error fetching user:", err)
-		return User{}, err
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := s.checkSession(ctx, claims.UserUUID, claims.ID); err != nil {
+		return nil, err
 	}
+	return claims, nil
+}
 
-	// Calculate age from DOB
-	user.Age = calculateAge(user.DOB)
+// checkSession returns ErrSessionRevoked unless the session a token of the
+// user was issued for is still active
+func (s *service) checkSession(ctx context.Context, userID, sessionID string) error {
+	if sessionID == "" {
+		// tokens issued before sessions were recorded carry no session ID and
+		// could never be revoked, so they are rejected and their users log in again
+		return ErrSessionRevoked
+	}
+	session, err := s.repo.FindSession(ctx, sessionID)
+	if errors.Is(err, ErrSessionNotFound) {
+		return ErrSessionRevoked
+	}
+	if err != nil {
+		return err
+	}
+	if strconv.Itoa(session.UserID) != userID || !session.Active(time.Now()) {
+		return ErrSessionRevoked
+	}
+	return nil
+}
 
-	// Unmarshal Address if present
-	if addressJSON != nil {
-		if err := json.Unmarshal(addressJSON, &user.Address); err != nil {
-			log.Println("[GetProfile] Error unmarshaling address:", err)
-			return User{}, err
+func (s *service) ListSessions(ctx context.Context, userID, currentSessionID string) ([]Session, error) {
+	history, err := s.ListLoginHistory(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	sessions := []Session{}
+	for _, session := range history {
+		if session.Active(now) {
+			session.Current = session.ID == currentSessionID
+			sessions = append(sessions, session)
 		}
-	} else {
-		log.Println("[GetProfile] Address is NULL, using zero value")
 	}
+	return sessions, nil
+}
+
+func (s *service) ListLoginHistory(ctx context.Context, userID string) ([]Session, error) {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user id %q: %w", userID, err)
+	}
+	return s.repo.ListSessions(ctx, idInt)
+}
+
+func (s *service) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return fmt.Errorf("invalid user id %q: %w", userID, err)
+	}
+	session, err := s.repo.RevokeSession(ctx, idInt, sessionID)
+	if err != nil {
+		return err
+	}
+	s.log.InfoContext(ctx, "[RevokeSession] session revoked", "user_id", userID)
+	s.audit(ctx, AuditEvent{Type: AuditTokenRevoked, UserID: idInt, Details: map[string]string{
+		"session_id": session.ID,
+		"device":     session.Device,
+	}})
+	return nil
+}
 
-	// Unmarshal Vehicle if present
-	if vehicleJSON != nil {
-		if err := json.Unmarshal(vehicleJSON, &user.Vehicle); err != nil {
-			log.Println("[GetProfile] Error unmarshaling vehicle:", err)
-			return User{}, err
+func (s *service) RequestMagicLink(ctx context.Context, email, device string) (string, error) {
+	if !validDeviceSecret(device) {
+		var err error
+		if device, err = randomHex(32); err != nil {
+			return "", fmt.Errorf("generate device secret: %w", err)
 		}
-	} else {
-		log.Println("[GetProfile] Vehicle is NULL, using zero value")
 	}
 
-	return *user, nil
+	user, err := s.repo.FindUserByEmail(ctx, strings.ToLower(email))
+	if errors.Is(err, ErrUserNotFound) {
+		s.log.InfoContext(ctx, "[RequestMagicLink] unknown email")
+		return device, nil
+	}
+	if err != nil {
+		s.log.ErrorContext(ctx, "[RequestMagicLink] user lookup failed", "err", err)
+		return "", err
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return "", fmt.Errorf("generate magic link id: %w", err)
+	}
+	// whole seconds like the iat and exp claims of the token
+	now := time.Now().UTC().Truncate(time.Second)
+	link := MagicLink{ID: id, UserID: user.ID, CreatedAt: now, ExpiresAt: now.Add(s.magicLink.TTL)}
+	throttled := false
+	err = s.repo.WithTx(ctx, func(tx Repository) error {
+		// the user row lock serializes concurrent requests for the same user
+		if _, err := tx.FindUserByIDForUpdate(ctx, strconv.Itoa(user.ID)); err != nil {
+			return err
+		}
+		count, err := tx.CountMagicLinks(ctx, user.ID, now.Add(-magicLinkWindow))
+		if err != nil {
+			return err
+		}
+		if count >= magicLinkLimit {
+			throttled = true
+			return nil
+		}
+		return tx.CreateMagicLink(ctx, link)
+	})
+	if err != nil {
+		s.log.ErrorContext(ctx, "[RequestMagicLink] error recording link", "user_id", user.ID, "err", err)
+		return "", err
+	}
+	if throttled {
+		s.log.WarnContext(ctx, "[RequestMagicLink] too many links requested", "user_id", user.ID)
+		return device, nil
+	}
+
+	token, err := newMagicLinkToken(s.keys.magicLink, link, device)
+	if err != nil {
+		s.log.ErrorContext(ctx, "[RequestMagicLink] error signing link", "user_id", user.ID, "err", err)
+		return "", err
+	}
+	linkURL, err := magicLinkURL(s.magicLink.URL, token)
+	if err != nil {
+		return "", err
+	}
+	mail := Mail{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf("Hi,\n\nUse this link to log in. It works once, for %d minutes and only in the browser you asked for it in:\n\n%s\n\nIf you didn't ask for it you can ignore this email.\n",
+			int(s.magicLink.TTL.Minutes()), linkURL),
+	}
+	// sent after returning so the response time doesn't tell registered emails apart
+	go s.sendMagicLink(context.WithoutCancel(ctx), user, link.ID, mail)
+	return device, nil
 }
 
-func sanitizeEmail(email string) string {
-	email = strings.ReplaceAll(email, "@", "_at_")
-	email = strings.ReplaceAll(email, ".", "_dot_")
-	return email
+// sendMagicLink delivers a link in the background, a failed delivery is
+// logged and the user has to ask again
+func (s *service) sendMagicLink(ctx context.Context, user *User, linkID string, mail Mail) {
+	ctx, cancel := context.WithTimeout(ctx, magicLinkSendTimeout)
+	defer cancel()
+
+	if err := s.mailer.Send(ctx, mail); err != nil {
+		s.log.ErrorContext(ctx, "[RequestMagicLink] error sending link", "user_id", user.ID, "err", err)
+		return
+	}
+	s.audit(ctx, AuditEvent{Type: AuditMagicLinkSent, Actor: user.Email, UserID: user.ID, Details: map[string]string{"magic_link_id": linkID}})
 }
 
-func (s *service) saveFile(file *multipart.FileHeader, email string) error {
-	safeEmail := sanitizeEmail(email)
-	filePath := filepath.Join(s.uploadDir, safeEmail+".pdf")
+func (s *service) LoginWithMagicLink(ctx context.Context, token, device string) (string, error) {
+	claims, err := parseMagicLinkToken(s.keys.magicLink, token, device)
+	if errors.Is(err, ErrMagicLinkDevice) {
+		// a forwarded or intercepted link, the link stays usable on the right device
+		userID, _ := strconv.Atoi(claims.Subject)
+		s.log.WarnContext(ctx, "[LoginWithMagicLink] link opened on another device", "user_id", claims.Subject)
+		s.audit(ctx, AuditEvent{Type: AuditLoginFailed, UserID: userID, Details: map[string]string{"reason": "magic_link_device", "magic_link_id": claims.ID}})
+		return "", ErrMagicLinkDevice
+	}
+	if err != nil {
+		s.log.InfoContext(ctx, "[LoginWithMagicLink] invalid token", "err", err)
+		return "", ErrMagicLinkInvalid
+	}
 
-	src, err := file.Open()
+	link, err := s.repo.ConsumeMagicLink(ctx, claims.ID)
+	if errors.Is(err, ErrMagicLinkNotFound) {
+		userID, _ := strconv.Atoi(claims.Subject)
+		s.log.InfoContext(ctx, "[LoginWithMagicLink] link was used", "user_id", claims.Subject)
+		s.audit(ctx, AuditEvent{Type: AuditLoginFailed, UserID: userID, Details: map[string]string{"reason": "magic_link_used", "magic_link_id": claims.ID}})
+		return "", ErrMagicLinkInvalid
+	}
 	if err != nil {
-		return err
+		return "", err
+	}
+	if strconv.Itoa(link.UserID) != claims.Subject {
+		return "", ErrMagicLinkInvalid
+	}
+	user, err := s.repo.FindUserByID(ctx, claims.Subject)
+	if errors.Is(err, ErrUserNotFound) {
+		return "", ErrMagicLinkInvalid
+	}
+	if err != nil {
+		return "", err
 	}
-	defer src.Close()
 
-	dst, err := os.Create(filePath)
+	session, err := s.startSession(ctx, user.ID)
+	if err != nil {
+		s.log.ErrorContext(ctx, "[LoginWithMagicLink] error recording session", "user_id", user.ID, "err", err)
+		return "", err
+	}
+	jwtToken, err := GenerateToken(s.keys.login, claims.Subject, user.Email, *session)
+	if err != nil {
+		s.log.ErrorContext(ctx, "[LoginWithMagicLink] error generating token", "user_id", user.ID, "err", err)
+		return "", err
+	}
+	s.audit(ctx, AuditEvent{Type: AuditLoginSucceeded, Actor: user.Email, UserID: user.ID, Details: map[string]string{
+		"session_id":    session.ID,
+		"magic_link_id": link.ID,
+	}})
+	return jwtToken, nil
+}
+
+func (s *service) CreateAPIKey(ctx context.Context, userID string, req APIKeyRequest) (*APIKey, string, error) {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid user id %q: %w", userID, err)
+	}
+	id, secret, key, err := newAPIKey()
 	if err != nil {
+		return nil, "", fmt.Errorf("generate API key: %w", err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	scopes := slices.Compact(slices.Sorted(slices.Values(req.Scopes)))
+
+	var created *APIKey
+	err = s.repo.WithTx(ctx, func(tx Repository) error {
+		keys, err := tx.ListAPIKeys(ctx, idInt)
+		if err != nil {
+			return err
+		}
+		// expired keys don't count against the limit
+		active := 0
+		for _, k := range keys {
+			if now.Before(k.ExpiresAt) {
+				active++
+			}
+		}
+		if active >= maxAPIKeysPerUser {
+			return ErrAPIKeyLimit
+		}
+
+		created, err = tx.CreateAPIKey(ctx, APIKey{
+			ID:         id,
+			UserID:     idInt,
+			Name:       req.Name,
+			SecretHash: hashAPIKeySecret(secret),
+			Scopes:     scopes,
+			CreatedAt:  now,
+			ExpiresAt:  now.AddDate(0, 0, cmp.Or(req.ExpiresInDays, defaultAPIKeyDays)),
+		})
 		return err
+	})
+	if err != nil {
+		return nil, "", err
 	}
-	defer dst.Close()
+	s.log.InfoContext(ctx, "[CreateAPIKey] API key created", "user_id", userID, "api_key_id", id)
+	s.audit(ctx, AuditEvent{Type: AuditAPIKeyCreated, UserID: idInt, Details: map[string]string{
+		"api_key_id": id,
+		"name":       req.Name,
+		"scopes":     strings.Join(scopes, " "),
+	}})
+	return created, key, nil
+}
 
-	_, err = io.Copy(dst, src)
-	return err
+func (s *service) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user id %q: %w", userID, err)
+	}
+	return s.repo.ListAPIKeys(ctx, idInt)
 }
-func (s *service) SaveUserPDF(ctx context.Context, email string, file *multipart.FileHeader) error {
-	return s.saveFile(file, email)
+
+func (s *service) RevokeAPIKey(ctx context.Context, userID, keyID string) error {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return fmt.Errorf("invalid user id %q: %w", userID, err)
+	}
+	if err := s.repo.DeleteAPIKey(ctx, idInt, keyID); err != nil {
+		return err
+	}
+	s.log.InfoContext(ctx, "[RevokeAPIKey] API key revoked", "user_id", userID, "api_key_id", keyID)
+	s.audit(ctx, AuditEvent{Type: AuditAPIKeyRevoked, UserID: idInt, Details: map[string]string{"api_key_id": keyID}})
+	return nil
 }
 
-// func (s *service) UpdateUserInfo(ctx context.Context, request UserInformationRequest) error {
-// 	// Try to update first
-// 	err := s.repo.UpdateUserInfo(ctx, request)
-// 	return err
-// }
+func (s *service) AuthenticateAPIKey(ctx context.Context, key string) (*APIKey, *User, error) {
+	id, secret, ok := parseAPIKey(key)
+	if !ok {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	apiKey, err := s.repo.FindAPIKey(ctx, id)
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	if subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(secret)), []byte(apiKey.SecretHash)) != 1 || !now.Before(apiKey.ExpiresAt) {
+		return nil, nil, ErrInvalidAPIKey
+	}
 
-// // User information update
+	user, err := s.repo.FindUserByID(ctx, strconv.Itoa(apiKey.UserID))
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyTouchInterval {
+		// a failure only loses the last used time, the request goes on
+		if err := s.repo.TouchAPIKey(ctx, apiKey.ID, now.UTC().Truncate(time.Second)); err != nil {
+			s.log.WarnContext(ctx, "[AuthenticateAPIKey] error recording last use", "api_key_id", apiKey.ID, "err", err)
+		}
+	}
+	return apiKey, user, nil
+}
+
+// rehash upgrades a stored hash to the current algorithm and parameters while
+// the plain password is at hand, a failure only delays it to the next login
+func (s *service) rehash(ctx context.Context, userID int, password string) {
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		s.log.ErrorContext(ctx, "[Login] error rehashing password", "user_id", userID, "err", err)
+		return
+	}
+	if err := s.repo.UpdatePassword(ctx, strconv.Itoa(userID), hashedPassword); err != nil {
+		s.log.ErrorContext(ctx, "[Login] error storing rehashed password", "user_id", userID, "err", err)
+		return
+	}
+	s.log.InfoContext(ctx, "[Login] password rehashed", "user_id", userID)
+}
+
+// get profilefunc calculateAge(dob time.Time) int {
+func calculateAge(dob time.Time) int {
+	// users who signed up with a provider that withheld the birthdate have none yet
+	if dob.IsZero() {
+		return 0
+	}
+	now := time.Now()
+	years := now.Year() - dob.Year()
+	if now.YearDay() < dob.YearDay() {
+		years--
+	}
+	return years
+}
+
+func (s *service) GetProfile(ctx context.Context, userID string) (User, error) {
+	s.log.DebugContext(ctx, "[GetProfile] called", "user_id", userID)
+
+	user, err := s.repo.FindUserByID(ctx, userID)
+	if err != nil {
+		s.log.ErrorContext(ctx, "[GetProfile] error fetching user", "user_id", userID, "err", err)
+		return User{}, err
+	}
+
+	// Calculate age from DOB
+	user.Age = calculateAge(user.DOB)
+
+	user.Vehicles, err = s.repo.ListVehicles(ctx, userID)
+	if err != nil {
+		s.log.ErrorContext(ctx, "[GetProfile] error fetching vehicles", "user_id", userID, "err", err)
+		return User{}, err
+	}
+
+	return *user, nil
+}
+
+func (s *service) ListVehicles(ctx context.Context, userID string) ([]UserVehicle, error) {
+	return s.repo.ListVehicles(ctx, userID)
+}
+
+func (s *service) GetVehicle(ctx context.Context, userID string, vehicleID int) (UserVehicle, error) {
+	vehicle, err := s.repo.FindVehicle(ctx, userID, vehicleID)
+	if err != nil {
+		return UserVehicle{}, err
+	}
+	return *vehicle, nil
+}
+
+func (s *service) CreateVehicle(ctx context.Context, userID string, req VehicleRequest) (UserVehicle, error) {
+	vehicle, err := newUserVehicle(userID, req)
+	if err != nil {
+		return UserVehicle{}, err
+	}
+
+	created, err := s.repo.CreateVehicle(ctx, vehicle)
+	if err != nil {
+		return UserVehicle{}, err
+	}
+	s.log.InfoContext(ctx, "[CreateVehicle] vehicle added", "user_id", userID, "vehicle_id", created.ID)
+	return *created, nil
+}
+
+func (s *service) UpdateVehicle(ctx context.Context, userID string, vehicleID int, req VehicleRequest) (UserVehicle, error) {
+	vehicle, err := newUserVehicle(userID, req)
+	if err != nil {
+		return UserVehicle{}, err
+	}
+	vehicle.ID = vehicleID
+
+	updated, err := s.repo.UpdateVehicle(ctx, vehicle)
+	if err != nil {
+		return UserVehicle{}, err
+	}
+	return *updated, nil
+}
+
+func (s *service) DeleteVehicle(ctx context.Context, userID string, vehicleID int) error {
+	if err := s.repo.DeleteVehicle(ctx, userID, vehicleID); err != nil {
+		return err
+	}
+	s.log.InfoContext(ctx, "[DeleteVehicle] vehicle removed", "user_id", userID, "vehicle_id", vehicleID)
+	return nil
+}
+
+// newUserVehicle builds the row for req, the registration number is stored
+// upper cased without spaces or dashes so "mh-12 ab 1234" and "MH12AB1234" match
+func newUserVehicle(userID string, req VehicleRequest) (UserVehicle, error) {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return UserVehicle{}, fmt.Errorf("invalid user id %q: %w", userID, err)
+	}
+
+	registration := strings.ToUpper(req.RegistrationNumber)
+	registration = strings.NewReplacer(" ", "", "-", "").Replace(registration)
+
+	return UserVehicle{
+		UserID:             idInt,
+		Type:               req.Type,
+		Make:               collapseSpaces(req.Make),
+		Model:              collapseSpaces(req.Model),
+		Year:               req.Year,
+		RegistrationNumber: registration,
+		OwnedFrom:          req.OwnedFrom,
+		OwnedUntil:         req.OwnedUntil,
+	}, nil
+}
+
+func (s *service) ListAddresses(ctx context.Context, userID string) ([]UserAddress, error) {
+	return s.repo.ListAddresses(ctx, userID)
+}
+
+func (s *service) GetAddress(ctx context.Context, userID string, addressID int) (UserAddress, error) {
+	address, err := s.repo.FindAddress(ctx, userID, addressID)
+	if err != nil {
+		return UserAddress{}, err
+	}
+	return *address, nil
+}
+
+// CreateAddress adds an address, the first one that is not a previous address
+// becomes primary even when the request does not ask for it
+func (s *service) CreateAddress(ctx context.Context, userID string, req AddressRequest) (UserAddress, error) {
+	address, err := newUserAddress(userID, req)
+	if err != nil {
+		return UserAddress{}, err
+	}
+
+	var created *UserAddress
+	err = s.repo.WithTx(ctx, func(tx Repository) error {
+		user, err := lockAddresses(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		before := profileSnapshot(user)
+		if address.IsPrimary {
+			if err := tx.ClearPrimaryAddress(ctx, userID); err != nil {
+				return err
+			}
+		}
+
+		if created, err = tx.CreateAddress(ctx, address); err != nil {
+			return err
+		}
+		if created, err = ensurePrimaryAddress(ctx, tx, userID, created); err != nil {
+			return err
+		}
+		return bumpVersionWithHistory(ctx, tx, before, userID, user.Email)
+	})
+	if err != nil {
+		return UserAddress{}, err
+	}
+	s.log.InfoContext(ctx, "[CreateAddress] address added", "user_id", userID, "address_id", created.ID, "primary", created.IsPrimary)
+	return *created, nil
+}
+
+// UpdateAddress replaces an address, demoting the primary address promotes
+// another one like DeleteAddress does
+func (s *service) UpdateAddress(ctx context.Context, userID string, addressID int, req AddressRequest) (UserAddress, error) {
+	address, err := newUserAddress(userID, req)
+	if err != nil {
+		return UserAddress{}, err
+	}
+	address.ID = addressID
+
+	var updated *UserAddress
+	err = s.repo.WithTx(ctx, func(tx Repository) error {
+		user, err := lockAddresses(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		before := profileSnapshot(user)
+		stored, err := tx.FindAddress(ctx, userID, addressID)
+		if err != nil {
+			return err
+		}
+		if address.IsPrimary && !stored.IsPrimary {
+			if err := tx.ClearPrimaryAddress(ctx, userID); err != nil {
+				return err
+			}
+		}
+
+		if updated, err = tx.UpdateAddress(ctx, address); err != nil {
+			return err
+		}
+		if updated, err = ensurePrimaryAddress(ctx, tx, userID, updated); err != nil {
+			return err
+		}
+		return bumpVersionWithHistory(ctx, tx, before, userID, user.Email)
+	})
+	if err != nil {
+		return UserAddress{}, err
+	}
+	return *updated, nil
+}
+
+// DeleteAddress removes an address, when it was the primary one the oldest
+// remaining address that is not a previous address takes its place
+func (s *service) DeleteAddress(ctx context.Context, userID string, addressID int) error {
+	err := s.repo.WithTx(ctx, func(tx Repository) error {
+		user, err := lockAddresses(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		before := profileSnapshot(user)
+		if err := tx.DeleteAddress(ctx, userID, addressID); err != nil {
+			return err
+		}
+		if _, err := ensurePrimaryAddress(ctx, tx, userID, nil); err != nil {
+			return err
+		}
+		return bumpVersionWithHistory(ctx, tx, before, userID, user.Email)
+	})
+	if err != nil {
+		return err
+	}
+	s.log.InfoContext(ctx, "[DeleteAddress] address removed", "user_id", userID, "address_id", addressID)
+	return nil
+}
+
+// lockAddresses locks the user so concurrent address changes keep a single
+// primary address, the user is returned as it was before the change
+func lockAddresses(ctx context.Context, tx Repository, userID string) (*User, error) {
+	return tx.FindUserByIDForUpdate(ctx, userID)
+}
+
+// ensurePrimaryAddress promotes the oldest address that is not a previous
+// address when the user has none, changed is returned with IsPrimary set if it
+// is the promoted one
+func ensurePrimaryAddress(ctx context.Context, tx Repository, userID string, changed *UserAddress) (*UserAddress, error) {
+	addresses, err := tx.ListAddresses(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidate *UserAddress
+	for i := range addresses {
+		if addresses[i].IsPrimary {
+			return changed, nil
+		}
+		if candidate == nil && addresses[i].Type != AddressPrevious {
+			candidate = &addresses[i]
+		}
+	}
+	if candidate == nil {
+		return changed, nil
+	}
+
+	candidate.IsPrimary = true
+	promoted, err := tx.UpdateAddress(ctx, *candidate)
+	if err != nil {
+		return nil, err
+	}
+	if changed != nil && changed.ID == promoted.ID {
+		return promoted, nil
+	}
+	return changed, nil
+}
+
+// bumpVersion increments the profile version and returns the new one, the
+// primary address is part of the profile so address changes invalidate its ETag
+func bumpVersion(ctx context.Context, tx Repository, userID string) (int, error) {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return 0, fmt.Errorf("invalid user id %q: %w", userID, err)
+	}
+	return tx.UpdateUserInfo(ctx, UserInformationRequest{ID: idInt, Version: AnyVersion})
+}
+
+// bumpVersionWithHistory bumps the version after a change the user made and
+// records it if the profile changed, like a change of the primary address
+func bumpVersionWithHistory(ctx context.Context, tx Repository, before ProfileSnapshot, userID, actor string) error {
+	version, err := bumpVersion(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	return recordHistory(ctx, tx, before, userID, version, actor, nil)
+}
+
+// newUserAddress builds the row for req with its address normalized
+func newUserAddress(userID string, req AddressRequest) (UserAddress, error) {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return UserAddress{}, fmt.Errorf("invalid user id %q: %w", userID, err)
+	}
+
+	address := req.Address
+	if err := NormalizeAddress(&address, ""); err != nil {
+		return UserAddress{}, err
+	}
+
+	return UserAddress{
+		UserID:     idInt,
+		Type:       req.Type,
+		Address:    address,
+		ValidFrom:  req.ValidFrom,
+		ValidUntil: req.ValidUntil,
+		IsPrimary:  req.IsPrimary,
+	}, nil
+}
+
+func sanitizeEmail(email string) string {
+	email = strings.ReplaceAll(email, "@", "_at_")
+	email = strings.ReplaceAll(email, ".", "_dot_")
+	return email
+}
+
+func (s *service) saveFile(file *multipart.FileHeader, email string) error {
+	safeEmail := sanitizeEmail(email)
+	filePath := filepath.Join(s.uploadDir, safeEmail+".pdf")
+
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+}
+func (s *service) SaveUserPDF(ctx context.Context, email string, file *multipart.FileHeader) error {
+	if err := s.saveFile(file, email); err != nil {
+		return err
+	}
+	s.audit(ctx, AuditEvent{Type: AuditDocumentUploaded, Details: map[string]string{
+		"email":    email,
+		"filename": file.Filename,
+		"size":     strconv.FormatInt(file.Size, 10),
+	}})
+	return nil
+}
+
+// func (s *service) UpdateUserInfo(ctx context.Context, request UserInformationRequest) error {
+// 	// Try to update first
+// 	err := s.repo.UpdateUserInfo(ctx, request)
+// 	return err
+// }
+
+// // User information update
 
 // // Helper functions to check empty structs
 // func isEmptyAddress(addr Address) bool {
@@ -234,3 +1330,529 @@ func (s *service) SaveUserPDF(ctx context.Context, email string, file *multipart
 // 	// Customize depending on what empty means
 // 	return (v.Car == nil && v.Bike == nil)
 // }
+
+func (s *service) OIDCProviders() []string {
+	return s.oidc.Names()
+}
+
+// OIDCLoginStart is where to send the user to sign in with a provider, State
+// has to come back with the callback in the cookie it is stored in
+type OIDCLoginStart struct {
+	AuthURL string
+	State   string
+}
+
+// OIDCCallback is the redirect back from a provider, Error is set instead of
+// Code when the user did not sign in
+type OIDCCallback struct {
+	Code  string
+	State string
+	Error string
+	// Cookie is OIDCLoginStart.State
+	Cookie string
+}
+
+// OIDCLoginResult is a login with a token for the user or, when the login
+// was started to link the provider, the linked Identity
+type OIDCLoginResult struct {
+	Token    string
+	UserID   string
+	Created  bool
+	Identity *UserIdentity
+}
+
+func (s *service) StartOIDCLogin(ctx context.Context, provider, linkUserID string) (OIDCLoginStart, error) {
+	p, err := s.oidc.get(provider)
+	if err != nil {
+		return OIDCLoginStart{}, err
+	}
+	state, err := newOIDCState(provider, linkUserID)
+	if err != nil {
+		return OIDCLoginStart{}, err
+	}
+
+	authURL, err := p.authURL(ctx, state.State, state.Nonce, state.Verifier)
+	if err != nil {
+		s.log.ErrorContext(ctx, "[StartOIDCLogin] error discovering provider", "provider", provider, "err", err)
+		return OIDCLoginStart{}, err
+	}
+	signed, err := state.sign(s.keys.oidcState)
+	if err != nil {
+		return OIDCLoginStart{}, err
+	}
+	return OIDCLoginStart{AuthURL: authURL, State: signed}, nil
+}
+
+func (s *service) FinishOIDCLogin(ctx context.Context, provider string, callback OIDCCallback) (OIDCLoginResult, error) {
+	p, err := s.oidc.get(provider)
+	if err != nil {
+		return OIDCLoginResult{}, err
+	}
+	state, err := parseOIDCState(s.keys.oidcState, callback.Cookie, provider, callback.State)
+	if err != nil {
+		s.log.WarnContext(ctx, "[FinishOIDCLogin] rejected callback", "provider", provider, "err", err)
+		return OIDCLoginResult{}, err
+	}
+	if callback.Error != "" {
+		s.log.InfoContext(ctx, "[FinishOIDCLogin] provider returned an error", "provider", provider, "error", callback.Error)
+		return OIDCLoginResult{}, fmt.Errorf("%w: %s", ErrOIDCLoginFailed, callback.Error)
+	}
+
+	idToken, err := p.exchange(ctx, callback.Code, state.Verifier)
+	if err != nil {
+		s.log.WarnContext(ctx, "[FinishOIDCLogin] code exchange failed", "provider", provider, "err", err)
+		return OIDCLoginResult{}, err
+	}
+	claims, err := p.verifyIDToken(ctx, idToken, state.Nonce)
+	if err != nil {
+		s.log.WarnContext(ctx, "[FinishOIDCLogin] ID token rejected", "provider", provider, "err", err)
+		return OIDCLoginResult{}, err
+	}
+
+	if state.LinkUserID != "" {
+		identity, err := s.linkIdentity(ctx, state.LinkUserID, provider, claims)
+		if err != nil {
+			return OIDCLoginResult{}, err
+		}
+		return OIDCLoginResult{UserID: state.LinkUserID, Identity: identity}, nil
+	}
+
+	var user *User
+	created := false
+	identity, err := s.repo.FindIdentity(ctx, provider, claims.Subject)
+	switch {
+	case err == nil:
+		user, err = s.repo.FindUserByID(ctx, strconv.Itoa(identity.UserID))
+	case errors.Is(err, ErrIdentityNotFound):
+		user, err = s.registerOIDCUser(ctx, provider, claims)
+		created = true
+	}
+	if err != nil {
+		return OIDCLoginResult{}, err
+	}
+
+	session, err := s.startSession(ctx, user.ID)
+	if err != nil {
+		s.log.ErrorContext(ctx, "[FinishOIDCLogin] error recording session", "user_id", user.ID, "err", err)
+		return OIDCLoginResult{}, err
+	}
+	userID := strconv.Itoa(user.ID)
+	token, err := GenerateToken(s.keys.login, userID, user.Email, *session)
+	if err != nil {
+		s.log.ErrorContext(ctx, "[FinishOIDCLogin] error generating token", "user_id", user.ID, "err", err)
+		return OIDCLoginResult{}, err
+	}
+	s.audit(ctx, AuditEvent{Type: AuditLoginSucceeded, Actor: user.Email, UserID: user.ID, Details: map[string]string{
+		"session_id": session.ID,
+		"provider":   provider,
+	}})
+	return OIDCLoginResult{Token: token, UserID: userID, Created: created}, nil
+}
+
+// registerOIDCUser creates the user for an account at a provider nobody
+// linked yet. The user has no password and can set one later. An existing
+// user with the email has to link the provider, taking over the account
+// because the provider reports its email would let the provider's users in.
+func (s *service) registerOIDCUser(ctx context.Context, provider string, claims *OIDCClaims) (*User, error) {
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, ErrOIDCEmailUnverified
+	}
+	email := strings.ToLower(claims.Email)
+	request := UserRegisterRequest{
+		FirstName: truncateName(cmp.Or(claims.GivenName, claims.Name, strings.Split(email, "@")[0])),
+		LastName:  truncateName(claims.FamilyName),
+		Email:     email,
+		Gender:    string(GenderPreferNotToSay),
+	}
+	// the provider may withhold the birthdate, the user can add it to the profile
+	if dob, err := time.Parse(time.DateOnly, claims.Birthdate); err == nil {
+		request.DOB = Date(dob)
+	}
+
+	var userID string
+	err := s.repo.WithTx(ctx, func(tx Repository) error {
+		_, err := tx.FindUserByEmail(ctx, email)
+		if err == nil {
+			return ErrOIDCAccountExists
+		}
+		if !errors.Is(err, ErrUserNotFound) {
+			return err
+		}
+		if userID, err = tx.UserRegister(ctx, request); err != nil {
+			return err
+		}
+		idInt, _ := strconv.Atoi(userID)
+		_, err = tx.CreateIdentity(ctx, UserIdentity{UserID: idInt, Provider: provider, Subject: claims.Subject, Email: email})
+		return err
+	})
+	if errors.Is(err, ErrEmailTaken) {
+		return nil, ErrOIDCAccountExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.InfoContext(ctx, "[FinishOIDCLogin] user registered", "user_id", userID, "provider", provider)
+	idInt, _ := strconv.Atoi(userID)
+	s.audit(ctx, AuditEvent{Type: AuditUserRegistered, Actor: email, UserID: idInt, Details: map[string]string{"provider": provider}})
+	return s.repo.FindUserByID(ctx, userID)
+}
+
+// truncateName cuts a name from a provider to the 100 characters a profile takes
+func truncateName(name string) string {
+	if r := []rune(name); len(r) > 100 {
+		return string(r[:100])
+	}
+	return name
+}
+
+func (s *service) linkIdentity(ctx context.Context, userID, provider string, claims *OIDCClaims) (*UserIdentity, error) {
+	user, err := s.repo.FindUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := s.repo.CreateIdentity(ctx, UserIdentity{UserID: user.ID, Provider: provider, Subject: claims.Subject, Email: strings.ToLower(claims.Email)})
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.InfoContext(ctx, "[FinishOIDCLogin] identity linked", "user_id", userID, "provider", provider)
+	s.audit(ctx, AuditEvent{Type: AuditIdentityLinked, Actor: user.Email, UserID: user.ID, Details: map[string]string{"provider": provider}})
+	return identity, nil
+}
+
+func (s *service) ListIdentities(ctx context.Context, userID string) ([]UserIdentity, error) {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user id %q: %w", userID, err)
+	}
+	return s.repo.ListIdentities(ctx, idInt)
+}
+
+func (s *service) UnlinkIdentity(ctx context.Context, userID, provider string) error {
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return fmt.Errorf("invalid user id %q: %w", userID, err)
+	}
+
+	err = s.repo.WithTx(ctx, func(tx Repository) error {
+		// locked so two unlinks can't both see another provider left
+		user, err := tx.FindUserByIDForUpdate(ctx, userID)
+		if err != nil {
+			return err
+		}
+		identities, err := tx.ListIdentities(ctx, idInt)
+		if err != nil {
+			return err
+		}
+		if user.Password == "" && len(identities) == 1 && identities[0].Provider == provider {
+			return ErrLastLoginMethod
+		}
+		return tx.DeleteIdentity(ctx, idInt, provider)
+	})
+	if err != nil {
+		return err
+	}
+	s.audit(ctx, AuditEvent{Type: AuditIdentityUnlinked, UserID: idInt, Details: map[string]string{"provider": provider}})
+	return nil
+}
+
+func (s *service) RegisterOAuthClient(ctx context.Context, req OAuthClientRequest) (*OAuthClient, string, error) {
+	for _, uri := range req.RedirectURIs {
+		if !validRedirectURI(uri) {
+			return nil, "", NewValidationError(url.Values{"redirect_uris": {fmt.Sprintf("%s must be https, or http on localhost, without a fragment", uri)}})
+		}
+	}
+
+	id, err := newOAuthSecret(12)
+	if err != nil {
+		return nil, "", err
+	}
+	client := OAuthClient{
+		ID:           id,
+		Name:         req.Name,
+		RedirectURIs: slices.Compact(slices.Sorted(slices.Values(req.RedirectURIs))),
+		Scopes:       []string{"openid"},
+	}
+	for _, scope := range OAuthScopes {
+		if scope != "openid" && slices.Contains(req.Scopes, scope) {
+			client.Scopes = append(client.Scopes, scope)
+		}
+	}
+	var secret string
+	if !req.Public {
+		if secret, err = newOAuthSecret(32); err != nil {
+			return nil, "", err
+		}
+		client.SecretHash = hashOAuthSecret(secret)
+	}
+
+	created, err := s.repo.CreateOAuthClient(ctx, client)
+	if err != nil {
+		return nil, "", err
+	}
+	s.log.InfoContext(ctx, "[RegisterOAuthClient] client registered", "client_id", created.ID, "public", req.Public)
+	s.audit(ctx, AuditEvent{Type: AuditOAuthClientRegistered, Details: map[string]string{"client_id": created.ID, "name": created.Name}})
+	return created, secret, nil
+}
+
+func (s *service) ListOAuthClients(ctx context.Context) ([]OAuthClient, error) {
+	return s.repo.ListOAuthClients(ctx)
+}
+
+func (s *service) DeleteOAuthClient(ctx context.Context, clientID string) error {
+	if err := s.repo.DeleteOAuthClient(ctx, clientID); err != nil {
+		return err
+	}
+	s.audit(ctx, AuditEvent{Type: AuditOAuthClientDeleted, Details: map[string]string{"client_id": clientID}})
+	return nil
+}
+
+func (s *service) OAuthMetadata() OAuthServerMetadata {
+	return s.oauth.metadata()
+}
+
+func (s *service) OAuthKeys(ctx context.Context) (JSONWebKeySet, error) {
+	keys, err := s.oauth.signingKeys(ctx, s.repo)
+	if err != nil {
+		s.log.ErrorContext(ctx, "[OAuthKeys] error loading signing keys", "err", err)
+		return JSONWebKeySet{}, err
+	}
+	set := JSONWebKeySet{Keys: make([]JSONWebKey, len(keys))}
+	for i, k := range keys {
+		set.Keys[i] = k.publicJWK()
+	}
+	return set, nil
+}
+
+func (s *service) PrepareAuthorization(ctx context.Context, req AuthorizationRequest) (*AuthorizationPrompt, error) {
+	client, err := s.repo.FindOAuthClient(ctx, req.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	// exact match only, the client proved it owns the registered URIs
+	if !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		return nil, ErrOAuthRedirectURI
+	}
+
+	if req.ResponseType != "code" {
+		return nil, newOAuthError(http.StatusBadRequest, "unsupported_response_type", "only the code response type is supported")
+	}
+	scopes := strings.Fields(req.Scope)
+	if len(scopes) == 0 {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_scope", "scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return nil, newOAuthError(http.StatusBadRequest, "invalid_scope", fmt.Sprintf("scope %q is not allowed for the client", scope))
+		}
+	}
+	switch {
+	case req.CodeChallenge == "" && client.Public():
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "public clients must use PKCE")
+	case req.CodeChallenge != "" && req.CodeChallengeMethod != "S256":
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "code_challenge_method must be S256")
+	}
+
+	return &AuthorizationPrompt{Client: *client, Scopes: slices.Compact(slices.Sorted(slices.Values(scopes)))}, nil
+}
+
+func (s *service) AuthorizeLogin(ctx context.Context, req AuthorizationRequest, email, password string) (AuthorizationStep, error) {
+	prompt, err := s.PrepareAuthorization(ctx, req)
+	if err != nil {
+		return AuthorizationStep{}, err
+	}
+	user, err := s.checkCredentials(ctx, email, password)
+	if err != nil {
+		return AuthorizationStep{}, err
+	}
+	s.audit(ctx, AuditEvent{Type: AuditLoginSucceeded, Actor: user.Email, UserID: user.ID, Details: map[string]string{"client_id": prompt.Client.ID}})
+
+	consent, err := s.repo.FindOAuthConsent(ctx, user.ID, prompt.Client.ID)
+	if err != nil && !errors.Is(err, ErrOAuthConsentNotFound) {
+		return AuthorizationStep{}, err
+	}
+	if consent != nil && !slices.ContainsFunc(prompt.Scopes, func(scope string) bool { return !slices.Contains(consent.Scopes, scope) }) {
+		redirectURL, err := s.issueAuthorizationCode(ctx, req, prompt, user.ID)
+		return AuthorizationStep{RedirectURL: redirectURL}, err
+	}
+
+	ticket, err := newConsentTicket(s.keys.consent, user.ID, req)
+	if err != nil {
+		return AuthorizationStep{}, err
+	}
+	return AuthorizationStep{ConsentTicket: ticket}, nil
+}
+
+func (s *service) AuthorizeConsent(ctx context.Context, req AuthorizationRequest, ticket string, approve bool) (AuthorizationStep, error) {
+	prompt, err := s.PrepareAuthorization(ctx, req)
+	if err != nil {
+		return AuthorizationStep{}, err
+	}
+	if !approve {
+		s.log.InfoContext(ctx, "[AuthorizeConsent] user denied the client", "client_id", prompt.Client.ID)
+		return AuthorizationStep{RedirectURL: req.errorRedirect(newOAuthError(http.StatusBadRequest, "access_denied", "the user denied the request"))}, nil
+	}
+
+	userID, err := parseConsentTicket(s.keys.consent, ticket, req)
+	if err != nil {
+		s.log.InfoContext(ctx, "[AuthorizeConsent] rejected consent ticket", "client_id", prompt.Client.ID, "err", err)
+		return AuthorizationStep{}, ErrConsentExpired
+	}
+	idInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return AuthorizationStep{}, ErrConsentExpired
+	}
+
+	// the scopes granted before stay granted
+	scopes := prompt.Scopes
+	consent, err := s.repo.FindOAuthConsent(ctx, idInt, prompt.Client.ID)
+	if err == nil {
+		scopes = slices.Compact(slices.Sorted(slices.Values(append(scopes, consent.Scopes...))))
+	} else if !errors.Is(err, ErrOAuthConsentNotFound) {
+		return AuthorizationStep{}, err
+	}
+	err = s.repo.SaveOAuthConsent(ctx, OAuthConsent{UserID: idInt, ClientID: prompt.Client.ID, Scopes: scopes, GrantedAt: time.Now().UTC().Truncate(time.Second)})
+	if err != nil {
+		return AuthorizationStep{}, err
+	}
+
+	redirectURL, err := s.issueAuthorizationCode(ctx, req, prompt, idInt)
+	return AuthorizationStep{RedirectURL: redirectURL}, err
+}
+
+// issueAuthorizationCode returns the redirect that hands the client a code
+func (s *service) issueAuthorizationCode(ctx context.Context, req AuthorizationRequest, prompt *AuthorizationPrompt, userID int) (string, error) {
+	code, err := newOAuthSecret(32)
+	if err != nil {
+		return "", err
+	}
+	err = s.repo.CreateAuthorizationCode(ctx, OAuthAuthorizationCode{
+		CodeHash:      hashOAuthSecret(code),
+		ClientID:      prompt.Client.ID,
+		UserID:        userID,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        prompt.Scopes,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().UTC().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		s.log.ErrorContext(ctx, "[Authorize] error storing authorization code", "client_id", prompt.Client.ID, "err", err)
+		return "", err
+	}
+
+	s.audit(ctx, AuditEvent{Type: AuditOAuthAuthorized, UserID: userID, Details: map[string]string{
+		"client_id": prompt.Client.ID,
+		"scope":     strings.Join(prompt.Scopes, " "),
+	}})
+	return req.redirect(url.Values{"code": {code}}), nil
+}
+
+func (s *service) ExchangeAuthorizationCode(ctx context.Context, req TokenRequest) (*TokenResponse, error) {
+	invalidClient := newOAuthError(http.StatusUnauthorized, "invalid_client", "client authentication failed")
+	client, err := s.repo.FindOAuthClient(ctx, req.ClientID)
+	if errors.Is(err, ErrOAuthClientNotFound) {
+		return nil, invalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !client.Public() && subtle.ConstantTimeCompare([]byte(hashOAuthSecret(req.ClientSecret)), []byte(client.SecretHash)) != 1 {
+		s.log.InfoContext(ctx, "[ExchangeAuthorizationCode] wrong client secret", "client_id", client.ID)
+		return nil, invalidClient
+	}
+	if req.GrantType != "authorization_code" {
+		return nil, newOAuthError(http.StatusBadRequest, "unsupported_grant_type", "only the authorization_code grant is supported")
+	}
+
+	invalidGrant := newOAuthError(http.StatusBadRequest, "invalid_grant", "the code is invalid, expired or was used")
+	code, err := s.repo.ConsumeAuthorizationCode(ctx, hashOAuthSecret(req.Code))
+	if errors.Is(err, ErrAuthorizationCodeNotFound) {
+		return nil, invalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+	if code.ClientID != client.ID || code.RedirectURI != req.RedirectURI || time.Now().After(code.ExpiresAt) {
+		s.log.InfoContext(ctx, "[ExchangeAuthorizationCode] code does not match the request", "client_id", client.ID)
+		return nil, invalidGrant
+	}
+	// the verifier must match the challenge, and there must be none without a challenge
+	challenge := sha256.Sum256([]byte(req.CodeVerifier))
+	if (code.CodeChallenge != "" || req.CodeVerifier != "") && base64.RawURLEncoding.EncodeToString(challenge[:]) != code.CodeChallenge {
+		s.log.InfoContext(ctx, "[ExchangeAuthorizationCode] code_verifier mismatch", "client_id", client.ID)
+		return nil, invalidGrant
+	}
+
+	userID := strconv.Itoa(code.UserID)
+	now := time.Now()
+	accessToken, err := s.oauth.sign(ctx, s.repo, accessTokenClaims{
+		ClientID: client.ID,
+		Scope:    strings.Join(code.Scopes, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.oauth.config.Issuer,
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{s.oauth.config.Issuer},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(oauthTokenTTL)),
+		},
+	}, accessTokenType)
+	if err != nil {
+		s.log.ErrorContext(ctx, "[ExchangeAuthorizationCode] error signing access token", "err", err)
+		return nil, err
+	}
+	response := &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(oauthTokenTTL.Seconds()),
+		Scope:       strings.Join(code.Scopes, " "),
+	}
+
+	if slices.Contains(code.Scopes, "openid") {
+		user, err := s.GetProfile(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		claims := jwt.MapClaims(profileClaims(user, code.Scopes))
+		claims["iss"] = s.oauth.config.Issuer
+		claims["sub"] = userID
+		claims["aud"] = client.ID
+		claims["iat"] = now.Unix()
+		claims["exp"] = now.Add(oauthTokenTTL).Unix()
+		// the user logged in on the login screen just before the code was issued
+		claims["auth_time"] = code.ExpiresAt.Add(-authorizationCodeTTL).Unix()
+		if code.Nonce != "" {
+			claims["nonce"] = code.Nonce
+		}
+		if response.IDToken, err = s.oauth.sign(ctx, s.repo, claims, ""); err != nil {
+			s.log.ErrorContext(ctx, "[ExchangeAuthorizationCode] error signing ID token", "err", err)
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+func (s *service) OAuthUserInfo(ctx context.Context, accessToken string) (map[string]any, error) {
+	claims, err := s.oauth.parseAccessToken(ctx, s.repo, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	// tokens of a deleted client stop working
+	if _, err := s.repo.FindOAuthClient(ctx, claims.ClientID); errors.Is(err, ErrOAuthClientNotFound) {
+		return nil, newOAuthError(http.StatusUnauthorized, "invalid_token", "the client was deleted")
+	} else if err != nil {
+		return nil, err
+	}
+
+	user, err := s.GetProfile(ctx, claims.Subject)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, newOAuthError(http.StatusUnauthorized, "invalid_token", "the user does not exist")
+	}
+	if err != nil {
+		return nil, err
+	}
+	info := profileClaims(user, strings.Fields(claims.Scope))
+	info["sub"] = claims.Subject
+	return info, nil
+}
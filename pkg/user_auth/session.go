@@ -0,0 +1,65 @@
+package userauth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// userAgentLabels are matched in order against the user agent, the first
+// match names the browser or the OS. Order matters as Edge and Opera also
+// claim to be Chrome and Chrome claims to be Safari.
+var (
+	browserLabels = []struct{ token, label string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"CriOS/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+	osLabels = []struct{ token, label string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// deviceLabel names the browser and OS of a user agent, e.g. "Firefox on Linux"
+func deviceLabel(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+	browser, system := "Unknown browser", ""
+	for _, l := range browserLabels {
+		if strings.Contains(userAgent, l.token) {
+			browser = l.label
+			break
+		}
+	}
+	for _, l := range osLabels {
+		if strings.Contains(userAgent, l.token) {
+			system = l.label
+			break
+		}
+	}
+	if system == "" {
+		return browser
+	}
+	return browser + " on " + system
+}
+
+// newSessionID returns a random session ID, it is the jti of the tokens
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate session id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
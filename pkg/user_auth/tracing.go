@@ -0,0 +1,459 @@
+package userauth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mime/multipart"
+
+	"github.com/abhiii71/clean-code-abhi/pkg/tracing"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/abhiii71/clean-code-abhi/pkg/user_auth")
+
+// startQuerySpan starts a client span for a single repository query
+func startQuerySpan(ctx context.Context, system attribute.KeyValue, operation, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "Repository."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			system,
+			semconv.DBOperationName(operation),
+			semconv.DBQueryText(tracing.SanitizeSQL(query)),
+		),
+	)
+}
+
+// recordError marks the span as failed, a missing row is not treated as a failure
+func recordError(span trace.Span, err error) {
+	if err == nil || errors.Is(err, sql.ErrNoRows) || errors.Is(err, pgx.ErrNoRows) || errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrVehicleNotFound) || errors.Is(err, ErrAddressNotFound) || errors.Is(err, ErrIdentityChangeNotFound) ||
+		errors.Is(err, ErrProfileVersionNotFound) || errors.Is(err, ErrSessionNotFound) ||
+		errors.Is(err, ErrIdentityNotFound) || errors.Is(err, ErrOAuthClientNotFound) || errors.Is(err, ErrOAuthConsentNotFound) ||
+		errors.Is(err, ErrAuthorizationCodeNotFound) || errors.Is(err, ErrAPIKeyNotFound) || errors.Is(err, ErrMagicLinkNotFound) {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// tracedService wraps a Service and opens a span around every call. It does
+// not embed the Service so a method without a wrapper fails to compile.
+type tracedService struct {
+	next Service
+}
+
+// NewTracedService decorates the given Service with tracing spans
+func NewTracedService(next Service) Service {
+	return &tracedService{next: next}
+}
+
+func (t *tracedService) UserRegister(ctx context.Context, request UserRegisterRequest) (string, error) {
+	ctx, span := tracer.Start(ctx, "Service.UserRegister")
+	defer span.End()
+
+	userID, err := t.next.UserRegister(ctx, request)
+	recordError(span, err)
+	return userID, err
+}
+
+func (t *tracedService) GetUserProfile(ctx context.Context, request UserLoginRequest) (string, error) {
+	ctx, span := tracer.Start(ctx, "Service.GetUserProfile")
+	defer span.End()
+
+	token, err := t.next.GetUserProfile(ctx, request)
+	recordError(span, err)
+	return token, err
+}
+
+func (t *tracedService) GetProfile(ctx context.Context, userID string) (User, error) {
+	ctx, span := tracer.Start(ctx, "Service.GetProfile")
+	defer span.End()
+
+	user, err := t.next.GetProfile(ctx, userID)
+	recordError(span, err)
+	return user, err
+}
+
+func (t *tracedService) UpdateUserInfo(ctx context.Context, req UserInformationRequest) (ProfileUpdate, error) {
+	ctx, span := tracer.Start(ctx, "Service.UpdateUserInfo")
+	defer span.End()
+
+	update, err := t.next.UpdateUserInfo(ctx, req)
+	recordError(span, err)
+	return update, err
+}
+
+func (t *tracedService) ChangePassword(ctx context.Context, userID string, req ChangePasswordRequest) error {
+	ctx, span := tracer.Start(ctx, "Service.ChangePassword")
+	defer span.End()
+
+	err := t.next.ChangePassword(ctx, userID, req)
+	recordError(span, err)
+	return err
+}
+
+func (t *tracedService) ListVehicles(ctx context.Context, userID string) ([]UserVehicle, error) {
+	ctx, span := tracer.Start(ctx, "Service.ListVehicles")
+	defer span.End()
+
+	vehicles, err := t.next.ListVehicles(ctx, userID)
+	recordError(span, err)
+	return vehicles, err
+}
+
+func (t *tracedService) GetVehicle(ctx context.Context, userID string, vehicleID int) (UserVehicle, error) {
+	ctx, span := tracer.Start(ctx, "Service.GetVehicle")
+	defer span.End()
+
+	vehicle, err := t.next.GetVehicle(ctx, userID, vehicleID)
+	recordError(span, err)
+	return vehicle, err
+}
+
+func (t *tracedService) CreateVehicle(ctx context.Context, userID string, req VehicleRequest) (UserVehicle, error) {
+	ctx, span := tracer.Start(ctx, "Service.CreateVehicle")
+	defer span.End()
+
+	vehicle, err := t.next.CreateVehicle(ctx, userID, req)
+	recordError(span, err)
+	return vehicle, err
+}
+
+func (t *tracedService) UpdateVehicle(ctx context.Context, userID string, vehicleID int, req VehicleRequest) (UserVehicle, error) {
+	ctx, span := tracer.Start(ctx, "Service.UpdateVehicle")
+	defer span.End()
+
+	vehicle, err := t.next.UpdateVehicle(ctx, userID, vehicleID, req)
+	recordError(span, err)
+	return vehicle, err
+}
+
+func (t *tracedService) DeleteVehicle(ctx context.Context, userID string, vehicleID int) error {
+	ctx, span := tracer.Start(ctx, "Service.DeleteVehicle")
+	defer span.End()
+
+	err := t.next.DeleteVehicle(ctx, userID, vehicleID)
+	recordError(span, err)
+	return err
+}
+
+func (t *tracedService) ListAddresses(ctx context.Context, userID string) ([]UserAddress, error) {
+	ctx, span := tracer.Start(ctx, "Service.ListAddresses")
+	defer span.End()
+
+	addresses, err := t.next.ListAddresses(ctx, userID)
+	recordError(span, err)
+	return addresses, err
+}
+
+func (t *tracedService) GetAddress(ctx context.Context, userID string, addressID int) (UserAddress, error) {
+	ctx, span := tracer.Start(ctx, "Service.GetAddress")
+	defer span.End()
+
+	address, err := t.next.GetAddress(ctx, userID, addressID)
+	recordError(span, err)
+	return address, err
+}
+
+func (t *tracedService) CreateAddress(ctx context.Context, userID string, req AddressRequest) (UserAddress, error) {
+	ctx, span := tracer.Start(ctx, "Service.CreateAddress")
+	defer span.End()
+
+	address, err := t.next.CreateAddress(ctx, userID, req)
+	recordError(span, err)
+	return address, err
+}
+
+func (t *tracedService) UpdateAddress(ctx context.Context, userID string, addressID int, req AddressRequest) (UserAddress, error) {
+	ctx, span := tracer.Start(ctx, "Service.UpdateAddress")
+	defer span.End()
+
+	address, err := t.next.UpdateAddress(ctx, userID, addressID, req)
+	recordError(span, err)
+	return address, err
+}
+
+func (t *tracedService) DeleteAddress(ctx context.Context, userID string, addressID int) error {
+	ctx, span := tracer.Start(ctx, "Service.DeleteAddress")
+	defer span.End()
+
+	err := t.next.DeleteAddress(ctx, userID, addressID)
+	recordError(span, err)
+	return err
+}
+
+func (t *tracedService) ListIdentityChanges(ctx context.Context, filter IdentityChangeFilter) ([]IdentityChange, error) {
+	ctx, span := tracer.Start(ctx, "Service.ListIdentityChanges")
+	defer span.End()
+
+	changes, err := t.next.ListIdentityChanges(ctx, filter)
+	recordError(span, err)
+	return changes, err
+}
+
+func (t *tracedService) DecideIdentityChange(ctx context.Context, changeID int, approve bool, admin string) (IdentityChange, error) {
+	ctx, span := tracer.Start(ctx, "Service.DecideIdentityChange")
+	defer span.End()
+
+	change, err := t.next.DecideIdentityChange(ctx, changeID, approve, admin)
+	recordError(span, err)
+	return change, err
+}
+
+func (t *tracedService) ListProfileHistory(ctx context.Context, userID string) ([]ProfileHistoryEntry, error) {
+	ctx, span := tracer.Start(ctx, "Service.ListProfileHistory")
+	defer span.End()
+
+	history, err := t.next.ListProfileHistory(ctx, userID)
+	recordError(span, err)
+	return history, err
+}
+
+func (t *tracedService) RevertProfile(ctx context.Context, userID string, version int, admin string) (ProfileHistoryEntry, error) {
+	ctx, span := tracer.Start(ctx, "Service.RevertProfile")
+	defer span.End()
+
+	entry, err := t.next.RevertProfile(ctx, userID, version, admin)
+	recordError(span, err)
+	return entry, err
+}
+
+func (t *tracedService) ListAuditEvents(ctx context.Context, filter AuditEventFilter) ([]AuditEvent, error) {
+	ctx, span := tracer.Start(ctx, "Service.ListAuditEvents")
+	defer span.End()
+
+	events, err := t.next.ListAuditEvents(ctx, filter)
+	recordError(span, err)
+	return events, err
+}
+
+func (t *tracedService) AuthenticateToken(ctx context.Context, token string) (*JWTClaims, error) {
+	ctx, span := tracer.Start(ctx, "Service.AuthenticateToken")
+	defer span.End()
+
+	claims, err := t.next.AuthenticateToken(ctx, token)
+	recordError(span, err)
+	return claims, err
+}
+
+func (t *tracedService) ListSessions(ctx context.Context, userID, currentSessionID string) ([]Session, error) {
+	ctx, span := tracer.Start(ctx, "Service.ListSessions")
+	defer span.End()
+
+	sessions, err := t.next.ListSessions(ctx, userID, currentSessionID)
+	recordError(span, err)
+	return sessions, err
+}
+
+func (t *tracedService) ListLoginHistory(ctx context.Context, userID string) ([]Session, error) {
+	ctx, span := tracer.Start(ctx, "Service.ListLoginHistory")
+	defer span.End()
+
+	sessions, err := t.next.ListLoginHistory(ctx, userID)
+	recordError(span, err)
+	return sessions, err
+}
+
+func (t *tracedService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	ctx, span := tracer.Start(ctx, "Service.RevokeSession")
+	defer span.End()
+
+	err := t.next.RevokeSession(ctx, userID, sessionID)
+	recordError(span, err)
+	return err
+}
+
+func (t *tracedService) RequestMagicLink(ctx context.Context, email, device string) (string, error) {
+	ctx, span := tracer.Start(ctx, "Service.RequestMagicLink")
+	defer span.End()
+
+	device, err := t.next.RequestMagicLink(ctx, email, device)
+	recordError(span, err)
+	return device, err
+}
+
+func (t *tracedService) LoginWithMagicLink(ctx context.Context, token, device string) (string, error) {
+	ctx, span := tracer.Start(ctx, "Service.LoginWithMagicLink")
+	defer span.End()
+
+	token, err := t.next.LoginWithMagicLink(ctx, token, device)
+	recordError(span, err)
+	return token, err
+}
+
+func (t *tracedService) CreateAPIKey(ctx context.Context, userID string, req APIKeyRequest) (*APIKey, string, error) {
+	ctx, span := tracer.Start(ctx, "Service.CreateAPIKey")
+	defer span.End()
+
+	key, secret, err := t.next.CreateAPIKey(ctx, userID, req)
+	recordError(span, err)
+	return key, secret, err
+}
+
+func (t *tracedService) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	ctx, span := tracer.Start(ctx, "Service.ListAPIKeys")
+	defer span.End()
+
+	keys, err := t.next.ListAPIKeys(ctx, userID)
+	recordError(span, err)
+	return keys, err
+}
+
+func (t *tracedService) RevokeAPIKey(ctx context.Context, userID, keyID string) error {
+	ctx, span := tracer.Start(ctx, "Service.RevokeAPIKey")
+	defer span.End()
+
+	err := t.next.RevokeAPIKey(ctx, userID, keyID)
+	recordError(span, err)
+	return err
+}
+
+func (t *tracedService) AuthenticateAPIKey(ctx context.Context, key string) (*APIKey, *User, error) {
+	ctx, span := tracer.Start(ctx, "Service.AuthenticateAPIKey")
+	defer span.End()
+
+	apiKey, user, err := t.next.AuthenticateAPIKey(ctx, key)
+	recordError(span, err)
+	return apiKey, user, err
+}
+
+func (t *tracedService) SaveUserPDF(ctx context.Context, email string, file *multipart.FileHeader) error {
+	ctx, span := tracer.Start(ctx, "Service.SaveUserPDF")
+	defer span.End()
+
+	err := t.next.SaveUserPDF(ctx, email, file)
+	recordError(span, err)
+	return err
+}
+
+// OIDCProviders reads the configuration only, it gets no span
+func (t *tracedService) OIDCProviders() []string {
+	return t.next.OIDCProviders()
+}
+
+func (t *tracedService) StartOIDCLogin(ctx context.Context, provider, linkUserID string) (OIDCLoginStart, error) {
+	ctx, span := tracer.Start(ctx, "Service.StartOIDCLogin")
+	defer span.End()
+
+	start, err := t.next.StartOIDCLogin(ctx, provider, linkUserID)
+	recordError(span, err)
+	return start, err
+}
+
+func (t *tracedService) FinishOIDCLogin(ctx context.Context, provider string, callback OIDCCallback) (OIDCLoginResult, error) {
+	ctx, span := tracer.Start(ctx, "Service.FinishOIDCLogin")
+	defer span.End()
+
+	result, err := t.next.FinishOIDCLogin(ctx, provider, callback)
+	recordError(span, err)
+	return result, err
+}
+
+func (t *tracedService) ListIdentities(ctx context.Context, userID string) ([]UserIdentity, error) {
+	ctx, span := tracer.Start(ctx, "Service.ListIdentities")
+	defer span.End()
+
+	identities, err := t.next.ListIdentities(ctx, userID)
+	recordError(span, err)
+	return identities, err
+}
+
+func (t *tracedService) UnlinkIdentity(ctx context.Context, userID, provider string) error {
+	ctx, span := tracer.Start(ctx, "Service.UnlinkIdentity")
+	defer span.End()
+
+	err := t.next.UnlinkIdentity(ctx, userID, provider)
+	recordError(span, err)
+	return err
+}
+
+func (t *tracedService) RegisterOAuthClient(ctx context.Context, req OAuthClientRequest) (*OAuthClient, string, error) {
+	ctx, span := tracer.Start(ctx, "Service.RegisterOAuthClient")
+	defer span.End()
+
+	client, secret, err := t.next.RegisterOAuthClient(ctx, req)
+	recordError(span, err)
+	return client, secret, err
+}
+
+func (t *tracedService) ListOAuthClients(ctx context.Context) ([]OAuthClient, error) {
+	ctx, span := tracer.Start(ctx, "Service.ListOAuthClients")
+	defer span.End()
+
+	clients, err := t.next.ListOAuthClients(ctx)
+	recordError(span, err)
+	return clients, err
+}
+
+func (t *tracedService) DeleteOAuthClient(ctx context.Context, clientID string) error {
+	ctx, span := tracer.Start(ctx, "Service.DeleteOAuthClient")
+	defer span.End()
+
+	err := t.next.DeleteOAuthClient(ctx, clientID)
+	recordError(span, err)
+	return err
+}
+
+// OAuthMetadata reads the configuration only, it gets no span
+func (t *tracedService) OAuthMetadata() OAuthServerMetadata {
+	return t.next.OAuthMetadata()
+}
+
+func (t *tracedService) OAuthKeys(ctx context.Context) (JSONWebKeySet, error) {
+	ctx, span := tracer.Start(ctx, "Service.OAuthKeys")
+	defer span.End()
+
+	keys, err := t.next.OAuthKeys(ctx)
+	recordError(span, err)
+	return keys, err
+}
+
+func (t *tracedService) PrepareAuthorization(ctx context.Context, req AuthorizationRequest) (*AuthorizationPrompt, error) {
+	ctx, span := tracer.Start(ctx, "Service.PrepareAuthorization")
+	defer span.End()
+
+	prompt, err := t.next.PrepareAuthorization(ctx, req)
+	recordError(span, err)
+	return prompt, err
+}
+
+func (t *tracedService) AuthorizeLogin(ctx context.Context, req AuthorizationRequest, email, password string) (AuthorizationStep, error) {
+	ctx, span := tracer.Start(ctx, "Service.AuthorizeLogin")
+	defer span.End()
+
+	step, err := t.next.AuthorizeLogin(ctx, req, email, password)
+	recordError(span, err)
+	return step, err
+}
+
+func (t *tracedService) AuthorizeConsent(ctx context.Context, req AuthorizationRequest, ticket string, approve bool) (AuthorizationStep, error) {
+	ctx, span := tracer.Start(ctx, "Service.AuthorizeConsent")
+	defer span.End()
+
+	step, err := t.next.AuthorizeConsent(ctx, req, ticket, approve)
+	recordError(span, err)
+	return step, err
+}
+
+func (t *tracedService) ExchangeAuthorizationCode(ctx context.Context, req TokenRequest) (*TokenResponse, error) {
+	ctx, span := tracer.Start(ctx, "Service.ExchangeAuthorizationCode")
+	defer span.End()
+
+	response, err := t.next.ExchangeAuthorizationCode(ctx, req)
+	recordError(span, err)
+	return response, err
+}
+
+func (t *tracedService) OAuthUserInfo(ctx context.Context, accessToken string) (map[string]any, error) {
+	ctx, span := tracer.Start(ctx, "Service.OAuthUserInfo")
+	defer span.End()
+
+	info, err := t.next.OAuthUserInfo(ctx, accessToken)
+	recordError(span, err)
+	return info, err
+}
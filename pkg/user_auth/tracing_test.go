@@ -0,0 +1,140 @@
+package userauth
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/abhiii71/clean-code-abhi/pkg/config"
+	"github.com/abhiii71/clean-code-abhi/pkg/db"
+	"github.com/abhiii71/clean-code-abhi/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// spanExporter is registered once, the package tracer keeps delegating to the
+// first provider registered globally
+var spanExporter = sync.OnceValue(func() *tracetest.InMemoryExporter {
+	_, exporter := tracing.NewInMemory()
+	return exporter
+})
+
+// recordSpans returns the exporter of the spans started from now on
+func recordSpans() *tracetest.InMemoryExporter {
+	exporter := spanExporter()
+	exporter.Reset()
+	return exporter
+}
+
+// untracedMethods only read the configuration, they get no span
+var untracedMethods = map[string]bool{"OIDCProviders": true, "OAuthMetadata": true}
+
+// panickingService fails every call, the spans of the traced service end in a
+// defer so they are recorded all the same
+type panickingService struct {
+	Service
+}
+
+func TestTracedServiceStartsASpanPerMethod(t *testing.T) {
+	exporter := recordSpans()
+
+	traced := reflect.ValueOf(NewTracedService(panickingService{}))
+	serviceType := reflect.TypeFor[Service]()
+	contextType := reflect.TypeFor[context.Context]()
+	for i := range serviceType.NumMethod() {
+		name := serviceType.Method(i).Name
+		if untracedMethods[name] {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			exporter.Reset()
+			method := traced.MethodByName(name)
+			args := make([]reflect.Value, method.Type().NumIn())
+			for j := range args {
+				if in := method.Type().In(j); in == contextType {
+					args[j] = reflect.ValueOf(context.Background())
+				} else {
+					args[j] = reflect.Zero(in)
+				}
+			}
+			func() {
+				defer func() { recover() }()
+				method.Call(args)
+			}()
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 || spans[0].Name != "Service."+name {
+				t.Errorf("got spans %v, want one span Service.%s", spanNames(spans), name)
+			}
+		})
+	}
+}
+
+func TestRepositoryQueriesStartSanitizedSpans(t *testing.T) {
+	exporter := recordSpans()
+	ctx := context.Background()
+
+	_, span := startQuerySpan(ctx, semconv.DBSystemSqlite, "Probe", "SELECT id FROM users\n    WHERE email = 'jane@example.com' AND age > 17")
+	span.End()
+	probe := exporter.GetSpans()[0]
+	if got := spanAttribute(probe.Attributes, semconv.DBQueryTextKey); got != "SELECT id FROM users WHERE email = ? AND age > ?" {
+		t.Errorf("got db.query.text %q, want the literals stripped", got)
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	sqlDB, err := db.ConnectSQLite(config.Config{SQLitePath: filepath.Join(t.TempDir(), "test.db")}, log)
+	if err != nil {
+		t.Fatalf("ConnectSQLite: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	repo := NewSQLiteRepository(sqlDB, log)
+
+	exporter.Reset()
+	id, err := repo.UserRegister(ctx, UserRegisterRequest{FirstName: "Jane", Email: "jane@example.com", Password: "hashed", Gender: "female"})
+	if err != nil {
+		t.Fatalf("UserRegister: %v", err)
+	}
+	if _, err := repo.FindUserByEmail(ctx, "jane@example.com"); err != nil {
+		t.Fatalf("FindUserByEmail: %v", err)
+	}
+	if _, err := repo.FindUserByID(ctx, id); err != nil {
+		t.Fatalf("FindUserByID: %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if got := spanNames(spans); !reflect.DeepEqual(got, []string{"Repository.UserRegister", "Repository.FindUserByEmail", "Repository.FindUserByID"}) {
+		t.Fatalf("got spans %v, want one per query", got)
+	}
+	for _, span := range spans {
+		query := spanAttribute(span.Attributes, semconv.DBQueryTextKey)
+		if query == "" || query != tracing.SanitizeSQL(query) || strings.Contains(query, "jane") {
+			t.Errorf("%s: got db.query.text %q, want a sanitized query", span.Name, query)
+		}
+		if got := spanAttribute(span.Attributes, semconv.DBSystemKey); got != "sqlite" {
+			t.Errorf("%s: got db.system %q, want sqlite", span.Name, got)
+		}
+	}
+}
+
+func spanNames(spans tracetest.SpanStubs) []string {
+	names := []string{}
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	return names
+}
+
+func spanAttribute(attrs []attribute.KeyValue, key attribute.Key) string {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return attr.Value.Emit()
+		}
+	}
+	return ""
+}
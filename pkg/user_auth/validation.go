@@ -0,0 +1,378 @@
+package userauth
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-playground/locales/de"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/es"
+	"github.com/go-playground/locales/fr"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	de_translations "github.com/go-playground/validator/v10/translations/de"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+	es_translations "github.com/go-playground/validator/v10/translations/es"
+	fr_translations "github.com/go-playground/validator/v10/translations/fr"
+	"golang.org/x/text/language"
+)
+
+const (
+	defaultLocale = "en"
+	minimumAge    = 18
+)
+
+var (
+	emailregex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z.-]+\.[a-zA-Z]{2,}$`)
+
+	// supportedLocales are matched against Accept-Language, the first is the fallback
+	supportedLocales = []language.Tag{language.English, language.Spanish, language.French, language.German}
+)
+
+// customMessages holds the translations of the rules registered by this
+// package and of the password policy rules, {0} is the field name and {1}
+// the rule parameter
+var customMessages = map[string]map[string]string{
+	"en": {
+		"email_format":         "{0} must be a valid email address",
+		"adult":                "{0} must be at least 18 years old",
+		"gender":               "{0} must be one of {1}",
+		"gender_described":     "{0} is required when gender is self_described",
+		"owned_after":          "{0} must not be before owned_from",
+		"valid_after":          "{0} must not be before valid_from",
+		"primary_not_previous": "{0} cannot be set on a previous address",
+		rulePasswordMinLength:  "{0} must be at least {1} characters long",
+		rulePasswordMaxLength:  "{0} must be at most {1} characters long",
+		rulePasswordMaxBytes:   "{0} must be at most {1} bytes long, letters outside ASCII count as several bytes",
+		rulePasswordUpper:      "{0} must include an uppercase letter",
+		rulePasswordLower:      "{0} must include a lowercase letter",
+		rulePasswordDigit:      "{0} must include a digit",
+		rulePasswordSpecial:    "{0} must include a special character",
+		rulePasswordPersonal:   "{0} must not contain your name or email",
+		rulePasswordBreached:   "{0} has appeared in a data breach, choose another one",
+		ruleAddressCountry:     "{0} must be an ISO 3166 country code",
+		ruleAddressPostalCode:  "{0} is not a valid postal code for {1}",
+		ruleAddressState:       "{0} is not a state or province of {1}",
+	},
+	"es": {
+		"email_format":         "{0} debe ser una dirección de correo válida",
+		"adult":                "{0} debe tener al menos 18 años",
+		"gender":               "{0} debe ser uno de {1}",
+		"gender_described":     "{0} es obligatorio cuando gender es self_described",
+		"owned_after":          "{0} no puede ser anterior a owned_from",
+		"valid_after":          "{0} no puede ser anterior a valid_from",
+		"primary_not_previous": "{0} no se puede activar en una dirección anterior",
+		rulePasswordMinLength:  "{0} debe tener al menos {1} caracteres",
+		rulePasswordMaxLength:  "{0} debe tener como máximo {1} caracteres",
+		rulePasswordMaxBytes:   "{0} debe ocupar como máximo {1} bytes, las letras fuera de ASCII cuentan como varios bytes",
+		rulePasswordUpper:      "{0} debe incluir una letra mayúscula",
+		rulePasswordLower:      "{0} debe incluir una letra minúscula",
+		rulePasswordDigit:      "{0} debe incluir un dígito",
+		rulePasswordSpecial:    "{0} debe incluir un carácter especial",
+		rulePasswordPersonal:   "{0} no debe contener tu nombre ni tu correo",
+		rulePasswordBreached:   "{0} ha aparecido en una filtración de datos, elige otra",
+		ruleAddressCountry:     "{0} debe ser un código de país ISO 3166",
+		ruleAddressPostalCode:  "{0} no es un código postal válido para {1}",
+		ruleAddressState:       "{0} no es un estado o provincia de {1}",
+	},
+	"fr": {
+		"email_format":         "{0} doit être une adresse e-mail valide",
+		"adult":                "{0} doit avoir au moins 18 ans",
+		"gender":               "{0} doit être l'un de {1}",
+		"gender_described":     "{0} est obligatoire lorsque gender vaut self_described",
+		"owned_after":          "{0} ne peut pas être antérieur à owned_from",
+		"valid_after":          "{0} ne peut pas être antérieur à valid_from",
+		"primary_not_previous": "{0} ne peut pas être activé sur une ancienne adresse",
+		rulePasswordMinLength:  "{0} doit contenir au moins {1} caractères",
+		rulePasswordMaxLength:  "{0} doit contenir au plus {1} caractères",
+		rulePasswordMaxBytes:   "{0} doit faire au plus {1} octets, les lettres hors ASCII comptent pour plusieurs octets",
+		rulePasswordUpper:      "{0} doit contenir une majuscule",
+		rulePasswordLower:      "{0} doit contenir une minuscule",
+		rulePasswordDigit:      "{0} doit contenir un chiffre",
+		rulePasswordSpecial:    "{0} doit contenir un caractère spécial",
+		rulePasswordPersonal:   "{0} ne doit pas contenir votre nom ou votre e-mail",
+		rulePasswordBreached:   "{0} figure dans une fuite de données, choisissez-en un autre",
+		ruleAddressCountry:     "{0} doit être un code pays ISO 3166",
+		ruleAddressPostalCode:  "{0} n'est pas un code postal valide pour {1}",
+		ruleAddressState:       "{0} n'est pas un État ou une province de {1}",
+	},
+	"de": {
+		"email_format":         "{0} muss eine gültige E-Mail-Adresse sein",
+		"adult":                "{0} muss mindestens 18 Jahre alt sein",
+		"gender":               "{0} muss einer der Werte {1} sein",
+		"gender_described":     "{0} ist erforderlich, wenn gender self_described ist",
+		"owned_after":          "{0} darf nicht vor owned_from liegen",
+		"valid_after":          "{0} darf nicht vor valid_from liegen",
+		"primary_not_previous": "{0} ist für eine frühere Adresse nicht möglich",
+		rulePasswordMinLength:  "{0} muss mindestens {1} Zeichen lang sein",
+		rulePasswordMaxLength:  "{0} darf höchstens {1} Zeichen lang sein",
+		rulePasswordMaxBytes:   "{0} darf höchstens {1} Bytes lang sein, Buchstaben außerhalb von ASCII zählen als mehrere Bytes",
+		rulePasswordUpper:      "{0} muss einen Großbuchstaben enthalten",
+		rulePasswordLower:      "{0} muss einen Kleinbuchstaben enthalten",
+		rulePasswordDigit:      "{0} muss eine Ziffer enthalten",
+		rulePasswordSpecial:    "{0} muss ein Sonderzeichen enthalten",
+		rulePasswordPersonal:   "{0} darf weder deinen Namen noch deine E-Mail enthalten",
+		rulePasswordBreached:   "{0} ist in einem Datenleck aufgetaucht, wähle ein anderes",
+		ruleAddressCountry:     "{0} muss ein ISO-3166-Ländercode sein",
+		ruleAddressPostalCode:  "{0} ist keine gültige Postleitzahl für {1}",
+		ruleAddressState:       "{0} ist kein Bundesland oder keine Provinz von {1}",
+	},
+}
+
+// structRules are reported by struct level validations, they are translated
+// like field rules
+var structRules = map[string]bool{
+	"owned_after":          true,
+	"valid_after":          true,
+	"primary_not_previous": true,
+	"gender_described":     true,
+}
+
+// Validator checks request structs against their validate tags and reports
+// the failures in the language asked for by Accept-Language
+type Validator struct {
+	validate *validator.Validate
+	uni      *ut.UniversalTranslator
+	matcher  language.Matcher
+	// genders are the genders offered to users, see ListGenders
+	genders []Gender
+}
+
+// NewValidator registers the custom rules and the translations of every
+// supported locale, genders restricts the accepted genders, all of Genders
+// when empty
+func NewValidator(genders []Gender) (*Validator, error) {
+	if len(genders) == 0 {
+		genders = Genders
+	}
+	v := validator.New(validator.WithRequiredStructEnabled())
+
+	// report fields by their JSON name
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" && f.Anonymous {
+			// embedded fields are inlined in the JSON
+			return embeddedName
+		}
+		return name
+	})
+	v.RegisterCustomTypeFunc(func(field reflect.Value) interface{} {
+		return time.Time(field.Interface().(Date))
+	}, Date{})
+
+	rules := map[string]validator.Func{
+		"email_format": validEmail,
+		"adult":        isAdult,
+		"gender":       validGender(genders),
+	}
+	// params fills {1} of the messages of rules without a parameter
+	params := map[string]string{
+		"gender": joinGenders(genders),
+	}
+	for tag, fn := range rules {
+		if err := v.RegisterValidation(tag, fn); err != nil {
+			return nil, err
+		}
+	}
+
+	v.RegisterStructValidation(vehicleOwnership, VehicleRequest{})
+	v.RegisterStructValidation(addressValidity, AddressRequest{})
+	v.RegisterStructValidation(genderDescribed, UserRegisterRequest{}, UserInformationRequest{})
+
+	enLocale := en.New()
+	uni := ut.New(enLocale, enLocale, es.New(), fr.New(), de.New())
+	defaults := map[string]func(*validator.Validate, ut.Translator) error{
+		"en": en_translations.RegisterDefaultTranslations,
+		"es": es_translations.RegisterDefaultTranslations,
+		"fr": fr_translations.RegisterDefaultTranslations,
+		"de": de_translations.RegisterDefaultTranslations,
+	}
+	for locale, register := range defaults {
+		trans, _ := uni.GetTranslator(locale)
+		if err := register(v, trans); err != nil {
+			return nil, err
+		}
+		for tag, msg := range customMessages[locale] {
+			if _, isRule := rules[tag]; !isRule && !structRules[tag] {
+				// messages of the service checks are looked up directly, see translateError
+				if err := trans.Add(tag, msg, false); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := registerTranslation(v, trans, tag, msg, params[tag]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &Validator{
+		validate: v,
+		uni:      uni,
+		matcher:  language.NewMatcher(supportedLocales),
+		genders:  genders,
+	}, nil
+}
+
+func registerTranslation(v *validator.Validate, trans ut.Translator, tag, msg, param string) error {
+	return v.RegisterTranslation(tag, trans,
+		func(ut ut.Translator) error {
+			return ut.Add(tag, msg, true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			if fe.Param() != "" {
+				param = fe.Param()
+			}
+			t, err := ut.T(tag, fe.Field(), param)
+			if err != nil {
+				return fe.Error()
+			}
+			return t
+		},
+	)
+}
+
+// Validate checks s and returns a ValidationError with messages in the best
+// match for acceptLanguage, or nil when s is valid
+func (v *Validator) Validate(s interface{}, acceptLanguage string) error {
+	err := v.validate.Struct(s)
+	if err == nil {
+		return nil
+	}
+
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return err
+	}
+
+	trans := v.translator(acceptLanguage)
+	fields := url.Values{}
+	for _, fe := range fieldErrs {
+		fields.Add(fieldPath(fe), fe.Translate(trans))
+	}
+	return NewValidationError(fields)
+}
+
+// translateError turns the field violations reported by the service into a
+// ValidationError with messages in the best match for acceptLanguage, other
+// errors are returned as is
+func (v *Validator) translateError(err error, acceptLanguage string) error {
+	var violationErr violationError
+	if !errors.As(err, &violationErr) {
+		return err
+	}
+
+	trans := v.translator(acceptLanguage)
+	fields := url.Values{}
+	for _, violation := range violationErr.fieldViolations() {
+		msg, tErr := trans.T(violation.Rule, violation.Field, violation.Param)
+		if tErr != nil {
+			msg = violation.Rule
+		}
+		fields.Add(violation.Field, msg)
+	}
+	return NewValidationError(fields)
+}
+
+func (v *Validator) translator(acceptLanguage string) ut.Translator {
+	tags, _, _ := language.ParseAcceptLanguage(acceptLanguage)
+	_, index, _ := v.matcher.Match(tags...)
+	base, _ := supportedLocales[index].Base()
+
+	trans, found := v.uni.GetTranslator(base.String())
+	if !found {
+		trans, _ = v.uni.GetTranslator(defaultLocale)
+	}
+	return trans
+}
+
+// embeddedName names embedded structs in the namespace of a field error,
+// fieldPath drops it
+const embeddedName = "~"
+
+// fieldPath is the JSON path of the field without the root struct name,
+// e.g. address.postal_code
+func fieldPath(fe validator.FieldError) string {
+	ns := strings.ReplaceAll(fe.Namespace(), "."+embeddedName+".", ".")
+	if i := strings.IndexByte(ns, '.'); i >= 0 {
+		return ns[i+1:]
+	}
+	return fe.Field()
+}
+
+func validEmail(fl validator.FieldLevel) bool {
+	email := fl.Field().String()
+	return emailregex.MatchString(email) && !strings.Contains(email, "..")
+}
+
+func isAdult(fl validator.FieldLevel) bool {
+	dob, ok := fl.Field().Interface().(time.Time)
+	if !ok {
+		return false
+	}
+	today := time.Now()
+	age := today.Year() - dob.Year()
+	if today.Month() < dob.Month() || today.Month() == dob.Month() && today.Day() < dob.Day() {
+		age--
+	}
+	return age >= minimumAge
+}
+
+func validGender(genders []Gender) validator.Func {
+	return func(fl validator.FieldLevel) bool {
+		return slices.Contains(genders, Gender(fl.Field().String()))
+	}
+}
+
+func joinGenders(genders []Gender) string {
+	names := make([]string, len(genders))
+	for i, g := range genders {
+		names[i] = string(g)
+	}
+	return strings.Join(names, ", ")
+}
+
+// genderDescribed requires the free text of a self_described gender
+func genderDescribed(sl validator.StructLevel) {
+	var gender, description string
+	switch req := sl.Current().Interface().(type) {
+	case UserRegisterRequest:
+		gender, description = req.Gender, req.GenderDescription
+	case UserInformationRequest:
+		gender, description = req.Gender, req.GenderDescription
+	}
+	if Gender(gender) == GenderSelfDescribed && strings.TrimSpace(description) == "" {
+		sl.ReportError(description, "gender_description", "GenderDescription", "gender_described", "")
+	}
+}
+
+// vehicleOwnership rejects a vehicle sold before it was bought
+func vehicleOwnership(sl validator.StructLevel) {
+	req := sl.Current().Interface().(VehicleRequest)
+	if req.OwnedFrom == nil || req.OwnedUntil == nil {
+		return
+	}
+	if time.Time(*req.OwnedUntil).Before(time.Time(*req.OwnedFrom)) {
+		sl.ReportError(req.OwnedUntil, "owned_until", "OwnedUntil", "owned_after", "")
+	}
+}
+
+// addressValidity rejects an address left before it was moved into and a
+// previous address marked as primary
+func addressValidity(sl validator.StructLevel) {
+	req := sl.Current().Interface().(AddressRequest)
+	if req.ValidFrom != nil && req.ValidUntil != nil && time.Time(*req.ValidUntil).Before(time.Time(*req.ValidFrom)) {
+		sl.ReportError(req.ValidUntil, "valid_until", "ValidUntil", "valid_after", "")
+	}
+	if req.IsPrimary && req.Type == AddressPrevious {
+		sl.ReportError(req.IsPrimary, "is_primary", "IsPrimary", "primary_not_previous", "")
+	}
+}